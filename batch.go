@@ -1,10 +1,12 @@
 package reductgo
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
 	"slices"
 	"strconv"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/reductstore/reduct-go/httpclient"
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
 type BatchType int
@@ -32,6 +35,19 @@ type Record struct {
 	Data        []byte
 	ContentType string
 	Labels      LabelMap
+	// addErr is the error of encoding the labels or encoding the data in
+	// Add, reported by Write.
+	addErr error
+	// stream is the payload of a record added with AddReader instead of Data.
+	stream *streamedPayload
+}
+
+// size returns the size of the payload, the declared one if it is streamed.
+func (r *Record) size() int64 {
+	if r.stream != nil {
+		return r.stream.size
+	}
+	return int64(len(r.Data))
 }
 
 type Batch struct {
@@ -42,7 +58,26 @@ type Batch struct {
 	records    map[int64]*Record
 	totalSize  int64
 	lastAccess time.Time
-	mu         sync.Mutex
+	// compression compresses and encryption encrypts the data of the records
+	// added to a write batch.
+	compression *CompressionOptions
+	encryption  *EncryptionOptions
+	// timestamps allocates the timestamps of AddNow and learns the others.
+	timestamps *TimestampAllocator
+	// conflict is the ConflictPolicy of a write batch, duplicates are the
+	// timestamps of records dropped by ConflictFail, and shifted maps the
+	// timestamps of records moved by ConflictShift to their new ones.
+	conflict   ConflictPolicy
+	duplicates map[int64]bool
+	shifted    map[int64]int64
+	// spool keeps the records of a write batch that fails because the server
+	// is unreachable.
+	spool *Spool
+	// dedup deduplicates the records of a write batch, and deduplicated maps
+	// the timestamps of duplicates to the ones of their originals.
+	dedup        *DedupOptions
+	deduplicated map[int64]int64
+	mu           sync.Mutex
 }
 
 type BatchOptions struct{}
@@ -50,39 +85,160 @@ type BatchOptions struct{}
 // newBatch creates a new batch.
 func newBatch(bucket, entry string, client httpclient.HTTPClient, batchType BatchType) *Batch {
 	return &Batch{
-		bucketName: bucket,
-		entryName:  entry,
-		httpClient: client,
-		batchType:  batchType,
-		records:    make(map[int64]*Record),
-		totalSize:  0,
-		lastAccess: time.Now().UTC(),
-		mu:         sync.Mutex{},
+		bucketName:   bucket,
+		entryName:    entry,
+		httpClient:   client,
+		batchType:    batchType,
+		records:      make(map[int64]*Record),
+		totalSize:    0,
+		lastAccess:   time.Now().UTC(),
+		duplicates:   map[int64]bool{},
+		shifted:      map[int64]int64{},
+		deduplicated: map[int64]int64{},
+		mu:           sync.Mutex{},
 	}
 }
 
 // Add adds a record to the batch.
-func (b *Batch) Add(ts int64, data []byte, contentType string, labels LabelMap) {
+// labels is a LabelMap, a map[string]string or a struct with reduct tags (see MarshalLabels).
+// The data is compressed and encrypted if set, see SetCompression and SetEncryption.
+// A record with labels that cannot be encoded is reported in the ErrorMap of Write.
+// A record at the timestamp of another record of a write batch is handled by
+// the conflict policy, see SetConflictPolicy. Duplicated payloads are skipped
+// or labeled by Write if set, see SetDedup.
+func (b *Batch) Add(ts int64, data []byte, contentType string, labels any) {
+	b.add(ts, data, nil, contentType, labels)
+}
+
+// AddNow adds a record at the next timestamp of the entry, see
+// TimestampAllocator, and returns the timestamp. The other arguments are
+// given like in Add.
+func (b *Batch) AddNow(data []byte, contentType string, labels any) int64 {
+	ts := b.timestamps.Next(b.entryName)
+	b.Add(ts, data, contentType, labels)
+	return ts
+}
+
+// AddReader adds a record whose payload of the given size is read from reader
+// when the batch is written, so that large payloads such as files or frames
+// are streamed into the request body instead of being held in memory. Only
+// size bytes are read; if reader ends before, the request fails with
+// ErrPayloadSize. labels are given like in Add.
+//
+// A reader is read once: a batch with streamed records cannot be written
+// again, and WriteWithRetry does not resend them. If compression or
+// encryption is set, the payload is read into memory by AddReader.
+func (b *Batch) AddReader(ts int64, reader io.Reader, size int64, contentType string, labels any) {
+	b.add(ts, nil, &streamedPayload{reader: reader, size: size}, contentType, labels)
+}
+
+func (b *Batch) add(ts int64, data []byte, stream *streamedPayload, contentType string, labels any) {
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
-	if labels == nil {
-		labels = LabelMap{}
+	labelMap, addErr := labelsOf(labels)
+	if labelMap == nil {
+		labelMap = LabelMap{}
 	}
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.totalSize += int64(len(data))
+	if existing := b.records[ts]; existing != nil && b.batchType == BatchWrite {
+		switch b.conflict {
+		case ConflictFail:
+			b.duplicates[ts] = true
+			return
+		case ConflictShift:
+			next := ts + 1
+			for b.records[next] != nil {
+				next++
+			}
+			b.shifted[ts] = next
+			ts = next
+		case ConflictOverwrite:
+			b.totalSize -= existing.size()
+		}
+	}
+	if b.batchType == BatchWrite {
+		b.timestamps.Observe(b.entryName, ts)
+	}
+
+	if addErr == nil && b.batchType == BatchWrite {
+		encryption := b.encryption
+		if b.dedup != nil && stream == nil {
+			labelMap, encryption, addErr = withDedupHash(labelMap, data, encryption)
+		}
+		if addErr == nil {
+			data, stream, labelMap, addErr = encodePayload(data, stream, labelMap, b.compression, encryption)
+		}
+	}
+
+	record := &Record{Data: data, ContentType: contentType, Labels: labelMap, addErr: addErr, stream: stream}
+	b.totalSize += record.size()
 	b.lastAccess = time.Now().UTC()
-	b.records[ts] = &Record{Data: data, ContentType: contentType, Labels: labels}
+	b.records[ts] = record
+}
+
+// SetConflictPolicy sets what a write batch does with a record added at the
+// timestamp of another one, and with records the entry already has when it is
+// written (HTTP 409). Defaults to ConflictFail.
+func (b *Batch) SetConflictPolicy(policy ConflictPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conflict = policy
+}
+
+// Shifted maps the timestamps of records moved by ConflictShift to the ones
+// they were written with. A record added at the timestamp of another one is
+// reported under the timestamp it was added with.
+func (b *Batch) Shifted() map[int64]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.shifted)
+}
+
+// SetDedup deduplicates the records added after the call with the options,
+// or stops deduplicating if options is nil. It only applies to write batches.
+func (b *Batch) SetDedup(options *DedupOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dedup = options
 }
 
-// AddOnlyLabels adds an empty record with only labels.
-func (b *Batch) AddOnlyLabels(ts int64, labels LabelMap) {
+// Deduplicated maps the timestamps of the records found to be duplicates by
+// Write to the timestamps of the records with the same payload. With
+// DedupSkip they were not written.
+func (b *Batch) Deduplicated() map[int64]int64 {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.records[ts] = &Record{Data: []byte{}, ContentType: "", Labels: labels}
+	return maps.Clone(b.deduplicated)
+}
+
+// SetCompression compresses the data of the records added after the call
+// with the options, or stops compressing if options is nil. It only applies to
+// write batches.
+func (b *Batch) SetCompression(options *CompressionOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.compression = options
+}
+
+// SetEncryption encrypts the data of the records added after the call with
+// the options, or stops encrypting if options is nil. Batches begun on a
+// bucket are encrypted like the bucket. It only applies to write batches.
+func (b *Batch) SetEncryption(options *EncryptionOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.encryption = options
+}
+
+// AddOnlyLabels adds an empty record with only labels, given like in Add.
+func (b *Batch) AddOnlyLabels(ts int64, labels any) {
+	labelMap, addErr := labelsOf(labels)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records[ts] = &Record{Data: []byte{}, ContentType: "", Labels: labelMap, addErr: addErr}
 }
 
 // AddOnlyTimestamp adds an empty record with only a timestamp.
@@ -92,73 +248,308 @@ func (b *Batch) AddOnlyTimestamp(ts int64) {
 	b.records[ts] = &Record{Data: []byte{}, ContentType: "", Labels: LabelMap{}}
 }
 
+// batchItem is a record of a Batch with the value of its header.
+type batchItem struct {
+	ts     int64
+	record *Record
+	header string
+}
+
 // Write writes the batch to the server.
 // It returns an ErrorMap with timestamps as keys and APIError as values for individual records that failed to write.
-// If the whole batch fails, it returns an error.
+// A batch that exceeds the batch limits of the client is sent in several requests in timestamp order, and their
+// errors are merged. If a request fails, Write returns its error together with the errors of the requests sent
+// before; the records of the failed request and the ones after it are not written. With a spool, see
+// ClientOptions.Spool, they are spooled instead if the server is unreachable.
 func (b *Batch) Write(ctx context.Context) (ErrorMap, error) {
+	errs, _, err := b.write(ctx)
+	return errs, err
+}
+
+// write is Write that also returns the records that were not sent if it
+// fails, like RecordBatch.send.
+func (b *Batch) write(ctx context.Context) (_ ErrorMap, unsent map[recordBatchKey]bool, err error) {
 	b.mu.Lock()
-	headers := http.Header{}
-	var chunks bytes.Buffer
+	records := b.records
+	dedup := b.dedup
+	var deduped *dedupBatch
+	if dedup != nil && b.batchType == BatchWrite {
+		records, deduped = b.dedupRecords(dedup)
+	}
+	items := make([]batchItem, 0, len(records))
 	var contentLength int64
-	timestamps := make([]int64, 0, len(b.records))
-	for ts := range b.records {
-		timestamps = append(timestamps, ts)
-	}
-	slices.Sort(timestamps)
-	for _, ts := range timestamps {
-		rec := b.records[ts]
-		contentLength += int64(len(rec.Data))
-		header := fmt.Sprintf("x-reduct-time-%d", ts)
-
-		headerValue := "0,"
-		if b.batchType == BatchWrite {
-			headerValue = fmt.Sprintf("%d,%s", len(rec.Data), rec.ContentType)
-		}
-		for k, v := range rec.Labels {
-			valStr, ok := v.(string)
-			if ok && strings.Contains(valStr, ",") {
-				headerValue += fmt.Sprintf(",%s=%q", k, valStr)
-			} else {
-				headerValue += fmt.Sprintf(",%s=%s", k, valStr)
+	errs := ErrorMap{}
+	for ts, rec := range records {
+		header, labelErr := b.headerValue(rec)
+		if labelErr != nil {
+			errs[ts] = labelRecordError(labelErr)
+			continue
+		}
+		contentLength += rec.size()
+		items = append(items, batchItem{ts: ts, record: rec, header: header})
+	}
+	recordCount := len(b.records)
+	duplicates := maps.Clone(b.duplicates)
+	policy := b.conflict
+	spool := b.spool
+	b.mu.Unlock()
+	slices.SortFunc(items, func(a, b batchItem) int { return cmp.Compare(a.ts, b.ts) })
+
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Batch.Write", Bucket: b.bucketName, Entry: b.entryName})
+	ctx, span := httpclient.TracerOf(b.httpClient).Start(ctx, "Batch.Write",
+		telemetry.String(telemetry.AttrBucket, b.bucketName),
+		telemetry.String(telemetry.AttrEntry, b.entryName),
+		telemetry.Int(telemetry.AttrRecordCount, recordCount),
+		telemetry.Int64(telemetry.AttrBytes, contentLength))
+	defer func() { endSpan(span, err) }()
+
+	if b.batchType != BatchWrite && b.batchType != BatchUpdate && b.batchType != BatchRemove {
+		return nil, b.unsentItems([][]batchItem{items}, deduped), errors.New("invalid batch type")
+	}
+
+	limits := httpclient.BatchLimitsOf(b.httpClient)
+	chunks := splitBatch(items, limits, func(item batchItem) int64 { return item.record.size() })
+	if len(items) == 0 && recordCount > 0 {
+		// All records were skipped or rejected; the duplicates are still reported.
+		chunks = nil
+	}
+	for i, chunk := range chunks {
+		if b.batchType == BatchWrite && spool.pending() {
+			// Records are written after the spooled ones.
+			if err = b.spoolChunks(ctx, spool, chunks[i:], nil, errs); err != nil {
+				return errs, b.unsentItems(chunks[i:], deduped), err
+			}
+			break
+		}
+		if err = b.writeChunk(ctx, span, limits, chunk, errs); err != nil {
+			if b.batchType != BatchWrite || spool == nil || !spoolable(err) {
+				return errs, b.unsentItems(chunks[i:], deduped), err
+			}
+			if spoolErr := b.spoolChunks(ctx, spool, chunks[i:], err, errs); spoolErr != nil {
+				return errs, b.unsentItems(chunks[i:], deduped), errors.Join(err, spoolErr)
+			}
+			err = nil
+			break
+		}
+	}
+	shifted := map[string]map[int64]int64{}
+	if b.batchType == BatchWrite && policy != ConflictFail {
+		if err = b.resolveConflicts(ctx, policy, items, errs, shifted); err != nil {
+			return errs, unresolvedConflicts(RecordBatchErrorMap{b.entryName: errs}), err
+		}
+	}
+	b.finishDedup(ctx, deduped, errs, shifted[b.entryName])
+	for ts := range duplicates {
+		if _, failed := errs[ts]; !failed {
+			errs[ts] = duplicateTimestampError()
+		}
+	}
+
+	observeRecordErrors(httpclient.MetricsOf(b.httpClient), "Batch.Write", errs)
+	if len(errs) > 0 {
+		httpclient.LoggerOf(b.httpClient).WarnContext(ctx, "batch partially failed",
+			"bucket", b.bucketName, "entry", b.entryName, "failed", len(errs), "records", recordCount)
+	}
+	return errs, nil, nil
+}
+
+// unsentItems returns the keys of the items of the chunks and of the
+// duplicates skipped for them, see unsentRecords.
+func (b *Batch) unsentItems(chunks [][]batchItem, deduped *dedupBatch) map[recordBatchKey]bool {
+	records := make([]*recordBatchRecord, 0, len(chunks))
+	for _, chunk := range chunks {
+		for _, item := range chunk {
+			records = append(records, &recordBatchRecord{entry: b.entryName, timestamp: item.ts})
+		}
+	}
+	return unsentRecords([][]*recordBatchRecord{records}, deduped)
+}
+
+// resolveConflicts writes the records that failed with 409 again according to the policy,
+// and adds the records moved by ConflictShift to shifted.
+func (b *Batch) resolveConflicts(ctx context.Context, policy ConflictPolicy, items []batchItem, errs ErrorMap,
+	shifted map[string]map[int64]int64,
+) error {
+	conflicts := map[recordBatchKey]*recordBatchRecord{}
+	occupied := map[int64]bool{}
+	for _, item := range items {
+		occupied[item.ts] = true
+		if recordErr, failed := errs[item.ts]; failed && isConflict(recordErr) {
+			conflicts[recordBatchKey{entry: b.entryName, ts: item.ts}] = b.asRecordBatchRecord(item.ts, item.record)
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	send := func(batchType BatchType) func(context.Context, map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error) {
+		return func(ctx context.Context, records map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error) {
+			batch := newBatch(b.bucketName, b.entryName, b.httpClient, batchType)
+			for key, rec := range records {
+				if batchType == BatchRemove {
+					batch.AddOnlyTimestamp(key.ts)
+				} else {
+					batch.records[key.ts] = rec.asRecord()
+				}
 			}
+			batchErrs, err := batch.Write(ctx)
+			return RecordBatchErrorMap{b.entryName: batchErrs}, err
 		}
-		headers.Set(header, headerValue)
-		chunks.Write(rec.Data)
 	}
+	err := resolveConflicts(ctx, policy, b.timestamps, conflicts,
+		func(key recordBatchKey) bool { return occupied[key.ts] },
+		send(BatchWrite), send(BatchRemove), RecordBatchErrorMap{b.entryName: errs}, shifted)
 
-	b.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	maps.Copy(b.shifted, shifted[b.entryName])
+	return err
+}
+
+// dedupRecords returns the records to write without the skipped duplicates,
+// see DedupOptions; the caller holds mu.
+func (b *Batch) dedupRecords(dedup *DedupOptions) (map[int64]*Record, *dedupBatch) {
+	sorted := make([]*recordBatchRecord, 0, len(b.records))
+	for ts, rec := range b.records {
+		sorted = append(sorted, b.asRecordBatchRecord(ts, rec))
+	}
+	sortRecordBatchItems(sorted)
+	deduped := dedup.dedup(b.bucketName, sorted)
+	records := make(map[int64]*Record, len(deduped.written))
+	for _, rec := range deduped.written {
+		records[rec.timestamp] = rec.asRecord()
+	}
+	return records, deduped
+}
+
+// finishDedup updates the index with the written records and adds the
+// duplicates to Deduplicated, see dedupBatch.finish. It does nothing without
+// deduplication.
+func (b *Batch) finishDedup(ctx context.Context, deduped *dedupBatch, errs ErrorMap, shifted map[int64]int64) {
+	if deduped == nil {
+		return
+	}
+	deduplicated := deduped.finish(ctx, b.httpClient, RecordBatchErrorMap{b.entryName: errs},
+		map[string]map[int64]int64{b.entryName: shifted})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	maps.Copy(b.deduplicated, deduplicated[b.entryName])
+}
+
+// spoolChunks appends the items of the chunks that were not sent to the
+// spool, see Spool.spoolChunks.
+func (b *Batch) spoolChunks(ctx context.Context, spool *Spool, chunks [][]batchItem, failed error, errs ErrorMap) error {
+	records := make([][]*recordBatchRecord, len(chunks))
+	for i, chunk := range chunks {
+		for _, item := range chunk {
+			records[i] = append(records[i], b.asRecordBatchRecord(item.ts, item.record))
+		}
+	}
+	spoolErrs := RecordBatchErrorMap{}
+	if err := spool.spoolChunks(ctx, b.bucketName, records, failed, spoolErrs); err != nil {
+		return err
+	}
+	maps.Copy(errs, spoolErrs[b.entryName])
+	return nil
+}
+
+// asRecordBatchRecord converts a record of the batch for the code shared with RecordBatch.
+func (b *Batch) asRecordBatchRecord(ts int64, rec *Record) *recordBatchRecord {
+	return &recordBatchRecord{
+		entry:       b.entryName,
+		timestamp:   ts,
+		data:        rec.Data,
+		contentType: rec.ContentType,
+		labels:      rec.Labels,
+		addErr:      rec.addErr,
+		stream:      rec.stream,
+	}
+}
+
+// headerValue returns the value of the x-reduct-time-* header of a record.
+func (b *Batch) headerValue(rec *Record) (string, error) {
+	if rec.addErr != nil {
+		return "", rec.addErr
+	}
+	headerValue := "0,"
+	if b.batchType == BatchWrite {
+		headerValue = fmt.Sprintf("%d,%s", rec.size(), rec.ContentType)
+	}
+	labels, err := normalizeLabels(rec.Labels)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range labels {
+		headerValue += fmt.Sprintf(",%s=%s", k, v)
+	}
+	return headerValue, nil
+}
+
+// writeChunk sends the items in one request and adds their per-record errors to errs.
+// Items whose headers exceed the limit are sent in halves.
+func (b *Batch) writeChunk(ctx context.Context, span telemetry.Span, limits httpclient.BatchLimits, items []batchItem, errs ErrorMap) error {
+	headers := http.Header{}
+	var contentLength int64
+	for _, item := range items {
+		headers.Set(fmt.Sprintf("x-reduct-time-%d", item.ts), item.header)
+		contentLength += item.record.size()
+	}
+	if len(items) > 1 && headerSize(headers) > limits.MaxHeaderSize {
+		half := len(items) / 2
+		if err := b.writeChunk(ctx, span, limits, items[:half], errs); err != nil {
+			return err
+		}
+		return b.writeChunk(ctx, span, limits, items[half:], errs)
+	}
+
+	metrics := httpclient.MetricsOf(b.httpClient)
+	observeBatch(metrics, "Batch.Write", len(items), contentLength)
 
 	var req *http.Request
 	var err error
 	path := fmt.Sprintf("/b/%s/%s/batch", b.bucketName, b.entryName)
-
 	switch b.batchType {
 	case BatchWrite:
-		req, err = b.httpClient.NewRequestWithContext(ctx, http.MethodPost, path, &chunks)
+		payloads := make([]batchPayload, 0, len(items))
+		for _, item := range items {
+			payloads = append(payloads, batchPayload{
+				data:   item.record.Data,
+				stream: item.record.stream,
+				record: fmt.Sprintf("%s/%d", b.entryName, item.ts),
+			})
+		}
+		body, getBody := newPayloadBody(payloads)
+		req, err = b.httpClient.NewRequestWithContext(ctx, http.MethodPost, path, body)
+		if err != nil {
+			return err
+		}
 		req.Header = headers
 		req.Header.Set("Content-Type", "application/octet-stream")
-		req.Header.Set("Content-Length", strconv.Itoa(chunks.Len()))
+		req.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		req.ContentLength = contentLength
+		// The body is replayed when the request is retried, unless a payload is streamed.
+		req.GetBody = getBody
 	case BatchUpdate:
 		req, err = b.httpClient.NewRequestWithContext(ctx, http.MethodPatch, path, nil)
+		if err != nil {
+			return err
+		}
 		req.Header = headers
 	case BatchRemove:
 		req, err = b.httpClient.NewRequestWithContext(ctx, http.MethodDelete, path, nil)
+		if err != nil {
+			return err
+		}
 		req.Header = headers
-	default:
-		return nil, errors.New("invalid batch type")
-	}
-
-	if err != nil {
-		return nil, err
 	}
 
 	resp, err := b.httpClient.Do(req)
+	setSpanStatus(span, resp)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	errs := ErrorMap{}
 	for key, val := range resp.Header {
 		lowerKey := strings.ToLower(key)
 		if strings.HasPrefix(lowerKey, "x-reduct-error-") {
@@ -176,7 +567,11 @@ func (b *Batch) Write(ctx context.Context) (ErrorMap, error) {
 			}
 		}
 	}
-	return errs, nil
+
+	if b.batchType == BatchWrite {
+		observeWritten(metrics, "Batch.Write", contentLength)
+	}
+	return nil
 }
 
 // Size returns the total size of the batch.
@@ -205,6 +600,9 @@ func (b *Batch) Clear() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.records = make(map[int64]*Record)
+	b.duplicates = map[int64]bool{}
+	b.shifted = map[int64]int64{}
+	b.deduplicated = map[int64]int64{}
 	b.totalSize = 0
 	b.lastAccess = time.Time{}
 }
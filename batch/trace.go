@@ -0,0 +1,49 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+// startBatchSpan starts the span of one batch fetch of a query.
+func startBatchSpan(ctx context.Context, client httpclient.HTTPClient, bucketName, entry string, id int64) (context.Context, telemetry.Span) {
+	attrs := []telemetry.Attribute{
+		telemetry.String(telemetry.AttrBucket, bucketName),
+		telemetry.Int64(telemetry.AttrQueryID, id),
+	}
+	if entry != "" {
+		attrs = append(attrs, telemetry.String(telemetry.AttrEntry, entry))
+	}
+	return httpclient.TracerOf(client).Start(ctx, "ReadBatch", attrs...)
+}
+
+// endBatchSpan finishes the span of a batch fetch. An empty batch (204) is the
+// regular end of a query and is not reported as an error.
+func endBatchSpan(span telemetry.Span, records []*Record, err error) {
+	var size int64
+	for _, rec := range records {
+		size += rec.Size
+	}
+	span.SetAttributes(
+		telemetry.Int(telemetry.AttrRecordCount, len(records)),
+		telemetry.Int64(telemetry.AttrBytes, size),
+	)
+
+	var apiErr model.APIError
+	if err != nil && (!errors.As(err, &apiErr) || apiErr.Status != http.StatusNoContent) {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// setStatus records the HTTP status of the response on the span.
+func setStatus(span telemetry.Span, resp *http.Response) {
+	if resp != nil {
+		span.SetAttributes(telemetry.Int(telemetry.AttrHTTPStatus, resp.StatusCode))
+	}
+}
@@ -0,0 +1,127 @@
+package batch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]any
+	err    error
+	ended  bool
+}
+
+type spanKey struct{}
+
+// recordingTracer keeps every span in memory for assertions.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...telemetry.Attribute) (context.Context, telemetry.Span) {
+	span := &recordedSpan{name: name, attrs: map[string]any{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	for _, attr := range attrs {
+		span.attrs[attr.Key] = attr.Value
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), &recordingSpan{tracer: t, span: span}
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...telemetry.Attribute) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, attr := range attrs {
+		s.span.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.err = err
+}
+
+func (s *recordingSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.ended = true
+}
+
+func TestFetchAndParse_SpanPerBatch(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		switch requests.Add(1) {
+		case 1:
+			w.Header().Set("x-reduct-time-100", "5,text/plain")
+			w.Header().Set("x-reduct-time-200", "5,text/plain")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte("helloworld"))
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	client := httpclient.NewHTTPClient(httpclient.Option{BaseURL: server.URL, Timeout: time.Second, Tracer: tracer})
+
+	ctx, parent := tracer.Start(context.Background(), "Bucket.Query")
+	records, errCh, err := FetchAndParse(ctx, client, "bucket", "entry", 7, false, time.Second, false)
+	require.NoError(t, err)
+	for rec := range records {
+		require.NoError(t, rec.Body.Close())
+	}
+	for range errCh { //nolint:revive // drain
+	}
+	parent.End()
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	require.Len(t, tracer.spans, 3)
+
+	first := tracer.spans[1]
+	assert.Equal(t, "ReadBatch", first.name)
+	assert.Equal(t, "Bucket.Query", first.parent)
+	assert.True(t, first.ended)
+	assert.NoError(t, first.err)
+	assert.Equal(t, map[string]any{
+		telemetry.AttrBucket:      "bucket",
+		telemetry.AttrEntry:       "entry",
+		telemetry.AttrQueryID:     int64(7),
+		telemetry.AttrRecordCount: int64(2),
+		telemetry.AttrBytes:       int64(10),
+		telemetry.AttrHTTPStatus:  int64(http.StatusOK),
+	}, first.attrs)
+
+	// The final empty batch ends the query and is not an error.
+	last := tracer.spans[2]
+	assert.Equal(t, "Bucket.Query", last.parent)
+	assert.True(t, last.ended)
+	assert.NoError(t, last.err)
+	assert.Equal(t, int64(http.StatusNoContent), last.attrs[telemetry.AttrHTTPStatus])
+}
@@ -32,6 +32,7 @@ type Record struct {
 // immediately as a normal error. Any error in subsequent batches is sent to
 // the returned error channel, which is closed when streaming ends.
 func FetchAndParse(ctx context.Context, client httpclient.HTTPClient, bucketName, entry string, id int64, continueQuery bool, pollInterval time.Duration, head bool) (<-chan *Record, <-chan error, error) { //nolint:gocritic // directional channels cannot be named returns
+	ctx = httpclient.WithOperation(ctx, queryOperation(ctx, bucketName, entry, id))
 	firstBatch, err := readBatchedRecords(ctx, client, bucketName, entry, id, head)
 	if err != nil {
 		var apiErr model.APIError
@@ -71,13 +72,8 @@ func FetchAndParse(ctx context.Context, client httpclient.HTTPClient, bucketName
 			if err != nil {
 				var apiErr model.APIError
 				if errors.As(err, &apiErr) && apiErr.Status == http.StatusNoContent {
-					if continueQuery {
-						select {
-						case <-ctx.Done():
-							return
-						case <-time.After(pollInterval):
-							continue
-						}
+					if continueQuery && waitForRecords(ctx, client, bucketName, id, pollInterval) {
+						continue
 					}
 					return
 				}
@@ -105,6 +101,34 @@ func FetchAndParse(ctx context.Context, client httpclient.HTTPClient, bucketName
 	return records, errCh, nil
 }
 
+// queryOperation describes the batch reads of a query. It keeps the name of the
+// SDK call that started the query, if any, and adds the query ID.
+func queryOperation(ctx context.Context, bucketName, entry string, id int64) httpclient.Operation {
+	op, ok := httpclient.OperationFromContext(ctx)
+	if !ok {
+		op.Name = "ReadBatch"
+	}
+	op.Bucket = bucketName
+	if entry != "" {
+		op.Entry = entry
+	}
+	op.QueryID = id
+	return op
+}
+
+// waitForRecords waits one poll interval of a continuous query that has no new
+// records. It returns false if the context is done first.
+func waitForRecords(ctx context.Context, client httpclient.HTTPClient, bucketName string, id int64, pollInterval time.Duration) bool {
+	httpclient.LoggerOf(client).DebugContext(ctx, "no new records, polling query",
+		"bucket", bucketName, "query_id", id, "interval", pollInterval)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(pollInterval):
+		return true
+	}
+}
+
 // CSVRowResult represents the parsed result of a CSV row.
 type CSVRowResult struct {
 	Size        int64  `json:"size"`
@@ -246,10 +270,12 @@ func collectTimeHeaders(headers http.Header) ([]timeHeader, error) {
 // buffered payloads of a batch share a single allocation, and the Record and
 // reader values are carved out of one backing array each, so per-record
 // allocation is limited to the label map.
-func readBatchedRecords(ctx context.Context, client httpclient.HTTPClient, bucketName, entry string, id int64, head bool) ([]*Record, error) {
+func readBatchedRecords(ctx context.Context, client httpclient.HTTPClient, bucketName, entry string, id int64, head bool) (batch []*Record, err error) {
+	ctx, span := startBatchSpan(ctx, client, bucketName, entry, id)
+	defer func() { endBatchSpan(span, batch, err) }()
+
 	path := fmt.Sprintf("/b/%s/%s/batch?q=%d", bucketName, entry, id)
 	var req *http.Request
-	var err error
 	if head {
 		req, err = client.NewRequestWithContext(ctx, http.MethodHead, path, nil)
 	} else {
@@ -265,6 +291,7 @@ func readBatchedRecords(ctx context.Context, client httpclient.HTTPClient, bucke
 	// The response body is handed to the last record of the batch, which the
 	// caller drains; every other exit path closes it explicitly.
 	resp, err := client.Do(req)
+	setStatus(span, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +42,7 @@ type parsedHeader struct {
 // are returned immediately as a normal error. Any error occurring in subsequent
 // batches is sent to the returned error channel, which is closed when streaming ends.
 func FetchAndParseV2(ctx context.Context, client httpclient.HTTPClient, bucketName string, id int64, continueQuery bool, pollInterval time.Duration, head bool) (<-chan *Record, <-chan error, error) { //nolint:gocritic // directional channels cannot be named returns
+	ctx = httpclient.WithOperation(ctx, queryOperation(ctx, bucketName, "", id))
 	firstBatch, err := readBatchedRecordsV2(ctx, client, bucketName, id, head)
 	if err != nil {
 		var apiErr model.APIError
@@ -81,13 +82,8 @@ func FetchAndParseV2(ctx context.Context, client httpclient.HTTPClient, bucketNa
 			if err != nil {
 				var apiErr model.APIError
 				if errors.As(err, &apiErr) && apiErr.Status == http.StatusNoContent {
-					if continueQuery {
-						select {
-						case <-ctx.Done():
-							return
-						case <-time.After(pollInterval):
-							continue
-						}
+					if continueQuery && waitForRecords(ctx, client, bucketName, id, pollInterval) {
+						continue
 					}
 					return
 				}
@@ -96,13 +92,8 @@ func FetchAndParseV2(ctx context.Context, client httpclient.HTTPClient, bucketNa
 			}
 
 			if len(batch) == 0 {
-				if continueQuery {
-					select {
-					case <-ctx.Done():
-						return
-					case <-time.After(pollInterval):
-						continue
-					}
+				if continueQuery && waitForRecords(ctx, client, bucketName, id, pollInterval) {
+					continue
 				}
 				return
 			}
@@ -126,11 +117,13 @@ func FetchAndParseV2(ctx context.Context, client httpclient.HTTPClient, bucketNa
 // readBatchedRecordsV2 fetches one batch of records for a query using Batch
 // Protocol v2. Like the v1 reader it buffers every record but the last in a
 // single allocation and streams the last one straight off the response body.
-func readBatchedRecordsV2(ctx context.Context, client httpclient.HTTPClient, bucketName string, id int64, head bool) ([]*Record, error) {
+func readBatchedRecordsV2(ctx context.Context, client httpclient.HTTPClient, bucketName string, id int64, head bool) (batch []*Record, err error) {
+	ctx, span := startBatchSpan(ctx, client, bucketName, "", id)
+	defer func() { endBatchSpan(span, batch, err) }()
+
 	path := fmt.Sprintf("/io/%s/read", bucketName)
 
 	var req *http.Request
-	var err error
 	if head {
 		req, err = client.NewRequestWithContext(ctx, http.MethodHead, path, nil)
 	} else {
@@ -147,6 +140,7 @@ func readBatchedRecordsV2(ctx context.Context, client httpclient.HTTPClient, buc
 	// The response body is handed to the last record of the batch, which the
 	// caller drains; every other exit path closes it explicitly.
 	resp, err := client.Do(req)
+	setStatus(span, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -179,3 +179,38 @@ func TestFetchAndParseV2_SubsequentBatchError(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "mid-stream failure")
 }
+
+func TestFetchAndParseV2_OperationSeenByMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.3")
+		w.Header().Set("x-reduct-entries", "entry")
+		w.Header().Set("x-reduct-start-ts", "100")
+		w.Header().Set("x-reduct-0-0", "5,text/plain")
+		w.Header().Set("x-reduct-last", "true")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("hello"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var operations []httpclient.Operation
+	client := httpclient.NewHTTPClient(httpclient.Option{
+		BaseURL: server.URL,
+		Timeout: time.Second,
+		Middlewares: []httpclient.Middleware{httpclient.RequestHook(func(req *http.Request) error {
+			op, _ := httpclient.OperationFromContext(req.Context())
+			operations = append(operations, op)
+			return nil
+		})},
+	})
+
+	ctx := httpclient.WithOperation(context.Background(), httpclient.Operation{Name: "Bucket.QueryMany", Bucket: "bucket"})
+	records, _, err := FetchAndParseV2(ctx, client, "bucket", 42, false, time.Second, false)
+	require.NoError(t, err)
+	for rec := range records {
+		require.NoError(t, rec.Body.Close())
+	}
+
+	require.Len(t, operations, 1)
+	assert.Equal(t, httpclient.Operation{Name: "Bucket.QueryMany", Bucket: "bucket", QueryID: 42}, operations[0])
+}
@@ -5,31 +5,56 @@ import (
 	"time"
 
 	"github.com/reductstore/reduct-go/batch"
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
-func (b *Bucket) fetchAndParseBatchedRecords(ctx context.Context, entry string, id int64, continueQuery bool, pollInterval time.Duration, head bool) (*QueryResult, error) {
+func (b *Bucket) fetchAndParseBatchedRecords(ctx context.Context, span telemetry.Span, entry string, id int64, continueQuery bool, pollInterval time.Duration, head bool) (*QueryResult, error) {
 	records, errCh, err := batch.FetchAndParse(ctx, b.HTTPClient, b.Name, entry, id, continueQuery, pollInterval, head)
 	if err != nil {
+		endSpan(span, err)
 		return &QueryResult{}, err
 	}
 
-	return wrapBatchRecords(ctx, records, errCh), nil
+	return wrapBatchRecords(ctx, span, b.HTTPClient, b.keys(), head, records, errCh), nil
 }
 
-func (b *Bucket) fetchAndParseBatchedRecordsV2(ctx context.Context, id int64, continueQuery bool, pollInterval time.Duration, head bool) (*QueryResult, error) {
+func (b *Bucket) fetchAndParseBatchedRecordsV2(ctx context.Context, span telemetry.Span, id int64, continueQuery bool, pollInterval time.Duration, head bool) (*QueryResult, error) {
 	records, errCh, err := batch.FetchAndParseV2(ctx, b.HTTPClient, b.Name, id, continueQuery, pollInterval, head)
 	if err != nil {
+		endSpan(span, err)
 		return &QueryResult{}, err
 	}
 
-	return wrapBatchRecords(ctx, records, errCh), nil
+	return wrapBatchRecords(ctx, span, b.HTTPClient, b.keys(), head, records, errCh), nil
 }
 
-func wrapBatchRecords(ctx context.Context, records <-chan *batch.Record, errCh <-chan error) *QueryResult {
+// wrapBatchRecords converts parsed batch records into readable records. The
+// query span ends when the stream does, so it covers the query end to end.
+// Records are reported to the metrics as they are delivered, head-only records
+// without their size.
+func wrapBatchRecords(ctx context.Context, span telemetry.Span, client httpclient.HTTPClient, keys KeyProvider, head bool, records <-chan *batch.Record, errCh <-chan error) *QueryResult {
 	out := make(chan *ReadableRecord, 100)
 	outErrCh := make(chan error, 1)
+	metrics := httpclient.MetricsOf(client)
+
+	// dropped logs the records that were fetched but not delivered because the
+	// context was cancelled: the current one and those still buffered.
+	dropped := func(rec *batch.Record) {
+		httpclient.LoggerOf(client).DebugContext(ctx, "query cancelled, dropping fetched records",
+			"entry", rec.Entry, "timestamp", rec.Time, "dropped", 1+len(records), "error", ctx.Err())
+	}
 
 	go func() {
+		var count, size int64
+		var streamErr error
+		defer func() {
+			span.SetAttributes(
+				telemetry.Int64(telemetry.AttrRecordCount, count),
+				telemetry.Int64(telemetry.AttrBytes, size),
+			)
+			endSpan(span, streamErr)
+		}()
 		defer close(outErrCh)
 		defer close(out)
 		for rec := range records {
@@ -39,6 +64,7 @@ func wrapBatchRecords(ctx context.Context, records <-chan *batch.Record, errCh <
 
 			select {
 			case <-ctx.Done():
+				dropped(rec)
 				return
 			default:
 			}
@@ -48,13 +74,20 @@ func wrapBatchRecords(ctx context.Context, records <-chan *batch.Record, errCh <
 				labels = LabelMap(rec.Labels)
 			}
 
-			record := NewReadableRecord(rec.Entry, rec.Time, rec.Size, rec.Last, rec.Body, labels, rec.ContentType)
+			record := newReadableRecord(rec.Entry, rec.Time, rec.Size, rec.Last, rec.Body, labels, rec.ContentType, keys, head)
 			record.SetLastInBatch(rec.LastInBatch)
 
 			select {
 			case <-ctx.Done():
+				dropped(rec)
 				return
 			case out <- record:
+				count++
+				size += rec.Size
+				metrics.AddCounter(telemetry.MetricRecordsRead, 1)
+				if !head {
+					metrics.AddCounter(telemetry.MetricBytesRead, float64(rec.Size))
+				}
 				if record.IsLast() {
 					return
 				}
@@ -62,6 +95,7 @@ func wrapBatchRecords(ctx context.Context, records <-chan *batch.Record, errCh <
 		}
 
 		if err, ok := <-errCh; ok && err != nil {
+			streamErr = err
 			outErrCh <- err
 		}
 	}()
@@ -0,0 +1,42 @@
+package reductgo
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/reductstore/reduct-go/httpclient"
+)
+
+// splitBatch splits items, in their order, into chunks of at most
+// limits.MaxRecords items and limits.MaxSize payload bytes. An item larger
+// than MaxSize gets a chunk of its own. No items give one empty chunk, so an
+// empty batch still sends a request.
+func splitBatch[T any](items []T, limits httpclient.BatchLimits, size func(T) int64) [][]T {
+	var chunks [][]T
+	start := 0
+	var chunkSize int64
+	for i, item := range items {
+		itemSize := size(item)
+		if i > start && (i-start >= limits.MaxRecords || chunkSize+itemSize > limits.MaxSize) {
+			chunks = append(chunks, items[start:i])
+			start = i
+			chunkSize = 0
+		}
+		chunkSize += itemSize
+	}
+	return append(chunks, items[start:])
+}
+
+// headerSize returns the size of the x-reduct-* headers as they are sent over HTTP/1.1.
+func headerSize(headers http.Header) int {
+	size := 0
+	for name, values := range headers {
+		if !strings.HasPrefix(strings.ToLower(name), "x-reduct-") {
+			continue
+		}
+		for _, value := range values {
+			size += len(name) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
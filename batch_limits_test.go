@@ -0,0 +1,139 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+// batchRequests records the x-reduct-* headers of the batch requests of a client.
+type batchRequests struct {
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func (r *batchRequests) hook(req *http.Request) error {
+	if op, ok := httpclient.OperationFromContext(req.Context()); ok &&
+		(op.Name == "Batch.Write" || op.Name == "RecordBatch.Send") {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.headers = append(r.headers, req.Header.Clone())
+	}
+	return nil
+}
+
+func (r *batchRequests) all() []http.Header {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.headers
+}
+
+func newLimitedTestBucket(t *testing.T, serverLimits *model.ServerLimits, clientLimits httpclient.BatchLimits) (BucketAPI, *batchRequests) {
+	t.Helper()
+	server := reducttest.NewServer(reducttest.Options{Limits: serverLimits})
+	t.Cleanup(server.Close)
+
+	requests := &batchRequests{}
+	client := NewClient(server.URL, ClientOptions{
+		BatchLimits: clientLimits,
+		Middlewares: []httpclient.Middleware{httpclient.RequestHook(requests.hook)},
+	})
+	info, err := client.GetInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, serverLimits, info.Limits)
+
+	bucket, err := client.CreateBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return bucket, requests
+}
+
+func TestBatchSplitByServerLimits(t *testing.T) {
+	ctx := context.Background()
+	bucket, requests := newLimitedTestBucket(t, &model.ServerLimits{BatchMaxRecords: 10}, httpclient.BatchLimits{})
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	for ts := int64(35); ts > 0; ts-- {
+		batch.Add(ts, []byte("data"), "", LabelMap{"n": strconv.FormatInt(ts, 10)})
+	}
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	sent := requests.all()
+	require.Len(t, sent, 4)
+	last := int64(0)
+	for _, headers := range sent {
+		var timestamps []int64
+		for name := range headers {
+			if tsRaw, ok := strings.CutPrefix(strings.ToLower(name), "x-reduct-time-"); ok {
+				ts, err := strconv.ParseInt(tsRaw, 10, 64)
+				require.NoError(t, err)
+				timestamps = append(timestamps, ts)
+			}
+		}
+		assert.LessOrEqual(t, len(timestamps), 10)
+		assert.Greater(t, slices.Min(timestamps), last, "records are sent in timestamp order")
+		last = slices.Max(timestamps)
+	}
+
+	result, err := bucket.Query(ctx, "entry", nil)
+	require.NoError(t, err)
+	count := 0
+	for record := range result.Records() {
+		count++
+		assert.Equal(t, strconv.FormatInt(record.Time(), 10), record.Labels()["n"])
+	}
+	assert.Equal(t, 35, count)
+}
+
+func TestRecordBatchSplitMergesErrors(t *testing.T) {
+	ctx := context.Background()
+	limits := &model.ServerLimits{BatchMaxSize: 64, BatchMaxHeaderSize: 400}
+	bucket, requests := newLimitedTestBucket(t, limits, httpclient.BatchLimits{
+		MaxSize:       limits.BatchMaxSize,
+		MaxHeaderSize: limits.BatchMaxHeaderSize,
+	})
+	require.NoError(t, bucket.BeginWrite(ctx, "b", &WriteOptions{Timestamp: 20}).Write("data"))
+
+	batch := bucket.BeginWriteRecordBatch(ctx)
+	for ts := int64(1); ts <= 20; ts++ {
+		batch.Add("a", ts, []byte("0123456789"), "text/plain", LabelMap{"label": strings.Repeat("x", int(ts))})
+		batch.Add("b", ts, []byte("0123456789"), "text/plain", nil)
+	}
+	errs, err := batch.Send(ctx)
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+	require.Len(t, errs["b"], 1)
+	assert.Equal(t, http.StatusConflict, errs["b"][20].Status)
+
+	sent := requests.all()
+	assert.Greater(t, len(sent), 40*10/64, "the batch is split by size")
+	for _, headers := range sent {
+		assert.LessOrEqual(t, headerSize(headers), limits.BatchMaxHeaderSize)
+	}
+}
+
+func TestBatchExceedingServerLimits(t *testing.T) {
+	ctx := context.Background()
+	bucket, _ := newLimitedTestBucket(t, &model.ServerLimits{BatchMaxRecords: 10}, httpclient.BatchLimits{MaxRecords: 20})
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	for ts := int64(1); ts <= 20; ts++ {
+		batch.Add(ts, []byte("data"), "", nil)
+	}
+	_, err := batch.Write(ctx)
+	var apiErr *model.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.Status)
+}
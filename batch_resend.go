@@ -0,0 +1,257 @@
+package reductgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+)
+
+// RecordErrorClass is the kind of a per-record batch error.
+type RecordErrorClass int
+
+const (
+	// RecordErrorPermanent fails again when the record is resent, e.g. 400 or 422.
+	RecordErrorPermanent RecordErrorClass = iota
+	// RecordErrorRetryable is transient, e.g. 5xx, 429 or a timeout.
+	RecordErrorRetryable
+	// RecordErrorConflict is 409: the entry already has a record with the
+	// timestamp. It is a duplicate if the stored content is the same.
+	RecordErrorConflict
+)
+
+// ClassifyRecordError returns the class of a per-record error from an ErrorMap.
+func ClassifyRecordError(err model.APIError) RecordErrorClass {
+	switch {
+	case err.Status == http.StatusConflict:
+		return RecordErrorConflict
+	case err.Status >= http.StatusInternalServerError || model.IsRetryableStatus(err.Status):
+		return RecordErrorRetryable
+	default:
+		return RecordErrorPermanent
+	}
+}
+
+// WriteReport tells which records of a batch landed after WriteWithRetry or
+// SendWithRetry. Timestamps are grouped by entry and sorted.
+type WriteReport struct {
+	// Written are the records the server accepted.
+	Written map[string][]int64
+	// Duplicates are the records the server already had with the same content.
+	Duplicates map[string][]int64
+	// Failed are the records that did not land, with their last error: a
+	// permanent error, a conflict with other content or a retryable error
+	// that persisted until the retries were exhausted. A record dropped with
+	// ConflictFail because it was added at the timestamp of another one is
+	// reported under the timestamp, like by Write and Send.
+	Failed RecordBatchErrorMap
+	// Attempts is the number of times the batch was sent.
+	Attempts int
+}
+
+// OK reports whether all records landed.
+func (r *WriteReport) OK() bool {
+	return len(r.Failed) == 0
+}
+
+func (r *WriteReport) fail(key recordBatchKey, err model.APIError) {
+	if r.Failed[key.entry] == nil {
+		r.Failed[key.entry] = ErrorMap{}
+	}
+	r.Failed[key.entry][key.ts] = err
+}
+
+func (r *WriteReport) sort() {
+	for _, timestamps := range r.Written {
+		slices.Sort(timestamps)
+	}
+	for _, timestamps := range r.Duplicates {
+		slices.Sort(timestamps)
+	}
+}
+
+// WriteWithRetry writes the batch and resends only the records that failed
+// with a retryable error, with the backoff of the policy, until they land or
+// policy.MaxAttempts is reached. A record that fails with 409 counts as a
+// duplicate if the stored record has the same content. The batch itself is
+// not changed. Records added with AddReader are sent once; they are reported
+// as failed if they would have to be resent.
+//
+// The returned error is that of the last attempt if the whole request
+// failed; the report is returned in any case.
+func (b *Batch) WriteWithRetry(ctx context.Context, policy httpclient.RetryPolicy) (*WriteReport, error) {
+	b.mu.Lock()
+	pending := make(map[recordBatchKey]*recordBatchRecord, len(b.records))
+	for ts, rec := range b.records {
+		pending[recordBatchKey{entry: b.entryName, ts: ts}] = b.asRecordBatchRecord(ts, rec)
+	}
+	duplicates := make(map[recordBatchKey]bool, len(b.duplicates))
+	for ts := range b.duplicates {
+		duplicates[recordBatchKey{entry: b.entryName, ts: ts}] = true
+	}
+	b.mu.Unlock()
+
+	send := func(ctx context.Context, records map[recordBatchKey]*recordBatchRecord,
+	) (RecordBatchErrorMap, map[recordBatchKey]bool, error) {
+		batch := newBatch(b.bucketName, b.entryName, b.httpClient, b.batchType)
+		for key, rec := range records {
+			batch.records[key.ts] = rec.asRecord()
+		}
+		errs, unsent, err := batch.write(ctx)
+		return RecordBatchErrorMap{b.entryName: errs}, unsent, err
+	}
+	return resendBatch(ctx, b.httpClient, b.bucketName, b.batchType, policy, pending, duplicates, send)
+}
+
+// SendWithRetry sends the batch and resends only the records that failed
+// with a retryable error, like Batch.WriteWithRetry.
+func (b *RecordBatch) SendWithRetry(ctx context.Context, policy httpclient.RetryPolicy) (*WriteReport, error) {
+	b.mu.Lock()
+	pending := maps.Clone(b.records)
+	duplicates := maps.Clone(b.duplicates)
+	b.mu.Unlock()
+
+	send := func(ctx context.Context, records map[recordBatchKey]*recordBatchRecord,
+	) (RecordBatchErrorMap, map[recordBatchKey]bool, error) {
+		batch := newRecordBatch(b.bucketName, b.httpClient, b.batchType)
+		batch.records = records
+		return batch.send(ctx)
+	}
+	return resendBatch(ctx, b.httpClient, b.bucketName, b.batchType, policy, pending, duplicates, send)
+}
+
+// resendBatch sends the pending records until none of them has a retryable
+// error left. duplicates are the records dropped by ConflictFail when they
+// were added. send returns the records it did not send if it fails, see
+// RecordBatch.send.
+func resendBatch(ctx context.Context, client httpclient.HTTPClient, bucketName string, batchType BatchType,
+	policy httpclient.RetryPolicy, pending map[recordBatchKey]*recordBatchRecord, duplicates map[recordBatchKey]bool,
+	send func(context.Context, map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, map[recordBatchKey]bool, error),
+) (*WriteReport, error) {
+	report := &WriteReport{
+		Written:    map[string][]int64{},
+		Duplicates: map[string][]int64{},
+		Failed:     RecordBatchErrorMap{},
+	}
+	defer func() {
+		for key := range duplicates {
+			if _, failed := report.Failed[key.entry][key.ts]; !failed {
+				report.fail(key, duplicateTimestampError())
+			}
+		}
+		report.sort()
+	}()
+
+	started := time.Now()
+	lastErrs := map[recordBatchKey]model.APIError{}
+	for attempt := 1; ; attempt++ {
+		report.Attempts = attempt
+		errs, unsent, err := send(ctx, pending)
+
+		// The records of the requests sent before a failure have their
+		// results in errs; only the ones of the failed request and the
+		// requests after it are resent if the error is retryable.
+		var batchErr model.APIError
+		retryable := false
+		if err != nil {
+			batchErr = toAPIError(err)
+			retryable = ClassifyRecordError(batchErr) == RecordErrorRetryable && ctx.Err() == nil
+		}
+		retry := map[recordBatchKey]*recordBatchRecord{}
+		for key, rec := range pending {
+			recordErr, failed := errs[key.entry][key.ts]
+			if err != nil && unsent[key] {
+				recordErr, failed = batchErr, true
+			}
+			if !failed {
+				report.Written[key.entry] = append(report.Written[key.entry], key.ts)
+				continue
+			}
+			switch ClassifyRecordError(recordErr) {
+			case RecordErrorRetryable:
+				// Streamed payloads have been consumed and cannot be resent.
+				if rec.stream != nil {
+					report.fail(key, recordErr)
+					continue
+				}
+				retry[key] = rec
+				lastErrs[key] = recordErr
+			case RecordErrorConflict:
+				if batchType == BatchWrite && isDuplicate(ctx, client, bucketName, rec) {
+					report.Duplicates[key.entry] = append(report.Duplicates[key.entry], key.ts)
+				} else {
+					report.fail(key, recordErr)
+				}
+			default:
+				report.fail(key, recordErr)
+			}
+		}
+		if err != nil && !retryable {
+			for key := range retry {
+				report.fail(key, lastErrs[key])
+			}
+			return report, err
+		}
+
+		if len(retry) == 0 {
+			return report, nil
+		}
+		delay := policy.Backoff(attempt)
+		if attempt >= policy.MaxAttempts || (policy.MaxElapsed > 0 && time.Since(started)+delay > policy.MaxElapsed) {
+			for key := range retry {
+				report.fail(key, lastErrs[key])
+			}
+			return report, err
+		}
+
+		httpclient.LoggerOf(client).DebugContext(ctx, "resending failed batch records",
+			"bucket", bucketName, "records", len(retry), "attempt", attempt+1, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			for key := range retry {
+				report.fail(key, lastErrs[key])
+			}
+			return report, ctx.Err()
+		case <-timer.C:
+		}
+		pending = retry
+	}
+}
+
+// isDuplicate reports whether the entry already stores the record with the same content.
+// A streamed payload is not kept, so it is never known to be a duplicate.
+func isDuplicate(ctx context.Context, client httpclient.HTTPClient, bucketName string, rec *recordBatchRecord) bool {
+	if rec.stream != nil {
+		return false
+	}
+	ts := rec.timestamp
+	stored, err := newBucket(bucketName, client).BeginRead(ctx, rec.entry, &ts)
+	if err != nil {
+		return false
+	}
+	// Compare the stored payload, which is compressed or encrypted like rec.data.
+	data, err := io.ReadAll(stored.raw)
+	return err == nil && bytes.Equal(data, rec.data)
+}
+
+// toAPIError returns the APIError of a failed request.
+func toAPIError(err error) model.APIError {
+	var apiErr *model.APIError
+	if errors.As(err, &apiErr) {
+		return *apiErr
+	}
+	var apiErrValue model.APIError
+	if errors.As(err, &apiErrValue) {
+		return apiErrValue
+	}
+	return model.APIError{Status: model.StatusUnknown, Message: err.Error(), Original: err}
+}
@@ -0,0 +1,210 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+var testResendPolicy = httpclient.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+// newFlakyTestBucket serves a bucket from an in-memory server through fail,
+// which handles a batch request itself when it returns true.
+func newFlakyTestBucket(t *testing.T, fail func(attempt int, w http.ResponseWriter, r *http.Request) bool) BucketAPI {
+	t.Helper()
+	return newFlakyLimitedTestBucket(t, httpclient.BatchLimits{}, fail)
+}
+
+// newFlakyLimitedTestBucket is newFlakyTestBucket with the batch limits of the client.
+func newFlakyLimitedTestBucket(t *testing.T, limits httpclient.BatchLimits,
+	fail func(attempt int, w http.ResponseWriter, r *http.Request) bool,
+) BucketAPI {
+	t.Helper()
+	backend := reducttest.NewUnstartedServer(reducttest.Options{})
+	t.Cleanup(backend.Close)
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isBatch := strings.HasSuffix(r.URL.Path, "/batch") || strings.Contains(r.URL.Path, "/io/")
+		if isBatch && r.Method != http.MethodGet && fail(int(attempts.Add(1)), w, r) {
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	bucket, err := NewClient(server.URL, ClientOptions{BatchLimits: limits}).CreateBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return bucket
+}
+
+func TestClassifyRecordError(t *testing.T) {
+	tests := map[int]RecordErrorClass{
+		http.StatusBadRequest:          RecordErrorPermanent,
+		http.StatusUnprocessableEntity: RecordErrorPermanent,
+		http.StatusConflict:            RecordErrorConflict,
+		http.StatusTooManyRequests:     RecordErrorRetryable,
+		http.StatusInternalServerError: RecordErrorRetryable,
+		http.StatusServiceUnavailable:  RecordErrorRetryable,
+		model.StatusTimeout:            RecordErrorRetryable,
+		model.StatusConnectionError:    RecordErrorRetryable,
+	}
+	for status, class := range tests {
+		assert.Equal(t, class, ClassifyRecordError(model.APIError{Status: status}), "status %d", status)
+	}
+}
+
+func TestBatchWriteWithRetry(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFlakyTestBucket(t, func(attempt int, w http.ResponseWriter, r *http.Request) bool {
+		if attempt == 1 {
+			// The server loses the last record of the first attempt.
+			r.Header.Del("x-reduct-time-4")
+			w.Header().Set("x-reduct-error-4", "500,Internal error")
+		}
+		return false
+	})
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("same"))
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 2}).Write("other"))
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("same"), "", nil)
+	batch.Add(2, []byte("new"), "", nil)
+	batch.Add(3, []byte("three"), "", nil)
+	batch.Add(4, []byte("four"), "", nil)
+	report, err := batch.WriteWithRetry(ctx, testResendPolicy)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Attempts)
+	assert.Equal(t, map[string][]int64{"entry": {3, 4}}, report.Written)
+	assert.Equal(t, map[string][]int64{"entry": {1}}, report.Duplicates)
+	require.Len(t, report.Failed["entry"], 1)
+	assert.Equal(t, http.StatusConflict, report.Failed["entry"][2].Status)
+	assert.False(t, report.OK())
+	assert.Equal(t, 4, batch.RecordCount(), "the batch is not changed")
+
+	ts := int64(4)
+	record, err := bucket.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	data, err := record.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "four", string(data))
+}
+
+func TestWriteWithRetryAfterPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	bucket := newFlakyLimitedTestBucket(t, httpclient.BatchLimits{MaxRecords: 2},
+		func(attempt int, w http.ResponseWriter, r *http.Request) bool {
+			requests.Add(1)
+			switch attempt {
+			case 1:
+				r.Header.Del("x-reduct-time-2")
+				w.Header().Set("x-reduct-error-2", "422,Invalid record")
+			case 2:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return true
+			}
+			return false
+		})
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	for ts := int64(1); ts <= 4; ts++ {
+		batch.Add(ts, []byte("data"), "", nil)
+	}
+	report, err := batch.WriteWithRetry(ctx, testResendPolicy)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Attempts)
+	assert.Equal(t, int32(3), requests.Load(), "only the records of the failed request are resent")
+	assert.Equal(t, map[string][]int64{"entry": {1, 3, 4}}, report.Written)
+	assert.Empty(t, report.Duplicates)
+	require.Len(t, report.Failed["entry"], 1)
+	assert.Equal(t, http.StatusUnprocessableEntity, report.Failed["entry"][2].Status)
+}
+
+func TestRecordBatchSendWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("recovers", func(t *testing.T) {
+		bucket := newFlakyTestBucket(t, func(attempt int, w http.ResponseWriter, _ *http.Request) bool {
+			if attempt == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return true
+			}
+			return false
+		})
+		batch := bucket.BeginWriteRecordBatch(ctx)
+		batch.Add("a", 1, []byte("a"), "", nil)
+		batch.Add("b", 1, []byte("b"), "", nil)
+
+		report, err := batch.SendWithRetry(ctx, testResendPolicy)
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+		assert.Equal(t, 2, report.Attempts)
+		assert.Equal(t, map[string][]int64{"a": {1}, "b": {1}}, report.Written)
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		bucket := newFlakyTestBucket(t, func(_ int, w http.ResponseWriter, _ *http.Request) bool {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		})
+		batch := bucket.BeginWriteRecordBatch(ctx)
+		batch.Add("a", 1, []byte("a"), "", nil)
+
+		report, err := batch.SendWithRetry(ctx, testResendPolicy)
+		require.ErrorIs(t, err, model.ErrServer)
+		assert.Equal(t, 3, report.Attempts)
+		assert.Empty(t, report.Written)
+		assert.Equal(t, http.StatusServiceUnavailable, report.Failed["a"][1].Status)
+	})
+
+	t.Run("permanent", func(t *testing.T) {
+		bucket := newFlakyTestBucket(t, func(_ int, w http.ResponseWriter, _ *http.Request) bool {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return true
+		})
+		batch := bucket.BeginWriteRecordBatch(ctx)
+		batch.Add("a", 1, []byte("a"), "", nil)
+
+		report, err := batch.SendWithRetry(ctx, testResendPolicy)
+		require.ErrorIs(t, err, model.ErrUnprocessable)
+		assert.Equal(t, 1, report.Attempts)
+		assert.Equal(t, http.StatusUnprocessableEntity, report.Failed["a"][1].Status)
+	})
+}
+
+func TestRetryReportsDuplicateTimestamps(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("first"), "", nil)
+	batch.Add(1, []byte("second"), "", nil)
+	batch.Add(2, []byte("other"), "", nil)
+	report, err := batch.WriteWithRetry(ctx, testResendPolicy)
+	require.NoError(t, err)
+	assert.False(t, report.OK(), "the dropped record is reported")
+	assert.Equal(t, RecordBatchErrorMap{"entry": {1: duplicateTimestampError()}}, report.Failed)
+	assert.Equal(t, "first", readEntryRecord(t, bucket, "entry", 1))
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("other", 1, []byte("first"), "", nil)
+	recordBatch.Add("other", 1, []byte("second"), "", nil)
+	recordBatch.Add("more", 1, []byte("more"), "", nil)
+	report, err = recordBatch.SendWithRetry(ctx, testResendPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, RecordBatchErrorMap{"other": {1: duplicateTimestampError()}}, report.Failed)
+	assert.Equal(t, map[string][]int64{"other": {1}, "more": {1}}, report.Written)
+}
@@ -0,0 +1,112 @@
+package reductgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamedPayload is the payload of a batch record added with AddReader. It
+// is read when the batch is sent, so it can be sent only once.
+type streamedPayload struct {
+	reader io.Reader
+	size   int64
+}
+
+// readAll reads the payload into memory, e.g. to compress it.
+func (s *streamedPayload) readAll() ([]byte, error) {
+	data := make([]byte, s.size)
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		return nil, fmt.Errorf("read payload of declared size %d: %w", s.size, err)
+	}
+	return data, nil
+}
+
+// batchPayload is the payload of a batch record in a request body.
+type batchPayload struct {
+	data   []byte
+	stream *streamedPayload
+	// record names the record in the errors of a streamed payload.
+	record string
+}
+
+func (p batchPayload) reader() io.Reader {
+	if p.stream == nil {
+		return bytes.NewReader(p.data)
+	}
+	return &sizedReader{src: p.stream.reader, remaining: p.stream.size, record: p.record}
+}
+
+// newPayloadBody concatenates the payloads into one request body without
+// copying them. getBody replays the body for retries if all payloads are in
+// memory; it is nil if any payload is streamed.
+func newPayloadBody(payloads []batchPayload) (body io.Reader, getBody func() (io.ReadCloser, error)) {
+	readers := func() io.Reader {
+		chunks := make([]io.Reader, 0, len(payloads))
+		for _, payload := range payloads {
+			chunks = append(chunks, payload.reader())
+		}
+		return io.MultiReader(chunks...)
+	}
+	for _, payload := range payloads {
+		if payload.stream != nil {
+			return readers(), nil
+		}
+	}
+	return readers(), func() (io.ReadCloser, error) {
+		return io.NopCloser(readers()), nil
+	}
+}
+
+// ErrPayloadSize is returned when the reader of a streamed batch payload ends
+// before its declared size. The request is aborted, as the records after it
+// would be shifted.
+var ErrPayloadSize = errors.New("payload is shorter than its declared size")
+
+// sizedReader reads the declared size of a streamed payload and no more.
+type sizedReader struct {
+	src       io.Reader
+	remaining int64
+	record    string
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.src.Read(p)
+	r.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		return n, fmt.Errorf("record %s: %w (%d bytes missing)", r.record, ErrPayloadSize, r.remaining)
+	}
+	return n, err
+}
+
+// encodePayload compresses and encrypts the payload of a record added to a
+// write batch. A streamed payload is read into memory if either is set.
+func encodePayload(data []byte, stream *streamedPayload, labels LabelMap,
+	compression *CompressionOptions, encryption *EncryptionOptions,
+) ([]byte, *streamedPayload, LabelMap, error) {
+	if stream != nil {
+		if stream.size < 0 {
+			return nil, nil, nil, fmt.Errorf("negative payload size %d", stream.size)
+		}
+		if compression == nil && encryption == nil {
+			return nil, stream, labels, nil
+		}
+		var err error
+		if data, err = stream.readAll(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	data, labels, err := compression.compress(data, labels)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, labels, err = encryption.encrypt(data, labels)
+	return data, nil, labels, err
+}
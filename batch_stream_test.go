@@ -0,0 +1,170 @@
+package reductgo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// patternReader produces size bytes without holding them in memory.
+type patternReader struct {
+	remaining int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), r.remaining))
+	for i := range n {
+		p[i] = byte('a' + i%26)
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func TestBatchAddReader(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	path := filepath.Join(t.TempDir(), "frame.bin")
+	require.NoError(t, os.WriteFile(path, []byte("frame from a file"), 0o600))
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.AddReader(3, file, 17, "image/raw", LabelMap{"source": "file"})
+	batch.Add(2, []byte("in memory"), "", nil)
+	batch.AddReader(1, strings.NewReader("streamed and longer than declared"), 8, "", nil)
+	assert.Equal(t, int64(17+9+8), batch.Size())
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.AddReader("a", 1, strings.NewReader("first"), 5, "", nil)
+	recordBatch.Add("b", 1, []byte("second"), "", nil)
+	recordBatch.AddReader("b", 2, strings.NewReader("third"), 5, "", LabelMap{"n": 3})
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	for key, want := range map[recordBatchKey]string{
+		{"entry", 1}: "streamed",
+		{"entry", 2}: "in memory",
+		{"entry", 3}: "frame from a file",
+		{"a", 1}:     "first",
+		{"b", 1}:     "second",
+		{"b", 2}:     "third",
+	} {
+		ts := key.ts
+		record, err := bucket.BeginRead(ctx, key.entry, &ts)
+		require.NoError(t, err)
+		data, err := record.ReadAsString()
+		require.NoError(t, err)
+		assert.Equal(t, want, data, "%s/%d", key.entry, key.ts)
+	}
+
+	ts := int64(3)
+	record, err := bucket.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	assert.Equal(t, "image/raw", record.ContentType())
+	assert.Equal(t, "file", record.Labels()["source"])
+}
+
+func TestBatchAddReaderShortPayload(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.AddReader(1, strings.NewReader("short"), 10, "", nil)
+	batch.Add(2, []byte("data"), "", nil)
+	_, err := batch.Write(ctx)
+	require.ErrorIs(t, err, ErrPayloadSize)
+	require.ErrorContains(t, err, "entry/1")
+	assert.Zero(t, countEntryRecords(t, bucket, "entry"), "the request is aborted")
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.AddReader("entry", 1, strings.NewReader("data"), -1, "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, recordErrs["entry"][1].Message, "negative payload size")
+}
+
+func TestBatchAddReaderCompressed(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	payload := strings.Repeat("compressible ", 100)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetCompression(&CompressionOptions{Algorithm: CompressionGzip})
+	batch.AddReader(1, strings.NewReader(payload), int64(len(payload)), "", nil)
+	assert.Less(t, batch.Size(), int64(len(payload)), "the payload is read and compressed by AddReader")
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	record, err := bucket.BeginRead(ctx, "entry", nil)
+	require.NoError(t, err)
+	data, err := record.ReadAsString()
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+}
+
+func TestBatchAddReaderConstantMemory(t *testing.T) {
+	ctx := context.Background()
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body) //nolint:errcheck // the size is checked
+		received.Store(n)
+		w.Header().Set("X-Reduct-API", "v1.20")
+	}))
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, ClientOptions{}).(*ReductClient)
+	bucket := newBucket("bucket", client.HTTPClient)
+
+	const frames, frameSize = 16, 4 << 20
+	batch := bucket.BeginWriteBatch(ctx, "frames")
+	for ts := range int64(frames) {
+		batch.AddReader(ts+1, &patternReader{remaining: frameSize}, frameSize, "", nil)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, err := batch.Write(ctx)
+	require.NoError(t, err)
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, int64(frames*frameSize), received.Load())
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(frameSize), "the payloads are not buffered")
+}
+
+func TestRecordBatchSendWithRetryStreamed(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFlakyTestBucket(t, func(attempt int, w http.ResponseWriter, _ *http.Request) bool {
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	batch := bucket.BeginWriteRecordBatch(ctx)
+	batch.Add("a", 1, []byte("a"), "", nil)
+	batch.AddReader("b", 1, strings.NewReader("b"), 1, "", nil)
+
+	report, err := batch.SendWithRetry(ctx, testResendPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int64{"a": {1}}, report.Written)
+	assert.Equal(t, http.StatusServiceUnavailable, report.Failed["b"][1].Status, "a streamed payload is not resent")
+}
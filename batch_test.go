@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -102,8 +103,10 @@ func TestFetchAndParseBatchedRecords(t *testing.T) {
 	queryResult, err := mainTestBucket.executeQuery(ctx, "batch-test-entry", nil)
 	assert.NoError(t, err)
 	id := queryResult.ID
+	_, span := telemetry.NoopTracer().Start(ctx, "Bucket.Query")
 	fetchResult, err := mainTestBucket.fetchAndParseBatchedRecords(
 		ctx,
+		span,
 		"batch-test-entry",
 		id,
 		true,
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -12,22 +13,127 @@ import (
 
 	"github.com/reductstore/reduct-go/httpclient"
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
+// BucketAPI is a bucket of a ReductStore instance. It is implemented by *Bucket
+// and can be replaced by a mock, e.g. the one in the reductmock package.
+type BucketAPI interface {
+	// Get the name of the bucket
+	GetName() string
+	// Check if the bucket exists
+	CheckExists(ctx context.Context) (bool, error)
+	// Get the stats of the bucket
+	GetInfo(ctx context.Context) (model.BucketInfo, error)
+	// Get the stats of the entries
+	GetEntries(ctx context.Context) ([]model.EntryInfo, error)
+	// Get the settings, stats and entries of the bucket
+	GetFullInfo(ctx context.Context) (model.FullBucketDetail, error)
+	// Get the settings of the bucket
+	GetSettings(ctx context.Context) (model.BucketSetting, error)
+	// Update the settings of the bucket
+	SetSettings(ctx context.Context, settings model.BucketSetting) error
+	// Rename the bucket
+	Rename(ctx context.Context, newName string) error
+	// Remove the bucket
+	Remove(ctx context.Context) error
+	// Remove a record
+	RemoveRecord(ctx context.Context, entry string, ts int64) error
+	// Remove an entry
+	RemoveEntry(ctx context.Context, entry string) error
+	// Rename an entry
+	RenameEntry(ctx context.Context, entry, newName string) error
+	// Read a record
+	BeginRead(ctx context.Context, entry string, ts *int64) (*ReadableRecord, error)
+	// Read the metadata of a record
+	BeginMetadataRead(ctx context.Context, entry string, ts *int64) (*ReadableRecord, error)
+	// Start writing a record
+	BeginWrite(ctx context.Context, entry string, options *WriteOptions) *WritableRecord
+	// Start a batch of records to write to an entry
+	BeginWriteBatch(ctx context.Context, entry string) *Batch
+	// Start a batch of label updates in an entry
+	BeginUpdateBatch(ctx context.Context, entry string) *Batch
+	// Start a batch of records to remove from an entry
+	BeginRemoveBatch(ctx context.Context, entry string) *Batch
+	// Start a batch of records to write to several entries
+	BeginWriteRecordBatch(ctx context.Context) *RecordBatch
+	// Start a batch of label updates in several entries
+	BeginUpdateRecordBatch(ctx context.Context) *RecordBatch
+	// Start a buffered writer that flushes records of several entries in the background
+	BeginBufferedWrite(ctx context.Context, options *BufferedWriterOptions) *BufferedWriter
+	// Start a batch of records to remove from several entries
+	BeginRemoveRecordBatch(ctx context.Context) *RecordBatch
+	// Write a large object as chunks in several records of an entry
+	WriteObject(ctx context.Context, entry, objectID string, reader io.Reader, options *ObjectOptions) (ObjectInfo, error)
+	// Read an object written in chunks
+	ReadObject(ctx context.Context, entry, objectID string) (*ObjectReader, error)
+	// Query the records of an entry
+	Query(ctx context.Context, entry string, options *QueryOptions) (*QueryResult, error)
+	// Query the records of several entries
+	QueryMany(ctx context.Context, entries []string, options *QueryOptions) (*QueryResult, error)
+	// Remove the records of an entry that match a query
+	RemoveQuery(ctx context.Context, entry string, options *QueryOptions) (int64, error)
+	// Remove the records of several entries that match a query
+	RemoveQueryMany(ctx context.Context, entries []string, options *QueryOptions) (int64, error)
+	// Update the labels of a record
+	Update(ctx context.Context, entry string, ts int64, labels any) error
+	// Write attachments of an entry
+	WriteAttachments(ctx context.Context, entry string, attachments map[string]any) error
+	// Read the attachments of an entry
+	ReadAttachments(ctx context.Context, entry string) (map[string]any, error)
+	// Remove attachments of an entry
+	RemoveAttachments(ctx context.Context, entry string, attachmentKeys []string) error
+	// Create a link to a record of a query
+	CreateQueryLink(ctx context.Context, entry string, options QueryLinkOptions) (string, error)
+	// Create a link to a record of a query on several entries
+	CreateQueryLinkMany(ctx context.Context, entries []string, options QueryLinkOptions) (string, error)
+}
+
+var _ BucketAPI = (*Bucket)(nil)
+
 type Bucket struct {
 	HTTPClient httpclient.HTTPClient
 	Name       string
-}
-
-func newBucket(name string, httpClient httpclient.HTTPClient) Bucket {
-	return Bucket{
+	// Encryption encrypts the payloads written to the bucket and decrypts the
+	// ones read from it. It is set from ClientOptions.Encryption.
+	Encryption *EncryptionOptions
+	// Timestamps allocates the timestamps of records written without one. The
+	// buckets of a client with the same name share it.
+	Timestamps *TimestampAllocator
+	// Spool keeps the records of write batches that fail because the server
+	// is unreachable. It is set from ClientOptions.Spool.
+	Spool *Spool
+}
+
+func newBucket(name string, httpClient httpclient.HTTPClient) *Bucket {
+	return &Bucket{
 		HTTPClient: httpClient,
 		Name:       name,
+		Timestamps: NewTimestampAllocator(),
+	}
+}
+
+// keys returns the key provider that decrypts the records of the bucket.
+func (b *Bucket) keys() KeyProvider {
+	if b.Encryption == nil {
+		return nil
 	}
+	return b.Encryption.Keys
+}
+
+// GetName returns the name of the bucket.
+func (b *Bucket) GetName() string {
+	return b.Name
+}
+
+// operation attaches the bucket call to ctx so that middlewares can see it.
+func (b *Bucket) operation(ctx context.Context, name, entry string) context.Context {
+	return httpclient.WithOperation(ctx, httpclient.Operation{Name: "Bucket." + name, Bucket: b.Name, Entry: entry})
 }
 
 // CheckExists checks if the bucket exists on the server.
 func (b *Bucket) CheckExists(ctx context.Context) (bool, error) {
+	ctx = b.operation(ctx, "CheckExists", "")
 	err := b.HTTPClient.Head(ctx, fmt.Sprintf("/b/%s", b.Name))
 	if err != nil {
 		return false, err
@@ -37,6 +143,7 @@ func (b *Bucket) CheckExists(ctx context.Context) (bool, error) {
 
 // GetInfo retrieves the basic information about the bucket, such as its name, size, and quota.
 func (b *Bucket) GetInfo(ctx context.Context) (model.BucketInfo, error) {
+	ctx = b.operation(ctx, "GetInfo", "")
 	resp := model.FullBucketDetail{}
 	err := b.HTTPClient.Get(ctx, fmt.Sprintf("/b/%s", b.Name), &resp)
 	if err != nil {
@@ -47,6 +154,7 @@ func (b *Bucket) GetInfo(ctx context.Context) (model.BucketInfo, error) {
 
 // GetEntries retrieves the list of entries and their information in the bucket.
 func (b *Bucket) GetEntries(ctx context.Context) ([]model.EntryInfo, error) {
+	ctx = b.operation(ctx, "GetEntries", "")
 	resp := &model.FullBucketDetail{}
 	err := b.HTTPClient.Get(ctx, fmt.Sprintf("/b/%s", b.Name), resp)
 	if err != nil {
@@ -57,6 +165,7 @@ func (b *Bucket) GetEntries(ctx context.Context) ([]model.EntryInfo, error) {
 
 // GetFullInfo retrieves the full details of the bucket, including its settings and entries.
 func (b *Bucket) GetFullInfo(ctx context.Context) (model.FullBucketDetail, error) {
+	ctx = b.operation(ctx, "GetFullInfo", "")
 	resp := model.FullBucketDetail{}
 	err := b.HTTPClient.Get(ctx, fmt.Sprintf("/b/%s", b.Name), &resp)
 	if err != nil {
@@ -67,6 +176,7 @@ func (b *Bucket) GetFullInfo(ctx context.Context) (model.FullBucketDetail, error
 
 // GetSettings retrieves the settings of the bucket.
 func (b *Bucket) GetSettings(ctx context.Context) (model.BucketSetting, error) {
+	ctx = b.operation(ctx, "GetSettings", "")
 	resp := &model.FullBucketDetail{}
 	err := b.HTTPClient.Get(ctx, fmt.Sprintf("/b/%s", b.Name), resp)
 	if err != nil {
@@ -77,11 +187,13 @@ func (b *Bucket) GetSettings(ctx context.Context) (model.BucketSetting, error) {
 
 // SetSettings updates the settings of the bucket.
 func (b *Bucket) SetSettings(ctx context.Context, settings model.BucketSetting) error {
+	ctx = b.operation(ctx, "SetSettings", "")
 	return b.HTTPClient.Put(ctx, fmt.Sprintf("/b/%s", b.Name), settings, nil)
 }
 
 // Rename changes the name of the bucket.
 func (b *Bucket) Rename(ctx context.Context, newName string) error {
+	ctx = b.operation(ctx, "Rename", "")
 	err := b.HTTPClient.Put(ctx, fmt.Sprintf("/b/%s/rename", b.Name), map[string]string{"new_name": newName}, nil)
 	if err != nil {
 		return err
@@ -92,6 +204,7 @@ func (b *Bucket) Rename(ctx context.Context, newName string) error {
 
 // Remove deletes the bucket from the server.
 func (b *Bucket) Remove(ctx context.Context) error {
+	ctx = b.operation(ctx, "Remove", "")
 	return b.HTTPClient.Delete(ctx, fmt.Sprintf("/b/%s", b.Name))
 }
 
@@ -102,6 +215,7 @@ func (b *Bucket) Remove(ctx context.Context) error {
 //   - entry: Name of the entry to remove the record from.
 //   - ts: Timestamp of the record to remove in microseconds.
 func (b *Bucket) RemoveRecord(ctx context.Context, entry string, ts int64) error {
+	ctx = b.operation(ctx, "RemoveRecord", entry)
 	return b.HTTPClient.Delete(ctx, fmt.Sprintf("/b/%s/%s?ts=%d", b.Name, entry, ts))
 }
 
@@ -111,6 +225,7 @@ func (b *Bucket) RemoveRecord(ctx context.Context, entry string, ts int64) error
 //   - ctx: Context for cancellation and timeout control.
 //   - entry: Name of the entry to remove.
 func (b *Bucket) RemoveEntry(ctx context.Context, entry string) error {
+	ctx = b.operation(ctx, "RemoveEntry", entry)
 	return b.HTTPClient.Delete(ctx, fmt.Sprintf("/b/%s/%s", b.Name, entry))
 }
 
@@ -121,6 +236,7 @@ func (b *Bucket) RemoveEntry(ctx context.Context, entry string) error {
 //   - entry: Name of the entry to rename.
 //   - newName: New name of the entry.
 func (b *Bucket) RenameEntry(ctx context.Context, entry, newName string) error {
+	ctx = b.operation(ctx, "RenameEntry", entry)
 	return b.HTTPClient.Put(ctx, fmt.Sprintf("/b/%s/%s/rename", b.Name, entry), map[string]string{"new_name": newName}, nil)
 }
 
@@ -135,6 +251,7 @@ func (b *Bucket) RenameEntry(ctx context.Context, entry, newName string) error {
 //
 // Use readableRecord.Read() to read the content of the reader.
 func (b *Bucket) BeginRead(ctx context.Context, entry string, ts *int64) (*ReadableRecord, error) {
+	ctx = b.operation(ctx, "BeginRead", entry)
 	if ts == nil {
 		// If no timestamp is provided, read the latest record
 		return b.readRecord(ctx, entry, nil, false)
@@ -154,6 +271,7 @@ func (b *Bucket) BeginRead(ctx context.Context, entry string, ts *int64) (*Reada
 //
 // Use readableRecord.Read() to read the content of the reader.
 func (b *Bucket) BeginMetadataRead(ctx context.Context, entry string, ts *int64) (*ReadableRecord, error) {
+	ctx = b.operation(ctx, "BeginMetadataRead", entry)
 	// If no timestamp is provided, read the latest record
 	if ts == nil {
 		return b.readRecord(ctx, entry, nil, true)
@@ -216,7 +334,7 @@ func (b *Bucket) readRecord(ctx context.Context, entry string, ts *string, head
 
 	timeVal, _ := strconv.ParseInt(timeStr, 10, 64) //nolint:errcheck //not needed
 	sizeVal, _ := strconv.ParseInt(sizeStr, 10, 64) //nolint:errcheck //not needed
-	record := NewReadableRecord(entry, timeVal, sizeVal, last, resp.Body, labels, resp.Header.Get("Content-Type"))
+	record := newReadableRecord(entry, timeVal, sizeVal, last, resp.Body, labels, resp.Header.Get("Content-Type"), b.keys(), head)
 	return record, nil
 
 }
@@ -226,25 +344,42 @@ func (b *Bucket) readRecord(ctx context.Context, entry string, ts *string, head
 // Parameters:
 //   - entry the name of the entry to write the record to.
 //   - options:
-//   - TimeStamp: timestamp in microseconds, it is set to current time if not provided
+//   - TimeStamp: timestamp in microseconds, it is set to the next timestamp of the entry if not provided, see TimestampAllocator
 //   - ContentType: "text/plain"
 //   - Labels: record label kev:value pairs  {label1: "value1", label2: "value2"}.
-func (b *Bucket) BeginWrite(_ context.Context, entry string, options *WriteOptions) *WritableRecord {
+//
+// The record is written with ctx when its Write method is called; use
+// WriteContext to write it with another context.
+func (b *Bucket) BeginWrite(ctx context.Context, entry string, options *WriteOptions) *WritableRecord {
 	var localOptions = WriteOptions{Timestamp: 0}
 	if options != nil {
 		localOptions = *options
 	}
 	if localOptions.Timestamp == 0 {
-		localOptions.Timestamp = time.Now().UTC().UnixMicro()
+		localOptions.Timestamp = b.Timestamps.Next(entry)
+	} else {
+		b.Timestamps.Observe(entry, localOptions.Timestamp)
 	}
 	if localOptions.ContentType == "" {
 		localOptions.ContentType = "application/octet-stream"
 	}
-	return NewWritableRecord(b.Name, entry, b.HTTPClient, localOptions)
+	if localOptions.Encryption == nil {
+		localOptions.Encryption = b.Encryption
+	}
+	record := NewWritableRecord(b.Name, entry, b.HTTPClient, localOptions)
+	record.timestamps = b.Timestamps
+	if ctx != nil {
+		record.ctx = ctx
+	}
+	return record
 }
 
 func (b *Bucket) BeginWriteBatch(_ context.Context, entry string) *Batch {
-	return newBatch(b.Name, entry, b.HTTPClient, BatchWrite)
+	batch := newBatch(b.Name, entry, b.HTTPClient, BatchWrite)
+	batch.encryption = b.Encryption
+	batch.timestamps = b.Timestamps
+	batch.spool = b.Spool
+	return batch
 }
 
 func (b *Bucket) BeginUpdateBatch(_ context.Context, entry string) *Batch {
@@ -257,7 +392,11 @@ func (b *Bucket) BeginRemoveBatch(_ context.Context, entry string) *Batch {
 
 // BeginWriteRecordBatch creates a new batch for writing records across multiple entries (Batch Protocol v2).
 func (b *Bucket) BeginWriteRecordBatch(_ context.Context) *RecordBatch {
-	return newRecordBatch(b.Name, b.HTTPClient, BatchWrite)
+	batch := newRecordBatch(b.Name, b.HTTPClient, BatchWrite)
+	batch.encryption = b.Encryption
+	batch.timestamps = b.Timestamps
+	batch.spool = b.Spool
+	return batch
 }
 
 // BeginUpdateRecordBatch creates a new batch for updating labels across entries (Batch Protocol v2).
@@ -384,6 +523,23 @@ type QueryResult struct {
 	errCh   <-chan error
 }
 
+// NewQueryResult returns a finished query result with the given records and
+// streaming error, e.g. as the return value of a mocked query.
+func NewQueryResult(records []*ReadableRecord, err error) *QueryResult {
+	recordCh := make(chan *ReadableRecord, len(records))
+	for _, record := range records {
+		recordCh <- record
+	}
+	close(recordCh)
+
+	errCh := make(chan error, 1)
+	if err != nil {
+		errCh <- err
+	}
+	close(errCh)
+	return &QueryResult{records: recordCh, errCh: errCh}
+}
+
 func (q *QueryResult) Records() <-chan *ReadableRecord {
 	if q.records == nil {
 		ch := make(chan *ReadableRecord)
@@ -430,6 +586,7 @@ func (q *QueryResult) Err() error {
 //	    // Process content...
 //	}
 func (b *Bucket) Query(ctx context.Context, entry string, options *QueryOptions) (*QueryResult, error) {
+	ctx = b.operation(ctx, "Query", entry)
 	if options == nil {
 		options = &QueryOptions{
 			QueryType:    QueryTypeQuery,
@@ -446,21 +603,45 @@ func (b *Bucket) Query(ctx context.Context, entry string, options *QueryOptions)
 	if entry == "" {
 		return &QueryResult{}, fmt.Errorf("entry name is required for queries")
 	}
+
 	if !strings.Contains(entry, "*") {
-		resp, err := b.executeQuery(ctx, entry, options)
-		if err != nil {
-			return &QueryResult{}, err
+		result, err := b.queryEntry(ctx, entry, options)
+		if err != nil || !options.Continuous {
+			return result, err
 		}
-
-		return b.fetchAndParseBatchedRecords(ctx, entry, resp.ID, options.Continuous, options.PollInterval, options.Head)
+		return b.resumeAfterFailover(ctx, entry, *options, result), nil
 	}
 
+	// Query IDs are only valid on the server that created them.
+	ctx = httpclient.WithStickyEndpoint(ctx)
+	ctx, span := httpclient.TracerOf(b.HTTPClient).Start(ctx, "Bucket.Query",
+		telemetry.String(telemetry.AttrBucket, b.Name),
+		telemetry.String(telemetry.AttrEntry, entry))
 	resp, err := b.executeIOQuery(ctx, []string{entry}, options)
 	if err != nil {
+		endSpan(span, err)
+		return &QueryResult{}, err
+	}
+
+	span.SetAttributes(telemetry.Int64(telemetry.AttrQueryID, resp.ID))
+	return b.fetchAndParseBatchedRecordsV2(ctx, span, resp.ID, options.Continuous, options.PollInterval, options.Head)
+}
+
+// queryEntry runs a query on a single entry.
+func (b *Bucket) queryEntry(ctx context.Context, entry string, options *QueryOptions) (*QueryResult, error) {
+	// Query IDs are only valid on the server that created them.
+	ctx = httpclient.WithStickyEndpoint(ctx)
+	ctx, span := httpclient.TracerOf(b.HTTPClient).Start(ctx, "Bucket.Query",
+		telemetry.String(telemetry.AttrBucket, b.Name),
+		telemetry.String(telemetry.AttrEntry, entry))
+	resp, err := b.executeQuery(ctx, entry, options)
+	if err != nil {
+		endSpan(span, err)
 		return &QueryResult{}, err
 	}
 
-	return b.fetchAndParseBatchedRecordsV2(ctx, resp.ID, options.Continuous, options.PollInterval, options.Head)
+	span.SetAttributes(telemetry.Int64(telemetry.AttrQueryID, resp.ID))
+	return b.fetchAndParseBatchedRecords(ctx, span, entry, resp.ID, options.Continuous, options.PollInterval, options.Head)
 }
 
 // QueryMany queries records for multiple entries and returns them through a channel.
@@ -470,6 +651,7 @@ func (b *Bucket) Query(ctx context.Context, entry string, options *QueryOptions)
 //   - entries: Names of the entries to query
 //   - options: Optional query options for filtering and controlling the query behavior
 func (b *Bucket) QueryMany(ctx context.Context, entries []string, options *QueryOptions) (*QueryResult, error) {
+	ctx = b.operation(ctx, "QueryMany", "")
 	if len(entries) == 0 {
 		return &QueryResult{}, fmt.Errorf("entries are required for QueryMany")
 	}
@@ -487,12 +669,19 @@ func (b *Bucket) QueryMany(ctx context.Context, entries []string, options *Query
 		options.QueryType = QueryTypeQuery
 	}
 
+	// Query IDs are only valid on the server that created them.
+	ctx = httpclient.WithStickyEndpoint(ctx)
+	ctx, span := httpclient.TracerOf(b.HTTPClient).Start(ctx, "Bucket.QueryMany",
+		telemetry.String(telemetry.AttrBucket, b.Name),
+		telemetry.String(telemetry.AttrEntry, strings.Join(entries, ",")))
 	resp, err := b.executeIOQuery(ctx, entries, options)
 	if err != nil {
+		endSpan(span, err)
 		return &QueryResult{}, err
 	}
 
-	return b.fetchAndParseBatchedRecordsV2(ctx, resp.ID, options.Continuous, options.PollInterval, options.Head)
+	span.SetAttributes(telemetry.Int64(telemetry.AttrQueryID, resp.ID))
+	return b.fetchAndParseBatchedRecordsV2(ctx, span, resp.ID, options.Continuous, options.PollInterval, options.Head)
 }
 
 // RemoveQuery removes records by query.
@@ -507,6 +696,7 @@ func (b *Bucket) QueryMany(ctx context.Context, entries []string, options *Query
 // Note: remove is exclusive of the end point. [start, end)
 // Returns the number of records removed.
 func (b *Bucket) RemoveQuery(ctx context.Context, entry string, options *QueryOptions) (int64, error) {
+	ctx = b.operation(ctx, "RemoveQuery", entry)
 	if entry == "" {
 		return 0, fmt.Errorf("entry name is required for queries")
 	}
@@ -543,6 +733,7 @@ func (b *Bucket) RemoveQuery(ctx context.Context, entry string, options *QueryOp
 // Note: remove is exclusive of the end point. [start, end)
 // Returns the number of records removed.
 func (b *Bucket) RemoveQueryMany(ctx context.Context, entries []string, options *QueryOptions) (int64, error) {
+	ctx = b.operation(ctx, "RemoveQueryMany", "")
 	if len(entries) == 0 {
 		return 0, fmt.Errorf("entries are required for RemoveQueryMany")
 	}
@@ -606,28 +797,27 @@ func (b *Bucket) executeIOQuery(ctx context.Context, entries []string, option *Q
 }
 
 // Update updates the labels of an existing record.
-// If a label has an empty string value, it will be removed.
+// If a label has an empty string or nil value, it will be removed.
+// Values are encoded with FormatLabel.
 //
 // Parameters:
 //   - ctx: Context for cancellation and timeout control
 //   - entry: Name of the entry
 //   - ts: Timestamp of record in microseconds
-//   - labels: Labels to update
-func (b *Bucket) Update(ctx context.Context, entry string, ts int64, labels LabelMap) error {
-	headers := make(map[string]string)
-
-	for key, value := range labels {
-		headers[fmt.Sprintf("x-reduct-label-%s", key)] = fmt.Sprint(value)
+//   - labels: Labels to update, a LabelMap, a map[string]string or a struct with reduct tags (see MarshalLabels)
+func (b *Bucket) Update(ctx context.Context, entry string, ts int64, labels any) error {
+	ctx = b.operation(ctx, "Update", entry)
+	labelMap, err := labelsOf(labels)
+	if err != nil {
+		return err
 	}
-
 	path := fmt.Sprintf("/b/%s/%s?ts=%d", b.Name, entry, ts)
 	req, err := b.HTTPClient.NewRequestWithContext(ctx, http.MethodPatch, path, nil)
 	if err != nil {
 		return err
 	}
-
-	for key, value := range headers {
-		req.Header.Set(key, value)
+	if err = setLabelHeaders(req.Header, labelMap); err != nil {
+		return err
 	}
 
 	resp, err := b.HTTPClient.Do(req)
@@ -654,6 +844,7 @@ func (b *Bucket) Update(ctx context.Context, entry string, ts int64, labels Labe
 //   - entry: Name of the entry
 //   - attachments: Attachment key/value payloads
 func (b *Bucket) WriteAttachments(ctx context.Context, entry string, attachments map[string]any) error {
+	ctx = b.operation(ctx, "WriteAttachments", entry)
 	if entry == "" {
 		return fmt.Errorf("entry name is required for attachments")
 	}
@@ -690,6 +881,7 @@ func (b *Bucket) WriteAttachments(ctx context.Context, entry string, attachments
 //
 // Returns a map where keys are attachment names and values are decoded JSON payloads.
 func (b *Bucket) ReadAttachments(ctx context.Context, entry string) (map[string]any, error) {
+	ctx = b.operation(ctx, "ReadAttachments", entry)
 	if entry == "" {
 		return nil, fmt.Errorf("entry name is required for attachments")
 	}
@@ -746,6 +938,7 @@ func (b *Bucket) ReadAttachments(ctx context.Context, entry string) (map[string]
 //   - entry: Name of the entry
 //   - attachmentKeys: Optional attachment keys to remove
 func (b *Bucket) RemoveAttachments(ctx context.Context, entry string, attachmentKeys []string) error {
+	ctx = b.operation(ctx, "RemoveAttachments", entry)
 	if entry == "" {
 		return fmt.Errorf("entry name is required for attachments")
 	}
@@ -910,6 +1103,7 @@ func (q *QueryLinkOptionsBuilder) Build() QueryLinkOptions {
 //   - entry: Name of the entry to query
 //   - options: Options for the query link, including query parameters, record index, expiration time, and file name
 func (b *Bucket) CreateQueryLink(ctx context.Context, entry string, options QueryLinkOptions) (string, error) {
+	ctx = b.operation(ctx, "CreateQueryLink", entry)
 	if entry == "" {
 		return "", fmt.Errorf("entry name is required for queries")
 	}
@@ -929,6 +1123,7 @@ func (b *Bucket) CreateQueryLink(ctx context.Context, entry string, options Quer
 //   - entries: Names of the entries to query
 //   - options: Options for the query link, including query parameters, record index, expiration time, and file name
 func (b *Bucket) CreateQueryLinkMany(ctx context.Context, entries []string, options QueryLinkOptions) (string, error) {
+	ctx = b.operation(ctx, "CreateQueryLinkMany", "")
 	if len(entries) == 0 {
 		return "", fmt.Errorf("entries are required for CreateQueryLinkMany")
 	}
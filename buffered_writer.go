@@ -0,0 +1,324 @@
+package reductgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+)
+
+const (
+	defaultBufferedMaxRecords = 1000
+	defaultBufferedMaxSize    = 8 * 1024 * 1024
+	defaultBufferedMaxAge     = time.Second
+)
+
+var (
+	// ErrBufferFull is returned by BufferedWriter.Write with OverflowDrop when
+	// the record does not fit into the buffer. The record is dropped.
+	ErrBufferFull = errors.New("buffered writer is full")
+	// ErrWriterClosed is returned by BufferedWriter.Write after Close.
+	ErrWriterClosed = errors.New("buffered writer is closed")
+)
+
+// OverflowPolicy decides what BufferedWriter.Write does when the buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Write wait until a flush frees enough space or its
+	// context is done.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop makes Write drop the record and return ErrBufferFull.
+	OverflowDrop
+)
+
+// BufferedWriterOptions configures a BufferedWriter. Zero values use the defaults.
+type BufferedWriterOptions struct {
+	// MaxRecords flushes a batch when it has this many records. Defaults to 1000.
+	MaxRecords int
+	// MaxSize flushes a batch when its payload reaches this many bytes. Defaults to 8 MiB.
+	MaxSize int64
+	// MaxAge flushes a batch when no record was added to it for this long, see
+	// RecordBatch.LastAccessTime. Defaults to one second.
+	MaxAge time.Duration
+	// MaxBufferedSize bounds the payload bytes held by the writer, including
+	// the batches being sent. Defaults to four times MaxSize. A record larger
+	// than the bound is accepted when the writer is empty.
+	MaxBufferedSize int64
+	// Overflow decides what Write does when the buffer is full. Defaults to OverflowBlock.
+	Overflow OverflowPolicy
+	// OnError is called for every record that failed to be written, with the
+	// per-record error of the server or the error of the whole batch.
+	OnError func(entry string, ts int64, err error)
+	// Compression compresses the record payloads if set. The size bounds
+	// apply to the payloads before compression.
+	Compression *CompressionOptions
+	// OnConflict is the conflict policy of the batches, see
+	// RecordBatch.SetConflictPolicy. Records moved by ConflictShift are not
+	// reported. Defaults to ConflictFail.
+	OnConflict ConflictPolicy
+	// Dedup deduplicates the records of the batches, see RecordBatch.SetDedup.
+	// Skipped duplicates are not reported.
+	Dedup *DedupOptions
+}
+
+// BufferedWriter collects records of several entries into RecordBatch writes
+// and flushes them in the background when a batch is large, has many records
+// or is idle. Create it with Bucket.BeginBufferedWrite and Close it when done.
+type BufferedWriter struct {
+	bucket  *Bucket
+	options BufferedWriterOptions
+	// ctx is the context of background flushes.
+	ctx context.Context
+
+	mu       sync.Mutex
+	batch    *RecordBatch
+	size     int64 // payload bytes of batch
+	sizes    map[recordBatchKey]int64
+	queue    []bufferedBatch
+	buffered int64
+	released chan struct{} // closed when buffered space is released
+	closed   bool
+
+	// sendMu serializes sending so that batches are written in order.
+	sendMu sync.Mutex
+	wake   chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+}
+
+type bufferedBatch struct {
+	batch *RecordBatch
+	size  int64
+}
+
+// BeginBufferedWrite starts a buffered writer for the records of several
+// entries (Batch Protocol v2). Background flushes use ctx; they stop being
+// sent once it is done, and the affected records are reported to OnError.
+func (b *Bucket) BeginBufferedWrite(ctx context.Context, options *BufferedWriterOptions) *BufferedWriter {
+	var opts BufferedWriterOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxRecords <= 0 {
+		opts.MaxRecords = defaultBufferedMaxRecords
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultBufferedMaxSize
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultBufferedMaxAge
+	}
+	if opts.MaxBufferedSize <= 0 {
+		opts.MaxBufferedSize = 4 * opts.MaxSize
+	}
+
+	w := &BufferedWriter{
+		bucket:   b,
+		options:  opts,
+		ctx:      ctx,
+		released: make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		sizes:    map[recordBatchKey]int64{},
+	}
+	w.batch = w.newBatch()
+	go w.run()
+	return w
+}
+
+// Write adds a record to the buffer. With OverflowBlock it waits while the
+// buffer is full, until ctx is done. labels are given like in RecordBatch.Add.
+// If ts is 0, the record is written at the next timestamp of the entry, see
+// TimestampAllocator. Errors of the write itself are reported to OnError when
+// the batch is flushed.
+func (w *BufferedWriter) Write(ctx context.Context, entry string, ts int64, data []byte, contentType string, labels any) error {
+	size := int64(len(data))
+	if ts == 0 {
+		ts = w.bucket.Timestamps.Next(entry)
+	}
+
+	w.mu.Lock()
+	for !w.closed && w.buffered > 0 && w.buffered+size > w.options.MaxBufferedSize {
+		if w.options.Overflow == OverflowDrop {
+			w.mu.Unlock()
+			return ErrBufferFull
+		}
+		released := w.released
+		w.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		w.mu.Lock()
+	}
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	key, queued := w.batch.add(entry, ts, data, nil, contentType, labels)
+	if !queued {
+		return nil
+	}
+	if replaced, ok := w.sizes[key]; ok {
+		w.size -= replaced
+		w.releaseLocked(replaced)
+	}
+	w.sizes[key] = size
+	w.size += size
+	w.buffered += size
+	if w.batch.RecordCount() >= w.options.MaxRecords || w.size >= w.options.MaxSize {
+		w.rotate()
+	}
+	return nil
+}
+
+// Flush sends all buffered records and waits until they are written. It
+// returns the errors of batches that failed as a whole; per-record errors are
+// reported to OnError.
+func (w *BufferedWriter) Flush(ctx context.Context) error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+
+	w.mu.Lock()
+	w.rotate()
+	w.mu.Unlock()
+	return w.sendQueued(ctx)
+}
+
+// Close stops the background flusher and flushes the remaining records with
+// ctx. Writes after Close fail with ErrWriterClosed.
+func (w *BufferedWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.releaseLocked(0)
+	w.mu.Unlock()
+
+	close(w.done)
+	<-w.exited
+	return w.Flush(ctx)
+}
+
+// Buffered returns the payload bytes held by the writer, including the
+// batches being sent.
+func (w *BufferedWriter) Buffered() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffered
+}
+
+// run flushes batches in the background.
+func (w *BufferedWriter) run() {
+	defer close(w.exited)
+	ticker := time.NewTicker(max(w.options.MaxAge/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.batch.RecordCount() > 0 && time.Since(w.batch.LastAccessTime()) >= w.options.MaxAge {
+				w.rotate()
+			}
+			w.mu.Unlock()
+		}
+
+		w.sendMu.Lock()
+		if err := w.sendQueued(w.ctx); err != nil {
+			httpclient.LoggerOf(w.bucket.HTTPClient).WarnContext(w.ctx, "buffered flush failed",
+				"bucket", w.bucket.Name, "error", err)
+		}
+		w.sendMu.Unlock()
+	}
+}
+
+func (w *BufferedWriter) newBatch() *RecordBatch {
+	batch := newRecordBatch(w.bucket.Name, w.bucket.HTTPClient, BatchWrite)
+	batch.compression = w.options.Compression
+	batch.encryption = w.bucket.Encryption
+	batch.timestamps = w.bucket.Timestamps
+	batch.conflict = w.options.OnConflict
+	batch.spool = w.bucket.Spool
+	batch.dedup = w.options.Dedup
+	return batch
+}
+
+// rotate queues the current batch for sending; the caller holds mu.
+func (w *BufferedWriter) rotate() {
+	if w.batch.RecordCount() == 0 {
+		return
+	}
+	w.queue = append(w.queue, bufferedBatch{batch: w.batch, size: w.size})
+	w.batch = w.newBatch()
+	w.size = 0
+	w.sizes = map[recordBatchKey]int64{}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendQueued sends the queued batches in order; the caller holds sendMu.
+func (w *BufferedWriter) sendQueued(ctx context.Context) error {
+	var errs []error
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return errors.Join(errs...)
+		}
+		next := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		if err := w.send(ctx, next.batch); err != nil {
+			errs = append(errs, err)
+		}
+
+		w.mu.Lock()
+		w.releaseLocked(next.size)
+		w.mu.Unlock()
+	}
+}
+
+// send sends the batch and reports the failed records to OnError: the records
+// of the requests that were sent with their errors, the ones that were not
+// sent with the error of the batch.
+func (w *BufferedWriter) send(ctx context.Context, batch *RecordBatch) error {
+	recordErrs, unsent, err := batch.send(ctx)
+	if w.options.OnError == nil {
+		return err
+	}
+
+	for entry, entryErrs := range recordErrs {
+		for ts, recordErr := range entryErrs {
+			if !unsent[recordBatchKey{entry: entry, ts: ts}] {
+				w.options.OnError(entry, ts, recordErr)
+			}
+		}
+	}
+	for key := range unsent {
+		w.options.OnError(key.entry, key.ts, err)
+	}
+	return err
+}
+
+// releaseLocked frees buffered space and wakes blocked writers; the caller holds mu.
+func (w *BufferedWriter) releaseLocked(size int64) {
+	w.buffered -= size
+	close(w.released)
+	w.released = make(chan struct{})
+}
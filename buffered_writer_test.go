@@ -0,0 +1,206 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+func newBufferedTestBucket(t *testing.T) *Bucket {
+	t.Helper()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+
+	bucket, err := NewClient(server.URL, ClientOptions{}).CreateBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return bucket.(*Bucket)
+}
+
+func countEntryRecords(t *testing.T, bucket *Bucket, entry string) int {
+	t.Helper()
+	result, err := bucket.Query(context.Background(), entry, nil)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for record := range result.Records() {
+		_, err := record.Read()
+		require.NoError(t, err)
+		count++
+	}
+	require.NoError(t, result.Err())
+	return count
+}
+
+func TestBufferedWriterFlushTriggers(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+
+	t.Run("count", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{MaxRecords: 3, MaxAge: time.Hour})
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		for ts := int64(1); ts <= 3; ts++ {
+			require.NoError(t, writer.Write(ctx, "count", ts, []byte("data"), "", nil))
+		}
+		assert.Eventually(t, func() bool { return countEntryRecords(t, bucket, "count") == 3 },
+			time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("size", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{MaxSize: 8, MaxAge: time.Hour})
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		require.NoError(t, writer.Write(ctx, "size-a", 1, []byte("data"), "", nil))
+		require.NoError(t, writer.Write(ctx, "size-b", 1, []byte("data"), "", nil))
+		assert.Eventually(t, func() bool {
+			return countEntryRecords(t, bucket, "size-a") == 1 && countEntryRecords(t, bucket, "size-b") == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("age", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{MaxAge: 50 * time.Millisecond})
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		require.NoError(t, writer.Write(ctx, "age", 1, []byte("data"), "", nil))
+		assert.Eventually(t, func() bool { return countEntryRecords(t, bucket, "age") == 1 },
+			time.Second, 10*time.Millisecond)
+		assert.Zero(t, writer.Buffered())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{MaxAge: time.Hour})
+		require.NoError(t, writer.Write(ctx, "close", 1, []byte("data"), "", nil))
+		require.NoError(t, writer.Close(ctx))
+		assert.Equal(t, 1, countEntryRecords(t, bucket, "close"))
+
+		assert.ErrorIs(t, writer.Write(ctx, "close", 2, []byte("data"), "", nil), ErrWriterClosed)
+		assert.NoError(t, writer.Close(ctx))
+	})
+}
+
+func TestBufferedWriterOverflow(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	options := BufferedWriterOptions{MaxAge: time.Hour, MaxBufferedSize: 6}
+
+	t.Run("block", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &options)
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		require.NoError(t, writer.Write(ctx, "block", 1, []byte("data"), "", nil))
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, writer.Write(timeout, "block", 2, []byte("data"), "", nil), context.DeadlineExceeded)
+
+		written := make(chan error, 1)
+		go func() { written <- writer.Write(ctx, "block", 2, []byte("data"), "", nil) }()
+		require.NoError(t, writer.Flush(ctx))
+		require.NoError(t, <-written)
+		require.NoError(t, writer.Flush(ctx))
+		assert.Equal(t, 2, countEntryRecords(t, bucket, "block"))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		dropOptions := options
+		dropOptions.Overflow = OverflowDrop
+		writer := bucket.BeginBufferedWrite(ctx, &dropOptions)
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		require.NoError(t, writer.Write(ctx, "drop", 1, []byte("data"), "", nil))
+		assert.ErrorIs(t, writer.Write(ctx, "drop", 2, []byte("data"), "", nil), ErrBufferFull)
+		assert.Equal(t, int64(4), writer.Buffered())
+
+		require.NoError(t, writer.Flush(ctx))
+		assert.Equal(t, 1, countEntryRecords(t, bucket, "drop"))
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{MaxAge: time.Hour})
+		defer func() { assert.NoError(t, writer.Close(ctx)) }()
+		require.NoError(t, writer.Write(ctx, "conflicts", 1, []byte("data"), "", nil))
+		require.NoError(t, writer.Write(ctx, "conflicts", 1, []byte("duplicate"), "", nil))
+		assert.Equal(t, int64(4), writer.Buffered(), "a dropped duplicate is not buffered")
+		require.NoError(t, writer.Write(ctx, "conflicts", 2, []byte("data"), "", nil))
+		require.NoError(t, writer.Flush(ctx))
+		assert.Equal(t, 2, countEntryRecords(t, bucket, "conflicts"))
+		assert.Zero(t, writer.Buffered())
+
+		overwrite := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{
+			MaxAge:     time.Hour,
+			OnConflict: ConflictOverwrite,
+		})
+		defer func() { assert.NoError(t, overwrite.Close(ctx)) }()
+		require.NoError(t, overwrite.Write(ctx, "overwrite", 1, []byte("data"), "", nil))
+		require.NoError(t, overwrite.Write(ctx, "overwrite", 1, []byte("new"), "", nil))
+		assert.Equal(t, int64(3), overwrite.Buffered(), "the replaced record is released")
+	})
+}
+
+func TestBufferedWriterOnError(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("data"))
+
+	var mu sync.Mutex
+	failed := map[int64]error{}
+	writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{
+		MaxAge: time.Hour,
+		OnError: func(entry string, ts int64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, "entry", entry)
+			failed[ts] = err
+		},
+	})
+	require.NoError(t, writer.Write(ctx, "entry", 1, []byte("data"), "", nil))
+	require.NoError(t, writer.Write(ctx, "entry", 2, []byte("data"), "", nil))
+	require.NoError(t, writer.Close(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, failed, 1)
+	assert.ErrorIs(t, failed[1], model.ErrConflict)
+}
+
+func TestBufferedWriterPartiallySentBatch(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFlakyLimitedTestBucket(t, httpclient.BatchLimits{MaxRecords: 2},
+		func(attempt int, w http.ResponseWriter, _ *http.Request) bool {
+			if attempt == 1 {
+				// The first request has the records 1 and 2 of the entry.
+				w.Header().Set("x-reduct-error-0-0", "422,Invalid record")
+				return false
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		})
+
+	var mu sync.Mutex
+	failed := map[int64]int{}
+	writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{
+		MaxAge: time.Hour,
+		OnError: func(_ string, ts int64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var apiErr *model.APIError
+			if assert.ErrorAs(t, err, &apiErr) {
+				failed[ts] = apiErr.Status
+			}
+		},
+	})
+	for ts := int64(1); ts <= 3; ts++ {
+		require.NoError(t, writer.Write(ctx, "entry", ts, []byte("data"), "", nil))
+	}
+	require.Error(t, writer.Close(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[int64]int{1: http.StatusUnprocessableEntity, 3: http.StatusServiceUnavailable}, failed,
+		"the records of the sent request are reported with their errors")
+}
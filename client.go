@@ -4,12 +4,17 @@ package reductgo
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/reductstore/reduct-go/httpclient"
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
 var defaultClientTimeout = 60 * time.Second
@@ -23,11 +28,11 @@ type Client interface {
 	// Get a list of the buckets with their stats
 	GetBuckets(ctx context.Context) ([]model.BucketInfo, error)
 	// Create a new bucket
-	CreateBucket(ctx context.Context, name string, settings *model.BucketSetting) (Bucket, error)
+	CreateBucket(ctx context.Context, name string, settings *model.BucketSetting) (BucketAPI, error)
 	// Create a new bucket if it doesn't exist and return it
-	CreateOrGetBucket(ctx context.Context, name string, settings *model.BucketSetting) (Bucket, error)
+	CreateOrGetBucket(ctx context.Context, name string, settings *model.BucketSetting) (BucketAPI, error)
 	// Get a bucket
-	GetBucket(ctx context.Context, name string) (Bucket, error)
+	GetBucket(ctx context.Context, name string) (BucketAPI, error)
 	// Check if a bucket exists
 	CheckBucketExists(ctx context.Context, name string) (bool, error)
 	// Remove a bucket
@@ -77,11 +82,68 @@ type Client interface {
 type ClientOptions struct {
 	APIToken string
 	Timeout  time.Duration
+	// TokenProvider supplies the API token for every request instead of APIToken,
+	// e.g. a RotatingTokenProvider for tokens that expire.
+	TokenProvider httpclient.TokenProvider
 	//
 	// Deprecated: TLS verification is enabled by default. Use InsecureSkipVerify to disable it explicitly.
 	VerifySSL          bool
 	InsecureSkipVerify bool
 	CACertPath         string
+	// TLSConfig is the base TLS configuration, e.g. for custom cipher suites or
+	// root CAs. It is cloned, and the other TLS options are applied on top of it.
+	TLSConfig *tls.Config
+	// ClientCertPath and ClientKeyPath are PEM files with a client certificate
+	// and key for mutual TLS. They are reloaded when the files are rotated.
+	ClientCertPath string
+	ClientKeyPath  string
+	// ClientCertPEM and ClientKeyPEM are an in-memory client certificate and key in PEM format.
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+	// ServerName overrides the host name used to verify the server certificate,
+	// e.g. when connecting through a proxy by IP address.
+	ServerName string
+	// MinTLSVersion is the minimum accepted TLS version, e.g. tls.VersionTLS13.
+	MinTLSVersion uint16
+	// HealthCheckInterval is how often a client created with NewFailoverClient
+	// checks an unavailable instance. Defaults to 5 seconds.
+	HealthCheckInterval time.Duration
+	// OnFailover is called when a client created with NewFailoverClient switches
+	// from one instance to another. It must not block.
+	OnFailover func(from, to string)
+	// Retry configures automatic retries with exponential backoff for transient failures
+	// (connection errors, timeouts, 429/502/503/504). The zero value disables retries,
+	// see httpclient.DefaultRetryPolicy for a reasonable default.
+	Retry httpclient.RetryPolicy
+	// Middlewares wrap every HTTP request of the client, e.g. to add headers, sign
+	// requests or trace them. Use httpclient.OperationFromContext on the request
+	// context to see which SDK call issued it.
+	Middlewares []httpclient.Middleware
+	// Tracer receives spans for queries, batch fetches and batch writes.
+	// Defaults to a no-op tracer.
+	Tracer telemetry.Tracer
+	// Metrics receives counters and histograms of requests, written and read
+	// bytes, batch sizes and per-record errors. telemetry.NewMemoryMetrics
+	// provides an in-memory implementation with a Prometheus handler.
+	Metrics telemetry.Metrics
+	// Logger receives the diagnostics of the SDK: version mismatch warnings,
+	// retries, partial batch failures, query polling and response body errors.
+	// Defaults to slog.Default(); use a logger with slog.DiscardHandler to
+	// silence the SDK.
+	Logger *slog.Logger
+	// BatchLimits bounds the body size, record count and header size of batch
+	// requests; larger batches are split into several requests. Zero fields are
+	// taken from the server info once GetInfo returns limits, otherwise
+	// httpclient.DefaultBatchLimits applies.
+	BatchLimits httpclient.BatchLimits
+	// Encryption enables client-side encryption of the record payloads of all
+	// buckets of the client, see EncryptionOptions. Reads decrypt the records
+	// with its Keys.
+	Encryption *EncryptionOptions
+	// Spool keeps the records of write batches that fail because the server is
+	// unreachable on disk and replays them once it is live, see OpenSpool. A
+	// spool is replayed by the first client it is passed to.
+	Spool *Spool
 }
 type ReductClient struct {
 	url      string
@@ -89,40 +151,72 @@ type ReductClient struct {
 	APIToken string
 	// this is a custom http client
 	HTTPClient httpclient.HTTPClient
+	encryption *EncryptionOptions
+	spool      *Spool
+	// timestamps are the timestamp allocators of the buckets by name.
+	timestamps sync.Map // map[string]*TimestampAllocator
 }
 
 // NewClient creates a new ReductClient.
 func NewClient(url string, options ClientOptions) Client {
+	return newClient(url, nil, options)
+}
+
+func newClient(url string, failoverURLs []string, options ClientOptions) *ReductClient {
 	if options.Timeout.Seconds() == 0 {
 		options.Timeout = defaultClientTimeout
 	}
 	client := &ReductClient{
-		url:      url,
-		timeout:  options.Timeout,
-		APIToken: options.APIToken,
+		url:        url,
+		timeout:    options.Timeout,
+		APIToken:   options.APIToken,
+		encryption: options.Encryption,
+		spool:      options.Spool,
 	}
 	client.HTTPClient = httpclient.NewHTTPClient(httpclient.Option{
-		APIToken:           options.APIToken,
-		Timeout:            options.Timeout,
-		BaseURL:            url,
-		VerifySSL:          options.VerifySSL,
-		InsecureSkipVerify: options.InsecureSkipVerify,
-		CACertPath:         options.CACertPath,
+		APIToken:            options.APIToken,
+		TokenProvider:       options.TokenProvider,
+		Timeout:             options.Timeout,
+		BaseURL:             url,
+		VerifySSL:           options.VerifySSL,
+		InsecureSkipVerify:  options.InsecureSkipVerify,
+		CACertPath:          options.CACertPath,
+		TLSConfig:           options.TLSConfig,
+		ClientCertPath:      options.ClientCertPath,
+		ClientKeyPath:       options.ClientKeyPath,
+		ClientCertPEM:       options.ClientCertPEM,
+		ClientKeyPEM:        options.ClientKeyPEM,
+		ServerName:          options.ServerName,
+		MinTLSVersion:       options.MinTLSVersion,
+		FailoverURLs:        failoverURLs,
+		HealthCheckInterval: options.HealthCheckInterval,
+		OnFailover:          options.OnFailover,
+		Retry:               options.Retry,
+		Middlewares:         options.Middlewares,
+		Tracer:              options.Tracer,
+		Metrics:             options.Metrics,
+		Logger:              options.Logger,
+		BatchLimits:         options.BatchLimits,
 	})
+	if client.spool != nil {
+		client.spool.attach(client)
+	}
 
 	return client
 }
 
 // GetInfo returns information about the server.
 func (c *ReductClient) GetInfo(ctx context.Context) (model.ServerInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetInfo"})
 	var info model.ServerInfo
 	err := c.HTTPClient.Get(ctx, "/info", &info)
 	if err != nil {
 		return model.ServerInfo{}, err
 	}
+	httpclient.LearnBatchLimits(c.HTTPClient, info.Limits)
 
 	// Check version compatibility
-	err = model.CheckServerAPIVersion(info.Version, model.GetVersion())
+	err = model.CheckServerAPIVersionWithLogger(httpclient.LoggerOf(c.HTTPClient), info.Version, model.GetVersion())
 	if err != nil {
 		return info, err
 	}
@@ -132,6 +226,7 @@ func (c *ReductClient) GetInfo(ctx context.Context) (model.ServerInfo, error) {
 
 // IsLive checks if the server is live.
 func (c *ReductClient) IsLive(ctx context.Context) (bool, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.IsLive"})
 	err := c.HTTPClient.Head(ctx, "/alive")
 	if err != nil {
 		return false, err
@@ -141,6 +236,7 @@ func (c *ReductClient) IsLive(ctx context.Context) (bool, error) {
 
 // GetBuckets returns a list of buckets with their stats.
 func (c *ReductClient) GetBuckets(ctx context.Context) ([]model.BucketInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetBuckets"})
 	var buckets map[string][]model.BucketInfo
 	err := c.HTTPClient.Get(ctx, "/list", &buckets)
 	if err != nil {
@@ -150,55 +246,67 @@ func (c *ReductClient) GetBuckets(ctx context.Context) ([]model.BucketInfo, erro
 }
 
 // GetBucket returns a bucket.
-func (c *ReductClient) GetBucket(ctx context.Context, name string) (Bucket, error) {
+func (c *ReductClient) GetBucket(ctx context.Context, name string) (BucketAPI, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetBucket", Bucket: name})
 	err := c.HTTPClient.Get(ctx, fmt.Sprintf(`/b/%s`, name), nil)
 	if err != nil {
 		var apiErr *model.APIError
-		ok := errors.As(err, &apiErr)
-
-		if ok && apiErr.Status == 404 {
-			return Bucket{}, model.APIError{Status: 404, Message: fmt.Sprintf("bucket '%s' not found", name), Original: err}
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			notFound := *apiErr
+			notFound.Message = fmt.Sprintf("bucket '%s' not found", name)
+			notFound.Original = err
+			return nil, &notFound
 		}
-		return Bucket{}, err
+		return nil, err
 	}
 
-	return newBucket(name, c.HTTPClient), nil
+	return c.bucket(name), nil
 }
 
-func (c *ReductClient) CreateBucket(ctx context.Context, name string, settings *model.BucketSetting) (Bucket, error) {
+// bucket returns the bucket with the encryption and timestamp allocator of the client.
+func (c *ReductClient) bucket(name string) *Bucket {
+	bucket := newBucket(name, c.HTTPClient)
+	bucket.Encryption = c.encryption
+	bucket.Spool = c.spool
+	timestamps, _ := c.timestamps.LoadOrStore(name, bucket.Timestamps)
+	bucket.Timestamps = timestamps.(*TimestampAllocator)
+	return bucket
+}
+
+func (c *ReductClient) CreateBucket(ctx context.Context, name string, settings *model.BucketSetting) (BucketAPI, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateBucket", Bucket: name})
 	if settings == nil {
 		settings = &model.BucketSetting{}
 	}
 
 	err := c.HTTPClient.Post(ctx, fmt.Sprintf("/b/%s", name), settings, nil)
 	if err != nil {
-		return Bucket{}, err
+		return nil, err
 	}
 
-	return newBucket(name, c.HTTPClient), err
+	return c.bucket(name), err
 }
 
-func (c *ReductClient) CreateOrGetBucket(ctx context.Context, name string, settings *model.BucketSetting) (Bucket, error) {
+func (c *ReductClient) CreateOrGetBucket(ctx context.Context, name string, settings *model.BucketSetting) (BucketAPI, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateOrGetBucket", Bucket: name})
 	if settings == nil {
 		settings = &model.BucketSetting{}
 	}
 
 	err := c.HTTPClient.Post(ctx, fmt.Sprintf("/b/%s", name), settings, nil)
 	if err != nil {
-		var apiErr *model.APIError
-		if errors.As(err, &apiErr) {
-			if apiErr.Status == 409 {
-				return c.GetBucket(ctx, name)
-			}
+		if errors.Is(err, model.ErrConflict) {
+			return c.GetBucket(ctx, name)
 		}
-		return Bucket{}, err
+		return nil, err
 	}
 
-	return newBucket(name, c.HTTPClient), err
+	return c.bucket(name), err
 }
 
 // CheckBucketExists checks if a bucket exists.
 func (c *ReductClient) CheckBucketExists(ctx context.Context, name string) (bool, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CheckBucketExists", Bucket: name})
 	err := c.HTTPClient.Head(ctx, fmt.Sprintf(`/b/%s`, name))
 	if err != nil {
 		return false, err
@@ -208,11 +316,13 @@ func (c *ReductClient) CheckBucketExists(ctx context.Context, name string) (bool
 
 // RemoveBucket removes a bucket.
 func (c *ReductClient) RemoveBucket(ctx context.Context, name string) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.RemoveBucket", Bucket: name})
 	return c.HTTPClient.Delete(ctx, fmt.Sprintf(`/b/%s`, name))
 }
 
 // GetTokens returns a list of tokens.
 func (c *ReductClient) GetTokens(ctx context.Context) ([]model.Token, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetTokens"})
 	var tokens map[string][]model.Token
 	err := c.HTTPClient.Get(ctx, "/tokens", &tokens)
 	if err != nil {
@@ -223,6 +333,7 @@ func (c *ReductClient) GetTokens(ctx context.Context) ([]model.Token, error) {
 
 // GetToken returns information about a token.
 func (c *ReductClient) GetToken(ctx context.Context, name string) (model.Token, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetToken"})
 	var token model.Token
 	err := c.HTTPClient.Get(ctx, fmt.Sprintf("/tokens/%s", name), &token)
 	if err != nil {
@@ -235,6 +346,7 @@ func (c *ReductClient) GetToken(ctx context.Context, name string) (model.Token,
 //
 // Deprecated: Use CreateTokenWithOptions. This method will be removed in v1.22.
 func (c *ReductClient) CreateToken(ctx context.Context, name string, permissions model.TokenPermissions) (string, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateToken"})
 	resp, err := c.CreateTokenWithOptions(ctx, name, model.TokenCreateOptions{Permissions: permissions})
 	if err != nil {
 		return "", err
@@ -244,6 +356,7 @@ func (c *ReductClient) CreateToken(ctx context.Context, name string, permissions
 
 // CreateTokenWithOptions creates a new token using API v2 payload.
 func (c *ReductClient) CreateTokenWithOptions(ctx context.Context, name string, options model.TokenCreateOptions) (model.TokenCreateResponse, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateTokenWithOptions"})
 	var token model.TokenCreateResponse
 	err := c.HTTPClient.Post(ctx, fmt.Sprintf("/tokens/%s", name), options, &token)
 	if err != nil {
@@ -254,6 +367,7 @@ func (c *ReductClient) CreateTokenWithOptions(ctx context.Context, name string,
 
 // RotateToken rotates a token value and revokes the previous one.
 func (c *ReductClient) RotateToken(ctx context.Context, name string) (model.TokenCreateResponse, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.RotateToken"})
 	var token model.TokenCreateResponse
 	err := c.HTTPClient.Post(ctx, fmt.Sprintf("/tokens/%s/rotate", name), struct{}{}, &token)
 	if err != nil {
@@ -264,13 +378,11 @@ func (c *ReductClient) RotateToken(ctx context.Context, name string) (model.Toke
 
 // RemoveToken removes a token.
 func (c *ReductClient) RemoveToken(ctx context.Context, name string) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.RemoveToken"})
 	err := c.HTTPClient.Delete(ctx, fmt.Sprintf("/tokens/%s", name))
 	if err != nil {
-		apiErr := &model.APIError{}
-		if errors.As(err, &apiErr) {
-			if apiErr.Status == 404 {
-				return nil
-			}
+		if errors.Is(err, model.ErrNotFound) {
+			return nil
 		}
 		return err
 	}
@@ -279,6 +391,7 @@ func (c *ReductClient) RemoveToken(ctx context.Context, name string) error {
 
 // GetCurrentToken returns the current token.
 func (c *ReductClient) GetCurrentToken(ctx context.Context) (model.Token, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetCurrentToken"})
 	var token model.Token
 	err := c.HTTPClient.Get(ctx, "/me", &token)
 	if err != nil {
@@ -341,6 +454,7 @@ func validateLifecycle(name string, lifecycle model.LifecycleSettings, defaultMo
 
 // GetReplicationTasks returns a list of replication tasks.
 func (c *ReductClient) GetReplicationTasks(ctx context.Context) ([]model.ReplicationInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetReplicationTasks"})
 	var tasks map[string][]model.ReplicationInfo
 	err := c.HTTPClient.Get(ctx, "/replications", &tasks)
 	if err != nil {
@@ -351,6 +465,7 @@ func (c *ReductClient) GetReplicationTasks(ctx context.Context) ([]model.Replica
 
 // GetReplicationTask returns a replication task.
 func (c *ReductClient) GetReplicationTask(ctx context.Context, name string) (model.FullReplicationInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetReplicationTask"})
 	var task model.FullReplicationInfo
 	err := c.HTTPClient.Get(ctx, fmt.Sprintf("/replications/%s", name), &task)
 	if err != nil {
@@ -361,6 +476,7 @@ func (c *ReductClient) GetReplicationTask(ctx context.Context, name string) (mod
 
 // CreateReplicationTask creates a new replication task.
 func (c *ReductClient) CreateReplicationTask(ctx context.Context, name string, task model.ReplicationSettings) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateReplicationTask"})
 	task, err := validateReplicationTask(name, task, true)
 	if err != nil {
 		return err
@@ -375,6 +491,7 @@ func (c *ReductClient) CreateReplicationTask(ctx context.Context, name string, t
 
 // UpdateReplicationTask updates an existing replication task.
 func (c *ReductClient) UpdateReplicationTask(ctx context.Context, name string, task model.ReplicationSettings) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.UpdateReplicationTask"})
 	task, err := validateReplicationTask(name, task, false)
 	if err != nil {
 		return err
@@ -389,6 +506,7 @@ func (c *ReductClient) UpdateReplicationTask(ctx context.Context, name string, t
 
 // SetReplicationMode updates the mode of an existing replication task.
 func (c *ReductClient) SetReplicationMode(ctx context.Context, name string, mode model.ReplicationMode) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.SetReplicationMode"})
 	if name == "" {
 		return fmt.Errorf("name is required")
 	}
@@ -405,11 +523,13 @@ func (c *ReductClient) SetReplicationMode(ctx context.Context, name string, mode
 
 // RemoveReplicationTask removes a replication task.
 func (c *ReductClient) RemoveReplicationTask(ctx context.Context, name string) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.RemoveReplicationTask"})
 	return c.HTTPClient.Delete(ctx, fmt.Sprintf("/replications/%s", name))
 }
 
 // GetLifecycles returns a list of lifecycle policies.
 func (c *ReductClient) GetLifecycles(ctx context.Context) ([]model.LifecycleInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetLifecycles"})
 	var lifecycles map[string][]model.LifecycleInfo
 	err := c.HTTPClient.Get(ctx, "/lifecycles", &lifecycles)
 	if err != nil {
@@ -420,6 +540,7 @@ func (c *ReductClient) GetLifecycles(ctx context.Context) ([]model.LifecycleInfo
 
 // GetLifecycle returns full lifecycle policy info.
 func (c *ReductClient) GetLifecycle(ctx context.Context, name string) (model.FullLifecycleInfo, error) {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.GetLifecycle"})
 	var lifecycle model.FullLifecycleInfo
 	err := c.HTTPClient.Get(ctx, fmt.Sprintf("/lifecycles/%s", name), &lifecycle)
 	if err != nil {
@@ -430,6 +551,7 @@ func (c *ReductClient) GetLifecycle(ctx context.Context, name string) (model.Ful
 
 // CreateLifecycle creates a new lifecycle policy.
 func (c *ReductClient) CreateLifecycle(ctx context.Context, name string, settings model.LifecycleSettings) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.CreateLifecycle"})
 	settings, err := validateLifecycle(name, settings, true)
 	if err != nil {
 		return err
@@ -444,6 +566,7 @@ func (c *ReductClient) CreateLifecycle(ctx context.Context, name string, setting
 
 // UpdateLifecycle updates an existing lifecycle policy.
 func (c *ReductClient) UpdateLifecycle(ctx context.Context, name string, settings model.LifecycleSettings) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.UpdateLifecycle"})
 	settings, err := validateLifecycle(name, settings, false)
 	if err != nil {
 		return err
@@ -458,6 +581,7 @@ func (c *ReductClient) UpdateLifecycle(ctx context.Context, name string, setting
 
 // SetLifecycleMode updates the mode of an existing lifecycle policy.
 func (c *ReductClient) SetLifecycleMode(ctx context.Context, name string, mode model.LifecycleMode) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.SetLifecycleMode"})
 	if name == "" {
 		return fmt.Errorf("name is required")
 	}
@@ -474,5 +598,6 @@ func (c *ReductClient) SetLifecycleMode(ctx context.Context, name string, mode m
 
 // RemoveLifecycle removes a lifecycle policy.
 func (c *ReductClient) RemoveLifecycle(ctx context.Context, name string) error {
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "Client.RemoveLifecycle"})
 	return c.HTTPClient.Delete(ctx, fmt.Sprintf("/lifecycles/%s", name))
 }
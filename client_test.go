@@ -13,7 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func removeEntryWithRetry(t *testing.T, bucket *Bucket, entry string) {
+func removeEntryWithRetry(t *testing.T, bucket BucketAPI, entry string) {
 	t.Helper()
 
 	ctx := context.Background()
@@ -53,7 +53,7 @@ func TestCreateOrGetBucket_Success(t *testing.T) {
 		WithMaxBlockRecords(1000).WithMaxBlockSize(1024).Build()
 	bucket, err := client.CreateOrGetBucket(context.Background(), mainTestBucket.Name, &settings)
 	assert.NoError(t, err)
-	assert.Equal(t, bucket.Name, mainTestBucket.Name)
+	assert.Equal(t, bucket.GetName(), mainTestBucket.Name)
 }
 
 func TestGetBucket_NotFound(t *testing.T) {
@@ -238,7 +238,7 @@ func TestBucketRemoveEntry(t *testing.T) {
 	writer := bucket.BeginWrite(context.Background(), "test-entry", nil)
 	err = writer.Write([]byte("test-data"))
 	assert.NoError(t, err)
-	removeEntryWithRetry(t, &bucket, "test-entry")
+	removeEntryWithRetry(t, bucket, "test-entry")
 	entries, err := bucket.GetEntries(context.Background())
 	assert.NoError(t, err)
 	// With non-blocking deletions in v1.18+, the entry may still be visible with DELETING status
@@ -438,10 +438,10 @@ func TestCreateBucket_Success(t *testing.T) {
 		WithMaxBlockRecords(1000).WithMaxBlockSize(1024).Build()
 	info, err := client.CreateBucket(ctx, newBucketName, &settings)
 	assert.NoError(t, err)
-	assert.Equal(t, newBucketName, info.Name)
+	assert.Equal(t, newBucketName, info.GetName())
 
 	// remove the created bucket
-	err = client.RemoveBucket(ctx, info.Name)
+	err = client.RemoveBucket(ctx, info.GetName())
 	assert.NoError(t, err)
 }
 
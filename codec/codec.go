@@ -0,0 +1,175 @@
+// Package codec encodes and decodes record payloads by their content type.
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Content types of the built-in codecs.
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeCBOR        = "application/cbor"
+	ContentTypeRaw         = "application/octet-stream"
+)
+
+// ErrUnknownContentType is returned when no codec is registered for a content type.
+var ErrUnknownContentType = errors.New("no codec for content type")
+
+// Codec encodes values to record payloads of one content type and back.
+type Codec interface {
+	// ContentType is the content type the payloads are stored with.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes values with encoding/json.
+	JSON Codec = jsonCodec{}
+	// MessagePack encodes values with github.com/vmihailenco/msgpack, which
+	// uses the msgpack struct tags and falls back to the json ones.
+	MessagePack Codec = msgpackCodec{}
+	// CBOR encodes values with github.com/fxamacker/cbor, which uses the cbor
+	// struct tags and falls back to the json ones.
+	CBOR Codec = cborCodec{}
+	// Raw stores []byte and string values as they are, and values that
+	// implement encoding.BinaryMarshaler as their binary form.
+	Raw Codec = rawCodec{}
+)
+
+// Default is the registry with the built-in codecs. MessagePack is also
+// registered as application/x-msgpack and application/vnd.msgpack.
+var Default = func() *Registry {
+	r := NewRegistry(JSON, MessagePack, CBOR, Raw)
+	r.Register(MessagePack, "application/x-msgpack", "application/vnd.msgpack")
+	return r
+}()
+
+// Registry looks up codecs by content type. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// NewRegistry creates a registry with the codecs.
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{codecs: make(map[string]Codec, len(codecs))}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register adds a codec for its content type and the additional content
+// types, replacing the codecs registered for them before.
+func (r *Registry) Register(c Codec, contentTypes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, contentType := range append([]string{c.ContentType()}, contentTypes...) {
+		r.codecs[mediaType(contentType)] = c
+	}
+}
+
+// Lookup returns the codec for a content type. Parameters such as charset are
+// ignored, and a structured syntax suffix selects the codec of its base type,
+// e.g. application/vnd.api+json selects the codec of application/json.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	typ := mediaType(contentType)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.codecs[typ]; ok {
+		return c, nil
+	}
+	if _, suffix, ok := strings.Cut(typ, "+"); ok {
+		if c, ok := r.codecs["application/"+suffix]; ok {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownContentType, contentType)
+}
+
+// mediaType returns the content type in lower case without parameters.
+func mediaType(contentType string) string {
+	if typ, _, err := mime.ParseMediaType(contentType); err == nil {
+		return typ
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return ContentTypeMessagePack }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string { return ContentTypeCBOR }
+
+func (cborCodec) Marshal(v any) ([]byte, error) { return cbor.Marshal(v) }
+
+func (cborCodec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string { return ContentTypeRaw }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	default:
+		return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = data
+	case *string:
+		*v = string(data)
+	case encoding.BinaryUnmarshaler:
+		return v.UnmarshalBinary(data)
+	default:
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sample struct {
+	Name   string            `json:"name"`
+	Count  int               `json:"count"`
+	Ratio  float64           `json:"ratio"`
+	Tags   []string          `json:"tags"`
+	Fields map[string]string `json:"fields"`
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	value := sample{Name: "a", Count: 3, Ratio: 0.5, Tags: []string{"x", "y"}, Fields: map[string]string{"k": "v"}}
+	for _, c := range []Codec{JSON, MessagePack, CBOR} {
+		data, err := c.Marshal(value)
+		require.NoError(t, err, c.ContentType())
+
+		var decoded sample
+		require.NoError(t, c.Unmarshal(data, &decoded), c.ContentType())
+		assert.Equal(t, value, decoded, c.ContentType())
+	}
+}
+
+func TestMessagePackAndCBORUseJSONTags(t *testing.T) {
+	for _, c := range []Codec{MessagePack, CBOR} {
+		data, err := c.Marshal(sample{Name: "a"})
+		require.NoError(t, err)
+
+		var fields map[string]any
+		require.NoError(t, c.Unmarshal(data, &fields))
+		assert.Contains(t, fields, "name", c.ContentType())
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	data, err := Raw.Marshal([]byte{1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, data)
+
+	data, err = Raw.Marshal("text")
+	require.NoError(t, err)
+	var text string
+	require.NoError(t, Raw.Unmarshal(data, &text))
+	assert.Equal(t, "text", text)
+
+	_, err = Raw.Marshal(1)
+	require.Error(t, err)
+	require.Error(t, Raw.Unmarshal(data, &sample{}))
+}
+
+// upperCodec stores strings in upper case.
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "text/x-upper" }
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func TestRegistryLookup(t *testing.T) {
+	tests := map[string]Codec{
+		"application/json":                JSON,
+		"Application/JSON; charset=utf-8": JSON,
+		"application/vnd.api+json":        JSON,
+		"application/msgpack":             MessagePack,
+		"application/x-msgpack":           MessagePack,
+		"application/cbor":                CBOR,
+		"application/octet-stream":        Raw,
+	}
+	for contentType, want := range tests {
+		got, err := Default.Lookup(contentType)
+		require.NoError(t, err, contentType)
+		assert.Equal(t, want, got, contentType)
+	}
+
+	_, err := Default.Lookup("image/png")
+	assert.ErrorIs(t, err, ErrUnknownContentType)
+
+	registry := NewRegistry(JSON)
+	registry.Register(upperCodec{}, "text/plain")
+	for _, contentType := range []string{"text/x-upper", "text/plain"} {
+		got, err := registry.Lookup(contentType)
+		require.NoError(t, err)
+		assert.Equal(t, upperCodec{}, got)
+	}
+	_, err = registry.Lookup(ContentTypeCBOR)
+	assert.ErrorIs(t, err, ErrUnknownContentType)
+}
@@ -0,0 +1,186 @@
+package reductgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm a record payload is compressed with.
+type Compression string
+
+const (
+	// CompressionGzip compresses payloads with gzip (RFC 1952).
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses payloads with Zstandard (RFC 8878).
+	CompressionZstd Compression = "zstd"
+)
+
+// Reserved labels of compressed records. Reads use them to decompress the
+// payload, so they must not be changed by updates.
+const (
+	// CompressionLabel is the algorithm the payload is compressed with.
+	CompressionLabel = "reduct-compression"
+	// UncompressedSizeLabel is the size of the payload before compression.
+	UncompressedSizeLabel = "reduct-uncompressed-size"
+)
+
+// CompressionOptions enables compression of record payloads on writes.
+// Records are decompressed transparently by ReadableRecord.Read and Stream.
+type CompressionOptions struct {
+	// Algorithm is the compression algorithm.
+	Algorithm Compression
+	// Level is the compression level of the algorithm: 1 to 9 for gzip and 1
+	// to 22 for zstd. 0 selects the default level.
+	Level int
+	// MinSize is the smallest payload in bytes that is compressed.
+	MinSize int
+	// OnlyIfSmaller stores a payload uncompressed if compression does not make
+	// it smaller, e.g. for data that is already compressed.
+	OnlyIfSmaller bool
+}
+
+// zstdEncoders caches an encoder per level; EncodeAll is safe for concurrent use.
+var zstdEncoders sync.Map // map[int]*zstd.Encoder
+
+// compress returns the payload to store and the labels with the reserved
+// labels set if the payload was compressed. A nil receiver changes nothing.
+func (o *CompressionOptions) compress(data []byte, labels LabelMap) ([]byte, LabelMap, error) {
+	if o == nil || len(data) == 0 || len(data) < o.MinSize {
+		return data, labels, nil
+	}
+
+	var compressed []byte
+	switch o.Algorithm {
+	case CompressionGzip:
+		level := o.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		var buf bytes.Buffer
+		w, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, nil, err
+		}
+		compressed = buf.Bytes()
+	case CompressionZstd:
+		encoder, err := zstdEncoder(o.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+		compressed = encoder.EncodeAll(data, nil)
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression %q", o.Algorithm)
+	}
+
+	if o.OnlyIfSmaller && len(compressed) >= len(data) {
+		return data, labels, nil
+	}
+	withReserved := make(LabelMap, len(labels)+2)
+	for name, value := range labels {
+		withReserved[name] = value
+	}
+	withReserved[CompressionLabel] = string(o.Algorithm)
+	withReserved[UncompressedSizeLabel] = strconv.Itoa(len(data))
+	return compressed, withReserved, nil
+}
+
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	if encoder, ok := zstdEncoders.Load(level); ok {
+		return encoder.(*zstd.Encoder), nil
+	}
+	options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := zstdEncoders.LoadOrStore(level, encoder)
+	return actual.(*zstd.Encoder), nil
+}
+
+// compressionOf returns the algorithm and uncompressed size of a record from
+// its reserved labels; size is -1 if it is unknown.
+func compressionOf(labels LabelMap) (algorithm Compression, size int64) {
+	name, ok := labels[CompressionLabel].(string)
+	if !ok || name == "" {
+		return "", -1
+	}
+	size = -1
+	if text, ok := labels[UncompressedSizeLabel].(string); ok {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			size = n
+		}
+	}
+	return Compression(name), size
+}
+
+// decompressReader decompresses a record stream. The decoder is created on
+// the first Read, so that the stream is not touched before the record is
+// read; the records of a batch share one response body.
+type decompressReader struct {
+	src       io.Reader
+	algorithm Compression
+	decoder   io.Reader
+	release   func()
+	err       error
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if d.decoder == nil && d.err == nil {
+		d.open()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.decoder.Read(p)
+	if err != nil {
+		d.err = err
+		if d.release != nil {
+			d.release()
+			d.release = nil
+		}
+	}
+	return n, err
+}
+
+func (d *decompressReader) open() {
+	switch d.algorithm {
+	case CompressionGzip:
+		r, err := gzip.NewReader(d.src)
+		switch {
+		case errors.Is(err, io.EOF):
+			// The stream is empty, e.g. of a metadata-only record.
+			d.err = io.EOF
+			return
+		case err != nil:
+			d.err = fmt.Errorf("decompress record: %w", err)
+			return
+		}
+		d.decoder = r
+	case CompressionZstd:
+		r, err := zstd.NewReader(d.src, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.decoder = r
+		d.release = r.Close
+	default:
+		d.err = fmt.Errorf("unsupported compression %q", d.algorithm)
+	}
+}
@@ -0,0 +1,155 @@
+package reductgo
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	payload := strings.Repeat(`{"x":1.5,"y":-2,"z":0.25}`, 400)
+
+	for _, algorithm := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			bucket := newBufferedTestBucket(t)
+			compression := &CompressionOptions{Algorithm: algorithm}
+
+			require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{
+				Timestamp:   1,
+				Labels:      LabelMap{"kind": "points"},
+				Compression: compression,
+			}).Write(strings.NewReader(payload)))
+
+			batch := bucket.BeginWriteBatch(ctx, "entry")
+			batch.SetCompression(compression)
+			batch.Add(2, []byte(payload), "", nil)
+			batch.Add(3, []byte(payload), "", nil)
+			errs, err := batch.Write(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, errs)
+
+			recordBatch := bucket.BeginWriteRecordBatch(ctx)
+			recordBatch.SetCompression(compression)
+			recordBatch.Add("entry", 4, []byte(payload), "", nil)
+			recordErrs, err := recordBatch.Send(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, recordErrs)
+
+			writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{Compression: compression})
+			require.NoError(t, writer.Write(ctx, "entry", 5, []byte(payload), "", nil))
+			require.NoError(t, writer.Close(ctx))
+
+			record, err := bucket.BeginRead(ctx, "entry", nil)
+			require.NoError(t, err)
+			data, err := record.Read()
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(data))
+
+			result, err := bucket.Query(ctx, "entry", nil)
+			require.NoError(t, err)
+			count := 0
+			for record := range result.Records() {
+				count++
+				assert.Equal(t, algorithm, record.Compression())
+				assert.Equal(t, int64(len(payload)), record.Size())
+				assert.Less(t, record.StoredSize(), record.Size())
+				data, err := io.ReadAll(record.Stream())
+				require.NoError(t, err)
+				assert.Equal(t, payload, string(data), "record %d", record.Time())
+			}
+			require.NoError(t, result.Err())
+			assert.Equal(t, 5, count)
+
+			ts := int64(1)
+			record, err = bucket.BeginMetadataRead(ctx, "entry", &ts)
+			require.NoError(t, err)
+			assert.Equal(t, "points", record.Labels()["kind"])
+			assert.Equal(t, int64(len(payload)), record.Size())
+			data, err = record.Read()
+			require.NoError(t, err)
+			assert.Empty(t, data)
+		})
+	}
+}
+
+func TestCompressionHeuristics(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	random := make([]byte, 4096)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetCompression(&CompressionOptions{Algorithm: CompressionZstd, OnlyIfSmaller: true, MinSize: 100})
+	batch.Add(1, random, "", nil)
+	batch.Add(2, []byte(strings.Repeat("a", 99)), "", nil)
+	batch.Add(3, []byte(strings.Repeat("a", 100)), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	result, err := bucket.Query(ctx, "entry", nil)
+	require.NoError(t, err)
+	var compressed []int64
+	for record := range result.Records() {
+		if record.Compression() != "" {
+			compressed = append(compressed, record.Time())
+		}
+		_, err := record.Read()
+		require.NoError(t, err)
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, []int64{3}, compressed, "only the compressible record above the minimum size")
+}
+
+func TestCompressionErrors(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	unsupported := &CompressionOptions{Algorithm: "lz4"}
+
+	err := bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Compression: unsupported}).Write("data")
+	require.ErrorContains(t, err, "unsupported compression")
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetCompression(unsupported)
+	batch.Add(1, []byte("data"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusInvalidRequest, errs[1].Status)
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{
+		Timestamp: 2,
+		Labels:    LabelMap{CompressionLabel: string(CompressionGzip)},
+	}).Write("not gzip"))
+	ts := int64(2)
+	record, err := bucket.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	_, err = record.Read()
+	require.ErrorContains(t, err, "decompress record")
+}
+
+func TestCompressionForgedSize(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	payload := strings.Repeat("compressible ", 100)
+	compression := &CompressionOptions{Algorithm: CompressionGzip}
+	for ts := int64(1); ts <= 2; ts++ {
+		require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: ts, Compression: compression}).Write(payload))
+	}
+
+	for ts, size := range map[int64]string{1: "100000000000000", 2: "10"} {
+		require.NoError(t, bucket.Update(ctx, "entry", ts, LabelMap{UncompressedSizeLabel: size}))
+		record, err := bucket.BeginRead(ctx, "entry", &ts)
+		require.NoError(t, err)
+		_, err = record.Read()
+		require.ErrorContains(t, err, "its labels say "+size, "the size label is only a hint")
+	}
+}
@@ -0,0 +1,426 @@
+package reductgo
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/reductstore/reduct-go/httpclient"
+)
+
+const (
+	// ContentHashLabel is the hex SHA-256 of the payload of a record written
+	// with deduplication, before compression and encryption. Duplicates of a
+	// record are found with a query like {"&content-hash": {"$eq": hash}}.
+	// For an encrypted record, the hash is keyed with the key the record is
+	// encrypted with, like ChunkHashLabel, so that it does not reveal the
+	// payload; duplicates are then only found among records with the same key.
+	ContentHashLabel = "content-hash"
+	// DuplicateOfLabel is the timestamp of the record that a record written
+	// with DedupLabel duplicates.
+	DuplicateOfLabel = "duplicate-of"
+)
+
+// DefaultDedupIndexSize is the number of hashes per entry that the default
+// index of DedupOptions keeps.
+const DefaultDedupIndexSize = 10000
+
+// DedupMode decides what a write does with a record whose payload duplicates
+// the one of a record of the entry.
+type DedupMode int
+
+const (
+	// DedupSkip does not write the duplicate.
+	DedupSkip DedupMode = iota
+	// DedupLabel writes the duplicate with DuplicateOfLabel.
+	DedupLabel
+)
+
+// DedupOptions enables deduplicated writes: the payload of every record is
+// hashed with SHA-256 and labeled with ContentHashLabel, and a record whose
+// payload the entry already has, according to the index, or that an earlier
+// record of the same batch has, is handled by the mode. Records added to a
+// batch with AddReader are not deduplicated. The options can be shared by
+// the writes to several buckets.
+type DedupOptions struct {
+	Mode DedupMode
+	// Index remembers the hashes of the written records by bucket and entry.
+	// Defaults to a MemoryDedupIndex with DefaultDedupIndexSize hashes per
+	// entry, shared by the writes with the options.
+	Index DedupIndex
+
+	mu           sync.Mutex
+	defaultIndex DedupIndex
+}
+
+// DedupIndex remembers the payload hashes of written records by bucket and
+// entry. It must be safe for concurrent use.
+type DedupIndex interface {
+	// Lookup returns the timestamp of the record of the entry of the bucket
+	// with the payload hash.
+	Lookup(bucket, entry, hash string) (int64, bool)
+	// Add remembers the record of the entry of the bucket with the payload
+	// hash, unless the index has one already.
+	Add(bucket, entry, hash string, ts int64) error
+}
+
+// index returns the index of the options.
+func (o *DedupOptions) index() DedupIndex {
+	if o.Index != nil {
+		return o.Index
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.defaultIndex == nil {
+		o.defaultIndex = NewMemoryDedupIndex(DefaultDedupIndexSize)
+	}
+	return o.defaultIndex
+}
+
+// contentHash returns the value of ContentHashLabel for the payload of a
+// record encrypted with kek, nil if it is not encrypted.
+func contentHash(data []byte, kek []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	return hashLabel(sum[:], kek)
+}
+
+// withDedupHash returns a copy of the labels with the hash of the payload and
+// the encryption to encode the payload with, pinned to the key the hash is
+// keyed with, see EncryptionOptions.pinned.
+func withDedupHash(labels LabelMap, data []byte, encryption *EncryptionOptions,
+) (LabelMap, *EncryptionOptions, error) {
+	encryption, kek, err := encryption.pinned()
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := contentHash(data, kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels = maps.Clone(labels)
+	if labels == nil {
+		labels = LabelMap{}
+	}
+	labels[ContentHashLabel] = hash
+	return labels, encryption, nil
+}
+
+// dedupBatch is a write batch without the duplicates skipped by the options.
+type dedupBatch struct {
+	options *DedupOptions
+	bucket  string
+	// written are the records to write, with DuplicateOfLabel set by DedupLabel.
+	written []*recordBatchRecord
+	// originals maps the duplicates to the records with the same payload.
+	originals map[recordBatchKey]recordBatchKey
+}
+
+// dedup finds the duplicates among the records of a write batch to the
+// bucket, sorted by entry and timestamp.
+func (o *DedupOptions) dedup(bucket string, records []*recordBatchRecord) *dedupBatch {
+	index := o.index()
+	batch := &dedupBatch{
+		options:   o,
+		bucket:    bucket,
+		written:   make([]*recordBatchRecord, 0, len(records)),
+		originals: map[recordBatchKey]recordBatchKey{},
+	}
+	seen := map[string]map[string]int64{} // timestamps of the hashes of the batch by entry
+	for _, rec := range records {
+		hash, _ := rec.labels[ContentHashLabel].(string)
+		if hash == "" || rec.addErr != nil {
+			batch.written = append(batch.written, rec)
+			continue
+		}
+		key := recordBatchKey{entry: rec.entry, ts: rec.timestamp}
+		original, found := seen[rec.entry][hash]
+		if !found {
+			original, found = index.Lookup(bucket, rec.entry, hash)
+		}
+		if !found {
+			if seen[rec.entry] == nil {
+				seen[rec.entry] = map[string]int64{}
+			}
+			seen[rec.entry][hash] = rec.timestamp
+			batch.written = append(batch.written, rec)
+			continue
+		}
+		batch.originals[key] = recordBatchKey{entry: rec.entry, ts: original}
+		if o.Mode == DedupLabel {
+			labeled := *rec
+			labeled.labels = maps.Clone(rec.labels)
+			labeled.labels[DuplicateOfLabel] = strconv.FormatInt(original, 10)
+			batch.written = append(batch.written, &labeled)
+		}
+	}
+	return batch
+}
+
+// finish adds the written records that are not duplicates to the index and
+// returns the timestamps of the originals of the duplicates by entry. A
+// skipped duplicate of a record of the batch that failed gets its error, and
+// shifted maps the records moved by ConflictShift to their timestamps. The
+// index is only a cache of the entry, so its errors are logged.
+func (d *dedupBatch) finish(ctx context.Context, client httpclient.HTTPClient, errs RecordBatchErrorMap,
+	shifted map[string]map[int64]int64,
+) map[string]map[int64]int64 {
+	index := d.options.index()
+	moved := func(key recordBatchKey) int64 {
+		if ts, ok := shifted[key.entry][key.ts]; ok {
+			return ts
+		}
+		return key.ts
+	}
+
+	for _, rec := range d.written {
+		key := recordBatchKey{entry: rec.entry, ts: rec.timestamp}
+		hash, _ := rec.labels[ContentHashLabel].(string)
+		if _, duplicate := d.originals[key]; duplicate || hash == "" {
+			continue
+		}
+		if _, failed := errs[key.entry][key.ts]; failed {
+			continue
+		}
+		if err := index.Add(d.bucket, key.entry, hash, moved(key)); err != nil {
+			httpclient.LoggerOf(client).WarnContext(ctx, "failed to add record to dedup index",
+				"bucket", d.bucket, "entry", key.entry, "ts", moved(key), "error", err)
+		}
+	}
+
+	deduplicated := map[string]map[int64]int64{}
+	for duplicate, original := range d.originals {
+		if d.options.Mode == DedupSkip {
+			if originalErr, failed := errs[original.entry][original.ts]; failed {
+				errs.add(duplicate.entry, duplicate.ts, originalErr)
+				continue
+			}
+		} else if _, failed := errs[duplicate.entry][duplicate.ts]; failed {
+			continue
+		}
+		if deduplicated[duplicate.entry] == nil {
+			deduplicated[duplicate.entry] = map[int64]int64{}
+		}
+		deduplicated[duplicate.entry][moved(duplicate)] = moved(original)
+	}
+	return deduplicated
+}
+
+// MemoryDedupIndex is a DedupIndex in memory that keeps the most recently
+// used hashes of every entry.
+type MemoryDedupIndex struct {
+	mu      sync.Mutex
+	size    int
+	entries map[dedupEntryKey]*dedupEntry
+}
+
+// dedupEntryKey identifies an entry of a bucket in an index.
+type dedupEntryKey struct {
+	bucket string
+	entry  string
+}
+
+// dedupEntry is the index of an entry; the most recently used hash is at the
+// front of the order.
+type dedupEntry struct {
+	order  *list.List
+	hashes map[string]*list.Element
+}
+
+type dedupItem struct {
+	hash string
+	ts   int64
+}
+
+// NewMemoryDedupIndex creates an index that keeps size hashes per entry.
+// Defaults to DefaultDedupIndexSize if size is not positive.
+func NewMemoryDedupIndex(size int) *MemoryDedupIndex {
+	if size <= 0 {
+		size = DefaultDedupIndexSize
+	}
+	return &MemoryDedupIndex{size: size, entries: map[dedupEntryKey]*dedupEntry{}}
+}
+
+// Lookup implements DedupIndex.
+func (m *MemoryDedupIndex) Lookup(bucket, entry, hash string) (int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := m.entries[dedupEntryKey{bucket: bucket, entry: entry}]
+	if index == nil {
+		return 0, false
+	}
+	element, ok := index.hashes[hash]
+	if !ok {
+		return 0, false
+	}
+	index.order.MoveToFront(element)
+	return element.Value.(dedupItem).ts, true
+}
+
+// Add implements DedupIndex. The least recently used hash of the entry is
+// dropped when the entry has more than the size of the index.
+func (m *MemoryDedupIndex) Add(bucket, entry, hash string, ts int64) error {
+	m.add(bucket, entry, hash, ts)
+	return nil
+}
+
+// add adds the hash and reports whether the index did not have it.
+func (m *MemoryDedupIndex) add(bucket, entry, hash string, ts int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := dedupEntryKey{bucket: bucket, entry: entry}
+	index := m.entries[key]
+	if index == nil {
+		index = &dedupEntry{order: list.New(), hashes: map[string]*list.Element{}}
+		m.entries[key] = index
+	}
+	if element, ok := index.hashes[hash]; ok {
+		index.order.MoveToFront(element)
+		return false
+	}
+	index.hashes[hash] = index.order.PushFront(dedupItem{hash: hash, ts: ts})
+	if index.order.Len() > m.size {
+		oldest := index.order.Back()
+		index.order.Remove(oldest)
+		delete(index.hashes, oldest.Value.(dedupItem).hash)
+	}
+	return true
+}
+
+// Len returns the number of hashes in the index.
+func (m *MemoryDedupIndex) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, index := range m.entries {
+		count += index.order.Len()
+	}
+	return count
+}
+
+// FileDedupIndex is a MemoryDedupIndex that is persisted in a file, so that
+// duplicates are found across restarts. Every added hash is appended to the
+// file, which is compacted to the hashes of the index when it is opened and
+// when it grows to twice their number.
+type FileDedupIndex struct {
+	path   string
+	memory *MemoryDedupIndex
+
+	mu    sync.Mutex
+	file  *os.File
+	lines int
+}
+
+// OpenFileDedupIndex opens the index in the file and creates it if needed.
+// It keeps size hashes per entry, see NewMemoryDedupIndex. Lines that cannot
+// be parsed, e.g. one that was partly written before a crash, are skipped.
+func OpenFileDedupIndex(path string, size int) (*FileDedupIndex, error) {
+	f := &FileDedupIndex{path: path, memory: NewMemoryDedupIndex(size)}
+	file, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("open dedup index: %w", err)
+	default:
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 4 {
+				continue
+			}
+			if ts, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+				f.memory.add(fields[0], fields[1], fields[2], ts)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read dedup index: %w", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lookup implements DedupIndex.
+func (f *FileDedupIndex) Lookup(bucket, entry, hash string) (int64, bool) {
+	return f.memory.Lookup(bucket, entry, hash)
+}
+
+// Add implements DedupIndex.
+func (f *FileDedupIndex) Add(bucket, entry, hash string, ts int64) error {
+	if !f.memory.add(bucket, entry, hash, ts) {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return errors.New("dedup index is closed")
+	}
+	if _, err := fmt.Fprintf(f.file, "%s %s %s %d\n", bucket, entry, hash, ts); err != nil {
+		return fmt.Errorf("write dedup index: %w", err)
+	}
+	f.lines++
+	if f.lines >= 2*max(f.memory.Len(), f.memory.size) {
+		return f.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file with the hashes of the index, the least recently
+// used first; the caller holds mu.
+func (f *FileDedupIndex) compact() error {
+	var out strings.Builder
+	lines := 0
+	f.memory.mu.Lock()
+	for key, index := range f.memory.entries {
+		for element := index.order.Back(); element != nil; element = element.Prev() {
+			item := element.Value.(dedupItem)
+			fmt.Fprintf(&out, "%s %s %s %d\n", key.bucket, key.entry, item.hash, item.ts)
+			lines++
+		}
+	}
+	f.memory.mu.Unlock()
+
+	if err := os.WriteFile(f.path+".tmp", []byte(out.String()), 0o600); err != nil {
+		return fmt.Errorf("compact dedup index: %w", err)
+	}
+	if err := os.Rename(f.path+".tmp", f.path); err != nil {
+		return fmt.Errorf("compact dedup index: %w", err)
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		f.file = nil
+		return fmt.Errorf("open dedup index: %w", err)
+	}
+	f.file = file
+	f.lines = lines
+	return nil
+}
+
+// Close closes the file of the index.
+func (f *FileDedupIndex) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
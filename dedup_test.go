@@ -0,0 +1,340 @@
+package reductgo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/reducttest"
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func queryContentHash(t *testing.T, bucket *Bucket, entry, hash string) []int64 {
+	t.Helper()
+	result, err := bucket.Query(context.Background(), entry, &QueryOptions{
+		When: map[string]any{"&" + ContentHashLabel: map[string]any{"$eq": hash}},
+	})
+	require.NoError(t, err)
+	var timestamps []int64
+	for record := range result.Records() {
+		timestamps = append(timestamps, record.Time())
+	}
+	require.NoError(t, result.Err())
+	return timestamps
+}
+
+func TestWriteDedup(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	dedup := &DedupOptions{}
+
+	write := func(ts int64, data any, options *DedupOptions) *WritableRecord {
+		record := bucket.BeginWrite(ctx, "entry", &WriteOptions{
+			Timestamp:   ts,
+			Dedup:       options,
+			Compression: &CompressionOptions{Algorithm: CompressionGzip},
+		})
+		require.NoError(t, record.Write(data))
+		return record
+	}
+
+	_, duplicate := write(1, "payload", dedup).DuplicateOf()
+	assert.False(t, duplicate)
+	original, duplicate := write(2, strings.NewReader("payload"), dedup).DuplicateOf()
+	assert.True(t, duplicate)
+	assert.Equal(t, int64(1), original)
+	assert.Equal(t, 1, countEntryRecords(t, bucket, "entry"), "the duplicate is skipped")
+
+	labeled := write(3, []byte("payload"), &DedupOptions{Mode: DedupLabel, Index: dedup.index()})
+	original, duplicate = labeled.DuplicateOf()
+	assert.True(t, duplicate)
+	assert.Equal(t, int64(1), original)
+	labels := mustReadEntry(t, bucket, "entry", 3).Labels()
+	assert.Equal(t, "1", labels[DuplicateOfLabel])
+
+	hash := sha256Hex("payload")
+	assert.Equal(t, hash, labels[ContentHashLabel], "the hash is of the uncompressed payload")
+	assert.Equal(t, []int64{1, 3}, queryContentHash(t, bucket, "entry", hash))
+
+	_, duplicate = write(4, "other", dedup).DuplicateOf()
+	assert.False(t, duplicate)
+	ts, found := dedup.index().Lookup("bucket", "entry", sha256Hex("other"))
+	assert.True(t, found)
+	assert.Equal(t, int64(4), ts)
+}
+
+func TestBatchDedup(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	dedup := &DedupOptions{}
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(dedup)
+	batch.Add(1, []byte("a"), "", nil)
+	batch.Add(2, []byte("b"), "", nil)
+	batch.Add(3, []byte("a"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[int64]int64{3: 1}, batch.Deduplicated())
+	assert.Equal(t, 2, countEntryRecords(t, bucket, "entry"))
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(dedup)
+	batch.Add(4, []byte("b"), "", nil)
+	batch.Add(5, []byte("a"), "", nil)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[int64]int64{4: 2, 5: 1}, batch.Deduplicated(), "duplicates are found with the index")
+	assert.Equal(t, 2, countEntryRecords(t, bucket, "entry"))
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.SetDedup(&DedupOptions{Mode: DedupLabel, Index: dedup.index()})
+	recordBatch.Add("entry", 6, []byte("a"), "", nil)
+	recordBatch.Add("other", 1, []byte("a"), "", nil)
+	recordBatch.Add("other", 2, []byte("a"), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+	assert.Equal(t, map[string]map[int64]int64{"entry": {6: 1}, "other": {2: 1}}, recordBatch.Deduplicated(),
+		"entries are deduplicated separately")
+	assert.Equal(t, "1", mustReadEntry(t, bucket, "entry", 6).Labels()[DuplicateOfLabel])
+	assert.NotContains(t, mustReadEntry(t, bucket, "other", 1).Labels(), DuplicateOfLabel)
+	assert.Equal(t, []int64{1, 6}, queryContentHash(t, bucket, "entry", sha256Hex("a")))
+
+	writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{
+		Dedup:   dedup,
+		OnError: func(entry string, ts int64, err error) { t.Errorf("%s/%d failed: %v", entry, ts, err) },
+	})
+	require.NoError(t, writer.Write(ctx, "entry", 7, []byte("b"), "", nil))
+	require.NoError(t, writer.Write(ctx, "entry", 8, []byte("c"), "", nil))
+	require.NoError(t, writer.Close(ctx))
+	assert.Equal(t, 4, countEntryRecords(t, bucket, "entry"))
+}
+
+func TestBatchDedupErrors(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("existing"))
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(&DedupOptions{})
+	batch.Add(1, []byte("a"), "", nil)
+	batch.Add(2, []byte("a"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, errs[1].Status)
+	assert.Equal(t, errs[1], errs[2], "a skipped duplicate fails with its original")
+	assert.Empty(t, batch.Deduplicated())
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(&DedupOptions{})
+	batch.SetConflictPolicy(ConflictShift)
+	batch.Add(1, []byte("a"), "", nil)
+	batch.Add(5, []byte("a"), "", nil)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[int64]int64{1: 2}, batch.Shifted())
+	assert.Equal(t, map[int64]int64{5: 2}, batch.Deduplicated(), "the original is reported where it was written")
+}
+
+func TestDedupReportsDuplicateTimestamps(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	metrics := telemetry.NewMemoryMetrics()
+	created, err := NewClient(server.URL, ClientOptions{Metrics: metrics}).CreateBucket(ctx, "bucket", nil)
+	require.NoError(t, err)
+	bucket := created.(*Bucket)
+	dedup := &DedupOptions{}
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Dedup: dedup}).Write("a"))
+
+	// The only record is a skipped duplicate, and another one is added at its timestamp.
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(dedup)
+	batch.Add(2, []byte("a"), "", nil)
+	batch.Add(2, []byte("b"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]int64{2: 1}, batch.Deduplicated())
+	assert.Equal(t, ErrorMap{2: duplicateTimestampError()}, errs)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.SetDedup(dedup)
+	recordBatch.Add("entry", 3, []byte("a"), "", nil)
+	recordBatch.Add("entry", 3, []byte("b"), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[int64]int64{"entry": {3: 1}}, recordBatch.Deduplicated())
+	assert.Equal(t, RecordBatchErrorMap{"entry": {3: duplicateTimestampError()}}, recordErrs)
+
+	assert.Equal(t, 1, countEntryRecords(t, bucket, "entry"))
+	snapshot := metrics.Snapshot()
+	for _, operation := range []string{"Batch.Write", "RecordBatch.Send"} {
+		assert.InDelta(t, 1, snapshot.Counter(telemetry.MetricRecordErrors,
+			telemetry.Label{Name: telemetry.LabelOperation, Value: operation},
+			telemetry.Label{Name: telemetry.LabelStatus, Value: "409"}), 0, operation)
+	}
+}
+
+func TestDedupAcrossBuckets(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, ClientOptions{})
+	buckets := map[string]*Bucket{}
+	for _, name := range []string{"a", "b"} {
+		bucket, err := client.CreateBucket(ctx, name, nil)
+		require.NoError(t, err)
+		buckets[name] = bucket.(*Bucket)
+	}
+	dedup := &DedupOptions{}
+
+	record := buckets["a"].BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Dedup: dedup})
+	require.NoError(t, record.Write("payload"))
+	record = buckets["b"].BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 2, Dedup: dedup})
+	require.NoError(t, record.Write("payload"))
+	_, duplicate := record.DuplicateOf()
+	assert.False(t, duplicate, "the record of the other bucket is not a duplicate")
+	assert.Equal(t, 1, countEntryRecords(t, buckets["b"], "entry"))
+
+	update := buckets["b"].BeginUpdateBatch(ctx, "entry")
+	update.SetDedup(dedup)
+	update.AddOnlyLabels(2, LabelMap{"checked": "true"})
+	errs, err := update.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Empty(t, update.Deduplicated(), "an update batch does not deduplicate")
+
+	for name, original := range map[string]int64{"a": 1, "b": 2} {
+		batch := buckets[name].BeginWriteBatch(ctx, "entry")
+		batch.SetDedup(dedup)
+		batch.Add(3, []byte("payload"), "", nil)
+		batch.Add(4, []byte("other"), "", nil)
+		errs, err = batch.Write(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, errs)
+		assert.Equal(t, map[int64]int64{3: original}, batch.Deduplicated(), name)
+	}
+
+	recordBatch := buckets["a"].BeginWriteRecordBatch(ctx)
+	recordBatch.SetDedup(dedup)
+	recordBatch.Add("entry", 5, []byte("other"), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+	assert.Equal(t, map[string]map[int64]int64{"entry": {5: 4}}, recordBatch.Deduplicated())
+	assert.Equal(t, 2, countEntryRecords(t, buckets["a"], "entry"))
+	assert.Equal(t, 2, countEntryRecords(t, buckets["b"], "entry"))
+}
+
+func TestDedupEncrypted(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	keys := StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": newEncryptionKey(t)}}
+	bucket := newEncryptedTestBucket(t, server, &EncryptionOptions{Keys: keys})
+	dedup := &DedupOptions{Mode: DedupLabel}
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Dedup: dedup}).Write("secret"))
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.SetDedup(dedup)
+	batch.Add(2, []byte("secret"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[int64]int64{2: 1}, batch.Deduplicated(), "the keyed hashes of a key match")
+
+	for _, ts := range []int64{1, 2} {
+		record := mustRead(t, bucket, ts)
+		hash := record.Labels()[ContentHashLabel]
+		assert.NotEmpty(t, hash)
+		assert.NotEqual(t, sha256Hex("secret"), hash, "the hash does not reveal the payload")
+		data, err := record.ReadAsString()
+		require.NoError(t, err)
+		assert.Equal(t, "secret", data)
+	}
+
+	rotated := newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "k2", Keys: map[string][]byte{"k1": keys.Keys["k1"], "k2": newEncryptionKey(t)}},
+	})
+	record := rotated.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 3, Dedup: dedup})
+	require.NoError(t, record.Write("secret"))
+	_, duplicate := record.DuplicateOf()
+	assert.False(t, duplicate, "the hash is keyed with the current key")
+	assert.Equal(t, "k2", mustRead(t, rotated, 3).Labels()[KeyIDLabel])
+}
+
+func TestMemoryDedupIndex(t *testing.T) {
+	index := NewMemoryDedupIndex(2)
+	require.NoError(t, index.Add("bucket", "entry", "a", 1))
+	require.NoError(t, index.Add("bucket", "entry", "b", 2))
+	require.NoError(t, index.Add("archive", "entry", "a", 10))
+	_, found := index.Lookup("bucket", "entry", "a")
+	require.True(t, found)
+
+	require.NoError(t, index.Add("bucket", "entry", "c", 3))
+	_, found = index.Lookup("bucket", "entry", "b")
+	assert.False(t, found, "the least recently used hash is dropped")
+	ts, found := index.Lookup("bucket", "entry", "a")
+	assert.True(t, found)
+	assert.Equal(t, int64(1), ts)
+	require.NoError(t, index.Add("bucket", "entry", "a", 4))
+	ts, _ = index.Lookup("bucket", "entry", "a")
+	assert.Equal(t, int64(1), ts, "the first record is kept")
+	ts, _ = index.Lookup("archive", "entry", "a")
+	assert.Equal(t, int64(10), ts, "the buckets are indexed separately")
+	assert.Equal(t, 3, index.Len())
+}
+
+func TestFileDedupIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	index, err := OpenFileDedupIndex(path, 2)
+	require.NoError(t, err)
+	for ts := int64(1); ts <= 5; ts++ {
+		require.NoError(t, index.Add("bucket", "entry", "hash"+strconv.FormatInt(ts, 10), ts))
+	}
+	require.NoError(t, index.Add("archive", "entry", "hash1", 1))
+	require.NoError(t, index.Close())
+	require.Error(t, index.Add("bucket", "entry", "hash6", 6))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Less(t, strings.Count(string(data), "\n"), 6, "the file is compacted")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString("bucket entry hash7")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	index, err = OpenFileDedupIndex(path, 2)
+	require.NoError(t, err)
+	defer index.Close()
+	for hash, want := range map[string]int64{"hash4": 4, "hash5": 5} {
+		ts, found := index.Lookup("bucket", "entry", hash)
+		assert.True(t, found, hash)
+		assert.Equal(t, want, ts)
+	}
+	_, found := index.Lookup("bucket", "entry", "hash3")
+	assert.False(t, found)
+	_, found = index.Lookup("bucket", "entry", "hash7")
+	assert.False(t, found, "a partly written line is skipped")
+	_, found = index.Lookup("archive", "entry", "hash1")
+	assert.True(t, found)
+}
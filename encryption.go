@@ -0,0 +1,438 @@
+package reductgo
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EncryptionAES256GCM is the value of EncryptionLabel for records encrypted with AES-256-GCM.
+const EncryptionAES256GCM = "aes-256-gcm"
+
+// Reserved labels of encrypted records. All labels with the reserved prefix
+// "reduct-" are authenticated, see EncryptionOptions.
+const (
+	// EncryptionLabel is the algorithm the payload is encrypted with.
+	EncryptionLabel = "reduct-encryption"
+	// KeyIDLabel is the ID of the key that encrypts the data key of the record.
+	KeyIDLabel = "reduct-key-id"
+	// PlaintextSizeLabel is the size of the payload before encryption.
+	PlaintextSizeLabel = "reduct-plaintext-size"
+	// AuthenticatedLabelsLabel lists the other labels bound to the payload.
+	AuthenticatedLabelsLabel = "reduct-authenticated-labels"
+)
+
+const (
+	reservedLabelPrefix      = "reduct-"
+	defaultEncryptionChunk   = 64 << 10
+	maxEncryptionChunk       = 16 << 20
+	encryptionVersion        = 1
+	encryptionKeySize        = 32
+	encryptionNonceSize      = 12
+	encryptionTagSize        = 16
+	encryptionWrappedKeySize = encryptionKeySize + encryptionTagSize
+	// encryptionHeaderSize is the version, the nonce and the wrapped data key,
+	// the chunk size and the base nonce of the chunks.
+	encryptionHeaderSize = 1 + encryptionNonceSize + encryptionWrappedKeySize + 4 + encryptionNonceSize
+	// hashKeyInfo derives the key of the hash labels of encrypted records.
+	hashKeyInfo = "reduct-go hash labels"
+)
+
+// ErrDecryptionFailed is returned when reading an encrypted record whose
+// payload or authenticated labels were changed, or with the wrong key.
+var ErrDecryptionFailed = errors.New("record decryption failed")
+
+// KeyProvider supplies the 32-byte keys that encrypt the data keys of records.
+// Keys are identified by an ID stored with each record, so that they can be
+// rotated: new records use the current key, older ones keep their key.
+type KeyProvider interface {
+	// CurrentKey returns the key new records are encrypted with and its ID.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the ID to decrypt a record.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with a fixed set of keys.
+type StaticKeys struct {
+	// Current is the ID of the key new records are encrypted with.
+	Current string
+	// Keys are the keys by ID, including the ones of older records.
+	Keys map[string][]byte
+}
+
+// CurrentKey implements KeyProvider.
+func (k StaticKeys) CurrentKey() (id string, key []byte, err error) {
+	key, err = k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key implements KeyProvider.
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return key, nil
+}
+
+// EncryptionOptions enables client-side envelope encryption of record
+// payloads: every record is encrypted with AES-256-GCM and its own random
+// data key, which is stored with the payload, encrypted with the current key
+// of Keys. The payload is encrypted in chunks so that it is decrypted as it
+// is streamed.
+//
+// The reserved labels and the labels in AuthenticatedLabels are authenticated
+// as additional data: a record whose authenticated labels are changed, e.g.
+// by Bucket.Update, cannot be decrypted anymore.
+type EncryptionOptions struct {
+	// Keys supplies the keys of new records and the keys to decrypt records.
+	Keys KeyProvider
+	// AuthenticatedLabels are the names of the labels bound to the payload.
+	// If nil, all labels given to the write are bound.
+	AuthenticatedLabels []string
+	// ChunkSize is the size of the plaintext chunks. Defaults to 64 KiB.
+	ChunkSize int
+}
+
+// encrypt returns the encrypted payload and the labels with the reserved
+// labels set. A nil receiver changes nothing.
+func (o *EncryptionOptions) encrypt(data []byte, labels LabelMap) ([]byte, LabelMap, error) {
+	if o == nil || o.Keys == nil {
+		return data, labels, nil
+	}
+	chunkSize := o.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultEncryptionChunk
+	}
+	if chunkSize > maxEncryptionChunk {
+		return nil, nil, fmt.Errorf("encryption chunk size %d exceeds %d", chunkSize, maxEncryptionChunk)
+	}
+
+	keyID, kek, err := o.Keys.CurrentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrap, err := newGCM(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	dek := make([]byte, encryptionKeySize)
+	nonces := make([]byte, 2*encryptionNonceSize)
+	if _, err = rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+	if _, err = rand.Read(nonces); err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := FormatLabels(labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	var authenticated []string
+	for name := range encrypted {
+		if o.AuthenticatedLabels == nil || slices.Contains(o.AuthenticatedLabels, name) {
+			if !strings.HasPrefix(strings.ToLower(name), reservedLabelPrefix) {
+				authenticated = append(authenticated, strings.ToLower(name))
+			}
+		}
+	}
+	slices.Sort(authenticated)
+	encrypted[EncryptionLabel] = EncryptionAES256GCM
+	encrypted[KeyIDLabel] = keyID
+	encrypted[PlaintextSizeLabel] = strconv.Itoa(len(data))
+	if len(authenticated) > 0 {
+		encrypted[AuthenticatedLabelsLabel] = strings.Join(authenticated, ",")
+	}
+	aad := labelsAAD(encrypted)
+
+	chunks := len(data)/chunkSize + 1
+	out := make([]byte, 0, encryptionHeaderSize+len(data)+chunks*encryptionTagSize)
+	out = append(out, encryptionVersion)
+	out = append(out, nonces[:encryptionNonceSize]...)
+	out = wrap.Seal(out, nonces[:encryptionNonceSize], dek, []byte(keyID))
+	out = binary.BigEndian.AppendUint32(out, uint32(chunkSize)) // #nosec G115 -- bounded by maxEncryptionChunk
+	out = append(out, nonces[encryptionNonceSize:]...)
+
+	baseNonce := nonces[encryptionNonceSize:]
+	for counter := uint64(0); ; counter++ {
+		chunk := data[:min(chunkSize, len(data))]
+		data = data[len(chunk):]
+		final := len(data) == 0
+		out = gcm.Seal(out, chunkNonce(baseNonce, counter), chunk, chunkAAD(aad, counter, final))
+		if final {
+			break
+		}
+	}
+
+	result := make(LabelMap, len(encrypted))
+	for name, value := range encrypted {
+		result[name] = value
+	}
+	return out, result, nil
+}
+
+// pinned returns a copy of the options that encrypts with the current key of
+// Keys only, and the key, so that hash labels computed with the key match the
+// records. Options without keys are returned as they are, with a nil key.
+func (o *EncryptionOptions) pinned() (*EncryptionOptions, []byte, error) {
+	if o == nil || o.Keys == nil {
+		return o, nil, nil
+	}
+	keyID, kek, err := o.Keys.CurrentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	pinned := *o
+	pinned.Keys = StaticKeys{Current: keyID, Keys: map[string][]byte{keyID: kek}}
+	return &pinned, kek, nil
+}
+
+// hashLabel returns the value of a hash label of a record for the SHA-256
+// sum of its payload. If the record is encrypted with kek, the value is the
+// HMAC-SHA256 of the sum with a key derived from kek, so that the label
+// cannot be used to confirm a guessed plaintext.
+func hashLabel(sum []byte, kek []byte) (string, error) {
+	if kek == nil {
+		return hex.EncodeToString(sum), nil
+	}
+	key, err := hkdf.Key(sha256.New, kek, nil, hashKeyInfo, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(sum)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// recordKey returns the key an encrypted record is encrypted with, or nil if
+// the record is not encrypted.
+func recordKey(keys KeyProvider, labels LabelMap) ([]byte, error) {
+	if algorithm, _ := encryptionOf(labels); algorithm == "" {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w: no key provider, see ClientOptions.Encryption", ErrDecryptionFailed)
+	}
+	keyID, _ := labels[KeyIDLabel].(string)
+	kek, err := keys.Key(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	return kek, nil
+}
+
+// encryptionOf returns the algorithm and plaintext size of a record from its
+// reserved labels; size is -1 if it is unknown.
+func encryptionOf(labels LabelMap) (algorithm string, size int64) {
+	algorithm, ok := labels[EncryptionLabel].(string)
+	if !ok || algorithm == "" {
+		return "", -1
+	}
+	size = -1
+	if text, ok := labels[PlaintextSizeLabel].(string); ok {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			size = n
+		}
+	}
+	return algorithm, size
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must have %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// labelsAAD encodes the authenticated labels: the labels with the reserved
+// prefix and the ones listed in AuthenticatedLabelsLabel, by lower-case name.
+func labelsAAD(labels map[string]string) []byte {
+	values := make(map[string]string, len(labels))
+	for name, value := range labels {
+		values[strings.ToLower(name)] = value
+	}
+	var names []string
+	for name := range values {
+		if strings.HasPrefix(name, reservedLabelPrefix) {
+			names = append(names, name)
+		}
+	}
+	if list := values[AuthenticatedLabelsLabel]; list != "" {
+		names = append(names, strings.Split(list, ",")...)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var aad []byte
+	for _, name := range names {
+		value, ok := values[name]
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(name))) // #nosec G115 -- label names are short
+		aad = append(aad, name...)
+		if !ok {
+			// A label that was removed does not match an empty one.
+			aad = append(aad, 0)
+			continue
+		}
+		aad = append(aad, 1)
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(value))) // #nosec G115 -- label values are short
+		aad = append(aad, value...)
+	}
+	return aad
+}
+
+// chunkNonce is the base nonce of the record with the counter of the chunk
+// in its last 8 bytes.
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := slices.Clone(base)
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^counter)
+	return nonce
+}
+
+// chunkAAD binds a chunk to the labels, its position and whether it is the
+// last one, so that chunks cannot be reordered, dropped or truncated.
+func chunkAAD(labels []byte, counter uint64, final bool) []byte {
+	aad := binary.BigEndian.AppendUint64(slices.Clip(labels), counter)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// decryptReader decrypts a record stream chunk by chunk. Like
+// decompressReader, it does not touch the stream before the first Read.
+type decryptReader struct {
+	src    io.Reader
+	keys   KeyProvider
+	labels LabelMap
+
+	in        *bufio.Reader
+	gcm       cipher.AEAD
+	aad       []byte
+	baseNonce []byte
+	counter   uint64
+	buf       []byte
+	plain     []byte
+	done      bool
+	err       error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 && d.err == nil {
+		d.err = d.next()
+	}
+	if len(d.plain) == 0 {
+		return 0, d.err
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk into plain.
+func (d *decryptReader) next() error {
+	if d.gcm == nil {
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+	if d.done {
+		return io.EOF
+	}
+
+	n, err := io.ReadFull(d.in, d.buf)
+	final := false
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: the payload is truncated", ErrDecryptionFailed)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return err
+	default:
+		_, err = d.in.Peek(1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		final = err != nil
+	}
+
+	d.plain, err = d.gcm.Open(d.buf[:0], chunkNonce(d.baseNonce, d.counter), d.buf[:n], chunkAAD(d.aad, d.counter, final))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %w", ErrDecryptionFailed, d.counter, err)
+	}
+	d.counter++
+	d.done = final
+	return nil
+}
+
+// open reads the header of the payload and unwraps the data key.
+func (d *decryptReader) open() error {
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(d.src, header); err != nil {
+		// An encrypted payload always has a header, even if it is empty.
+		return fmt.Errorf("%w: the header is truncated", ErrDecryptionFailed)
+	}
+	if header[0] != encryptionVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrDecryptionFailed, header[0])
+	}
+	if algorithm, _ := encryptionOf(d.labels); algorithm != EncryptionAES256GCM {
+		return fmt.Errorf("%w: unsupported encryption %q", ErrDecryptionFailed, algorithm)
+	}
+	if d.keys == nil {
+		return fmt.Errorf("%w: no key provider, see ClientOptions.Encryption", ErrDecryptionFailed)
+	}
+
+	keyID, _ := d.labels[KeyIDLabel].(string)
+	kek, err := d.keys.Key(keyID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	wrap, err := newGCM(kek)
+	if err != nil {
+		return err
+	}
+	header = header[1:]
+	dek, err := wrap.Open(nil, header[:encryptionNonceSize], header[encryptionNonceSize:encryptionNonceSize+encryptionWrappedKeySize], []byte(keyID))
+	if err != nil {
+		return fmt.Errorf("%w: data key: %w", ErrDecryptionFailed, err)
+	}
+	header = header[encryptionNonceSize+encryptionWrappedKeySize:]
+	chunkSize := int(binary.BigEndian.Uint32(header))
+	if chunkSize <= 0 || chunkSize > maxEncryptionChunk {
+		return fmt.Errorf("%w: invalid chunk size %d", ErrDecryptionFailed, chunkSize)
+	}
+	if d.gcm, err = newGCM(dek); err != nil {
+		return err
+	}
+
+	text := make(map[string]string, len(d.labels))
+	for name, value := range d.labels {
+		text[name], _ = value.(string)
+	}
+	d.aad = labelsAAD(text)
+	d.baseNonce = header[4:]
+	d.buf = make([]byte, chunkSize+encryptionTagSize)
+	d.in = bufio.NewReader(d.src)
+	return nil
+}
@@ -0,0 +1,218 @@
+package reductgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+func newEncryptionKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+// newEncryptedTestBucket returns the bucket of the server through a client with the encryption.
+func newEncryptedTestBucket(t *testing.T, server *reducttest.Server, encryption *EncryptionOptions) *Bucket {
+	t.Helper()
+	bucket, err := NewClient(server.URL, ClientOptions{Encryption: encryption}).
+		CreateOrGetBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return bucket.(*Bucket)
+}
+
+func mustRead(t *testing.T, bucket *Bucket, ts int64) *ReadableRecord {
+	t.Helper()
+	record, err := bucket.BeginRead(context.Background(), "entry", &ts)
+	require.NoError(t, err)
+	return record
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	keys := StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": newEncryptionKey(t)}}
+	bucket := newEncryptedTestBucket(t, server, &EncryptionOptions{Keys: keys, ChunkSize: 100})
+	payload := strings.Repeat("secret sensor data ", 50)
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{
+		Timestamp: 1,
+		Labels:    LabelMap{"kind": "points"},
+	}).Write(strings.NewReader(payload)))
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{
+		Timestamp:   2,
+		Compression: &CompressionOptions{Algorithm: CompressionZstd},
+	}).Write(payload))
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(3, []byte(payload), "", nil)
+	batch.Add(4, []byte{}, "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("entry", 5, []byte(payload[:200]), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	writer := bucket.BeginBufferedWrite(ctx, nil)
+	require.NoError(t, writer.Write(ctx, "entry", 6, []byte(payload), "", nil))
+	require.NoError(t, writer.Close(ctx))
+
+	want := map[int64]string{1: payload, 2: payload, 3: payload, 4: "", 5: payload[:200], 6: payload}
+	result, err := bucket.Query(ctx, "entry", nil)
+	require.NoError(t, err)
+	count := 0
+	for record := range result.Records() {
+		count++
+		assert.Equal(t, EncryptionAES256GCM, record.Encryption())
+		assert.Equal(t, int64(len(want[record.Time()])), record.Size())
+		data, err := io.ReadAll(record.Stream())
+		require.NoError(t, err)
+		assert.Equal(t, want[record.Time()], string(data), "record %d", record.Time())
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, len(want), count)
+
+	ts := int64(1)
+	record, err := bucket.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	assert.Equal(t, "points", record.Labels()["kind"])
+	assert.Equal(t, "k1", record.Labels()[KeyIDLabel])
+	data, err := record.Read()
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(data))
+
+	plain := newEncryptedTestBucket(t, server, nil)
+	record, err = plain.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	stored, err := io.ReadAll(record.raw)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(stored, []byte("secret")), "the payload is stored encrypted")
+
+	record, err = plain.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	_, err = record.Read()
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	old, current := newEncryptionKey(t), newEncryptionKey(t)
+
+	bucket := newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "old", Keys: map[string][]byte{"old": old}},
+	})
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("first"))
+
+	bucket = newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "current", Keys: map[string][]byte{"old": old, "current": current}},
+	})
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 2}).Write("second"))
+
+	for ts, want := range map[int64]string{1: "first", 2: "second"} {
+		record, err := bucket.BeginRead(ctx, "entry", &ts)
+		require.NoError(t, err)
+		data, err := record.ReadAsString()
+		require.NoError(t, err)
+		assert.Equal(t, want, data)
+	}
+
+	retired := newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "current", Keys: map[string][]byte{"current": current}},
+	})
+	ts := int64(1)
+	record, err := retired.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	_, err = record.Read()
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+	require.ErrorContains(t, err, `unknown encryption key "old"`)
+}
+
+func TestEncryptionAuthenticatedLabels(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	bucket := newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys:                StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": newEncryptionKey(t)}},
+		AuthenticatedLabels: []string{"sensor"},
+	})
+	read := func(ts int64) error {
+		record, err := bucket.BeginRead(ctx, "entry", &ts)
+		require.NoError(t, err)
+		_, err = record.Read()
+		return err
+	}
+
+	for ts := int64(1); ts <= 3; ts++ {
+		require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{
+			Timestamp: ts,
+			Labels:    LabelMap{"sensor": "temp", "note": "ok"},
+		}).Write("data"))
+	}
+	assert.Equal(t, "sensor", mustRead(t, bucket, 1).Labels()[AuthenticatedLabelsLabel])
+
+	require.NoError(t, bucket.Update(ctx, "entry", 1, LabelMap{"note": "changed"}))
+	require.NoError(t, read(1), "the label is not authenticated")
+
+	require.NoError(t, bucket.Update(ctx, "entry", 2, LabelMap{"sensor": "humidity"}))
+	require.ErrorIs(t, read(2), ErrDecryptionFailed)
+
+	require.NoError(t, bucket.Update(ctx, "entry", 3, LabelMap{PlaintextSizeLabel: "1"}))
+	require.ErrorIs(t, read(3), ErrDecryptionFailed)
+}
+
+func TestEncryptionErrors(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+
+	err := bucket.BeginWrite(ctx, "entry", &WriteOptions{
+		Timestamp:  1,
+		Encryption: &EncryptionOptions{Keys: StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("short")}}},
+	}).Write("data")
+	require.ErrorContains(t, err, "encryption key must have 32 bytes")
+
+	err = bucket.BeginWrite(ctx, "entry", &WriteOptions{
+		Timestamp:  1,
+		Encryption: &EncryptionOptions{Keys: StaticKeys{Current: "missing"}},
+	}).Write("data")
+	require.ErrorContains(t, err, "unknown encryption key")
+
+	key := newEncryptionKey(t)
+	encrypted, labels, err := (&EncryptionOptions{
+		Keys:      StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key}},
+		ChunkSize: 4,
+	}).encrypt([]byte("0123456789"), nil)
+	require.NoError(t, err)
+	keys := StaticKeys{Keys: map[string][]byte{"k1": key}}
+	for _, size := range []int{len(encrypted) - 1, encryptionHeaderSize + 20, encryptionHeaderSize, 10, 0} {
+		record := newReadableRecord("entry", 1, int64(size), true, bytes.NewReader(encrypted[:size]), labels, "", keys, false)
+		_, err = record.Read()
+		require.ErrorIs(t, err, ErrDecryptionFailed, "truncated to %d bytes", size)
+	}
+
+	for _, size := range []string{"100000000000000", "5"} {
+		forged := maps.Clone(labels)
+		forged[PlaintextSizeLabel] = size
+		record := newReadableRecord("entry", 1, int64(len(encrypted)), true, bytes.NewReader(encrypted), forged, "", keys, false)
+		_, err = record.Read()
+		require.ErrorIs(t, err, ErrDecryptionFailed, "the size label is authenticated and only a hint")
+	}
+}
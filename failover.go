@@ -0,0 +1,83 @@
+package reductgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+)
+
+// NewFailoverClient creates a client for several ReductStore instances that
+// hold the same data, e.g. a primary and a standby kept in sync by replication
+// tasks. The first URL is preferred, the others are used in order when it
+// cannot be reached.
+//
+// Unavailable instances are checked like IsLive every
+// ClientOptions.HealthCheckInterval, and the client returns to the first live
+// one in the list. ClientOptions.OnFailover is called on every switch.
+// Continuous queries on a single entry restart on the new instance from the
+// record after the last delivered one, with the backoff of ClientOptions.Retry
+// between restarts; other queries fail with an error that matches
+// httpclient.ErrEndpointChanged.
+func NewFailoverClient(urls []string, options ClientOptions) Client {
+	if len(urls) == 0 {
+		urls = []string{""}
+	}
+	return newClient(urls[0], urls[1:], options)
+}
+
+// resumeAfterFailover forwards the records of a continuous query and restarts
+// the query when the client fails over to another instance. Restarts without
+// a record in between wait for the backoff of the retry policy of the client.
+func (b *Bucket) resumeAfterFailover(ctx context.Context, entry string, options QueryOptions, result *QueryResult) *QueryResult {
+	out := make(chan *ReadableRecord, 100)
+	outErrCh := make(chan error, 1)
+
+	go func() {
+		defer close(outErrCh)
+		defer close(out)
+		policy := httpclient.RetryPolicyOf(b.HTTPClient)
+		restarts := 0
+		for {
+			for record := range result.Records() {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- record:
+					options.Start = record.Time() + 1
+					restarts = 0
+				}
+			}
+
+			err := result.Err()
+			if !errors.Is(err, httpclient.ErrEndpointChanged) {
+				if err != nil {
+					outErrCh <- err
+				}
+				return
+			}
+			for errors.Is(err, httpclient.ErrEndpointChanged) {
+				restarts++
+				delay := policy.Backoff(restarts)
+				httpclient.LoggerOf(b.HTTPClient).InfoContext(ctx, "restarting continuous query after failover",
+					"bucket", b.Name, "entry", entry, "start", options.Start, "endpoint", httpclient.EndpointOf(b.HTTPClient),
+					"delay", delay)
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				result, err = b.queryEntry(ctx, entry, &options)
+			}
+			if err != nil {
+				outErrCh <- err
+				return
+			}
+		}
+	}()
+
+	return &QueryResult{records: out, errCh: outErrCh}
+}
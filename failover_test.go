@@ -0,0 +1,144 @@
+package reductgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/httpclient"
+)
+
+// newQueryServer serves a single entry with the given timestamps. Each query
+// returns the records from its start in batches of one.
+func newQueryServer(t *testing.T, timestamps []int64) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	queries := map[string][]int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/q"):
+			var options QueryOptions
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&options))
+			var pending []int64
+			for _, ts := range timestamps {
+				if ts >= options.Start {
+					pending = append(pending, ts)
+				}
+			}
+			id := fmt.Sprint(len(queries) + 1)
+			queries[id] = pending
+			_, err := fmt.Fprintf(w, `{"id":%s}`, id)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/batch"):
+			pending := queries[r.URL.Query().Get("q")]
+			if len(pending) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			queries[r.URL.Query().Get("q")] = pending[1:]
+			w.Header().Set(fmt.Sprintf("x-reduct-time-%d", pending[0]), "1,text/plain")
+			_, err := w.Write([]byte("x"))
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestContinuousQueryResumesAfterFailover(t *testing.T) {
+	primary := newQueryServer(t, []int64{1, 2})
+	standby := newQueryServer(t, []int64{1, 2, 3, 4})
+
+	failedOver := make(chan [2]string, 1)
+	client := NewFailoverClient([]string{primary.URL, standby.URL}, ClientOptions{
+		OnFailover: func(from, to string) { failedOver <- [2]string{from, to} },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bucket, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+
+	result, err := bucket.Query(ctx, "entry", &QueryOptions{Continuous: true, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	var received []int64
+	for record := range result.Records() {
+		received = append(received, record.Time())
+		if record.Time() == 2 {
+			primary.Close()
+		}
+		if record.Time() == 4 {
+			break
+		}
+	}
+
+	assert.Equal(t, []int64{1, 2, 3, 4}, received)
+	assert.Equal(t, [2]string{primary.URL, standby.URL}, <-failedOver)
+}
+
+func TestContinuousQueryBacksOffWhileFailingOver(t *testing.T) {
+	primary := newQueryServer(t, []int64{1, 2})
+	standby := newQueryServer(t, []int64{1, 2})
+
+	var flapping atomic.Bool
+	var restarts atomic.Int32
+	client := NewFailoverClient([]string{primary.URL, standby.URL}, ClientOptions{
+		Retry: httpclient.RetryPolicy{InitialBackoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond},
+		Middlewares: []httpclient.Middleware{func(next http.RoundTripper) http.RoundTripper {
+			return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if flapping.Load() && strings.Contains(req.URL.Path, "/entry") {
+					if req.Method == http.MethodPost {
+						restarts.Add(1)
+					}
+					return nil, httpclient.ErrEndpointChanged
+				}
+				return next.RoundTrip(req)
+			})
+		}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bucket, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+	result, err := bucket.Query(ctx, "entry", &QueryOptions{Continuous: true, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	record := <-result.Records()
+	require.NotNil(t, record)
+	flapping.Store(true)
+	time.Sleep(200 * time.Millisecond)
+	assert.LessOrEqual(t, restarts.Load(), int32(12), "restarts wait for the backoff")
+	assert.Positive(t, restarts.Load())
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		for range result.Records() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the query does not stop when the context is canceled")
+	}
+}
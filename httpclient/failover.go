@@ -0,0 +1,237 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHealthCheckInterval = 5 * time.Second
+
+// ErrEndpointChanged is returned for a request bound to an endpoint with
+// WithStickyEndpoint after the client has failed over to another endpoint.
+var ErrEndpointChanged = errors.New("client failed over to another endpoint")
+
+type stickyKey struct{}
+
+type stickyEndpoint struct {
+	mu       sync.Mutex
+	endpoint *endpoint
+}
+
+// WithStickyEndpoint returns a copy of ctx that binds the requests made with it
+// to one endpoint of a failover client: the one that serves the first request.
+// Later requests fail with ErrEndpointChanged if the client fails over, which
+// is needed for state that lives on a single server, e.g. query IDs.
+func WithStickyEndpoint(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stickyKey{}, &stickyEndpoint{})
+}
+
+// EndpointOf returns the base URL the client currently sends requests to.
+// For a client with a single URL it is always that URL.
+func EndpointOf(client HTTPClient) string {
+	if endpoints, ok := client.(interface{ Endpoint() string }); ok {
+		return endpoints.Endpoint()
+	}
+	return ""
+}
+
+// Endpoint returns the base URL the client currently sends requests to.
+func (c *httpClient) Endpoint() string {
+	if c.endpoints == nil {
+		return c.baseURL
+	}
+	return c.endpoints.current().raw
+}
+
+type endpoint struct {
+	raw  string
+	base *url.URL
+
+	healthy   atomic.Bool
+	probing   atomic.Bool
+	lastProbe atomic.Int64
+}
+
+// endpointSet tracks the health of the endpoints of a failover client. Requests
+// go to the first healthy endpoint, so the client fails back to the primary as
+// soon as a background probe finds it live again.
+type endpointSet struct {
+	endpoints  []*endpoint
+	interval   time.Duration
+	onFailover func(from, to string)
+	probe      func(ctx context.Context, e *endpoint) bool
+
+	mu     sync.Mutex
+	active *endpoint
+}
+
+func newEndpointSet(urls []string, interval time.Duration, onFailover func(from, to string)) (*endpointSet, error) {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	set := &endpointSet{interval: interval, onFailover: onFailover}
+	for _, raw := range urls {
+		base, err := url.Parse(fmt.Sprintf("%s/api/%s", strings.TrimSuffix(raw, "/"), APIVersion))
+		if err != nil {
+			return nil, fmt.Errorf("parse endpoint %q: %w", raw, err)
+		}
+		e := &endpoint{raw: raw, base: base}
+		e.healthy.Store(true)
+		set.endpoints = append(set.endpoints, e)
+	}
+	set.active = set.endpoints[0]
+	return set, nil
+}
+
+// current returns the active endpoint and starts probes of the preferred
+// endpoints that are down.
+func (s *endpointSet) current() *endpoint {
+	s.mu.Lock()
+	active := s.active
+	s.mu.Unlock()
+
+	for _, e := range s.endpoints {
+		if e == active {
+			break
+		}
+		s.probeLater(e)
+	}
+	return active
+}
+
+// markDown records a failed connection to the endpoint. It returns true if the
+// client switched to another endpoint.
+func (s *endpointSet) markDown(e *endpoint) bool {
+	e.healthy.Store(false)
+	e.lastProbe.Store(time.Now().UnixNano())
+	return s.update() != e
+}
+
+// probeLater checks an endpoint that is down in the background, at most once per interval.
+func (s *endpointSet) probeLater(e *endpoint) {
+	if e.healthy.Load() || time.Since(time.Unix(0, e.lastProbe.Load())) < s.interval {
+		return
+	}
+	if !e.probing.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer e.probing.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		defer cancel()
+
+		live := s.probe(ctx, e)
+		e.lastProbe.Store(time.Now().UnixNano())
+		if live {
+			e.healthy.Store(true)
+			s.update()
+		}
+	}()
+}
+
+// update makes the first healthy endpoint active and calls the failover hook
+// if it changed. If no endpoint is healthy the active one is kept.
+func (s *endpointSet) update() *endpoint {
+	s.mu.Lock()
+	from := s.active
+	for _, e := range s.endpoints {
+		if e.healthy.Load() {
+			s.active = e
+			break
+		}
+	}
+	to := s.active
+	s.mu.Unlock()
+
+	if from != to && s.onFailover != nil {
+		s.onFailover(from.raw, to.raw)
+	}
+	return to
+}
+
+// rewrite returns a copy of the request addressed to the endpoint. Requests
+// are built against the primary URL; other URLs are left unchanged.
+func (s *endpointSet) rewrite(req *http.Request, e *endpoint) *http.Request {
+	primary := s.endpoints[0].base
+	if e == s.endpoints[0] || req.URL.Scheme != primary.Scheme || req.URL.Host != primary.Host ||
+		!strings.HasPrefix(req.URL.Path, primary.Path) {
+		return req
+	}
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = e.base.Scheme
+	out.URL.Host = e.base.Host
+	out.URL.Path = e.base.Path + strings.TrimPrefix(req.URL.Path, primary.Path)
+	out.URL.RawPath = ""
+	out.Host = ""
+	return out
+}
+
+// send sends the request to the active endpoint. If the endpoint cannot be
+// reached, the client fails over and resends the request, unless it is bound
+// to an endpoint with WithStickyEndpoint.
+func (c *httpClient) send(req *http.Request) (*http.Response, error) {
+	if c.endpoints == nil {
+		return c.sendAttempt(req)
+	}
+
+	sticky, _ := req.Context().Value(stickyKey{}).(*stickyEndpoint)
+	// Each failover marks one more endpoint down, so the loop ends.
+	for {
+		e := c.endpoints.current()
+		if sticky != nil {
+			sticky.mu.Lock()
+			if sticky.endpoint == nil {
+				sticky.endpoint = e
+			}
+			bound := sticky.endpoint
+			sticky.mu.Unlock()
+			if bound != e {
+				return nil, fmt.Errorf("%w: from %s to %s", ErrEndpointChanged, bound.raw, e.raw)
+			}
+		}
+
+		resp, err := c.sendAttempt(c.endpoints.rewrite(req, e))
+		if err == nil || !isDialError(err) || !c.endpoints.markDown(e) {
+			return resp, err
+		}
+		if sticky != nil {
+			return resp, fmt.Errorf("%w: %w", ErrEndpointChanged, err)
+		}
+		if !isReplayable(req) {
+			return resp, err
+		}
+
+		next, cloneErr := cloneForRetry(req)
+		if cloneErr != nil {
+			return resp, err
+		}
+		req = next
+	}
+}
+
+// probeEndpoint checks the endpoint like Client.IsLive does.
+func (c *httpClient) probeEndpoint(ctx context.Context, e *endpoint) bool {
+	ctx = WithOperation(ctx, Operation{Name: "Client.IsLive"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, e.base.String()+"/alive", http.NoBody)
+	if err != nil {
+		return false
+	}
+	c.setClientHeaders(req)
+
+	// #nosec G704 -- the URL is one of the configured endpoints.
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	drainAndClose(resp)
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,116 @@
+package httpclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNamedServer(t *testing.T, name string, listener net.Listener) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.Header().Set("X-Server", name)
+		w.WriteHeader(http.StatusOK)
+	}))
+	if listener != nil {
+		require.NoError(t, server.Listener.Close())
+		server.Listener = listener
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func serverName(ctx context.Context, client HTTPClient) (string, error) {
+	req, err := client.NewRequestWithContext(ctx, http.MethodGet, "/info", http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("X-Server"), nil
+}
+
+func TestFailoverAndBack(t *testing.T) {
+	primary := newNamedServer(t, "primary", nil)
+	standby := newNamedServer(t, "standby", nil)
+	addr := primary.Listener.Addr().String()
+
+	var mu sync.Mutex
+	var switches [][2]string
+	client := NewHTTPClient(Option{
+		BaseURL:             primary.URL,
+		FailoverURLs:        []string{standby.URL},
+		Timeout:             defaultTimeout,
+		HealthCheckInterval: 10 * time.Millisecond,
+		OnFailover: func(from, to string) {
+			mu.Lock()
+			defer mu.Unlock()
+			switches = append(switches, [2]string{from, to})
+		},
+	})
+
+	name, err := serverName(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, "primary", name)
+
+	// The request that finds the primary down is sent to the standby.
+	primary.Close()
+	name, err = serverName(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, "standby", name)
+	assert.Equal(t, standby.URL, EndpointOf(client))
+
+	// A request bound to the standby fails once the primary is back.
+	sticky := WithStickyEndpoint(context.Background())
+	_, err = serverName(sticky, client)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	newNamedServer(t, "primary", listener)
+
+	require.Eventually(t, func() bool {
+		name, err := serverName(context.Background(), client)
+		return err == nil && name == "primary"
+	}, 5*time.Second, 5*time.Millisecond)
+
+	_, err = serverName(sticky, client)
+	require.ErrorIs(t, err, ErrEndpointChanged)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, [][2]string{{primary.URL, standby.URL}, {standby.URL, primary.URL}}, switches)
+}
+
+func TestFailoverStickyRequestIsNotResent(t *testing.T) {
+	primary := newNamedServer(t, "primary", nil)
+	standby := newNamedServer(t, "standby", nil)
+	client := NewHTTPClient(Option{
+		BaseURL:      primary.URL,
+		FailoverURLs: []string{standby.URL},
+		Timeout:      defaultTimeout,
+		Retry:        fastRetryPolicy(),
+	})
+
+	sticky := WithStickyEndpoint(context.Background())
+	_, err := serverName(sticky, client)
+	require.NoError(t, err)
+
+	primary.Close()
+	_, err = serverName(sticky, client)
+	require.ErrorIs(t, err, ErrEndpointChanged)
+	assert.Equal(t, standby.URL, EndpointOf(client))
+}
@@ -9,13 +9,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
 const (
@@ -23,12 +26,12 @@ const (
 )
 
 var (
-	InvalidRequest  = -6 // used for invalid requests.
-	Interrupt       = -5 // used for interrupting a long-running task or query.
-	URLParseError   = -4 // used for invalid url.
-	ConnectionError = -3 // used for network errors.
-	Timeout         = -2 // used for timeout errors.
-	Unknown         = -1 // used for unknown errors.
+	InvalidRequest  = model.StatusInvalidRequest  // used for invalid requests.
+	Interrupt       = model.StatusInterrupt       // used for interrupting a long-running task or query.
+	URLParseError   = model.StatusURLParseError   // used for invalid url.
+	ConnectionError = model.StatusConnectionError // used for network errors.
+	Timeout         = model.StatusTimeout         // used for timeout errors.
+	Unknown         = model.StatusUnknown         // used for unknown errors.
 )
 
 type HTTPClient interface {
@@ -47,31 +50,107 @@ type Option struct {
 	BaseURL  string
 	APIToken string
 	Timeout  time.Duration
+	// TokenProvider supplies the API token for every request instead of APIToken.
+	TokenProvider TokenProvider
 	// Deprecated: TLS verification is enabled by default. Use InsecureSkipVerify to disable it explicitly.
 	VerifySSL          bool
 	InsecureSkipVerify bool
 	CACertPath         string
+	// TLSConfig is the base TLS configuration of the client. It is cloned, and
+	// the other TLS options are applied on top of it.
+	TLSConfig *tls.Config
+	// ClientCertPath and ClientKeyPath are PEM files with the client certificate
+	// and its private key for mutual TLS. The files are reloaded when they change.
+	ClientCertPath string
+	ClientKeyPath  string
+	// ClientCertPEM and ClientKeyPEM are an in-memory client certificate and key
+	// in PEM format. They are ignored if ClientCertPath is set.
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+	// ServerName overrides the host name used to verify the server certificate.
+	ServerName string
+	// MinTLSVersion is the minimum TLS version, e.g. tls.VersionTLS13.
+	MinTLSVersion uint16
+	// FailoverURLs are base URLs of replicas of BaseURL in order of preference.
+	// If the active URL cannot be reached, the client switches to the next live
+	// one and returns to a preferred URL once a health check finds it live.
+	FailoverURLs []string
+	// HealthCheckInterval is how often an unavailable URL is checked. Defaults to 5s.
+	HealthCheckInterval time.Duration
+	// OnFailover is called when the client switches from one URL to another.
+	OnFailover func(from, to string)
+	// Retry configures automatic retries of transient failures. The zero value disables retries.
+	Retry RetryPolicy
+	// Middlewares wrap the transport of the client, the first one is the outermost.
+	Middlewares []Middleware
+	// Tracer receives the spans of SDK operations. Defaults to a no-op tracer.
+	Tracer telemetry.Tracer
+	// Metrics receives request counts and latencies. Defaults to no-op metrics.
+	Metrics telemetry.Metrics
+	// Logger receives the diagnostics of the client. Defaults to slog.Default().
+	Logger *slog.Logger
+	// BatchLimits bounds the requests of batches. Zero fields are taken from
+	// the server info, see LearnBatchLimits, or DefaultBatchLimits.
+	BatchLimits BatchLimits
 }
 
 type httpClient struct {
-	client   *http.Client
-	apiToken string
-	url      string
-	initErr  error
+	client    *http.Client
+	apiToken  string
+	tokens    TokenProvider
+	url       string
+	baseURL   string
+	endpoints *endpointSet
+	retry     RetryPolicy
+	tracer    telemetry.Tracer
+	metrics   telemetry.Metrics
+	logger    *slog.Logger
+	initErr   error
+
+	batchLimits  BatchLimits
+	limitsMu     sync.Mutex
+	serverLimits BatchLimits
 }
 
 func NewHTTPClient(option Option) HTTPClient {
 	transport, err := buildTransport(option)
 
-	return &httpClient{
+	var roundTripper http.RoundTripper
+	if err == nil {
+		roundTripper = chainMiddlewares(transport, option.Middlewares)
+	}
+
+	c := &httpClient{
 		client: &http.Client{
 			Timeout:   option.Timeout,
-			Transport: transport,
+			Transport: roundTripper,
 		},
 		url:      fmt.Sprintf("%s/api/%s", option.BaseURL, APIVersion),
+		baseURL:  option.BaseURL,
 		apiToken: option.APIToken,
+		tokens:   option.TokenProvider,
+		retry:    option.Retry,
+		tracer:   option.Tracer,
+		metrics:  option.Metrics,
+		logger:   option.Logger,
 		initErr:  err,
+
+		batchLimits: option.BatchLimits,
 	}
+
+	if len(option.FailoverURLs) > 0 && err == nil {
+		urls := append([]string{option.BaseURL}, option.FailoverURLs...)
+		c.endpoints, c.initErr = newEndpointSet(urls, option.HealthCheckInterval, func(from, to string) {
+			c.Logger().Warn("switching to another endpoint", "from", from, "to", to)
+			if option.OnFailover != nil {
+				option.OnFailover(from, to)
+			}
+		})
+		if c.endpoints != nil {
+			c.endpoints.probe = c.probeEndpoint
+		}
+	}
+	return c
 }
 
 func buildTransport(option Option) (*http.Transport, error) {
@@ -81,35 +160,14 @@ func buildTransport(option Option) (*http.Transport, error) {
 	}
 
 	cloned := transport.Clone()
-	if option.CACertPath == "" && !option.InsecureSkipVerify {
+	if !option.hasTLSSettings() {
 		return cloned, nil
 	}
 
-	// #nosec G402 -- caller must opt in explicitly; this preserves secure defaults while allowing test/private CA troubleshooting.
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: option.InsecureSkipVerify,
-	}
-
-	if option.CACertPath != "" {
-		rootCAs, err := x509.SystemCertPool()
-		if err != nil {
-			return nil, fmt.Errorf("load system cert pool: %w", err)
-		}
-		if rootCAs == nil {
-			rootCAs = x509.NewCertPool()
-		}
-
-		caPEM, err := os.ReadFile(option.CACertPath)
-		if err != nil {
-			return nil, fmt.Errorf("read CA certificate %q: %w", option.CACertPath, err)
-		}
-
-		if !rootCAs.AppendCertsFromPEM(caPEM) {
-			return nil, fmt.Errorf("parse CA certificate %q: no PEM certificates found", option.CACertPath)
-		}
-		tlsConfig.RootCAs = rootCAs
+	tlsConfig, err := buildTLSConfig(option)
+	if err != nil {
+		return nil, err
 	}
-
 	cloned.TLSClientConfig = tlsConfig
 	return cloned, nil
 }
@@ -140,8 +198,13 @@ func (c *httpClient) NewRequestWithContext(ctx context.Context, method, path str
 	return req, nil
 }
 func (c *httpClient) setClientHeaders(req *http.Request) {
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	if c.tokens == nil {
+		req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	}
+	// Keep the content type of record writes set by the caller.
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 }
 
 func (c *httpClient) Put(ctx context.Context, path string, requestBody, responseData any) error {
@@ -177,38 +240,34 @@ func (c *httpClient) Put(ctx context.Context, path string, requestBody, response
 		return err
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 
 	// Read the response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  resp.Status,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	if responseData != nil && len(bodyBytes) > 0 {
 		// Unmarshal the response into the provided responseData interface
 		err := json.Unmarshal(bodyBytes, responseData)
 		if err != nil {
-			return &model.APIError{
+			return describeError(req, &model.APIError{
 				Message:  reductError,
 				Original: err,
 				Status:   resp.StatusCode,
-			}
+			})
 		}
 	}
 	return nil
@@ -249,37 +308,33 @@ func (c *httpClient) Patch(ctx context.Context, path string, requestBody, respon
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 	// Read the response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	if responseData != nil && len(bodyBytes) > 0 {
 		// Unmarshal the response into the provided responseData interface
 		err := json.Unmarshal(bodyBytes, responseData)
 		if err != nil {
-			return &model.APIError{
+			return describeError(req, &model.APIError{
 				Message:  reductError,
 				Original: err,
 				Status:   resp.StatusCode,
-			}
+			})
 		}
 	}
 	return nil
@@ -319,38 +374,34 @@ func (c *httpClient) Post(ctx context.Context, path string, requestBody, respons
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 
 	// Read the response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	if responseData != nil && len(bodyBytes) > 0 {
 		// Unmarshal the response into the provided responseData interface
 		err := json.Unmarshal(bodyBytes, responseData)
 		if err != nil {
-			return &model.APIError{
+			return describeError(req, &model.APIError{
 				Message:  reductError,
 				Original: err,
 				Status:   resp.StatusCode,
-			}
+			})
 		}
 	}
 	return nil
@@ -436,38 +487,34 @@ func (c *httpClient) Get(ctx context.Context, path string, responseData any) err
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 
 	// Read the response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 	if responseData != nil && len(bodyBytes) > 0 {
 		// Unmarshal the response into the provided responseData interface
 		err := json.Unmarshal(bodyBytes, responseData)
 		if err != nil {
-			return &model.APIError{
+			return describeError(req, &model.APIError{
 				Message:  reductError,
 				Original: err,
 				Status:   resp.StatusCode,
-			}
+			})
 		}
 	}
 	return nil
@@ -490,19 +537,15 @@ func (c *httpClient) Head(ctx context.Context, path string) error {
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 
 	return nil
@@ -525,19 +568,15 @@ func (c *httpClient) Delete(ctx context.Context, path string) error {
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Printf("Failed to close response body: %v", err)
-		}
-	}()
+	defer c.closeBody(resp)
 
 	// Check for non-OK status codes
 	if resp.StatusCode != http.StatusOK {
-		return &model.APIError{
+		return describeError(req, &model.APIError{
 			Message:  reductError,
 			Original: err,
 			Status:   resp.StatusCode,
-		}
+		})
 	}
 
 	return nil
@@ -550,37 +589,62 @@ func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
 
 	// set request headers
 	c.setClientHeaders(req)
-	// Create an HTTP client and perform the Do
-	// #nosec G704 -- request URL is controlled by configured client base URL (or explicitly by SDK caller via NewRequest APIs).
-	resp, err := c.client.Do(req)
+	token, err := c.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	// Perform the request, retrying transient failures according to the retry policy
+	resp, err := c.doWithRetry(req)
+	resp, err = c.retryUnauthorized(req, token, resp, err)
 
 	if err != nil {
 		if resp != nil {
-			return resp, handleHTTPError(err, resp.StatusCode)
+			return resp, describeError(req, handleHTTPError(err, resp.StatusCode))
 		}
-		return nil, handleHTTPError(err, 0)
+		return nil, describeError(req, handleHTTPError(err, 0))
 	}
 	reductError := resp.Header.Get("X-Reduct-Error")
 
 	// Check API version header
 	apiVersion := resp.Header.Get("X-Reduct-API")
 	if apiVersion == "" {
-		return resp, &model.APIError{
+		return resp, describeError(req, &model.APIError{
 			Status:  resp.StatusCode,
 			Message: "Server did not provide API version",
-		}
+		})
 	}
 
-	err = model.CheckServerAPIVersion(apiVersion, model.GetVersion())
+	err = model.CheckServerAPIVersionWithLogger(c.Logger(), apiVersion, model.GetVersion())
 	if err != nil {
-		return resp, err
+		return resp, describeError(req, err)
 	}
 
 	if resp.StatusCode >= 300 {
-		return resp, &model.APIError{
+		return resp, describeError(req, &model.APIError{
 			Status:  resp.StatusCode,
 			Message: reductError,
-		}
+		})
 	}
 	return resp, nil
 }
+
+// describeError adds the request and operation to an APIError, so that callers
+// can tell which call failed and whether it is worth repeating.
+func describeError(req *http.Request, err error) error {
+	var apiErr *model.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	apiErr.Method = req.Method
+	apiErr.Path = req.URL.Path
+	if _, path, found := strings.Cut(req.URL.Path, "/api/"+APIVersion); found {
+		apiErr.Path = path
+	}
+	if op, ok := OperationFromContext(req.Context()); ok {
+		apiErr.Bucket = op.Bucket
+		apiErr.Entry = op.Entry
+	}
+	apiErr.Retryable = model.IsRetryableStatus(apiErr.Status) && req.Context().Err() == nil
+	return apiErr
+}
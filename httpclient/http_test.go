@@ -178,3 +178,37 @@ func writeTestCACert(t *testing.T, cert *x509.Certificate) string {
 	require.NoError(t, os.WriteFile(path, pemBytes, 0o600))
 	return path
 }
+
+func TestDoDescribesFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.3")
+		if r.URL.Path == "/api/v1/b/bucket/entry" {
+			w.Header().Set("X-Reduct-Error", "Entry 'entry' not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout})
+	ctx := WithOperation(t.Context(), Operation{Name: "Bucket.Read", Bucket: "bucket", Entry: "entry"})
+
+	err := client.Get(ctx, "/b/bucket/entry", nil)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	var apiErr model.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.MethodGet, apiErr.Method)
+	assert.Equal(t, "/b/bucket/entry", apiErr.Path)
+	assert.Equal(t, "bucket", apiErr.Bucket)
+	assert.Equal(t, "entry", apiErr.Entry)
+	assert.False(t, apiErr.Retryable)
+	assert.Contains(t, err.Error(), "GET /b/bucket/entry")
+
+	err = client.Delete(t.Context(), "/b/other")
+	require.ErrorIs(t, err, model.ErrServer)
+	require.ErrorAs(t, err, &apiErr)
+	assert.True(t, apiErr.Retryable)
+	assert.Empty(t, apiErr.Bucket)
+}
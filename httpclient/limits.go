@@ -0,0 +1,85 @@
+package httpclient
+
+import "github.com/reductstore/reduct-go/model"
+
+const (
+	defaultBatchMaxSize       = 64 * 1024 * 1024
+	defaultBatchMaxRecords    = 1000
+	defaultBatchMaxHeaderSize = 64 * 1024
+)
+
+// BatchLimits bounds the requests of batch writes, updates and removals. A
+// batch that exceeds them is sent in several requests.
+type BatchLimits struct {
+	// MaxSize is the maximum body size of a request in bytes. Defaults to 64 MiB.
+	MaxSize int64
+	// MaxRecords is the maximum number of records in a request. Defaults to 1000.
+	MaxRecords int
+	// MaxHeaderSize is the maximum total size of the x-reduct-* headers of a
+	// request in bytes. Defaults to 64 KiB.
+	MaxHeaderSize int
+}
+
+// DefaultBatchLimits returns the limits used when neither the client options
+// nor the server set them.
+func DefaultBatchLimits() BatchLimits {
+	return BatchLimits{
+		MaxSize:       defaultBatchMaxSize,
+		MaxRecords:    defaultBatchMaxRecords,
+		MaxHeaderSize: defaultBatchMaxHeaderSize,
+	}
+}
+
+// or fills the limits that are not set from other.
+func (l BatchLimits) or(other BatchLimits) BatchLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = other.MaxSize
+	}
+	if l.MaxRecords <= 0 {
+		l.MaxRecords = other.MaxRecords
+	}
+	if l.MaxHeaderSize <= 0 {
+		l.MaxHeaderSize = other.MaxHeaderSize
+	}
+	return l
+}
+
+// BatchLimits returns the batch limits of the client. Limits that are not
+// configured are taken from the last server info that reported them, see
+// LearnBatchLimits, and otherwise from DefaultBatchLimits.
+func (c *httpClient) BatchLimits() BatchLimits {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	return c.batchLimits.or(c.serverLimits).or(DefaultBatchLimits())
+}
+
+// LearnBatchLimits stores the batch limits reported by the server.
+func (c *httpClient) LearnBatchLimits(limits *model.ServerLimits) {
+	if limits == nil {
+		return
+	}
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	c.serverLimits = BatchLimits{
+		MaxSize:       limits.BatchMaxSize,
+		MaxRecords:    limits.BatchMaxRecords,
+		MaxHeaderSize: limits.BatchMaxHeaderSize,
+	}
+}
+
+// BatchLimitsOf returns the batch limits of the client or DefaultBatchLimits
+// if the client does not provide them, e.g. a custom HTTPClient implementation.
+func BatchLimitsOf(client HTTPClient) BatchLimits {
+	if limited, ok := client.(interface{ BatchLimits() BatchLimits }); ok {
+		return limited.BatchLimits()
+	}
+	return DefaultBatchLimits()
+}
+
+// LearnBatchLimits passes the batch limits from the server info to the client
+// if it supports them.
+func LearnBatchLimits(client HTTPClient, limits *model.ServerLimits) {
+	if learner, ok := client.(interface{ LearnBatchLimits(*model.ServerLimits) }); ok {
+		learner.LearnBatchLimits(limits)
+	}
+}
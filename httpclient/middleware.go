@@ -0,0 +1,84 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// Operation describes the SDK call that issued a request, so that middlewares
+// can act on the logical operation rather than parse the raw URL.
+type Operation struct {
+	// Name of the SDK method, e.g. "Bucket.Query" or "Batch.Write".
+	Name string
+	// Bucket the operation works on, empty for server-wide calls.
+	Bucket string
+	// Entry the operation works on, empty for bucket-wide or multi-entry calls.
+	Entry string
+	// QueryID is set for requests reading the results of a query.
+	QueryID int64
+}
+
+type operationKey struct{}
+
+// WithOperation returns a copy of ctx that carries the operation.
+// Requests created with the context expose it through OperationFromContext.
+func WithOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// OperationFromContext returns the operation stored in ctx by WithOperation.
+func OperationFromContext(ctx context.Context) (Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(Operation)
+	return op, ok
+}
+
+// Middleware wraps the round tripper that sends every request of the client.
+// It sees each attempt of a request, including retries, with the SDK headers
+// already set. Use OperationFromContext(req.Context()) to get the operation.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use an ordinary function as http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// RequestHook returns a middleware that calls hook before each request is sent.
+// The hook gets a clone of the request for each attempt and may modify it,
+// e.g. add headers or a signature; a returned error aborts the request.
+func RequestHook(hook func(req *http.Request) error) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// A RoundTripper must not modify the request of its caller.
+			req = req.Clone(req.Context())
+			if err := hook(req); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// ResponseHook returns a middleware that calls hook after each request with the
+// response or the transport error, e.g. for auditing.
+func ResponseHook(hook func(req *http.Request, resp *http.Response, err error)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			hook(req, resp, err)
+			return resp, err
+		})
+	}
+}
+
+// chainMiddlewares wraps transport so that the first middleware is the outermost one.
+func chainMiddlewares(transport http.RoundTripper, middlewares []Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			transport = middlewares[i](transport)
+		}
+	}
+	return transport
+}
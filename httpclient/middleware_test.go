@@ -0,0 +1,129 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewaresWrapEveryRequest(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Signature"))
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var order []string
+	var operations []Operation
+	client := NewHTTPClient(Option{
+		BaseURL: server.URL,
+		Timeout: defaultTimeout,
+		Middlewares: []Middleware{
+			RequestHook(func(req *http.Request) error {
+				order = append(order, "outer")
+				op, _ := OperationFromContext(req.Context())
+				operations = append(operations, op)
+				return nil
+			}),
+			RequestHook(func(req *http.Request) error {
+				order = append(order, "inner")
+				// The SDK headers are already set when middlewares run.
+				req.Header.Set("X-Signature", strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+				return nil
+			}),
+		},
+		APIToken: "token",
+	})
+
+	ctx := WithOperation(context.Background(), Operation{Name: "Bucket.GetInfo", Bucket: "bucket"})
+	require.NoError(t, client.Get(ctx, "/b/bucket", nil))
+
+	req, err := client.NewRequestWithContext(
+		WithOperation(context.Background(), Operation{Name: "Batch.Write", Bucket: "bucket", Entry: "entry"}),
+		http.MethodPost, "/b/bucket/entry/batch", http.NoBody)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{"outer", "inner", "outer", "inner"}, order)
+	assert.Equal(t, []string{"token", "token"}, received)
+	assert.Equal(t, []Operation{
+		{Name: "Bucket.GetInfo", Bucket: "bucket"},
+		{Name: "Batch.Write", Bucket: "bucket", Entry: "entry"},
+	}, operations)
+}
+
+func TestRequestHookAbortsRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("request must not reach the server")
+	}))
+	defer server.Close()
+
+	errDenied := errors.New("denied")
+	client := NewHTTPClient(Option{
+		BaseURL:     server.URL,
+		Timeout:     defaultTimeout,
+		Middlewares: []Middleware{RequestHook(func(*http.Request) error { return errDenied })},
+	})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.ErrorIs(t, err, errDenied)
+}
+
+func TestRequestHookDoesNotModifyTheRequest(t *testing.T) {
+	var received [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Values("X-Attempt"))
+		w.Header().Set("X-Reduct-API", "v1.20")
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(Option{
+		BaseURL: server.URL,
+		Timeout: defaultTimeout,
+		Retry:   fastRetryPolicy(),
+		Middlewares: []Middleware{RequestHook(func(req *http.Request) error {
+			req.Header.Add("X-Attempt", "1")
+			return nil
+		})},
+	})
+
+	req, err := client.NewRequestWithContext(context.Background(), http.MethodGet, "/info", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, [][]string{{"1"}, {"1"}}, received, "the headers of a hook do not stack up on retries")
+	assert.Empty(t, req.Header.Values("X-Attempt"))
+}
+
+func TestResponseHookSeesEveryAttempt(t *testing.T) {
+	server, _ := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+
+	var statuses []int
+	client := NewHTTPClient(Option{
+		BaseURL: server.URL,
+		Timeout: defaultTimeout,
+		Retry:   fastRetryPolicy(),
+		Middlewares: []Middleware{ResponseHook(func(_ *http.Request, resp *http.Response, err error) {
+			require.NoError(t, err)
+			statuses = append(statuses, resp.StatusCode)
+		})},
+	})
+
+	require.NoError(t, client.Head(context.Background(), "/alive"))
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusOK}, statuses)
+}
@@ -0,0 +1,273 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultMultiplier     = 2.0
+)
+
+// RetryPolicy configures automatic retries of transient failures such as
+// connection resets, client timeouts and 429/502/503/504 responses.
+//
+// The zero value disables retries. Only requests whose body can be replayed are
+// retried: bodies built from []byte, strings or bytes.Buffer are replayable,
+// while one-shot io.Reader bodies are sent exactly once.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// MaxElapsed caps the total time spent on a request including backoff.
+	// Zero means no limit besides MaxAttempts and the request context.
+	MaxElapsed time.Duration
+	// InitialBackoff is the delay before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps a single delay, including one requested by Retry-After. Defaults to 10s.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each attempt. Defaults to 2.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, in the range [0, 1].
+	Jitter float64
+	// RetryNonIdempotent allows POST and PATCH requests to be retried on any transient
+	// failure. Otherwise they are retried only when the server did not process them:
+	// the connection could not be established or the server answered 429 or 503.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a policy with 5 attempts, exponential backoff from 100ms
+// up to 10s with 20% jitter and a budget of one minute.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		MaxElapsed:     time.Minute,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		Multiplier:     defaultMultiplier,
+		Jitter:         0.2,
+	}
+}
+
+// RetryPolicyOf returns the retry policy configured for the client, or the
+// zero value if the client does not provide one, e.g. a custom HTTPClient
+// implementation.
+func RetryPolicyOf(client HTTPClient) RetryPolicy {
+	if retrying, ok := client.(interface{ RetryPolicy() RetryPolicy }); ok {
+		return retrying.RetryPolicy()
+	}
+	return RetryPolicy{}
+}
+
+// RetryPolicy returns the retry policy of the client.
+func (c *httpClient) RetryPolicy() RetryPolicy {
+	return c.retry
+}
+
+// Enabled reports whether the policy retries at all.
+func (p RetryPolicy) Enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// Backoff returns the delay before the given retry (1-based), with jitter.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := p.maxBackoff()
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+
+	delay := float64(initial)
+	for i := 1; i < retry && delay < float64(maxBackoff); i++ {
+		delay *= multiplier
+	}
+	if delay > float64(maxBackoff) {
+		delay = float64(maxBackoff)
+	}
+
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay += delay * jitter * (2*rand.Float64() - 1) //nolint:gosec // jitter does not need a CSPRNG
+	}
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+// isIdempotent reports whether a request with the method may be repeated safely.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReplayable reports whether the request body can be sent again.
+func isReplayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// shouldRetryStatus reports whether a response status is worth another attempt.
+// 429 and 503 mean the server rejected the request without processing it.
+func (p RetryPolicy) shouldRetryStatus(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return p.RetryNonIdempotent || isIdempotent(method)
+	default:
+		return false
+	}
+}
+
+// shouldRetryError reports whether a transport error is transient.
+func (p RetryPolicy) shouldRetryError(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		// The caller gave up, another attempt would fail the same way.
+		return false
+	}
+
+	if errors.Is(err, ErrEndpointChanged) {
+		// The request is bound to a server that is no longer used.
+		return false
+	}
+	if isDialError(err) {
+		// Nothing reached the server, so any method is safe to resend.
+		return true
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(req.Method) {
+		return false
+	}
+
+	var opErr *net.OpError
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Timeout() {
+		return true
+	}
+	return errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// isDialError reports whether the connection could not be established.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// doWithRetry sends the request and repeats it according to the retry policy.
+// It returns the last response or transport error.
+func (c *httpClient) doWithRetry(req *http.Request) (*http.Response, error) {
+	policy := c.retry
+	if !policy.Enabled() || !isReplayable(req) {
+		return c.send(req)
+	}
+
+	started := time.Now()
+	attempt := req
+	for retry := 1; ; retry++ {
+		resp, err := c.send(attempt)
+
+		var delay time.Duration
+		switch {
+		case retry >= policy.MaxAttempts:
+			return resp, err
+		case err != nil:
+			if !policy.shouldRetryError(req, err) {
+				return resp, err
+			}
+			delay = policy.Backoff(retry)
+		case policy.shouldRetryStatus(req.Method, resp.StatusCode):
+			delay = policy.Backoff(retry)
+			if after, ok := retryAfter(resp); ok {
+				delay = min(max(delay, after), policy.maxBackoff())
+			}
+		default:
+			return resp, nil
+		}
+
+		if policy.MaxElapsed > 0 && time.Since(started)+delay > policy.MaxElapsed {
+			return resp, err
+		}
+
+		next, cloneErr := cloneForRetry(req)
+		if cloneErr != nil {
+			return resp, err
+		}
+
+		reason := slog.Any("error", err)
+		if resp != nil {
+			reason = slog.Int("status", resp.StatusCode)
+			drainAndClose(resp)
+		}
+		c.Logger().DebugContext(req.Context(), "retrying request",
+			"method", req.Method, "path", req.URL.Path, "attempt", retry+1, "delay", delay, reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, &url.Error{Op: req.Method, URL: req.URL.String(), Err: req.Context().Err()}
+		case <-timer.C:
+		}
+		attempt = next
+	}
+}
+
+// cloneForRetry prepares a fresh copy of the request with a rewound body.
+func cloneForRetry(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// drainAndClose discards a small remainder of the body so the connection can be reused.
+func drainAndClose(resp *http.Response) {
+	_, _ = io.CopyN(io.Discard, resp.Body, 4096) //nolint:errcheck // best effort
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,227 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reductstore/reduct-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fastRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+}
+
+func newFlakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		body, err := io.ReadAll(r.Body)
+		if err != nil || (r.Method == http.MethodPost && string(body) != `{"key":"value"}`) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if calls.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	server, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy()})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryReplaysJSONBody(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy()})
+
+	err := client.Post(context.Background(), "/b/bucket", map[string]string{"key": "value"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := newFlakyServer(t, 10, http.StatusBadGateway)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy()})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestRetryDisabledByDefault(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetrySkipsNonIdempotentOnBadGateway(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusBadGateway)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy()})
+
+	err := client.Post(context.Background(), "/b/bucket", map[string]string{"key": "value"}, nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	policy := fastRetryPolicy()
+	policy.RetryNonIdempotent = true
+	client = NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: policy})
+
+	err = client.Post(context.Background(), "/b/bucket", map[string]string{"key": "value"}, nil)
+	require.NoError(t, err)
+}
+
+func TestRetrySkipsOneShotBody(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy()})
+
+	// A reader without a known way to rewind it must be sent only once.
+	body := io.MultiReader(strings.NewReader(`{"key":`), strings.NewReader(`"value"}`))
+	req, err := client.NewRequestWithContext(context.Background(), http.MethodPost, "/b/bucket", body)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.Error(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, int32(1), calls.Load())
+
+	req, err = client.NewRequestWithContext(context.Background(), http.MethodPost, "/b/bucket", bytes.NewReader([]byte(`{"key":"value"}`)))
+	require.NoError(t, err)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	var first time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		if calls.Add(1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	policy := fastRetryPolicy()
+	policy.MaxBackoff = 2 * time.Second
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: policy})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.GreaterOrEqual(t, time.Since(first), time.Second)
+}
+
+func TestRetryRespectsMaxElapsed(t *testing.T) {
+	server, calls := newFlakyServer(t, 10, http.StatusServiceUnavailable)
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		MaxElapsed:     50 * time.Millisecond,
+		InitialBackoff: 40 * time.Millisecond,
+		MaxBackoff:     40 * time.Millisecond,
+	}
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: policy})
+
+	err := client.Get(context.Background(), "/info", nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryConnectionRefused(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	policy := fastRetryPolicy()
+	client := NewHTTPClient(Option{BaseURL: url, Timeout: defaultTimeout, Retry: policy})
+
+	started := time.Now()
+	err := client.Get(context.Background(), "/info", nil)
+	require.Error(t, err)
+	// Three backoffs of at least a millisecond prove the request was repeated.
+	assert.GreaterOrEqual(t, time.Since(started), 3*time.Millisecond)
+}
+
+func TestRetryBackoffGrowsAndCaps(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	assert.Equal(t, 10*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.Backoff(4))
+
+	policy.Jitter = 0.5
+	for range 100 {
+		delay := policy.Backoff(1)
+		assert.GreaterOrEqual(t, delay, 5*time.Millisecond)
+		assert.LessOrEqual(t, delay, 15*time.Millisecond)
+	}
+}
+
+func TestRetryAttemptsAreCounted(t *testing.T) {
+	server, _ := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+	metrics := telemetry.NewMemoryMetrics()
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy(), Metrics: metrics})
+
+	ctx := WithOperation(context.Background(), Operation{Name: "Client.GetInfo"})
+	require.NoError(t, client.Get(ctx, "/info", nil))
+
+	endpoint := telemetry.Label{Name: telemetry.LabelEndpoint, Value: "Client.GetInfo"}
+	snapshot := metrics.Snapshot()
+	assert.InDelta(t, 1, snapshot.Counter(telemetry.MetricRequests, endpoint, telemetry.Label{Name: telemetry.LabelStatus, Value: "503"}), 0)
+	assert.InDelta(t, 1, snapshot.Counter(telemetry.MetricRequests, endpoint, telemetry.Label{Name: telemetry.LabelStatus, Value: "200"}), 0)
+
+	latency, ok := snapshot.Histogram(telemetry.MetricRequestDuration, endpoint, telemetry.Label{Name: telemetry.LabelMethod, Value: http.MethodGet})
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), latency.Count)
+}
+
+func TestRetryIsLogged(t *testing.T) {
+	server, _ := newFlakyServer(t, 1, http.StatusServiceUnavailable)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, Retry: fastRetryPolicy(), Logger: logger})
+
+	require.NoError(t, client.Get(context.Background(), "/info", nil))
+	assert.Contains(t, buf.String(), `msg="retrying request" method=GET path=/api/v1/info attempt=2`)
+	assert.Contains(t, buf.String(), "status=503")
+}
@@ -0,0 +1,96 @@
+package httpclient
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+// Tracer returns the tracer of the client.
+func (c *httpClient) Tracer() telemetry.Tracer {
+	return c.tracer
+}
+
+// TracerOf returns the tracer configured for the client or a no-op tracer if
+// the client does not provide one, e.g. a custom HTTPClient implementation.
+func TracerOf(client HTTPClient) telemetry.Tracer {
+	if traced, ok := client.(interface{ Tracer() telemetry.Tracer }); ok && traced.Tracer() != nil {
+		return traced.Tracer()
+	}
+	return telemetry.NoopTracer()
+}
+
+// Metrics returns the metrics of the client.
+func (c *httpClient) Metrics() telemetry.Metrics {
+	return c.metrics
+}
+
+// MetricsOf returns the metrics configured for the client or no-op metrics if
+// the client does not provide them, e.g. a custom HTTPClient implementation.
+func MetricsOf(client HTTPClient) telemetry.Metrics {
+	if measured, ok := client.(interface{ Metrics() telemetry.Metrics }); ok && measured.Metrics() != nil {
+		return measured.Metrics()
+	}
+	return telemetry.NoopMetrics()
+}
+
+// sendAttempt performs a single attempt of the request and reports it to the metrics.
+// The endpoint is the name of the SDK operation, so the series stay bounded.
+func (c *httpClient) sendAttempt(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	// #nosec G704 -- request URL is controlled by configured client base URL (or explicitly by SDK caller via NewRequest APIs).
+	resp, err := c.client.Do(req)
+	if c.metrics == nil {
+		return resp, err
+	}
+
+	endpoint := "other"
+	if op, ok := OperationFromContext(req.Context()); ok && op.Name != "" {
+		endpoint = op.Name
+	}
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	c.metrics.AddCounter(telemetry.MetricRequests, 1,
+		telemetry.Label{Name: telemetry.LabelEndpoint, Value: endpoint},
+		telemetry.Label{Name: telemetry.LabelMethod, Value: req.Method},
+		telemetry.Label{Name: telemetry.LabelStatus, Value: status})
+	c.metrics.ObserveHistogram(telemetry.MetricRequestDuration, time.Since(started).Seconds(),
+		telemetry.Label{Name: telemetry.LabelEndpoint, Value: endpoint},
+		telemetry.Label{Name: telemetry.LabelMethod, Value: req.Method})
+	return resp, err
+}
+
+// Logger returns the logger of the client, slog.Default() if none is set.
+func (c *httpClient) Logger() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
+// LoggerOf returns the logger configured for the client or slog.Default() if
+// the client does not provide one, e.g. a custom HTTPClient implementation.
+func LoggerOf(client HTTPClient) *slog.Logger {
+	if logged, ok := client.(interface{ Logger() *slog.Logger }); ok && logged.Logger() != nil {
+		return logged.Logger()
+	}
+	return slog.Default()
+}
+
+// closeBody closes the response body and logs a failure, which usually means
+// the connection could not be reused.
+func (c *httpClient) closeBody(resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		logger := c.Logger()
+		if resp.Request != nil {
+			logger = logger.With("method", resp.Request.Method, "path", resp.Request.URL.Path)
+		}
+		logger.Warn("failed to close response body", "error", err)
+	}
+}
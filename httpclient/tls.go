@@ -0,0 +1,166 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// hasTLSSettings reports whether the option changes the default TLS configuration.
+func (option Option) hasTLSSettings() bool {
+	return option.CACertPath != "" || option.InsecureSkipVerify || option.TLSConfig != nil ||
+		option.ClientCertPath != "" || option.ClientKeyPath != "" || len(option.ClientCertPEM) > 0 ||
+		option.ServerName != "" || option.MinTLSVersion != 0
+}
+
+func buildTLSConfig(option Option) (*tls.Config, error) {
+	tlsConfig := &tls.Config{} //nolint:gosec // the minimum version is Go's default unless set by the caller
+	if option.TLSConfig != nil {
+		tlsConfig = option.TLSConfig.Clone()
+	}
+
+	if option.InsecureSkipVerify {
+		// #nosec G402 -- caller must opt in explicitly; this preserves secure defaults while allowing test/private CA troubleshooting.
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if option.ServerName != "" {
+		tlsConfig.ServerName = option.ServerName
+	}
+	if option.MinTLSVersion != 0 {
+		tlsConfig.MinVersion = option.MinTLSVersion
+	}
+
+	if option.CACertPath != "" {
+		rootCAs := tlsConfig.RootCAs
+		if rootCAs == nil {
+			var err error
+			rootCAs, err = x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("load system cert pool: %w", err)
+			}
+			if rootCAs == nil {
+				rootCAs = x509.NewCertPool()
+			}
+		} else {
+			rootCAs = rootCAs.Clone()
+		}
+
+		caPEM, err := os.ReadFile(option.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate %q: %w", option.CACertPath, err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("parse CA certificate %q: no PEM certificates found", option.CACertPath)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	switch {
+	case option.ClientCertPath != "" || option.ClientKeyPath != "":
+		if option.ClientCertPath == "" || option.ClientKeyPath == "" {
+			return nil, errors.New("both client certificate and key paths must be set")
+		}
+		reloader, err := newCertReloader(option.ClientCertPath, option.ClientKeyPath, option.Logger)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = reloader.getClientCertificate
+	case len(option.ClientCertPEM) > 0 || len(option.ClientKeyPEM) > 0:
+		cert, err := tls.X509KeyPair(option.ClientCertPEM, option.ClientKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parse client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader serves a client certificate from files and loads it again when
+// the files change, so that rotated certificates are used by new connections.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod fileVersion
+	keyMod  fileVersion
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(certPath, keyPath string, logger *slog.Logger) (*certReloader, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	reloader := &certReloader{certPath: certPath, keyPath: keyPath, logger: logger}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.changed() {
+		if err := r.reload(); err != nil {
+			// A rotation may be in progress, e.g. the certificate is written but the key is not yet.
+			// Keep using the previous certificate and try again on the next handshake.
+			r.logger.Warn("failed to reload client certificate", "cert", r.certPath, "key", r.keyPath, "error", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// changed reports whether the files differ from the loaded ones.
+func (r *certReloader) changed() bool {
+	certMod, certErr := statFile(r.certPath)
+	keyMod, keyErr := statFile(r.keyPath)
+	if certErr != nil || keyErr != nil {
+		return false
+	}
+	return certMod != r.certMod || keyMod != r.keyMod
+}
+
+func (r *certReloader) reload() error {
+	certMod, err := statFile(r.certPath)
+	if err != nil {
+		return fmt.Errorf("read client certificate %q: %w", r.certPath, err)
+	}
+	keyMod, err := statFile(r.keyPath)
+	if err != nil {
+		return fmt.Errorf("read client key %q: %w", r.keyPath, err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load client certificate %q: %w", r.certPath, err)
+	}
+
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+func statFile(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
@@ -0,0 +1,171 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMTLSServer starts a server that requires a client certificate and
+// returns its common name in the X-Client-CN header.
+func newMTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.Header().Set("X-Client-CN", r.TLS.PeerCertificates[0].Subject.CommonName)
+		// New connections make the client present its current certificate.
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClientCertPEM(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func clientCN(t *testing.T, client HTTPClient, url string) string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url+"/test", http.NoBody)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	return resp.Header.Get("X-Client-CN")
+}
+
+func TestClientCertificateFromPEM(t *testing.T) {
+	server := newMTLSServer(t)
+	certPEM, keyPEM := newClientCertPEM(t, "in-memory")
+
+	client := NewHTTPClient(Option{
+		BaseURL:       server.URL,
+		Timeout:       defaultTimeout,
+		CACertPath:    writeTestCACert(t, server.Certificate()),
+		ClientCertPEM: certPEM,
+		ClientKeyPEM:  keyPEM,
+	})
+
+	assert.Equal(t, "in-memory", clientCN(t, client, server.URL))
+}
+
+func TestClientCertificateReloadsRotatedFiles(t *testing.T) {
+	server := newMTLSServer(t)
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+
+	writeCert := func(commonName string, modTime time.Time) {
+		certPEM, keyPEM := newClientCertPEM(t, commonName)
+		require.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+		require.NoError(t, os.WriteFile(keyPath, keyPEM, 0o600))
+		require.NoError(t, os.Chtimes(certPath, modTime, modTime))
+		require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+	}
+
+	writeCert("first", time.Now().Add(-time.Minute))
+	client := NewHTTPClient(Option{
+		BaseURL:        server.URL,
+		Timeout:        defaultTimeout,
+		CACertPath:     writeTestCACert(t, server.Certificate()),
+		ClientCertPath: certPath,
+		ClientKeyPath:  keyPath,
+	})
+	assert.Equal(t, "first", clientCN(t, client, server.URL))
+
+	writeCert("second", time.Now())
+	assert.Equal(t, "second", clientCN(t, client, server.URL))
+
+	// A half-written rotation keeps the previous certificate in use.
+	require.NoError(t, os.WriteFile(keyPath, []byte("broken"), 0o600))
+	assert.Equal(t, "second", clientCN(t, client, server.URL))
+}
+
+func TestClientCertificateMissingFiles(t *testing.T) {
+	client := NewHTTPClient(Option{
+		BaseURL:        "https://example.com",
+		Timeout:        defaultTimeout,
+		ClientCertPath: filepath.Join(t.TempDir(), "missing.crt"),
+		ClientKeyPath:  filepath.Join(t.TempDir(), "missing.key"),
+	})
+
+	_, err := client.NewRequest(http.MethodGet, "/test", http.NoBody)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing.crt")
+}
+
+func TestServerNameOverride(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	caPath := writeTestCACert(t, server.Certificate())
+
+	// The test certificate is issued for example.com and 127.0.0.1.
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, CACertPath: caPath, ServerName: "example.com"})
+	require.NoError(t, client.Get(t.Context(), "/info", nil))
+
+	client = NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, CACertPath: caPath, ServerName: "reduct.example.org"})
+	require.Error(t, client.Get(t.Context(), "/info", nil))
+}
+
+func TestMinTLSVersionAndCustomConfig(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	base := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, TLSConfig: base})
+	require.NoError(t, client.Get(t.Context(), "/info", nil))
+
+	client = NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, TLSConfig: base, MinTLSVersion: tls.VersionTLS13})
+	require.Error(t, client.Get(t.Context(), "/info", nil))
+	// The caller's config is not modified.
+	assert.Equal(t, uint16(tls.VersionTLS12), base.MinVersion)
+}
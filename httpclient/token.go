@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+// TokenProvider supplies the API token. It is consulted for every request,
+// so it must be safe for concurrent use and should cache the token.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenRefresher is a TokenProvider that can replace a token the server
+// rejected with 401 Unauthorized. The request is then sent once more with the
+// token returned by Refresh.
+type TokenRefresher interface {
+	TokenProvider
+	// Refresh returns a new token instead of rejected. If another request has
+	// already replaced rejected, it should return the current token.
+	Refresh(ctx context.Context, rejected string) (string, error)
+}
+
+// StaticToken is a TokenProvider that always returns the same token.
+type StaticToken string
+
+// Token returns the token.
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// TokenFunc is an adapter to use an ordinary function as a TokenProvider.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token calls f(ctx).
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// authorize sets the token of the provider on the request.
+func (c *httpClient) authorize(req *http.Request) (string, error) {
+	if c.tokens == nil {
+		return c.apiToken, nil
+	}
+
+	token, err := c.tokens.Token(req.Context())
+	if err != nil {
+		return "", &model.APIError{Message: "failed to get API token", Original: err, Status: Unknown}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token, nil
+}
+
+// retryUnauthorized sends the request once more with a refreshed token if the
+// server rejected the token and the provider can refresh it.
+func (c *httpClient) retryUnauthorized(req *http.Request, token string, resp *http.Response, err error) (*http.Response, error) {
+	refresher, ok := c.tokens.(TokenRefresher)
+	if !ok || err != nil || resp.StatusCode != http.StatusUnauthorized || !isReplayable(req) {
+		return resp, err
+	}
+
+	fresh, refreshErr := refresher.Refresh(req.Context(), token)
+	if refreshErr != nil {
+		c.Logger().WarnContext(req.Context(), "failed to refresh API token", "error", refreshErr)
+		return resp, err
+	}
+	next, cloneErr := cloneForRetry(req)
+	if cloneErr != nil {
+		return resp, err
+	}
+	drainAndClose(resp)
+
+	next.Header.Set("Authorization", "Bearer "+fresh)
+	return c.doWithRetry(next)
+}
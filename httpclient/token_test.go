@@ -0,0 +1,95 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type refreshingTokens struct {
+	mu        sync.Mutex
+	token     string
+	refreshes int
+}
+
+func (p *refreshingTokens) Token(context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token, nil
+}
+
+func (p *refreshingTokens) Refresh(_ context.Context, rejected string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == rejected {
+		p.refreshes++
+		p.token = "fresh"
+	}
+	return p.token, nil
+}
+
+func newTokenServer(t *testing.T, valid string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-Reduct-API", "v1.20")
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestTokenProviderIsConsultedPerRequest(t *testing.T) {
+	server, _ := newTokenServer(t, "second")
+
+	var token atomic.Value
+	token.Store("first")
+	client := NewHTTPClient(Option{
+		BaseURL:  server.URL,
+		Timeout:  defaultTimeout,
+		APIToken: "ignored",
+		TokenProvider: TokenFunc(func(context.Context) (string, error) {
+			return token.Load().(string), nil
+		}),
+	})
+
+	require.Error(t, client.Get(context.Background(), "/info", nil))
+	token.Store("second")
+	require.NoError(t, client.Get(context.Background(), "/info", nil))
+}
+
+func TestUnauthorizedIsRetriedOnceAfterRefresh(t *testing.T) {
+	server, calls := newTokenServer(t, "fresh")
+	tokens := &refreshingTokens{token: "expired"}
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, TokenProvider: tokens})
+
+	require.NoError(t, client.Post(context.Background(), "/b/bucket", map[string]string{"key": "value"}, nil))
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, 1, tokens.refreshes)
+
+	// A token that is still rejected after the refresh is not retried again.
+	server, calls = newTokenServer(t, "other")
+	client = NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, TokenProvider: tokens})
+	require.Error(t, client.Get(context.Background(), "/info", nil))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestStaticTokenIsNotRetried(t *testing.T) {
+	server, calls := newTokenServer(t, "valid")
+	client := NewHTTPClient(Option{BaseURL: server.URL, Timeout: defaultTimeout, TokenProvider: StaticToken("invalid")})
+
+	require.Error(t, client.Get(context.Background(), "/info", nil))
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,221 @@
+package reductgo
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+// LabelError is returned when a label value cannot be encoded.
+type LabelError struct {
+	Name   string
+	Value  any
+	Reason string
+}
+
+func (e *LabelError) Error() string {
+	return fmt.Sprintf("label %q with value %v: %s", e.Name, e.Value, e.Reason)
+}
+
+// FormatLabel encodes a label value as it is stored by the server:
+//
+//   - strings as they are,
+//   - integers in decimal and floats in the shortest form that parses back to
+//     the same value, e.g. "1.5" or "1e+21",
+//   - bools as "true" or "false",
+//   - time.Time in RFC 3339 with nanoseconds in UTC,
+//   - time.Duration as returned by its String method, e.g. "1m30s",
+//   - LabelMarshaler, encoding.TextMarshaler and fmt.Stringer values as their
+//     text, in this order of precedence,
+//   - nil and nil pointers as "", which removes the label in an update.
+//
+// Other values, e.g. slices or maps, are rejected.
+func FormatLabel(name string, value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return checkLabelText(name, v)
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return v.String(), nil
+	case LabelMarshaler:
+		text, err := v.MarshalLabel()
+		if err != nil {
+			return "", &LabelError{Name: name, Value: value, Reason: err.Error()}
+		}
+		return checkLabelText(name, text)
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return "", &LabelError{Name: name, Value: value, Reason: err.Error()}
+		}
+		return checkLabelText(name, string(text))
+	case fmt.Stringer:
+		return checkLabelText(name, v.String())
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "", nil
+		}
+		return FormatLabel(name, rv.Elem().Interface())
+	case reflect.String:
+		return checkLabelText(name, rv.String())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), nil
+	default:
+		return "", &LabelError{Name: name, Value: value, Reason: fmt.Sprintf("unsupported type %T", value)}
+	}
+}
+
+// checkLabelText rejects control characters, which HTTP headers cannot carry.
+func checkLabelText(name, value string) (string, error) {
+	for _, c := range []byte(value) {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return "", &LabelError{Name: name, Value: value, Reason: "control characters are not allowed"}
+		}
+	}
+	return value, nil
+}
+
+// FormatLabels encodes all labels with FormatLabel.
+func FormatLabels(labels LabelMap) (map[string]string, error) {
+	out := make(map[string]string, len(labels))
+	for name, value := range labels {
+		text, err := FormatLabel(name, value)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = text
+	}
+	return out, nil
+}
+
+// quoteBatchLabel encodes a label value for the CSV-like record headers of
+// the batch protocols: values with a comma are wrapped in double quotes. The
+// protocols have no escape for a double quote inside a value, so such values
+// are rejected rather than silently changed; write them with a single-record
+// write or update instead.
+func quoteBatchLabel(name, value string) (string, error) {
+	if strings.Contains(value, `"`) {
+		return "", &LabelError{Name: name, Value: value, Reason: "batch headers cannot carry double quotes"}
+	}
+	if strings.Contains(value, ",") {
+		return `"` + value + `"`, nil
+	}
+	return value, nil
+}
+
+// setLabelHeaders sets the x-reduct-label-<name> headers of a single-record request.
+func setLabelHeaders(header http.Header, labels LabelMap) error {
+	formatted, err := FormatLabels(labels)
+	if err != nil {
+		return err
+	}
+	for name, value := range formatted {
+		header.Set("x-reduct-label-"+name, value)
+	}
+	return nil
+}
+
+// labelRecordError reports a record that was not sent because its labels or
+// payload could not be encoded.
+func labelRecordError(err error) model.APIError {
+	return model.APIError{Status: model.StatusInvalidRequest, Message: err.Error(), Original: err}
+}
+
+// LabelType is the Go type a label value is decoded to by a LabelSchema.
+type LabelType int
+
+const (
+	// LabelString keeps the value as a string.
+	LabelString LabelType = iota
+	// LabelInt decodes the value to int64.
+	LabelInt
+	// LabelUint decodes the value to uint64.
+	LabelUint
+	// LabelFloat decodes the value to float64.
+	LabelFloat
+	// LabelBool decodes the value to bool.
+	LabelBool
+	// LabelTime decodes an RFC 3339 value to time.Time.
+	LabelTime
+	// LabelDuration decodes the value to time.Duration.
+	LabelDuration
+)
+
+// ParseLabel decodes a label value written by FormatLabel to the Go type.
+func ParseLabel(value string, typ LabelType) (any, error) {
+	switch typ {
+	case LabelString:
+		return value, nil
+	case LabelInt:
+		return strconv.ParseInt(value, 10, 64)
+	case LabelUint:
+		return strconv.ParseUint(value, 10, 64)
+	case LabelFloat:
+		return strconv.ParseFloat(value, 64)
+	case LabelBool:
+		return strconv.ParseBool(value)
+	case LabelTime:
+		return time.Parse(time.RFC3339Nano, value)
+	case LabelDuration:
+		return time.ParseDuration(value)
+	default:
+		return nil, fmt.Errorf("unknown label type %d", typ)
+	}
+}
+
+// LabelSchema maps label names to the types their values are decoded to.
+// Labels that are not in the schema stay strings.
+type LabelSchema map[string]LabelType
+
+// Decode returns a copy of the labels with the values of the schema decoded
+// to their Go types.
+func (s LabelSchema) Decode(labels LabelMap) (LabelMap, error) {
+	out := make(LabelMap, len(labels))
+	for name, value := range labels {
+		typ, ok := s[name]
+		text, isText := value.(string)
+		if !ok || !isText {
+			out[name] = value
+			continue
+		}
+		decoded, err := ParseLabel(text, typ)
+		if err != nil {
+			return nil, &LabelError{Name: name, Value: value, Reason: err.Error()}
+		}
+		out[name] = decoded
+	}
+	return out, nil
+}
@@ -0,0 +1,415 @@
+package reductgo
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LabelMarshaler is implemented by types that encode themselves as a label value.
+type LabelMarshaler interface {
+	MarshalLabel() (string, error)
+}
+
+// LabelUnmarshaler is implemented by types that decode themselves from a label value.
+type LabelUnmarshaler interface {
+	UnmarshalLabel(value string) error
+}
+
+// Time formats of the format option of the reduct struct tag.
+const (
+	LabelTimeRFC3339   = "rfc3339"
+	LabelTimeUnix      = "unix"
+	LabelTimeUnixMilli = "unixmilli"
+	LabelTimeUnixMicro = "unixmicro"
+	LabelTimeUnixNano  = "unixnano"
+)
+
+var (
+	labelMarshalerType   = reflect.TypeFor[LabelMarshaler]()
+	labelUnmarshalerType = reflect.TypeFor[LabelUnmarshaler]()
+	textMarshalerType    = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType  = reflect.TypeFor[encoding.TextUnmarshaler]()
+	timeType             = reflect.TypeFor[time.Time]()
+	durationType         = reflect.TypeFor[time.Duration]()
+)
+
+// labelField is a field of a struct that is stored in a label.
+type labelField struct {
+	name       string
+	index      []int
+	omitEmpty  bool
+	timeFormat string
+}
+
+// labelStruct is the list of label fields of a struct type, or the error of its tags.
+type labelStruct struct {
+	fields []labelField
+	err    error
+}
+
+var labelStructCache sync.Map // map[reflect.Type]*labelStruct
+
+// MarshalLabels encodes the exported fields of a struct, or a pointer to one,
+// as labels. The label of a field is configured with the reduct struct tag:
+//
+//	type Meta struct {
+//		RobotID  string    `reduct:"robot_id"`
+//		Speed    float64   `reduct:"speed,omitempty"`
+//		Started  time.Time `reduct:"started,format=unixmilli"`
+//		Pose     Pose      `reduct:"pose"`    // pose_x, pose_y, ...
+//		Location Location  `reduct:",inline"` // fields without a prefix
+//		Internal string    `reduct:"-"`       // skipped
+//	}
+//
+// Without a tag, the field name is the label name. The options are:
+//
+//   - omitempty skips the field if it has its zero value,
+//   - format sets the format of a time.Time: rfc3339 (the default), unix,
+//     unixmilli, unixmicro, unixnano or a layout of the time package without
+//     commas,
+//   - prefix sets the prefix of the labels of a nested struct, which is the
+//     label name followed by "_" by default,
+//   - inline stores the fields of a nested struct without a prefix, which is
+//     the default for embedded structs.
+//
+// Values are encoded with FormatLabel, so types implementing LabelMarshaler or
+// encoding.TextMarshaler are stored as their text. Nil pointers are skipped.
+func MarshalLabels(v any) (LabelMap, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return LabelMap{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot marshal %T to labels: not a struct", v)
+	}
+	if !rv.CanAddr() {
+		// Make pointer receivers of LabelMarshaler available.
+		addressable := reflect.New(rv.Type()).Elem()
+		addressable.Set(rv)
+		rv = addressable
+	}
+
+	info := labelStructOf(rv.Type())
+	if info.err != nil {
+		return nil, info.err
+	}
+	labels := make(LabelMap, len(info.fields))
+	for _, field := range info.fields {
+		fv, ok := labelFieldValue(rv, field.index, false)
+		if !ok || (fv.Kind() == reflect.Pointer && fv.IsNil()) {
+			continue
+		}
+		if field.omitEmpty && fv.IsZero() {
+			continue
+		}
+		text, err := marshalLabelField(field, fv)
+		if err != nil {
+			return nil, err
+		}
+		labels[field.name] = text
+	}
+	return labels, nil
+}
+
+// UnmarshalLabels decodes labels into the struct v points to, with the
+// struct tags described in MarshalLabels. Fields without a label are not
+// changed. Types implementing LabelUnmarshaler or encoding.TextUnmarshaler
+// decode themselves.
+func UnmarshalLabels(labels LabelMap, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot unmarshal labels into %T: not a pointer to a struct", v)
+	}
+	rv = rv.Elem()
+
+	info := labelStructOf(rv.Type())
+	if info.err != nil {
+		return info.err
+	}
+	for _, field := range info.fields {
+		value, ok := labels[field.name]
+		if !ok {
+			continue
+		}
+		text, err := FormatLabel(field.name, value)
+		if err != nil {
+			return err
+		}
+		fv, _ := labelFieldValue(rv, field.index, true)
+		if err := unmarshalLabelField(field, fv, text); err != nil {
+			return &LabelError{Name: field.name, Value: value, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// labelsOf returns the labels of a write: a LabelMap, a map[string]string or
+// a struct with reduct tags.
+func labelsOf(labels any) (LabelMap, error) {
+	switch v := labels.(type) {
+	case nil:
+		return LabelMap{}, nil
+	case LabelMap:
+		return v, nil
+	case map[string]any:
+		return v, nil
+	case map[string]string:
+		out := make(LabelMap, len(v))
+		for name, value := range v {
+			out[name] = value
+		}
+		return out, nil
+	default:
+		return MarshalLabels(v)
+	}
+}
+
+func marshalLabelField(field labelField, fv reflect.Value) (string, error) {
+	if fv.Kind() != reflect.Pointer && fv.CanAddr() {
+		// Use marshalers with pointer receivers.
+		if ptr := fv.Addr().Type(); ptr.Implements(labelMarshalerType) || ptr.Implements(textMarshalerType) {
+			fv = fv.Addr()
+		}
+	}
+	value := fv.Interface()
+	if field.timeFormat != "" {
+		t := reflect.Indirect(fv).Interface().(time.Time)
+		switch field.timeFormat {
+		case LabelTimeRFC3339:
+		case LabelTimeUnix:
+			return strconv.FormatInt(t.Unix(), 10), nil
+		case LabelTimeUnixMilli:
+			return strconv.FormatInt(t.UnixMilli(), 10), nil
+		case LabelTimeUnixMicro:
+			return strconv.FormatInt(t.UnixMicro(), 10), nil
+		case LabelTimeUnixNano:
+			return strconv.FormatInt(t.UnixNano(), 10), nil
+		default:
+			value = t.Format(field.timeFormat)
+		}
+	}
+	return FormatLabel(field.name, value)
+}
+
+func unmarshalLabelField(field labelField, fv reflect.Value, text string) error {
+	if fv.Kind() == reflect.Pointer {
+		if text == "" {
+			fv.SetZero()
+			return nil
+		}
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+
+	target := fv.Addr().Interface()
+	if u, ok := target.(LabelUnmarshaler); ok {
+		return u.UnmarshalLabel(text)
+	}
+	if fv.Type() == timeType {
+		t, err := parseLabelTime(text, field.timeFormat)
+		if err == nil {
+			fv.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+	if u, ok := target.(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err == nil {
+			fv.SetInt(int64(d))
+		}
+		return err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(text, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+func parseLabelTime(text, format string) (time.Time, error) {
+	switch format {
+	case "", LabelTimeRFC3339:
+		return time.Parse(time.RFC3339Nano, text)
+	case LabelTimeUnix, LabelTimeUnixMilli, LabelTimeUnixMicro, LabelTimeUnixNano:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch format {
+		case LabelTimeUnix:
+			return time.Unix(n, 0).UTC(), nil
+		case LabelTimeUnixMilli:
+			return time.UnixMilli(n).UTC(), nil
+		case LabelTimeUnixMicro:
+			return time.UnixMicro(n).UTC(), nil
+		default:
+			return time.Unix(0, n).UTC(), nil
+		}
+	default:
+		return time.Parse(format, text)
+	}
+}
+
+// labelFieldValue returns the field at index. Nil pointers to nested structs
+// are allocated if alloc is set; otherwise ok is false.
+func labelFieldValue(v reflect.Value, index []int, alloc bool) (_ reflect.Value, ok bool) {
+	for i, n := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(n)
+	}
+	return v, true
+}
+
+func labelStructOf(t reflect.Type) *labelStruct {
+	if cached, ok := labelStructCache.Load(t); ok {
+		return cached.(*labelStruct)
+	}
+	info := &labelStruct{}
+	info.fields, info.err = collectLabelFields(t, "", nil, map[reflect.Type]bool{})
+	if info.err == nil {
+		seen := make(map[string]bool, len(info.fields))
+		for _, field := range info.fields {
+			if seen[field.name] {
+				info.err = fmt.Errorf("%s: duplicate label %q", t, field.name)
+				break
+			}
+			seen[field.name] = true
+		}
+	}
+	cached, _ := labelStructCache.LoadOrStore(t, info)
+	return cached.(*labelStruct)
+}
+
+// isLabelLeaf reports whether a type is stored in one label rather than as a nested struct.
+func isLabelLeaf(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return true
+	}
+	ptr := reflect.PointerTo(t)
+	return ptr.Implements(labelMarshalerType) || ptr.Implements(labelUnmarshalerType) ||
+		ptr.Implements(textMarshalerType) || ptr.Implements(textUnmarshalerType)
+}
+
+func collectLabelFields(t reflect.Type, prefix string, index []int, visiting map[reflect.Type]bool) ([]labelField, error) {
+	if visiting[t] {
+		return nil, fmt.Errorf("%s: recursive struct type", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var fields []labelField
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("reduct")
+		if tag == "-" || (!sf.IsExported() && !sf.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		field := labelField{index: append(append([]int(nil), index...), i)}
+		nestedPrefix, inline := "", sf.Anonymous && name == ""
+		for option := range strings.SplitSeq(options, ",") {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "":
+			case "omitempty":
+				field.omitEmpty = true
+			case "inline":
+				inline = true
+			case "prefix":
+				nestedPrefix = value
+			case "format":
+				field.timeFormat = value
+			default:
+				return nil, fmt.Errorf("%s.%s: unknown option %q in reduct tag", t, sf.Name, key)
+			}
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		if !isLabelLeaf(sf.Type) {
+			nestedType := sf.Type
+			if nestedType.Kind() == reflect.Pointer {
+				if !sf.IsExported() {
+					// The pointer cannot be allocated by UnmarshalLabels.
+					continue
+				}
+				nestedType = nestedType.Elem()
+			}
+			if nestedPrefix == "" && !inline {
+				nestedPrefix = name + "_"
+			}
+			nested, err := collectLabelFields(nestedType, prefix+nestedPrefix, field.index, visiting)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		fieldType := sf.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.timeFormat != "" && fieldType != timeType {
+			return nil, fmt.Errorf("%s.%s: format option on a field that is not a time.Time", t, sf.Name)
+		}
+		field.name = prefix + name
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
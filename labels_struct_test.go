@@ -0,0 +1,199 @@
+package reductgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+// robotState is stored as "<state>!" through its LabelMarshaler.
+type robotState string
+
+func (s *robotState) MarshalLabel() (string, error) {
+	if *s == "" {
+		return "", errors.New("empty state")
+	}
+	return string(*s) + "!", nil
+}
+
+func (s *robotState) UnmarshalLabel(value string) error {
+	*s = robotState(strings.TrimSuffix(value, "!"))
+	return nil
+}
+
+type pose struct {
+	X float64 `reduct:"x"`
+	Y float64 `reduct:"y"`
+}
+
+type location struct {
+	Site string `reduct:"site"`
+}
+
+type Firmware struct {
+	Version string
+}
+
+type robotLabels struct {
+	Firmware
+	RobotID  string        `reduct:"robot_id"`
+	Speed    float64       `reduct:"speed,omitempty"`
+	Active   bool          `reduct:"active"`
+	Count    uint16        `reduct:"count"`
+	Started  time.Time     `reduct:"started,format=unixmilli"`
+	Day      time.Time     `reduct:"day,format=2006-01-02"`
+	Seen     *time.Time    `reduct:"seen"`
+	Interval time.Duration `reduct:"interval"`
+	State    robotState    `reduct:"state"`
+	Pose     pose          `reduct:"pose"`
+	Target   *pose         `reduct:"target,prefix=to-"`
+	Location location      `reduct:",inline"`
+	Internal string        `reduct:"-"`
+	private  string
+}
+
+func TestMarshalLabels(t *testing.T) {
+	started := time.Date(2025, 5, 6, 7, 8, 9, 123_000_000, time.UTC)
+	robot := robotLabels{
+		Firmware: Firmware{Version: "1.2"},
+		RobotID:  "r-1",
+		Count:    7,
+		Started:  started,
+		Day:      started.Truncate(24 * time.Hour),
+		Interval: time.Second,
+		State:    "moving",
+		Pose:     pose{X: 1.5, Y: -2},
+		Target:   &pose{X: 3},
+		Location: location{Site: "a,b"},
+		Internal: "secret",
+		private:  "secret",
+	}
+
+	labels, err := MarshalLabels(robot)
+	require.NoError(t, err)
+	assert.Equal(t, LabelMap{
+		"Version":  "1.2",
+		"robot_id": "r-1",
+		"active":   "false",
+		"count":    "7",
+		"started":  "1746515289123",
+		"day":      "2025-05-06",
+		"interval": "1s",
+		"state":    "moving!",
+		"pose_x":   "1.5",
+		"pose_y":   "-2",
+		"to-x":     "3",
+		"to-y":     "0",
+		"site":     "a,b",
+	}, labels)
+
+	var decoded robotLabels
+	require.NoError(t, UnmarshalLabels(labels, &decoded))
+	robot.Internal, robot.private = "", ""
+	assert.Equal(t, robot, decoded)
+
+	seen := started.Add(time.Hour)
+	labels, err = MarshalLabels(&robotLabels{Seen: &seen, Speed: 0.5, State: "idle"})
+	require.NoError(t, err)
+	assert.Equal(t, "2025-05-06T08:08:09.123Z", labels["seen"])
+	assert.Equal(t, "0.5", labels["speed"])
+	assert.NotContains(t, labels, "to-x", "nil nested structs are skipped")
+
+	require.NoError(t, UnmarshalLabels(labels, &decoded))
+	require.NotNil(t, decoded.Seen)
+	assert.True(t, seen.Equal(*decoded.Seen))
+}
+
+func TestMarshalLabelsErrors(t *testing.T) {
+	_, err := MarshalLabels("text")
+	require.Error(t, err)
+
+	var labelErr *LabelError
+	_, err = MarshalLabels(robotLabels{})
+	require.ErrorAs(t, err, &labelErr, "the marshaler of state fails")
+	assert.Equal(t, "state", labelErr.Name)
+
+	_, err = MarshalLabels(struct {
+		A string `reduct:"name"`
+		B string `reduct:"name"`
+	}{})
+	require.ErrorContains(t, err, "duplicate label")
+
+	_, err = MarshalLabels(struct {
+		A string `reduct:"a,format=unix"`
+	}{})
+	require.ErrorContains(t, err, "format option")
+
+	var robot robotLabels
+	require.Error(t, UnmarshalLabels(LabelMap{}, robot), "a pointer is required")
+	err = UnmarshalLabels(LabelMap{"count": "70000"}, &robot)
+	require.ErrorAs(t, err, &labelErr)
+	assert.Equal(t, "count", labelErr.Name)
+}
+
+type sensorLabels struct {
+	Sensor string  `reduct:"sensor"`
+	Value  float64 `reduct:"value"`
+	Valid  bool    `reduct:"valid"`
+}
+
+func TestStructLabelsInWrites(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	labels := sensorLabels{Sensor: "temp", Value: 21.5, Valid: true}
+
+	require.NoError(t, bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 1, LabelsFrom: labels}).Write("data"))
+	require.NoError(t, bucket.BeginWrite(ctx, "merged", &WriteOptions{
+		Timestamp:  1,
+		Labels:     LabelMap{"sensor": "humidity", "robot": "r1"},
+		LabelsFrom: labels,
+	}).Write("data"))
+	assert.Equal(t, LabelMap{"sensor": "humidity", "robot": "r1", "value": "21.5", "valid": "true"},
+		mustReadEntry(t, bucket, "merged", 1).Labels(), "Labels wins over LabelsFrom")
+	err := bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 2, LabelsFrom: 42}).Write("data")
+	require.Error(t, err)
+
+	batch := bucket.BeginWriteBatch(ctx, "batch")
+	batch.Add(1, []byte("data"), "", &labels)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("record-batch", 1, []byte("data"), "", labels)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	require.NoError(t, bucket.Update(ctx, "single", 1, struct {
+		Valid bool `reduct:"valid"`
+	}{}))
+
+	for entry, want := range map[string]sensorLabels{
+		"single":       {Sensor: "temp", Value: 21.5},
+		"batch":        labels,
+		"record-batch": labels,
+	} {
+		ts := int64(1)
+		record, err := bucket.BeginRead(ctx, entry, &ts)
+		require.NoError(t, err)
+		var got sensorLabels
+		require.NoError(t, UnmarshalLabels(record.Labels(), &got))
+		assert.Equal(t, want, got, entry)
+	}
+
+	batch = bucket.BeginWriteBatch(ctx, "batch")
+	batch.Add(2, []byte("data"), "", 42)
+	batch.Add(3, []byte("data"), "", map[string]string{"sensor": "humidity"})
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+	assert.Equal(t, model.StatusInvalidRequest, errs[2].Status)
+}
@@ -0,0 +1,154 @@
+package reductgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+type level int
+
+func TestFormatLabel(t *testing.T) {
+	moment := time.Date(2025, 3, 4, 5, 6, 7, 890, time.FixedZone("CET", 3600))
+	var nilPointer *int
+	count := 3
+
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "a,b", want: "a,b"},
+		{value: `say "hi"`, want: `say "hi"`},
+		{value: "k=v", want: "k=v"},
+		{value: 42, want: "42"},
+		{value: int8(-8), want: "-8"},
+		{value: uint64(18446744073709551615), want: "18446744073709551615"},
+		{value: 1.5, want: "1.5"},
+		{value: float32(0.1), want: "0.1"},
+		{value: 1e21, want: "1e+21"},
+		{value: true, want: "true"},
+		{value: moment, want: "2025-03-04T04:06:07.00000089Z"},
+		{value: 90 * time.Second, want: "1m30s"},
+		{value: level(2), want: "2"},
+		{value: &count, want: "3"},
+		{value: nilPointer, want: ""},
+		{value: nil, want: ""},
+	}
+	for _, tt := range tests {
+		got, err := FormatLabel("label", tt.value)
+		require.NoError(t, err, "%v", tt.value)
+		assert.Equal(t, tt.want, got, "%v", tt.value)
+	}
+
+	_, err := FormatLabel("label", []string{"a"})
+	var labelErr *LabelError
+	require.ErrorAs(t, err, &labelErr)
+	assert.Equal(t, "label", labelErr.Name)
+
+	_, err = FormatLabel("label", "line\nbreak")
+	assert.ErrorAs(t, err, &labelErr)
+}
+
+func TestLabelSchemaDecode(t *testing.T) {
+	schema := LabelSchema{
+		"count":    LabelInt,
+		"size":     LabelUint,
+		"ratio":    LabelFloat,
+		"ok":       LabelBool,
+		"at":       LabelTime,
+		"interval": LabelDuration,
+	}
+	moment := time.Date(2025, 3, 4, 5, 6, 7, 890, time.UTC)
+	labels := LabelMap{
+		"count": int64(-3), "size": uint64(7), "ratio": 0.25, "ok": true,
+		"at": moment, "interval": 1500 * time.Millisecond, "name": "x,y=z",
+	}
+	formatted, err := FormatLabels(labels)
+	require.NoError(t, err)
+	encoded := LabelMap{}
+	for name, value := range formatted {
+		encoded[name] = value
+	}
+
+	decoded, err := schema.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, labels, decoded)
+
+	_, err = schema.Decode(LabelMap{"count": "many"})
+	var labelErr *LabelError
+	assert.ErrorAs(t, err, &labelErr)
+}
+
+func TestLabelsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	schema := LabelSchema{"count": LabelInt, "ratio": LabelFloat, "ok": LabelBool, "at": LabelTime, "interval": LabelDuration}
+	labels := LabelMap{
+		"count":    int64(12),
+		"ratio":    0.1,
+		"ok":       false,
+		"at":       time.Date(2025, 1, 2, 3, 4, 5, 6000, time.UTC),
+		"interval": 2 * time.Minute,
+		"comma":    "a,b,c",
+		"equals":   "a=b",
+	}
+
+	require.NoError(t, bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 1, Labels: labels}).Write("data"))
+
+	batch := bucket.BeginWriteBatch(ctx, "batch")
+	batch.Add(1, []byte("data"), "", labels)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("record-batch", 1, []byte("data"), "", labels)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	for _, entry := range []string{"single", "batch", "record-batch"} {
+		ts := int64(1)
+		record, err := bucket.BeginRead(ctx, entry, &ts)
+		require.NoError(t, err)
+		typed, err := record.TypedLabels(schema)
+		require.NoError(t, err)
+		assert.Equal(t, labels, typed, entry)
+	}
+}
+
+func TestLabelsWithQuotesInBatches(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	quoted := LabelMap{"quote": `say "hi"`}
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Labels: quoted}).Write("data"))
+	record, err := bucket.BeginRead(ctx, "entry", nil)
+	require.NoError(t, err)
+	assert.Equal(t, `say "hi"`, record.Labels()["quote"])
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(2, []byte("data"), "", quoted)
+	batch.Add(3, []byte("data"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+	assert.Equal(t, model.StatusInvalidRequest, errs[2].Status)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("entry", 4, []byte("data"), "", quoted)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusInvalidRequest, recordErrs["entry"][4].Status)
+
+	err = bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 5, Labels: LabelMap{"bad": []int{1}}}).Write("data")
+	var labelErr *LabelError
+	require.ErrorAs(t, err, &labelErr)
+	assert.Equal(t, "bad", labelErr.Name)
+}
@@ -0,0 +1,40 @@
+package reductgo
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerReceivesDiagnostics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// An outdated server and a rejected record
+		w.Header().Set("X-Reduct-API", "v1.0")
+		w.Header().Set("x-reduct-error-2", "409,A record with timestamp 2 already exists")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	client := NewClient(server.URL, ClientOptions{Logger: logger})
+
+	ctx := context.Background()
+	bucket, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("data"), "", nil)
+	batch.Add(2, []byte("data"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+
+	assert.Contains(t, buf.String(), `level=WARN msg="server API version is too old for this client, please update your server" server_version=v1.0`)
+	assert.Contains(t, buf.String(), `level=WARN msg="batch partially failed" bucket=bucket entry=entry failed=1 records=2`)
+}
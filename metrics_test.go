@@ -0,0 +1,97 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/reductstore/reduct-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsOfWritesAndQueries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/batch"):
+			w.Header().Set("x-reduct-error-2", "409,A record with timestamp 2 already exists")
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/write"):
+			w.Header().Set("x-reduct-entries", "a,b")
+			w.Header().Set("x-reduct-start-ts", "1")
+			w.Header().Set("x-reduct-error-0-0", "422,bad record")
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/q"):
+			_, err := w.Write([]byte(`{"id":1}`))
+			assert.NoError(t, err)
+			return
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/batch"):
+			w.Header().Set("x-reduct-time-1", "3,text/plain")
+			w.Header().Set("x-reduct-time-2", "4,text/plain")
+			w.Header().Set("x-reduct-last", "true")
+			_, err := w.Write([]byte("abcdefg"))
+			assert.NoError(t, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	metrics := telemetry.NewMemoryMetrics()
+	client := NewClient(server.URL, ClientOptions{Metrics: metrics})
+
+	ctx := context.Background()
+	bucket, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("data"))
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("abc"), "", nil)
+	batch.Add(2, []byte("de"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Len(t, errs, 1)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("a", 1, []byte("xyz"), "", nil)
+	recordBatch.Add("b", 1, []byte("x"), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Len(t, recordErrs, 1)
+
+	result, err := bucket.Query(ctx, "entry", nil)
+	require.NoError(t, err)
+	for record := range result.Records() {
+		_, err := record.Read()
+		require.NoError(t, err)
+	}
+
+	snapshot := metrics.Snapshot()
+	operation := func(name string) telemetry.Label {
+		return telemetry.Label{Name: telemetry.LabelOperation, Value: name}
+	}
+
+	assert.InDelta(t, 4, snapshot.Counter(telemetry.MetricBytesWritten, operation("WritableRecord.Write")), 0)
+	assert.InDelta(t, 5, snapshot.Counter(telemetry.MetricBytesWritten, operation("Batch.Write")), 0)
+	assert.InDelta(t, 4, snapshot.Counter(telemetry.MetricBytesWritten, operation("RecordBatch.Send")), 0)
+	assert.InDelta(t, 2, snapshot.Counter(telemetry.MetricRecordsRead), 0)
+	assert.InDelta(t, 7, snapshot.Counter(telemetry.MetricBytesRead), 0)
+
+	assert.InDelta(t, 1, snapshot.Counter(telemetry.MetricRecordErrors, operation("Batch.Write"),
+		telemetry.Label{Name: telemetry.LabelStatus, Value: "409"}), 0)
+	assert.InDelta(t, 1, snapshot.Counter(telemetry.MetricRecordErrors, operation("RecordBatch.Send"),
+		telemetry.Label{Name: telemetry.LabelStatus, Value: "422"}), 0)
+
+	batchRecords, ok := snapshot.Histogram(telemetry.MetricBatchRecords, operation("Batch.Write"))
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), batchRecords.Count)
+	assert.InDelta(t, 2, batchRecords.Sum, 0)
+
+	// The query request and the request of its first batch.
+	assert.InDelta(t, 2, snapshot.Counter(telemetry.MetricRequests,
+		telemetry.Label{Name: telemetry.LabelEndpoint, Value: "Bucket.Query"},
+		telemetry.Label{Name: telemetry.LabelStatus, Value: "200"}), 0)
+	assert.InDelta(t, 6, snapshot.Counter(telemetry.MetricRequests), 0)
+}
@@ -0,0 +1,60 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewaresSeeOperations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var operations []httpclient.Operation
+	client := NewClient(server.URL, ClientOptions{
+		Middlewares: []httpclient.Middleware{httpclient.RequestHook(func(req *http.Request) error {
+			op, ok := httpclient.OperationFromContext(req.Context())
+			assert.True(t, ok, "missing operation for %s", req.URL.Path)
+			mu.Lock()
+			operations = append(operations, op)
+			mu.Unlock()
+			return nil
+		})},
+	})
+
+	ctx := context.Background()
+	bucket, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("data"))
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(2, []byte("data"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.Add("entry", 3, []byte("data"), "", nil)
+	_, err = recordBatch.Send(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.Update(ctx, "entry", 1, LabelMap{"key": "value"}))
+
+	assert.Equal(t, []httpclient.Operation{
+		{Name: "Client.GetBucket", Bucket: "bucket"},
+		{Name: "WritableRecord.Write", Bucket: "bucket", Entry: "entry"},
+		{Name: "Batch.Write", Bucket: "bucket", Entry: "entry"},
+		{Name: "RecordBatch.Send", Bucket: "bucket"},
+		{Name: "Bucket.Update", Bucket: "bucket", Entry: "entry"},
+	}, operations)
+}
@@ -1,27 +1,135 @@
 package model
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Statuses of errors that happen before the storage engine answers.
+const (
+	StatusInvalidRequest  = -6 // the request could not be built.
+	StatusInterrupt       = -5 // a long-running task or query was interrupted.
+	StatusURLParseError   = -4 // the URL is invalid.
+	StatusConnectionError = -3 // the server could not be reached.
+	StatusTimeout         = -2 // the request timed out.
+	StatusUnknown         = -1 // the cause is unknown.
+)
+
+// Sentinel errors for common failures. An APIError matches them with errors.Is
+// by its status, whether it is returned as a value or as a pointer:
+//
+//	if errors.Is(err, model.ErrNotFound) { ... }
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrUnprocessable   = errors.New("unprocessable entity")
+	ErrTooEarly        = errors.New("too early")
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrServer matches any 5xx status.
+	ErrServer = errors.New("server error")
+	// ErrTimeout matches client timeouts, 408 Request Timeout and 504 Gateway Timeout.
+	ErrTimeout = errors.New("timeout")
+	// ErrConnection matches network errors: the server could not be reached or
+	// the connection broke.
+	ErrConnection = errors.New("connection error")
+	// ErrInterrupted matches requests canceled by the caller.
+	ErrInterrupted = errors.New("interrupted")
+)
 
 // APIError represents an HTTP error with optional status, message, and original error info.
 type APIError struct {
 	Status   int    `json:"status,omitempty"`   // HTTP status of the error (nil if communication issue)
 	Message  string `json:"message,omitempty"`  // Parsed message from the storage engine
 	Original error  `json:"original,omitempty"` // Original error (can be of any type)
+
+	Method    string `json:"method,omitempty"`    // HTTP method of the failed request
+	Path      string `json:"path,omitempty"`      // Request path relative to the API, e.g. /b/bucket/entry
+	Bucket    string `json:"bucket,omitempty"`    // Bucket of the operation, if any
+	Entry     string `json:"entry,omitempty"`     // Entry of the operation, if any
+	Retryable bool   `json:"retryable,omitempty"` // Whether another attempt may succeed
 }
 
 // NewAPIError creates a new instance of APIError with given message, status, and original error.
 func NewAPIError(message string, status int, original error) *APIError {
 	return &APIError{
-		Status:   status,
-		Message:  message,
-		Original: original,
+		Status:    status,
+		Message:   message,
+		Original:  original,
+		Retryable: IsRetryableStatus(status),
+	}
+}
+
+// IsRetryableStatus reports whether a failure with the status is transient:
+// network errors, timeouts, 429 Too Many Requests and 502, 503 and 504.
+func IsRetryableStatus(status int) bool {
+	switch status {
+	case StatusConnectionError, StatusTimeout,
+		http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
 	}
 }
 
 func (e APIError) Error() string {
-	return fmt.Sprintf("status: %d, message: %s, error: %v", e.Status, e.Message, e.Original)
+	msg := fmt.Sprintf("status: %d, message: %s, error: %v", e.Status, e.Message, e.Original)
+	if e.Method != "" {
+		msg += fmt.Sprintf(", request: %s %s", e.Method, e.Path)
+	}
+	return msg
 }
 
 func (e APIError) Unwrap() error {
 	return e.Original
 }
+
+// Is reports whether the error matches one of the sentinel errors.
+func (e APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Status == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrUnprocessable:
+		return e.Status == http.StatusUnprocessableEntity
+	case ErrTooEarly:
+		return e.Status == http.StatusTooEarly
+	case ErrTooManyRequests:
+		return e.Status == http.StatusTooManyRequests
+	case ErrServer:
+		return e.Status >= http.StatusInternalServerError && e.Status <= 599
+	case ErrTimeout:
+		return e.Status == StatusTimeout || e.Status == http.StatusRequestTimeout || e.Status == http.StatusGatewayTimeout
+	case ErrConnection:
+		return e.Status == StatusConnectionError
+	case ErrInterrupted:
+		return e.Status == StatusInterrupt
+	default:
+		return false
+	}
+}
+
+// As lets errors.As extract the error both as APIError and *APIError, whichever
+// form it was returned in.
+func (e APIError) As(target any) bool {
+	switch t := target.(type) {
+	case *APIError:
+		*t = e
+		return true
+	case **APIError:
+		*t = &e
+		return true
+	default:
+		return false
+	}
+}
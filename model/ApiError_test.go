@@ -0,0 +1,65 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIErrorIsSentinel(t *testing.T) {
+	tests := []struct {
+		status   int
+		sentinel error
+	}{
+		{http.StatusBadRequest, ErrBadRequest},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusUnprocessableEntity, ErrUnprocessable},
+		{http.StatusTooEarly, ErrTooEarly},
+		{http.StatusTooManyRequests, ErrTooManyRequests},
+		{http.StatusInternalServerError, ErrServer},
+		{http.StatusGatewayTimeout, ErrServer},
+		{http.StatusGatewayTimeout, ErrTimeout},
+		{StatusTimeout, ErrTimeout},
+		{StatusConnectionError, ErrConnection},
+		{StatusInterrupt, ErrInterrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %v", tt.status, tt.sentinel), func(t *testing.T) {
+			value := APIError{Status: tt.status}
+			assert.ErrorIs(t, value, tt.sentinel)
+			assert.ErrorIs(t, &value, tt.sentinel)
+			assert.ErrorIs(t, fmt.Errorf("wrapped: %w", &value), tt.sentinel)
+		})
+	}
+
+	assert.NotErrorIs(t, APIError{Status: http.StatusNotFound}, ErrConflict)
+	assert.NotErrorIs(t, &APIError{Status: http.StatusBadGateway}, ErrTimeout)
+}
+
+func TestAPIErrorAsBothForms(t *testing.T) {
+	var value APIError
+	require.ErrorAs(t, fmt.Errorf("wrapped: %w", &APIError{Status: http.StatusNotFound, Path: "/b/bucket"}), &value)
+	assert.Equal(t, "/b/bucket", value.Path)
+
+	var ptr *APIError
+	require.ErrorAs(t, fmt.Errorf("wrapped: %w", APIError{Status: http.StatusConflict, Bucket: "bucket"}), &ptr)
+	assert.Equal(t, "bucket", ptr.Bucket)
+}
+
+func TestAPIErrorUnwrapsOriginal(t *testing.T) {
+	original := errors.New("boom")
+	err := NewAPIError("failed", StatusConnectionError, original)
+
+	assert.ErrorIs(t, err, original)
+	assert.ErrorIs(t, err, ErrConnection)
+	assert.True(t, err.Retryable)
+	assert.False(t, NewAPIError("failed", http.StatusUnprocessableEntity, nil).Retryable)
+}
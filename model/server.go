@@ -5,6 +5,17 @@ type ServerDefaults struct {
 	Bucket BucketSetting `json:"bucket"`
 }
 
+// ServerLimits represents the limits of batch requests accepted by the server.
+// Zero values mean the server does not report the limit.
+type ServerLimits struct {
+	// Maximum body size of a batch request in bytes
+	BatchMaxSize int64 `json:"batch_max_size"`
+	// Maximum number of records in a batch request
+	BatchMaxRecords int `json:"batch_max_records"`
+	// Maximum total size of the x-reduct-* headers of a batch request in bytes
+	BatchMaxHeaderSize int `json:"batch_max_header_size"`
+}
+
 // LicenseInfo represents license information for the server.
 type LicenseInfo struct {
 	// Licensee name
@@ -41,4 +52,6 @@ type ServerInfo struct {
 	License *LicenseInfo `json:"license,omitempty"`
 	// Default settings
 	Defaults ServerDefaults `json:"defaults"`
+	// Limits of batch requests, reported by servers that support it
+	Limits *ServerLimits `json:"limits,omitempty"`
 }
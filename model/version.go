@@ -3,7 +3,7 @@ package model
 import (
 	_ "embed"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 )
@@ -74,7 +74,13 @@ func (v *Version) IsOlderThan(other *Version, minorVersionDiff int) bool {
 // CheckServerAPIVersion checks if the server API version is compatible with the client version
 // It returns an error if the major versions don't match, and logs a warning if the server
 // is more than 2 minor versions behind the client.
+// The warning is written to slog.Default(), see CheckServerAPIVersionWithLogger.
 func CheckServerAPIVersion(serverVersion, clientVersion string) error {
+	return CheckServerAPIVersionWithLogger(slog.Default(), serverVersion, clientVersion)
+}
+
+// CheckServerAPIVersionWithLogger is like CheckServerAPIVersion but writes the warning to logger.
+func CheckServerAPIVersionWithLogger(logger *slog.Logger, serverVersion, clientVersion string) error {
 	server, err := ParseVersion(serverVersion)
 	if err != nil {
 		return fmt.Errorf("failed to parse server version: %w", err)
@@ -93,8 +99,8 @@ func CheckServerAPIVersion(serverVersion, clientVersion string) error {
 	}
 
 	if client.Minor-server.Minor > 2 {
-		log.Printf("WARNING: Server API version %s is too old for this client version %s. Please update your server.",
-			serverVersion, clientVersion)
+		logger.Warn("server API version is too old for this client, please update your server",
+			"server_version", serverVersion, "client_version", clientVersion)
 	}
 
 	return nil
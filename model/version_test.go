@@ -1,6 +1,8 @@
 package model
 
 import (
+	"bytes"
+	"log/slog"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -194,3 +196,18 @@ func TestCheckServerAPIVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckServerAPIVersionWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	err := CheckServerAPIVersionWithLogger(logger, "1.0", "1.3")
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), "server_version=1.0 client_version=1.3")
+
+	buf.Reset()
+	err = CheckServerAPIVersionWithLogger(logger, "1.1", "1.3")
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,418 @@
+package reductgo
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"strconv"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+// Reserved labels of the chunks of an object, see Bucket.WriteObject.
+const (
+	// ObjectIDLabel is the ID of the object a chunk belongs to.
+	ObjectIDLabel = "reduct-object-id"
+	// ChunkIndexLabel is the position of a chunk in its object, from 0.
+	ChunkIndexLabel = "reduct-chunk-index"
+	// ChunkHashLabel is the hex SHA-256 of the payload of a chunk. For an
+	// encrypted chunk, it is keyed with the key of the chunk (HMAC-SHA256 of
+	// the SHA-256 with a key derived from it), see EncryptionOptions.
+	ChunkHashLabel = "reduct-chunk-sha256"
+	// ChunkCountLabel is the number of chunks of the object. Only the last chunk has it.
+	ChunkCountLabel = "reduct-chunk-count"
+	// ObjectSizeLabel is the size of the object. Only the last chunk has it.
+	ObjectSizeLabel = "reduct-object-size"
+	// ObjectHashLabel is the hex SHA-256 of the object, keyed like
+	// ChunkHashLabel if the last chunk is encrypted. Only the last chunk has it.
+	ObjectHashLabel = "reduct-object-sha256"
+)
+
+// DefaultObjectChunkSize is the size of the chunks of an object if
+// ObjectOptions.ChunkSize is not set.
+const DefaultObjectChunkSize = 8 << 20
+
+var (
+	// ErrObjectNotFound is returned by ReadObject if the entry has no first
+	// chunk of the object.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectCorrupted is returned when reading an object whose chunks are
+	// missing, out of order or do not match their hashes.
+	ErrObjectCorrupted = errors.New("object is incomplete or corrupted")
+	// ErrObjectMismatch is returned by WriteObject if a chunk of the object is
+	// already stored with other data than the reader provides.
+	ErrObjectMismatch = errors.New("stored chunk does not match the object")
+)
+
+// ObjectOptions are the options of Bucket.WriteObject.
+type ObjectOptions struct {
+	// Timestamp of the first chunk in microseconds; chunk i is written at
+	// Timestamp+i. If 0, an upload of the object is resumed at the timestamp of
+	// its stored chunks, and a new one starts at the current time.
+	Timestamp int64
+	// ChunkSize is the payload size of all chunks but the last one. Defaults to
+	// DefaultObjectChunkSize. A resumed upload must use the same chunk size.
+	ChunkSize int64
+	// ContentType of the chunks.
+	ContentType string
+	// Labels of all chunks: a LabelMap, a map[string]string or a struct with
+	// reduct tags (see MarshalLabels).
+	Labels any
+}
+
+// ObjectInfo describes an object written with Bucket.WriteObject.
+type ObjectInfo struct {
+	ID string
+	// Timestamp of the first chunk in microseconds.
+	Timestamp int64
+	Size      int64
+	Chunks    int
+	// Resumed is the number of chunks that were already stored and not written again.
+	Resumed int
+	// SHA256 is the hex SHA-256 of the object.
+	SHA256 string
+}
+
+// storedChunk is a chunk of an object found in the entry.
+type storedChunk struct {
+	ts   int64
+	size int64
+	hash string
+	// key is the key the chunk is encrypted with, nil if it is not.
+	key []byte
+}
+
+// WriteObject writes the data of the reader as an object that spans several
+// records of the entry: chunks of ObjectOptions.ChunkSize bytes at consecutive
+// timestamps, labeled with the object ID and the chunk index. The last chunk
+// also has the number of chunks, the size and the hash of the object. Only
+// one chunk is held in memory.
+//
+// If the entry already has chunks of the object, e.g. from an upload that
+// failed, WriteObject reads the reader from the start but writes only the
+// missing chunks. A stored chunk with other data fails with ErrObjectMismatch.
+// Chunks are encrypted with the encryption of the bucket, all with its
+// current key, and their hash labels are keyed with it.
+//
+// Example:
+//
+//	file, err := os.Open("run.bag")
+//	if err != nil {
+//	    return err
+//	}
+//	defer file.Close()
+//	info, err := bucket.WriteObject(ctx, "bags", "run-42", file, nil)
+func (b *Bucket) WriteObject(ctx context.Context, entry, objectID string, reader io.Reader,
+	options *ObjectOptions,
+) (ObjectInfo, error) {
+	var opts ObjectOptions
+	if options != nil {
+		opts = *options
+	}
+	if objectID == "" {
+		return ObjectInfo{}, fmt.Errorf("object ID is required")
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultObjectChunkSize
+	}
+	if opts.ChunkSize < 0 {
+		return ObjectInfo{}, fmt.Errorf("negative chunk size %d", opts.ChunkSize)
+	}
+	labels, err := labelsOf(opts.Labels)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	encryption, kek, err := b.Encryption.pinned()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stored, start, err := b.storedChunks(ctx, entry, objectID, opts.Timestamp)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if start == 0 {
+		start = b.Timestamps.Next(entry)
+	}
+
+	info := ObjectInfo{ID: objectID, Timestamp: start}
+	objectHash := sha256.New()
+	source := bufio.NewReader(reader)
+	buf := make([]byte, opts.ChunkSize)
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(source, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return info, fmt.Errorf("read chunk %d of object %q: %w", index, objectID, err)
+		}
+		last := n < len(buf)
+		if !last {
+			if _, err := source.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return info, fmt.Errorf("read chunk %d of object %q: %w", index+1, objectID, err)
+			}
+		}
+		payload := buf[:n]
+		sum := sha256.Sum256(payload)
+		objectHash.Write(payload)
+		info.Size += int64(n)
+		info.Chunks++
+
+		chunkLabels := make(LabelMap, len(labels)+6)
+		maps.Copy(chunkLabels, labels)
+		chunkLabels[ObjectIDLabel] = objectID
+		chunkLabels[ChunkIndexLabel] = strconv.Itoa(index)
+		if chunkLabels[ChunkHashLabel], err = hashLabel(sum[:], kek); err != nil {
+			return info, err
+		}
+		if last {
+			objectSum := objectHash.Sum(nil)
+			info.SHA256 = hex.EncodeToString(objectSum)
+			chunkLabels[ChunkCountLabel] = strconv.Itoa(info.Chunks)
+			chunkLabels[ObjectSizeLabel] = strconv.FormatInt(info.Size, 10)
+			if chunkLabels[ObjectHashLabel], err = hashLabel(objectSum, kek); err != nil {
+				return info, err
+			}
+		}
+
+		ts := start + int64(index)
+		if chunk, ok := stored[index]; ok {
+			storedHash, err := hashLabel(sum[:], chunk.key)
+			if err != nil {
+				return info, err
+			}
+			if chunk.ts != ts || chunk.size != int64(n) || chunk.hash != storedHash {
+				return info, fmt.Errorf("chunk %d of object %q: %w", index, objectID, ErrObjectMismatch)
+			}
+			info.Resumed++
+		} else {
+			err = b.BeginWrite(ctx, entry, &WriteOptions{
+				Timestamp:   ts,
+				ContentType: opts.ContentType,
+				Labels:      chunkLabels,
+				Encryption:  encryption,
+			}).Write(payload)
+			if err != nil {
+				return info, fmt.Errorf("write chunk %d of object %q: %w", index, objectID, err)
+			}
+		}
+		if last {
+			return info, nil
+		}
+	}
+}
+
+// storedChunks returns the chunks of the object that the entry has from start
+// on, by index, and the timestamp of the first chunk if start is 0.
+func (b *Bucket) storedChunks(ctx context.Context, entry, objectID string, start int64,
+) (map[int]storedChunk, int64, error) {
+	result, err := b.Query(ctx, entry, &QueryOptions{
+		Start: start,
+		When:  map[string]any{"&" + ObjectIDLabel: map[string]any{"$eq": objectID}},
+		Head:  true,
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return nil, start, nil
+		}
+		return nil, 0, err
+	}
+	chunks := map[int]storedChunk{}
+	for record := range result.Records() {
+		labels := record.Labels()
+		index, err := strconv.Atoi(fmt.Sprint(labels[ChunkIndexLabel]))
+		if err != nil {
+			continue
+		}
+		if start == 0 {
+			start = record.Time() - int64(index)
+		}
+		hash, _ := labels[ChunkHashLabel].(string)
+		key, err := recordKey(b.keys(), labels)
+		if err != nil {
+			return nil, 0, err
+		}
+		chunks[index] = storedChunk{ts: record.Time(), size: record.Size(), hash: hash, key: key}
+	}
+	if err := result.Err(); err != nil {
+		return nil, 0, err
+	}
+	return chunks, start, nil
+}
+
+// ReadObject reads an object written with WriteObject. The returned reader
+// reassembles the chunks in order and verifies them: reading fails with
+// ErrObjectCorrupted if a chunk is missing or does not match its hash, or if
+// the object does not match the size and hash of its last chunk. Close the
+// reader to stop reading early.
+func (b *Bucket) ReadObject(ctx context.Context, entry, objectID string) (*ObjectReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	result, err := b.Query(ctx, entry, &QueryOptions{
+		When: map[string]any{"&" + ObjectIDLabel: map[string]any{"$eq": objectID}},
+	})
+	if err != nil {
+		cancel()
+		if errors.Is(err, model.ErrNotFound) {
+			return nil, fmt.Errorf("object %q: %w", objectID, ErrObjectNotFound)
+		}
+		return nil, err
+	}
+	reader := &ObjectReader{id: objectID, result: result, cancel: cancel, keys: b.keys(), objectHash: sha256.New()}
+	first, ok := <-result.Records()
+	if !ok {
+		cancel()
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("object %q: %w", objectID, ErrObjectNotFound)
+	}
+	if err := reader.begin(first); err != nil {
+		cancel()
+		return nil, err
+	}
+	reader.timestamp = first.Time()
+	return reader, nil
+}
+
+// ObjectReader reads an object from its chunks, see Bucket.ReadObject.
+type ObjectReader struct {
+	id        string
+	timestamp int64
+	result    *QueryResult
+	cancel    context.CancelFunc
+	keys      KeyProvider
+
+	chunk      *ReadableRecord
+	chunkKey   []byte
+	index      int
+	chunkHash  hash.Hash
+	chunkRead  int64
+	objectHash hash.Hash
+	size       int64
+	done       bool
+	err        error
+}
+
+// ID returns the ID of the object.
+func (r *ObjectReader) ID() string {
+	return r.id
+}
+
+// Timestamp returns the timestamp of the first chunk of the object in microseconds.
+func (r *ObjectReader) Timestamp() int64 {
+	return r.timestamp
+}
+
+// Read reads the object. It returns io.EOF after the last chunk is verified.
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for r.chunk == nil {
+		if r.done {
+			return 0, io.EOF
+		}
+		record, ok := <-r.result.Records()
+		if !ok {
+			if err := r.result.Err(); err != nil {
+				return 0, r.fail(err)
+			}
+			return 0, r.corrupted("chunk %d is missing", r.index)
+		}
+		if err := r.begin(record); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.chunk.Stream().Read(p)
+	r.chunkHash.Write(p[:n])
+	r.objectHash.Write(p[:n])
+	r.chunkRead += int64(n)
+	if errors.Is(err, io.EOF) {
+		return n, r.finishChunk()
+	}
+	if err != nil {
+		return n, r.fail(err)
+	}
+	return n, nil
+}
+
+// Close stops reading the object.
+func (r *ObjectReader) Close() error {
+	r.cancel()
+	if r.err == nil {
+		r.err = fmt.Errorf("object %q: %w", r.id, errReaderClosed)
+	}
+	return nil
+}
+
+var errReaderClosed = errors.New("reader is closed")
+
+// begin starts reading the next chunk.
+func (r *ObjectReader) begin(record *ReadableRecord) error {
+	labels := record.Labels()
+	if id := fmt.Sprint(labels[ObjectIDLabel]); id != r.id {
+		return r.corrupted("chunk %d belongs to object %q", r.index, id)
+	}
+	if index := fmt.Sprint(labels[ChunkIndexLabel]); index != strconv.Itoa(r.index) {
+		return r.corrupted("chunk %s found instead of chunk %d", index, r.index)
+	}
+	key, err := recordKey(r.keys, labels)
+	if err != nil {
+		return r.fail(err)
+	}
+	r.chunk = record
+	r.chunkKey = key
+	r.chunkHash = sha256.New()
+	r.chunkRead = 0
+	return nil
+}
+
+// finishChunk verifies the chunk that was read to the end.
+func (r *ObjectReader) finishChunk() error {
+	labels := r.chunk.Labels()
+	chunkHash, err := hashLabel(r.chunkHash.Sum(nil), r.chunkKey)
+	if err != nil {
+		return r.fail(err)
+	}
+	if chunkHash != fmt.Sprint(labels[ChunkHashLabel]) {
+		return r.corrupted("chunk %d does not match its hash", r.index)
+	}
+	r.size += r.chunkRead
+	r.index++
+	r.chunk = nil
+
+	count, last := labels[ChunkCountLabel]
+	if !last {
+		return nil
+	}
+	objectHash, err := hashLabel(r.objectHash.Sum(nil), r.chunkKey)
+	if err != nil {
+		return r.fail(err)
+	}
+	if fmt.Sprint(count) != strconv.Itoa(r.index) ||
+		fmt.Sprint(labels[ObjectSizeLabel]) != strconv.FormatInt(r.size, 10) ||
+		fmt.Sprint(labels[ObjectHashLabel]) != objectHash {
+		return r.corrupted("object does not match the size and hash of its last chunk")
+	}
+	r.done = true
+	r.cancel()
+	return io.EOF
+}
+
+func (r *ObjectReader) corrupted(format string, args ...any) error {
+	return r.fail(fmt.Errorf("object %q: %w: %s", r.id, ErrObjectCorrupted, fmt.Sprintf(format, args...)))
+}
+
+// fail stops reading the object with the error.
+func (r *ObjectReader) fail(err error) error {
+	r.err = err
+	r.cancel()
+	return err
+}
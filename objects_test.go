@@ -0,0 +1,192 @@
+package reductgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+// failingReader returns an error after its data.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection lost")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readObject(t *testing.T, bucket *Bucket, objectID string) ([]byte, error) {
+	t.Helper()
+	reader, err := bucket.ReadObject(context.Background(), "objects", objectID)
+	require.NoError(t, err)
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestWriteObject(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	data, err := io.ReadAll(&patternReader{remaining: 10*1000 + 3})
+	require.NoError(t, err)
+	sum := sha256.Sum256(data)
+
+	info, err := bucket.WriteObject(ctx, "objects", "bag", bytes.NewReader(data), &ObjectOptions{
+		Timestamp:   100,
+		ChunkSize:   1000,
+		ContentType: "application/x-rosbag",
+		Labels:      LabelMap{"robot": "r1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ObjectInfo{
+		ID: "bag", Timestamp: 100, Size: int64(len(data)), Chunks: 11, SHA256: hex.EncodeToString(sum[:]),
+	}, info)
+	assert.Equal(t, 11, countEntryRecords(t, bucket, "objects"))
+
+	last := mustReadEntry(t, bucket, "objects", 110)
+	assert.Equal(t, "r1", last.Labels()["robot"])
+	assert.Equal(t, "10", last.Labels()[ChunkIndexLabel])
+	assert.Equal(t, "11", last.Labels()[ChunkCountLabel])
+	assert.Equal(t, "application/x-rosbag", last.ContentType())
+	assert.NotContains(t, mustReadEntry(t, bucket, "objects", 100).Labels(), ChunkCountLabel)
+
+	read, err := readObject(t, bucket, "bag")
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	info, err = bucket.WriteObject(ctx, "objects", "empty", bytes.NewReader(nil), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Chunks)
+	read, err = readObject(t, bucket, "empty")
+	require.NoError(t, err)
+	assert.Empty(t, read)
+
+	_, err = bucket.ReadObject(ctx, "objects", "missing")
+	require.ErrorIs(t, err, ErrObjectNotFound)
+	_, err = bucket.ReadObject(ctx, "no-entry", "bag")
+	require.ErrorIs(t, err, ErrObjectNotFound)
+}
+
+func TestWriteObjectResume(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	data, err := io.ReadAll(&patternReader{remaining: 5000})
+	require.NoError(t, err)
+	options := &ObjectOptions{ChunkSize: 1000}
+
+	_, err = bucket.WriteObject(ctx, "objects", "video", &failingReader{data: data[:2500]}, options)
+	require.ErrorContains(t, err, "connection lost")
+	assert.Equal(t, 2, countEntryRecords(t, bucket, "objects"))
+	_, err = readObject(t, bucket, "video")
+	require.ErrorIs(t, err, ErrObjectCorrupted, "the upload is incomplete")
+
+	info, err := bucket.WriteObject(ctx, "objects", "video", bytes.NewReader(data), options)
+	require.NoError(t, err)
+	assert.Equal(t, 5, info.Chunks)
+	assert.Equal(t, 2, info.Resumed)
+	read, err := readObject(t, bucket, "video")
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	again, err := bucket.WriteObject(ctx, "objects", "video", bytes.NewReader(data), options)
+	require.NoError(t, err)
+	assert.Equal(t, info.Timestamp, again.Timestamp)
+	assert.Equal(t, 5, again.Resumed)
+
+	changed := bytes.Clone(data)
+	changed[1500] = '!'
+	_, err = bucket.WriteObject(ctx, "objects", "video", bytes.NewReader(changed), options)
+	require.ErrorIs(t, err, ErrObjectMismatch)
+}
+
+func TestReadObjectCorrupted(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	data := bytes.Repeat([]byte("chunk"), 200)
+	_, err := bucket.WriteObject(ctx, "objects", "a", bytes.NewReader(data), &ObjectOptions{Timestamp: 1, ChunkSize: 100})
+	require.NoError(t, err)
+	_, err = bucket.WriteObject(ctx, "objects", "b", bytes.NewReader(data), &ObjectOptions{Timestamp: 100, ChunkSize: 100})
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.RemoveRecord(ctx, "objects", 5))
+	_, err = readObject(t, bucket, "a")
+	require.ErrorIs(t, err, ErrObjectCorrupted)
+	require.ErrorContains(t, err, "chunk 5 found instead of chunk 4")
+
+	require.NoError(t, bucket.Update(ctx, "objects", 103, LabelMap{ChunkHashLabel: "0000"}))
+	_, err = readObject(t, bucket, "b")
+	require.ErrorIs(t, err, ErrObjectCorrupted)
+	require.ErrorContains(t, err, "chunk 3 does not match its hash")
+}
+
+func TestEncryptedObject(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	old, current := newEncryptionKey(t), newEncryptionKey(t)
+	data, err := io.ReadAll(&patternReader{remaining: 3500})
+	require.NoError(t, err)
+	options := &ObjectOptions{Timestamp: 1, ChunkSize: 1000}
+
+	bucket := newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "old", Keys: map[string][]byte{"old": old}},
+	})
+	_, err = bucket.WriteObject(ctx, "objects", "scan", &failingReader{data: data[:1500]}, options)
+	require.ErrorContains(t, err, "connection lost")
+
+	bucket = newEncryptedTestBucket(t, server, &EncryptionOptions{
+		Keys: StaticKeys{Current: "current", Keys: map[string][]byte{"old": old, "current": current}},
+	})
+	info, err := bucket.WriteObject(ctx, "objects", "scan", bytes.NewReader(data), options)
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Resumed, "a chunk of the old key is resumed")
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), info.SHA256)
+
+	first := mustReadEntry(t, bucket, "objects", 1).Labels()
+	chunkSum := sha256.Sum256(data[:1000])
+	assert.Equal(t, "old", first[KeyIDLabel])
+	assert.NotEqual(t, hex.EncodeToString(chunkSum[:]), first[ChunkHashLabel], "the hash is keyed")
+	last := mustReadEntry(t, bucket, "objects", 4).Labels()
+	assert.Equal(t, "current", last[KeyIDLabel])
+	assert.NotEqual(t, info.SHA256, last[ObjectHashLabel], "the hash is keyed")
+
+	read, err := readObject(t, bucket, "scan")
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	changed := bytes.Clone(data)
+	changed[10] = '!'
+	_, err = bucket.WriteObject(ctx, "objects", "scan", bytes.NewReader(changed), options)
+	require.ErrorIs(t, err, ErrObjectMismatch)
+
+	require.NoError(t, bucket.Update(ctx, "objects", 2, LabelMap{ChunkHashLabel: first[ChunkHashLabel]}))
+	_, err = readObject(t, bucket, "scan")
+	require.ErrorIs(t, err, ErrDecryptionFailed, "the hash labels are authenticated")
+
+	_, err = bucket.WriteObject(ctx, "objects", "other", bytes.NewReader(data[:10]), &ObjectOptions{Timestamp: 10})
+	require.NoError(t, err)
+	plain := newEncryptedTestBucket(t, server, nil)
+	_, err = plain.ReadObject(ctx, "objects", "other")
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func mustReadEntry(t *testing.T, bucket *Bucket, entry string, ts int64) *ReadableRecord {
+	t.Helper()
+	record, err := bucket.BeginMetadataRead(context.Background(), entry, &ts)
+	require.NoError(t, err)
+	return record
+}
@@ -2,10 +2,15 @@ package reductgo
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/reductstore/reduct-go/httpclient"
 	"github.com/reductstore/reduct-go/model"
@@ -15,7 +20,76 @@ type WriteOptions struct {
 	Timestamp   int64
 	ContentType string
 	Labels      LabelMap
-	Size        int64
+	// LabelsFrom is a struct with reduct tags (see MarshalLabels) or a
+	// map[string]string whose labels are written with Labels. Labels wins if
+	// both have a label.
+	LabelsFrom any
+	Size       int64
+	// Compression compresses the payload if set. An io.Reader payload is then
+	// read into memory before it is written.
+	Compression *CompressionOptions
+	// Encryption encrypts the payload after compression if set. It defaults to
+	// the encryption of the bucket, see ClientOptions.Encryption. An io.Reader
+	// payload is then read into memory before it is written.
+	Encryption *EncryptionOptions
+	// OnConflict decides what the write does if the entry already has a record
+	// with the timestamp. ConflictShift and ConflictOverwrite need to send the
+	// payload again, so they do not apply to an io.Reader that is not an
+	// io.Seeker. Defaults to ConflictFail.
+	OnConflict ConflictPolicy
+	// Dedup skips or labels the record if the entry has one with the same
+	// payload, see DedupOptions and WritableRecord.DuplicateOf. An io.Reader
+	// payload is then read into memory before it is written.
+	Dedup *DedupOptions
+}
+
+// labels returns Labels together with the labels of LabelsFrom.
+func (o *WriteOptions) labels() (LabelMap, error) {
+	from, err := labelsOf(o.LabelsFrom)
+	if err != nil || len(from) == 0 {
+		return o.Labels, err
+	}
+	labels := make(LabelMap, len(from)+len(o.Labels))
+	maps.Copy(labels, from)
+	maps.Copy(labels, o.Labels)
+	return labels, nil
+}
+
+// commitCheckTimeout bounds the request that checks whether a canceled write
+// was committed.
+const commitCheckTimeout = 5 * time.Second
+
+// WriteCanceledError is returned when the context of a write is canceled or
+// its deadline passes before the server answers. It unwraps to the context
+// error, so errors.Is(err, context.Canceled) works.
+type WriteCanceledError struct {
+	Bucket    string
+	Entry     string
+	Timestamp int64
+	// Committed reports that the server has the record although the write was
+	// canceled: the upload completed before the cancellation took effect. A
+	// record at the timestamp with another size or other labels, e.g. one that
+	// was there before, is not the written one.
+	Committed bool
+	// CheckErr is the error of the request that checks whether the record was
+	// committed. If it is set, Committed is false but the record may exist.
+	CheckErr error
+	Err      error
+}
+
+func (e *WriteCanceledError) Error() string {
+	state := "not committed"
+	switch {
+	case e.Committed:
+		state = "committed"
+	case e.CheckErr != nil:
+		state = fmt.Sprintf("commit unknown: %v", e.CheckErr)
+	}
+	return fmt.Sprintf("write of record %d to %s/%s canceled (%s): %v", e.Timestamp, e.Bucket, e.Entry, state, e.Err)
+}
+
+func (e *WriteCanceledError) Unwrap() error {
+	return e.Err
 }
 
 type WritableRecord struct {
@@ -23,6 +97,14 @@ type WritableRecord struct {
 	entryName  string
 	httpClient httpclient.HTTPClient
 	options    WriteOptions
+	// ctx is the context of Bucket.BeginWrite, used by Write.
+	ctx context.Context
+	// timestamps learns the timestamps of records moved by ConflictShift.
+	timestamps *TimestampAllocator
+	// duplicateOf is the timestamp of the record with the same payload if
+	// duplicate is set by a deduplicated write.
+	duplicateOf int64
+	duplicate   bool
 }
 
 func NewWritableRecord(bucketName string,
@@ -35,15 +117,23 @@ func NewWritableRecord(bucketName string,
 		entryName:  entryName,
 		httpClient: httpClient,
 		options:    options,
+		ctx:        context.Background(),
 	}
 }
 
-// Write writes the record to the bucket.
+// Write writes the record to the bucket with the context of Bucket.BeginWrite.
 //
 // data can be a string, []byte, or io.Reader.
 // size is the size of the data to write.
 // if size is not provided, it will be calculated from the data.
 func (w *WritableRecord) Write(data any) error {
+	return w.WriteContext(w.ctx, data)
+}
+
+// WriteContext writes the record to the bucket like Write. The upload stops
+// when ctx is canceled or its deadline passes; the write then fails with a
+// *WriteCanceledError that tells whether the server committed the record.
+func (w *WritableRecord) WriteContext(ctx context.Context, data any) error {
 	if w.options.Timestamp == 0 {
 		return fmt.Errorf("timestamp must be set")
 	}
@@ -53,15 +143,14 @@ func (w *WritableRecord) Write(data any) error {
 
 	var reader io.Reader
 	var contentLength int64
-	var err error
+	// payload is the data if it is in memory, so that it can be sent again.
+	var payload []byte
 
 	switch v := data.(type) {
 	case string:
-		reader = bytes.NewBufferString(v)
-		contentLength = int64(len(v))
+		payload = []byte(v)
 	case []byte:
-		reader = bytes.NewReader(v)
-		contentLength = int64(len(v))
+		payload = v
 	case io.Reader:
 		reader = v
 		if w.options.Size != 0 {
@@ -71,9 +160,107 @@ func (w *WritableRecord) Write(data any) error {
 		return fmt.Errorf("unsupported data type")
 	}
 
-	url := fmt.Sprintf("/b/%s/%s?ts=%d", w.bucketName, w.entryName, w.options.Timestamp)
+	labels, err := w.options.labels()
+	if err != nil {
+		return err
+	}
+	var hash string
+	if dedup := w.options.Dedup; dedup != nil {
+		if reader != nil {
+			if payload, err = w.readAll(reader); err != nil {
+				return err
+			}
+			reader = nil
+		}
+		if labels, w.options.Encryption, err = withDedupHash(labels, payload, w.options.Encryption); err != nil {
+			return err
+		}
+		hash, _ = labels[ContentHashLabel].(string)
+		w.duplicateOf, w.duplicate = dedup.index().Lookup(w.bucketName, w.entryName, hash)
+		if w.duplicate {
+			if dedup.Mode == DedupSkip {
+				return nil
+			}
+			labels[DuplicateOfLabel] = strconv.FormatInt(w.duplicateOf, 10)
+		}
+	}
+	if w.options.Compression != nil || w.options.Encryption != nil {
+		if reader != nil {
+			if payload, err = w.readAll(reader); err != nil {
+				return err
+			}
+		}
+		if payload, labels, err = w.encode(payload, labels); err != nil {
+			return err
+		}
+	}
+
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{
+		Name:   "WritableRecord.Write",
+		Bucket: w.bucketName,
+		Entry:  w.entryName,
+	})
+	if err = w.write(ctx, reader, contentLength, payload, labels); err != nil {
+		return err
+	}
+	if hash != "" && !w.duplicate {
+		if err = w.options.Dedup.index().Add(w.bucketName, w.entryName, hash, w.options.Timestamp); err != nil {
+			httpclient.LoggerOf(w.httpClient).WarnContext(ctx, "failed to add record to dedup index",
+				"bucket", w.bucketName, "entry", w.entryName, "ts", w.options.Timestamp, "error", err)
+		}
+	}
+	return nil
+}
+
+// write sends the record, again according to the conflict policy if the
+// entry has one with the timestamp. payload is the data if it is in memory.
+func (w *WritableRecord) write(ctx context.Context, reader io.Reader, contentLength int64, payload []byte, labels LabelMap) error {
+	var err error
+	start, seekable := int64(0), false
+	if seeker, ok := reader.(io.Seeker); ok && payload == nil {
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		seekable = err == nil
+	}
+	for attempt := 0; ; attempt++ {
+		if payload != nil {
+			reader, contentLength = bytes.NewReader(payload), int64(len(payload))
+		} else if attempt > 0 {
+			if _, err = reader.(io.Seeker).Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		err = w.send(ctx, reader, contentLength, labels)
+		if !errors.Is(err, model.ErrConflict) || (payload == nil && !seekable) {
+			return err
+		}
+
+		switch w.options.OnConflict {
+		case ConflictShift:
+			if attempt == maxConflictShifts {
+				return err
+			}
+			w.options.Timestamp++
+			w.timestamps.Observe(w.entryName, w.options.Timestamp)
+		case ConflictOverwrite:
+			if attempt > 0 {
+				return err
+			}
+			if err = newBucket(w.bucketName, w.httpClient).RemoveRecord(ctx, w.entryName, w.options.Timestamp); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
 
-	req, err := w.httpClient.NewRequest("POST", url, reader)
+// send sends the record in one request.
+func (w *WritableRecord) send(ctx context.Context, reader io.Reader, contentLength int64, labels LabelMap) error {
+	if err := ctx.Err(); err != nil {
+		return &WriteCanceledError{Bucket: w.bucketName, Entry: w.entryName, Timestamp: w.options.Timestamp, Err: err}
+	}
+	url := fmt.Sprintf("/b/%s/%s?ts=%d", w.bucketName, w.entryName, w.options.Timestamp)
+	req, err := w.httpClient.NewRequestWithContext(ctx, http.MethodPost, url, reader)
 	if err != nil {
 		return err
 	}
@@ -82,32 +269,126 @@ func (w *WritableRecord) Write(data any) error {
 	req.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
 
 	// Custom label headers
-	for k, v := range w.options.Labels {
-		req.Header.Set(fmt.Sprintf("x-reduct-label-%s", k), fmt.Sprint(v))
+	if err = setLabelHeaders(req.Header, labels); err != nil {
+		return err
 	}
 
 	resp, err := w.httpClient.Do(req)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return w.canceled(ctx, ctxErr, contentLength, labels)
+		}
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		return model.APIError{
-			Status:  resp.StatusCode,
-			Message: resp.Header.Get("x-reduct-error"),
-		}
+	observeWritten(httpclient.MetricsOf(w.httpClient), "WritableRecord.Write", contentLength)
+	return nil
+}
+
+// Timestamp returns the timestamp of the record. After a write with
+// ConflictShift, it is the timestamp the record was written with.
+func (w *WritableRecord) Timestamp() int64 {
+	return w.options.Timestamp
+}
+
+// DuplicateOf returns the timestamp of the record of the entry with the same
+// payload if a write with WriteOptions.Dedup found one. With DedupSkip the
+// record was then not written.
+func (w *WritableRecord) DuplicateOf() (int64, bool) {
+	return w.duplicateOf, w.duplicate
+}
+
+// readAll reads an io.Reader payload into memory, up to the size of the options.
+func (w *WritableRecord) readAll(reader io.Reader) ([]byte, error) {
+	if w.options.Size != 0 {
+		reader = io.LimitReader(reader, w.options.Size)
 	}
+	return io.ReadAll(reader)
+}
 
-	return nil
+// encode compresses and encrypts the payload with the options of the record.
+func (w *WritableRecord) encode(data []byte, labels LabelMap) ([]byte, LabelMap, error) {
+	data, labels, err := w.options.Compression.compress(data, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.options.Encryption.encrypt(data, labels)
+}
+
+// canceled builds the error of a write that was canceled after the request
+// was sent. It asks the server whether it has the record with the size and
+// labels of the write, ignoring the cancellation of ctx.
+func (w *WritableRecord) canceled(ctx context.Context, err error, contentLength int64, labels LabelMap) error {
+	canceled := &WriteCanceledError{
+		Bucket:    w.bucketName,
+		Entry:     w.entryName,
+		Timestamp: w.options.Timestamp,
+		Err:       err,
+	}
+
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitCheckTimeout)
+	defer cancel()
+	checkCtx = httpclient.WithOperation(checkCtx, httpclient.Operation{
+		Name:   "WritableRecord.CheckCommit",
+		Bucket: w.bucketName,
+		Entry:  w.entryName,
+	})
+	url := fmt.Sprintf("/b/%s/%s?ts=%d", w.bucketName, w.entryName, w.options.Timestamp)
+	req, checkErr := w.httpClient.NewRequestWithContext(checkCtx, http.MethodHead, url, nil)
+	if checkErr != nil {
+		canceled.CheckErr = checkErr
+		return canceled
+	}
+	resp, checkErr := w.httpClient.Do(req)
+	switch {
+	case checkErr == nil:
+		resp.Body.Close()
+		canceled.Committed, canceled.CheckErr = sameRecord(resp.Header, contentLength, labels)
+	case !errors.Is(checkErr, model.ErrNotFound):
+		canceled.CheckErr = checkErr
+	}
+	return canceled
+}
+
+// sameRecord reports whether the headers of a stored record have the size and
+// the labels of a written one.
+func sameRecord(header http.Header, contentLength int64, labels LabelMap) (bool, error) {
+	if header.Get("Content-Length") != strconv.FormatInt(contentLength, 10) {
+		return false, nil
+	}
+	formatted, err := FormatLabels(labels)
+	if err != nil {
+		return false, err
+	}
+	stored := map[string]string{}
+	for key, values := range header {
+		if name, ok := strings.CutPrefix(strings.ToLower(key), "x-reduct-label-"); ok && len(values) > 0 {
+			stored[name] = values[0]
+		}
+	}
+	for name, value := range formatted {
+		if stored[strings.ToLower(name)] != value {
+			return false, nil
+		}
+	}
+	return len(stored) == len(formatted), nil
 }
 
 type ReadableRecord struct {
-	time        int64
+	time int64
+	// size is the stored size and logicalSize the size after decryption and
+	// decompression, -1 if it is unknown.
 	size        int64
+	logicalSize int64
 	last        bool
 	lastInBatch bool
 	stream      io.Reader
+	// raw is the stream as stored, before decryption and decompression, and
+	// decoded is set if stream differs from it; logicalSize then comes from
+	// the labels of the record.
+	raw         io.Reader
+	decoded     bool
 	labels      LabelMap
 	contentType string
 	entry       string
@@ -120,15 +401,48 @@ func NewReadableRecord(entry string,
 	stream io.Reader,
 	labels LabelMap,
 	contentType string,
+) *ReadableRecord {
+	return newReadableRecord(entry, time, size, last, stream, labels, contentType, nil, false)
+}
+
+// newReadableRecord creates a record whose stream is decrypted with the keys
+// and decompressed according to its reserved labels, unless the record is
+// metadata-only (head) and has no payload.
+func newReadableRecord(entry string,
+	time int64,
+	size int64,
+	last bool,
+	stream io.Reader,
+	labels LabelMap,
+	contentType string,
+	keys KeyProvider,
+	head bool,
 ) *ReadableRecord {
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
+	raw := stream
+	logicalSize := size
+	if encrypted, plaintext := encryptionOf(labels); encrypted != "" {
+		if !head {
+			stream = &decryptReader{src: stream, keys: keys, labels: labels}
+		}
+		logicalSize = plaintext
+	}
+	if algorithm, uncompressed := compressionOf(labels); algorithm != "" {
+		if !head {
+			stream = &decompressReader{src: stream, algorithm: algorithm}
+		}
+		logicalSize = uncompressed
+	}
 	return &ReadableRecord{
 		time:        time,
 		size:        size,
+		logicalSize: logicalSize,
 		last:        last,
 		stream:      stream,
+		raw:         raw,
+		decoded:     stream != raw,
 		labels:      labels,
 		contentType: contentType,
 		entry:       entry,
@@ -153,7 +467,37 @@ func (r *ReadableRecord) Read() ([]byte, error) {
 			Message: "stream is nil, nothing to read",
 		}
 	}
-	return readStream(r.stream, r.size)
+	return r.readAll()
+}
+
+// maxSizeHint bounds the buffer allocated up front for a decoded payload. Its
+// size comes from labels, which anyone who can write to the entry can forge.
+const maxSizeHint = 16 << 20
+
+// readAll drains the stream of the record. The size of a decoded payload is
+// only a hint for the buffer, and the payload must have it.
+func (r *ReadableRecord) readAll() ([]byte, error) {
+	if !r.decoded {
+		return readStream(r.stream, r.size)
+	}
+	if r.logicalSize < 0 {
+		return io.ReadAll(r.stream)
+	}
+	hint := min(r.logicalSize, maxSizeHint)
+	if algorithm, _ := compressionOf(r.labels); algorithm == "" {
+		// A plaintext is not larger than its ciphertext.
+		hint = max(min(hint, r.size), 0)
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, hint))
+	// Stop decoding a payload that grows past its size.
+	if _, err := buffer.ReadFrom(io.LimitReader(r.stream, r.logicalSize+1)); err != nil {
+		return nil, err
+	}
+	if int64(buffer.Len()) != r.logicalSize {
+		return nil, fmt.Errorf("record %d of %s decodes to %d bytes, its labels say %d",
+			r.time, r.entry, buffer.Len(), r.logicalSize)
+	}
+	return buffer.Bytes(), nil
 }
 
 // readStream drains a record body. The record size is known up front, so the
@@ -177,14 +521,15 @@ func readStream(stream io.Reader, size int64) ([]byte, error) {
 //
 // use this to read the record at once.
 func (r *ReadableRecord) ReadAsString() (string, error) {
-	data, err := readStream(r.stream, r.size)
+	data, err := r.readAll()
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
 
-// Stream returns the stream of the record.
+// Stream returns the stream of the record, decrypted and decompressed if the
+// record is encrypted or compressed.
 //
 // use this to read the record in a stream.
 func (r *ReadableRecord) Stream() io.Reader {
@@ -211,16 +556,47 @@ func (r *ReadableRecord) SetLastInBatch(last bool) {
 	r.lastInBatch = last
 }
 
-// Size returns the size of the record.
+// Size returns the size of the record payload as returned by Read. For a
+// compressed or encrypted record, it is the size before compression and
+// encryption, see StoredSize.
 func (r *ReadableRecord) Size() int64 {
+	if r.logicalSize < 0 {
+		return r.size
+	}
+	return r.logicalSize
+}
+
+// StoredSize returns the size of the record payload stored on the server,
+// which differs from Size for a compressed or encrypted record.
+func (r *ReadableRecord) StoredSize() int64 {
 	return r.size
 }
 
+// Compression returns the algorithm the payload is stored with, or "" if it
+// is not compressed. Read and Stream return the decompressed payload.
+func (r *ReadableRecord) Compression() Compression {
+	algorithm, _ := compressionOf(r.labels)
+	return algorithm
+}
+
+// Encryption returns the algorithm the payload is encrypted with, or "" if it
+// is not encrypted. Read and Stream return the decrypted payload.
+func (r *ReadableRecord) Encryption() string {
+	algorithm, _ := encryptionOf(r.labels)
+	return algorithm
+}
+
 // Labels returns the labels of the record.
 func (r *ReadableRecord) Labels() LabelMap {
 	return r.labels
 }
 
+// TypedLabels returns the labels with the values in the schema decoded to
+// their Go types, see LabelSchema.
+func (r *ReadableRecord) TypedLabels(schema LabelSchema) (LabelMap, error) {
+	return schema.Decode(r.labels)
+}
+
 // ContentType returns the content type of the record.
 func (r *ReadableRecord) ContentType() string {
 	return r.contentType
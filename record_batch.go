@@ -2,9 +2,12 @@ package reductgo
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"slices"
 	"strconv"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/reductstore/reduct-go/httpclient"
 	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
 )
 
 const (
@@ -27,6 +31,14 @@ const (
 // RecordBatchErrorMap represents errors per entry and timestamp.
 type RecordBatchErrorMap map[string]ErrorMap
 
+// add adds the error of a record.
+func (m RecordBatchErrorMap) add(entry string, ts int64, err model.APIError) {
+	if m[entry] == nil {
+		m[entry] = ErrorMap{}
+	}
+	m[entry][ts] = err
+}
+
 type recordBatchKey struct {
 	entry string
 	ts    int64
@@ -38,6 +50,26 @@ type recordBatchRecord struct {
 	data        []byte
 	contentType string
 	labels      LabelMap
+	// addErr is the error of encoding the labels or encoding the data in
+	// Add, reported by Send.
+	addErr error
+	// stream is the payload of a record added with AddReader instead of data.
+	stream *streamedPayload
+	// encodedLabels are the labels encoded for the batch headers, set by Send.
+	encodedLabels map[string]string
+}
+
+// asRecord converts the record for a Batch of its entry.
+func (r *recordBatchRecord) asRecord() *Record {
+	return &Record{Data: r.data, ContentType: r.contentType, Labels: r.labels, addErr: r.addErr, stream: r.stream}
+}
+
+// size returns the size of the payload, the declared one if it is streamed.
+func (r *recordBatchRecord) size() int64 {
+	if r.stream != nil {
+		return r.stream.size
+	}
+	return int64(len(r.data))
 }
 
 type recordBatchMeta struct {
@@ -48,6 +80,7 @@ type recordBatchMeta struct {
 type recordBatchRequest struct {
 	headers       http.Header
 	body          io.Reader
+	getBody       func() (io.ReadCloser, error)
 	entries       []string
 	startTS       int64
 	contentLength int64
@@ -67,51 +100,199 @@ type RecordBatch struct {
 	records    map[recordBatchKey]*recordBatchRecord
 	totalSize  int64
 	lastAccess time.Time
-	mu         sync.Mutex
+	// compression compresses and encryption encrypts the data of the records
+	// added to a write batch.
+	compression *CompressionOptions
+	encryption  *EncryptionOptions
+	// timestamps allocates the timestamps of AddNow and learns the others.
+	timestamps *TimestampAllocator
+	// conflict is the ConflictPolicy of a write batch, duplicates are the
+	// records dropped by ConflictFail, and shifted maps the timestamps of
+	// records moved by ConflictShift to their new ones.
+	conflict   ConflictPolicy
+	duplicates map[recordBatchKey]bool
+	shifted    map[string]map[int64]int64
+	// spool keeps the records of a write batch that fails because the server
+	// is unreachable.
+	spool *Spool
+	// dedup deduplicates the records of a write batch, and deduplicated maps
+	// the timestamps of duplicates to the ones of their originals by entry.
+	dedup        *DedupOptions
+	deduplicated map[string]map[int64]int64
+	mu           sync.Mutex
 }
 
 // newRecordBatch creates a new record batch.
 func newRecordBatch(bucket string, client httpclient.HTTPClient, batchType BatchType) *RecordBatch {
 	return &RecordBatch{
-		bucketName: bucket,
-		httpClient: client,
-		batchType:  batchType,
-		records:    make(map[recordBatchKey]*recordBatchRecord),
-		totalSize:  0,
-		lastAccess: time.Time{},
-		mu:         sync.Mutex{},
+		bucketName:   bucket,
+		httpClient:   client,
+		batchType:    batchType,
+		records:      make(map[recordBatchKey]*recordBatchRecord),
+		totalSize:    0,
+		lastAccess:   time.Time{},
+		duplicates:   map[recordBatchKey]bool{},
+		shifted:      map[string]map[int64]int64{},
+		deduplicated: map[string]map[int64]int64{},
+		mu:           sync.Mutex{},
 	}
 }
 
 // Add adds a record to the batch with entry name.
-func (b *RecordBatch) Add(entry string, ts int64, data []byte, contentType string, labels LabelMap) {
+// labels is a LabelMap, a map[string]string or a struct with reduct tags (see MarshalLabels).
+// The data is compressed and encrypted if set, see SetCompression and SetEncryption.
+// A record with labels that cannot be encoded is reported in the RecordBatchErrorMap of Send.
+// A record at the timestamp of another record of the entry in a write batch is
+// handled by the conflict policy, see SetConflictPolicy. Duplicated payloads
+// are skipped or labeled by Send if set, see SetDedup.
+func (b *RecordBatch) Add(entry string, ts int64, data []byte, contentType string, labels any) {
+	b.add(entry, ts, data, nil, contentType, labels)
+}
+
+// AddNow adds a record to the entry at its next timestamp, see
+// TimestampAllocator, and returns the timestamp. The other arguments are
+// given like in Add.
+func (b *RecordBatch) AddNow(entry string, data []byte, contentType string, labels any) int64 {
+	ts := b.timestamps.Next(entry)
+	b.Add(entry, ts, data, contentType, labels)
+	return ts
+}
+
+// AddReader adds a record whose payload of the given size is read from reader
+// when the batch is sent, like Batch.AddReader. A batch with streamed records
+// cannot be sent again, and SendWithRetry does not resend them.
+func (b *RecordBatch) AddReader(entry string, ts int64, reader io.Reader, size int64, contentType string, labels any) {
+	b.add(entry, ts, nil, &streamedPayload{reader: reader, size: size}, contentType, labels)
+}
+
+// add adds a record and returns the key it was queued at; queued is false
+// if the record was dropped.
+func (b *RecordBatch) add(entry string, ts int64, data []byte, stream *streamedPayload, contentType string, labels any) (key recordBatchKey, queued bool) {
 	if entry == "" {
-		return
+		return key, false
 	}
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
-	if labels == nil {
-		labels = LabelMap{}
+	labelMap, addErr := labelsOf(labels)
+	if labelMap == nil {
+		labelMap = LabelMap{}
 	}
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.totalSize += int64(len(data))
-	b.lastAccess = time.Now().UTC()
-	key := recordBatchKey{entry: entry, ts: ts}
-	b.records[key] = &recordBatchRecord{
+	key = recordBatchKey{entry: entry, ts: ts}
+	if existing := b.records[key]; existing != nil && b.batchType == BatchWrite {
+		switch b.conflict {
+		case ConflictFail:
+			b.duplicates[key] = true
+			return key, false
+		case ConflictShift:
+			for b.records[key] != nil {
+				key.ts++
+			}
+			if b.shifted[entry] == nil {
+				b.shifted[entry] = map[int64]int64{}
+			}
+			b.shifted[entry][ts] = key.ts
+			ts = key.ts
+		case ConflictOverwrite:
+			b.totalSize -= existing.size()
+		}
+	}
+	if b.batchType == BatchWrite {
+		b.timestamps.Observe(entry, ts)
+	}
+
+	if addErr == nil && b.batchType == BatchWrite {
+		encryption := b.encryption
+		if b.dedup != nil && stream == nil {
+			labelMap, encryption, addErr = withDedupHash(labelMap, data, encryption)
+		}
+		if addErr == nil {
+			data, stream, labelMap, addErr = encodePayload(data, stream, labelMap, b.compression, encryption)
+		}
+	}
+
+	record := &recordBatchRecord{
 		entry:       entry,
 		timestamp:   ts,
 		data:        data,
 		contentType: contentType,
-		labels:      labels,
+		labels:      labelMap,
+		addErr:      addErr,
+		stream:      stream,
+	}
+	b.totalSize += record.size()
+	b.lastAccess = time.Now().UTC()
+	b.records[key] = record
+	return key, true
+}
+
+// SetConflictPolicy sets what a write batch does with a record added at the
+// timestamp of another one of the entry, and with records the entries already
+// have when it is sent (HTTP 409). Defaults to ConflictFail.
+func (b *RecordBatch) SetConflictPolicy(policy ConflictPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conflict = policy
+}
+
+// Shifted maps the timestamps of records moved by ConflictShift to the ones
+// they were written with, by entry, like Batch.Shifted.
+func (b *RecordBatch) Shifted() map[string]map[int64]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	shifted := make(map[string]map[int64]int64, len(b.shifted))
+	for entry, timestamps := range b.shifted {
+		shifted[entry] = maps.Clone(timestamps)
 	}
+	return shifted
 }
 
-// AddOnlyLabels adds an empty record with only labels for update/remove operations.
-func (b *RecordBatch) AddOnlyLabels(entry string, ts int64, labels LabelMap) {
+// SetDedup deduplicates the records added after the call with the options,
+// or stops deduplicating if options is nil. It only applies to write batches.
+func (b *RecordBatch) SetDedup(options *DedupOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dedup = options
+}
+
+// Deduplicated maps the timestamps of the records found to be duplicates by
+// Send to the timestamps of the records with the same payload, by entry, like
+// Batch.Deduplicated.
+func (b *RecordBatch) Deduplicated() map[string]map[int64]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	deduplicated := make(map[string]map[int64]int64, len(b.deduplicated))
+	for entry, timestamps := range b.deduplicated {
+		deduplicated[entry] = maps.Clone(timestamps)
+	}
+	return deduplicated
+}
+
+// SetCompression compresses the data of the records added after the call
+// with the options, or stops compressing if options is nil. It only applies to
+// write batches.
+func (b *RecordBatch) SetCompression(options *CompressionOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.compression = options
+}
+
+// SetEncryption encrypts the data of the records added after the call with
+// the options, or stops encrypting if options is nil. Batches begun on a
+// bucket are encrypted like the bucket. It only applies to write batches.
+func (b *RecordBatch) SetEncryption(options *EncryptionOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.encryption = options
+}
+
+// AddOnlyLabels adds an empty record with only labels for update/remove operations,
+// given like in Add.
+func (b *RecordBatch) AddOnlyLabels(entry string, ts int64, labels any) {
 	b.Add(entry, ts, nil, "", labels)
 }
 
@@ -121,73 +302,279 @@ func (b *RecordBatch) AddOnlyTimestamp(entry string, ts int64) {
 }
 
 // Send sends the batch to the server using Batch Protocol v2.
+// A batch that exceeds the batch limits of the client is sent in several requests, ordered by entry and timestamp,
+// and their errors are merged. If a request fails, Send returns its error together with the errors of the requests
+// sent before; the records of the failed request and the ones after it are not sent. With a spool, see
+// ClientOptions.Spool, they are spooled instead if the server is unreachable.
 func (b *RecordBatch) Send(ctx context.Context) (RecordBatchErrorMap, error) {
+	errs, _, err := b.send(ctx)
+	return errs, err
+}
+
+// send is Send that also returns the records that were not sent if it fails:
+// the ones of the failed request and the ones after it, with the duplicates
+// skipped for them.
+func (b *RecordBatch) send(ctx context.Context) (errs RecordBatchErrorMap, unsent map[recordBatchKey]bool, err error) {
 	b.mu.Lock()
 	items := make([]*recordBatchRecord, 0, len(b.records))
 	for _, record := range b.records {
 		items = append(items, record)
 	}
+	size := b.totalSize
+	duplicates := maps.Clone(b.duplicates)
+	policy := b.conflict
+	spool := b.spool
+	dedup := b.dedup
 	b.mu.Unlock()
+	sortRecordBatchItems(items)
+	recordCount := len(items)
+	var deduped *dedupBatch
+	if dedup != nil && b.batchType == BatchWrite {
+		deduped = dedup.dedup(b.bucketName, items)
+		items = deduped.written
+	}
 
-	switch b.batchType {
-	case BatchWrite:
-		reqData := buildRecordBatchWriteRequest(items)
+	ctx = httpclient.WithOperation(ctx, httpclient.Operation{Name: "RecordBatch.Send", Bucket: b.bucketName})
+	ctx, span := httpclient.TracerOf(b.httpClient).Start(ctx, "RecordBatch.Send",
+		telemetry.String(telemetry.AttrBucket, b.bucketName),
+		telemetry.Int(telemetry.AttrRecordCount, recordCount),
+		telemetry.Int64(telemetry.AttrBytes, size))
+	defer func() { endSpan(span, err) }()
 
-		path := fmt.Sprintf("/io/%s/write", b.bucketName)
-		req, err := b.httpClient.NewRequestWithContext(ctx, http.MethodPost, path, reqData.body)
+	metrics := httpclient.MetricsOf(b.httpClient)
+	defer func() {
 		if err != nil {
-			return nil, err
+			return
 		}
-		req.Header = reqData.headers
-		req.Header.Set("Content-Type", "application/octet-stream")
-		req.ContentLength = reqData.contentLength
+		failed := 0
+		for _, entryErrs := range errs {
+			observeRecordErrors(metrics, "RecordBatch.Send", entryErrs)
+			failed += len(entryErrs)
+		}
+		if failed > 0 {
+			httpclient.LoggerOf(b.httpClient).WarnContext(ctx, "batch partially failed",
+				"bucket", b.bucketName, "failed", failed, "records", recordCount)
+		}
+	}()
 
-		resp, err := b.httpClient.Do(req)
-		if err != nil {
-			return nil, err
+	if b.batchType != BatchWrite && b.batchType != BatchUpdate && b.batchType != BatchRemove {
+		return nil, unsentRecords([][]*recordBatchRecord{items}, deduped), fmt.Errorf("invalid batch type")
+	}
+
+	errs = RecordBatchErrorMap{}
+	encoded := make([]*recordBatchRecord, 0, len(items))
+	for _, record := range items {
+		labels, labelErr := normalizeLabels(record.labels)
+		if record.addErr != nil {
+			labelErr = record.addErr
+		}
+		if labelErr != nil {
+			errs.add(record.entry, record.timestamp, labelRecordError(labelErr))
+			continue
 		}
-		defer resp.Body.Close()
+		copied := *record
+		copied.encodedLabels = labels
+		encoded = append(encoded, &copied)
+	}
+	limits := httpclient.BatchLimitsOf(b.httpClient)
+	chunks := splitBatch(encoded, limits, func(record *recordBatchRecord) int64 { return record.size() })
+	if len(encoded) == 0 && recordCount > 0 {
+		// All records were skipped or rejected; the duplicates are still reported.
+		chunks = nil
+	}
+	for i, chunk := range chunks {
+		if b.batchType == BatchWrite && spool.pending() {
+			// Records are written after the spooled ones.
+			if err = spool.spoolChunks(ctx, b.bucketName, chunks[i:], nil, errs); err != nil {
+				return errs, unsentRecords(chunks[i:], deduped), err
+			}
+			break
+		}
+		if err = b.sendChunk(ctx, span, limits, chunk, errs); err != nil {
+			if b.batchType != BatchWrite || spool == nil || !spoolable(err) {
+				return errs, unsentRecords(chunks[i:], deduped), err
+			}
+			if spoolErr := spool.spoolChunks(ctx, b.bucketName, chunks[i:], err, errs); spoolErr != nil {
+				return errs, unsentRecords(chunks[i:], deduped), errors.Join(err, spoolErr)
+			}
+			err = nil
+			break
+		}
+	}
+	shifted := map[string]map[int64]int64{}
+	if b.batchType == BatchWrite && policy != ConflictFail {
+		if err = b.resolveConflicts(ctx, policy, items, errs, shifted); err != nil {
+			return errs, unresolvedConflicts(errs), err
+		}
+	}
+	b.finishDedup(ctx, deduped, errs, shifted)
+	for key := range duplicates {
+		if _, failed := errs[key.entry][key.ts]; !failed {
+			errs.add(key.entry, key.ts, duplicateTimestampError())
+		}
+	}
+	maps.DeleteFunc(errs, func(_ string, entryErrs ErrorMap) bool { return len(entryErrs) == 0 })
+	return errs, nil, nil
+}
 
-		return parseRecordBatchErrors(resp.Header, reqData.entries, reqData.startTS)
-	case BatchUpdate:
-		reqData := buildRecordBatchUpdateRequest(items)
+// unsentRecords returns the keys of the records of the chunks and of the
+// duplicates skipped for them by dedup.
+func unsentRecords(chunks [][]*recordBatchRecord, deduped *dedupBatch) map[recordBatchKey]bool {
+	unsent := map[recordBatchKey]bool{}
+	for _, chunk := range chunks {
+		for _, rec := range chunk {
+			unsent[recordBatchKey{entry: rec.entry, ts: rec.timestamp}] = true
+		}
+	}
+	if deduped != nil {
+		for duplicate, original := range deduped.originals {
+			if unsent[original] {
+				unsent[duplicate] = true
+			}
+		}
+	}
+	return unsent
+}
 
-		path := fmt.Sprintf("/io/%s/update", b.bucketName)
-		req, err := b.httpClient.NewRequestWithContext(ctx, http.MethodPatch, path, nil)
-		if err != nil {
-			return nil, err
+// unresolvedConflicts returns the keys of the records that still fail with
+// 409 when resolving the conflicts failed.
+func unresolvedConflicts(errs RecordBatchErrorMap) map[recordBatchKey]bool {
+	unsent := map[recordBatchKey]bool{}
+	for entry, entryErrs := range errs {
+		for ts, recordErr := range entryErrs {
+			if isConflict(recordErr) {
+				unsent[recordBatchKey{entry: entry, ts: ts}] = true
+			}
 		}
-		req.Header = reqData.headers
-		req.ContentLength = 0
+	}
+	return unsent
+}
 
-		resp, err := b.httpClient.Do(req)
-		if err != nil {
-			return nil, err
+// resolveConflicts sends the records that failed with 409 again according to the policy,
+// and adds the records moved by ConflictShift to shifted.
+func (b *RecordBatch) resolveConflicts(ctx context.Context, policy ConflictPolicy, items []*recordBatchRecord,
+	errs RecordBatchErrorMap, shifted map[string]map[int64]int64,
+) error {
+	conflicts := map[recordBatchKey]*recordBatchRecord{}
+	occupied := map[recordBatchKey]bool{}
+	for _, record := range items {
+		key := recordBatchKey{entry: record.entry, ts: record.timestamp}
+		occupied[key] = true
+		if recordErr, failed := errs[key.entry][key.ts]; failed && isConflict(recordErr) {
+			conflicts[key] = record
 		}
-		defer resp.Body.Close()
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
 
-		return parseRecordBatchErrors(resp.Header, reqData.entries, reqData.startTS)
-	case BatchRemove:
-		reqData := buildRecordBatchRemoveRequest(items)
+	send := func(batchType BatchType) func(context.Context, map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error) {
+		return func(ctx context.Context, records map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error) {
+			batch := newRecordBatch(b.bucketName, b.httpClient, batchType)
+			for key, rec := range records {
+				if batchType == BatchRemove {
+					batch.AddOnlyTimestamp(key.entry, key.ts)
+				} else {
+					batch.records[key] = rec
+				}
+			}
+			return batch.Send(ctx)
+		}
+	}
+	err := resolveConflicts(ctx, policy, b.timestamps, conflicts,
+		func(key recordBatchKey) bool { return occupied[key] },
+		send(BatchWrite), send(BatchRemove), errs, shifted)
 
-		path := fmt.Sprintf("/io/%s/remove", b.bucketName)
-		req, err := b.httpClient.NewRequestWithContext(ctx, http.MethodDelete, path, nil)
-		if err != nil {
-			return nil, err
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for entry, timestamps := range shifted {
+		if b.shifted[entry] == nil {
+			b.shifted[entry] = map[int64]int64{}
 		}
-		req.Header = reqData.headers
-		req.ContentLength = 0
+		maps.Copy(b.shifted[entry], timestamps)
+	}
+	return err
+}
 
-		resp, err := b.httpClient.Do(req)
-		if err != nil {
-			return nil, err
+// finishDedup updates the index with the written records and adds the
+// duplicates to Deduplicated, see dedupBatch.finish. It does nothing without
+// deduplication.
+func (b *RecordBatch) finishDedup(ctx context.Context, deduped *dedupBatch, errs RecordBatchErrorMap, shifted map[string]map[int64]int64) {
+	if deduped == nil {
+		return
+	}
+	deduplicated := deduped.finish(ctx, b.httpClient, errs, shifted)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for entry, timestamps := range deduplicated {
+		if b.deduplicated[entry] == nil {
+			b.deduplicated[entry] = map[int64]int64{}
 		}
-		defer resp.Body.Close()
+		maps.Copy(b.deduplicated[entry], timestamps)
+	}
+}
 
-		return parseRecordBatchErrors(resp.Header, reqData.entries, reqData.startTS)
-	default:
-		return nil, fmt.Errorf("invalid batch type")
+// sendChunk sends the records in one request and adds their per-record errors to errs.
+// Records whose headers exceed the limit are sent in halves.
+func (b *RecordBatch) sendChunk(ctx context.Context, span telemetry.Span, limits httpclient.BatchLimits, items []*recordBatchRecord, errs RecordBatchErrorMap) error {
+	var reqData recordBatchRequest
+	var method, path string
+	switch b.batchType {
+	case BatchWrite:
+		reqData = buildRecordBatchWriteRequest(items)
+		method, path = http.MethodPost, fmt.Sprintf("/io/%s/write", b.bucketName)
+	case BatchUpdate:
+		headerReq := buildRecordBatchUpdateRequest(items)
+		reqData = recordBatchRequest{headers: headerReq.headers, entries: headerReq.entries, startTS: headerReq.startTS}
+		method, path = http.MethodPatch, fmt.Sprintf("/io/%s/update", b.bucketName)
+	case BatchRemove:
+		headerReq := buildRecordBatchRemoveRequest(items)
+		reqData = recordBatchRequest{headers: headerReq.headers, entries: headerReq.entries, startTS: headerReq.startTS}
+		method, path = http.MethodDelete, fmt.Sprintf("/io/%s/remove", b.bucketName)
+	}
+	if len(items) > 1 && headerSize(reqData.headers) > limits.MaxHeaderSize {
+		half := len(items) / 2
+		if err := b.sendChunk(ctx, span, limits, items[:half], errs); err != nil {
+			return err
+		}
+		return b.sendChunk(ctx, span, limits, items[half:], errs)
+	}
+
+	metrics := httpclient.MetricsOf(b.httpClient)
+	observeBatch(metrics, "RecordBatch.Send", len(items), reqData.contentLength)
+
+	req, err := b.httpClient.NewRequestWithContext(ctx, method, path, reqData.body)
+	if err != nil {
+		return err
+	}
+	req.Header = reqData.headers
+	req.ContentLength = reqData.contentLength
+	if b.batchType == BatchWrite {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		// The body is replayed when the request is retried, unless a payload is streamed.
+		req.GetBody = reqData.getBody
+	}
+
+	resp, err := b.httpClient.Do(req)
+	setSpanStatus(span, resp)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	chunkErrs, err := parseRecordBatchErrors(resp.Header, reqData.entries, reqData.startTS)
+	if err != nil {
+		return err
+	}
+	for entry, entryErrs := range chunkErrs {
+		for ts, recordErr := range entryErrs {
+			errs.add(entry, ts, recordErr)
+		}
+	}
+	if b.batchType == BatchWrite {
+		observeWritten(metrics, "RecordBatch.Send", reqData.contentLength)
 	}
+	return nil
 }
 
 // Size returns the total size of the batch.
@@ -216,10 +603,23 @@ func (b *RecordBatch) Clear() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.records = make(map[recordBatchKey]*recordBatchRecord)
+	b.duplicates = map[recordBatchKey]bool{}
+	b.shifted = map[string]map[int64]int64{}
+	b.deduplicated = map[string]map[int64]int64{}
 	b.totalSize = 0
 	b.lastAccess = time.Time{}
 }
 
+// sortRecordBatchItems sorts records by entry and timestamp.
+func sortRecordBatchItems(items []*recordBatchRecord) {
+	slices.SortFunc(items, func(a, b *recordBatchRecord) int {
+		if a.entry != b.entry {
+			return strings.Compare(a.entry, b.entry)
+		}
+		return cmp.Compare(a.timestamp, b.timestamp)
+	})
+}
+
 type indexedRecord struct {
 	entryIndex int
 	timestamp  int64
@@ -234,8 +634,11 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 		headers.Set("Content-Type", "application/octet-stream")
 		headers.Set("Content-Length", "0")
 		return recordBatchRequest{
-			headers:       headers,
-			body:          bytes.NewReader(nil),
+			headers: headers,
+			body:    bytes.NewReader(nil),
+			getBody: func() (io.ReadCloser, error) {
+				return http.NoBody, nil
+			},
 			entries:       []string{},
 			startTS:       0,
 			contentLength: 0,
@@ -243,22 +646,7 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 	}
 
 	items := append([]*recordBatchRecord(nil), records...)
-	slices.SortFunc(items, func(a, b *recordBatchRecord) int {
-		if a.entry != b.entry {
-			if a.entry < b.entry {
-				return -1
-			}
-			return 1
-		}
-		switch {
-		case a.timestamp < b.timestamp:
-			return -1
-		case a.timestamp > b.timestamp:
-			return 1
-		default:
-			return 0
-		}
-	})
+	sortRecordBatchItems(items)
 
 	entries := make([]string, 0)
 	entryIndexLookup := map[string]int{}
@@ -294,12 +682,16 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 	lastMeta := map[int]recordBatchMeta{}
 
 	var contentLength int64
-	chunks := make([]io.Reader, 0, len(indexed))
+	payloads := make([]batchPayload, 0, len(indexed))
 
 	for _, item := range indexed {
 		record := item.record
-		contentLength += int64(len(record.data))
-		chunks = append(chunks, bytes.NewReader(record.data))
+		contentLength += record.size()
+		payloads = append(payloads, batchPayload{
+			data:   record.data,
+			stream: record.stream,
+			record: fmt.Sprintf("%s/%d", record.entry, record.timestamp),
+		})
 
 		delta := record.timestamp - startTS
 		contentType := record.contentType
@@ -307,7 +699,7 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 			contentType = "application/octet-stream"
 		}
 
-		currentLabels := normalizeLabels(record.labels)
+		currentLabels := record.encodedLabels
 		prev := lastMeta[item.entryIndex]
 		var prevPtr *recordBatchMeta
 		if prev.labels != nil || prev.contentType != "" {
@@ -321,7 +713,7 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 			contentTypePart = ""
 		}
 
-		parts := []string{strconv.Itoa(len(record.data))}
+		parts := []string{strconv.FormatInt(record.size(), 10)}
 		if contentTypePart != "" || hasLabels {
 			parts = append(parts, contentTypePart)
 		}
@@ -344,10 +736,11 @@ func buildRecordBatchWriteRequest(records []*recordBatchRecord) recordBatchReque
 
 	headers.Set("Content-Length", strconv.FormatInt(contentLength, 10))
 
-	body := io.MultiReader(chunks...)
+	body, getBody := newPayloadBody(payloads)
 	return recordBatchRequest{
 		headers:       headers,
 		body:          body,
+		getBody:       getBody,
 		entries:       entries,
 		startTS:       startTS,
 		contentLength: contentLength,
@@ -368,22 +761,7 @@ func buildRecordBatchUpdateRequest(records []*recordBatchRecord) recordBatchHead
 	}
 
 	items := append([]*recordBatchRecord(nil), records...)
-	slices.SortFunc(items, func(a, b *recordBatchRecord) int {
-		if a.entry != b.entry {
-			if a.entry < b.entry {
-				return -1
-			}
-			return 1
-		}
-		switch {
-		case a.timestamp < b.timestamp:
-			return -1
-		case a.timestamp > b.timestamp:
-			return 1
-		default:
-			return 0
-		}
-	})
+	sortRecordBatchItems(items)
 
 	entries := make([]string, 0)
 	entryIndexLookup := map[string]int{}
@@ -407,7 +785,7 @@ func buildRecordBatchUpdateRequest(records []*recordBatchRecord) recordBatchHead
 		}
 
 		delta := record.timestamp - startTS
-		labelDelta := buildUpdateLabelDelta(record.labels, labelIndex, &labelNames)
+		labelDelta := buildUpdateLabelDelta(record.encodedLabels, labelIndex, &labelNames)
 
 		headerName := fmt.Sprintf("%s%d-%d", recordBatchHeaderPrefix, entryIndex, delta)
 		if labelDelta == "" {
@@ -446,22 +824,7 @@ func buildRecordBatchRemoveRequest(records []*recordBatchRecord) recordBatchHead
 	}
 
 	items := append([]*recordBatchRecord(nil), records...)
-	slices.SortFunc(items, func(a, b *recordBatchRecord) int {
-		if a.entry != b.entry {
-			if a.entry < b.entry {
-				return -1
-			}
-			return 1
-		}
-		switch {
-		case a.timestamp < b.timestamp:
-			return -1
-		case a.timestamp > b.timestamp:
-			return 1
-		default:
-			return 0
-		}
-	})
+	sortRecordBatchItems(items)
 
 	entries := make([]string, 0)
 	entryIndexLookup := map[string]int{}
@@ -559,12 +922,18 @@ func parseRecordBatchErrors(headers http.Header, entries []string, startTS int64
 	return errs, nil
 }
 
-func normalizeLabels(labels LabelMap) map[string]string {
-	out := map[string]string{}
-	for k, v := range labels {
-		out[k] = fmt.Sprint(v)
+// normalizeLabels encodes labels for the record headers of Batch Protocol v2.
+func normalizeLabels(labels LabelMap) (map[string]string, error) {
+	out, err := FormatLabels(labels)
+	if err != nil {
+		return nil, err
 	}
-	return out
+	for name, value := range out {
+		if out[name], err = quoteBatchLabel(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
 }
 
 type labelDeltaOp struct {
@@ -592,7 +961,7 @@ func buildLabelDelta(labels map[string]string, previous *recordBatchMeta, labelI
 		slices.Sort(keys)
 		for _, key := range keys {
 			idx := ensureLabel(key)
-			val := formatLabelValue(labels[key])
+			val := labels[key]
 			ops = append(ops, labelDeltaOp{idx: idx, value: &val})
 		}
 	} else {
@@ -619,7 +988,7 @@ func buildLabelDelta(labels map[string]string, previous *recordBatchMeta, labelI
 				ops = append(ops, labelDeltaOp{idx: idx, value: nil})
 				continue
 			}
-			val := formatLabelValue(currVal)
+			val := currVal
 			ops = append(ops, labelDeltaOp{idx: idx, value: &val})
 		}
 	}
@@ -639,7 +1008,7 @@ func buildLabelDelta(labels map[string]string, previous *recordBatchMeta, labelI
 	return strings.Join(parts, ",")
 }
 
-func buildUpdateLabelDelta(labels LabelMap, labelIndex map[string]int, labelNames *[]string) string {
+func buildUpdateLabelDelta(labels map[string]string, labelIndex map[string]int, labelNames *[]string) string {
 	if len(labels) == 0 {
 		return ""
 	}
@@ -662,31 +1031,13 @@ func buildUpdateLabelDelta(labels LabelMap, labelIndex map[string]int, labelName
 
 	parts := make([]string, 0, len(keys))
 	for _, key := range keys {
-		idx := ensureLabel(key)
-		val := labels[key]
-		if val == nil {
-			parts = append(parts, fmt.Sprintf("%d=", idx))
-			continue
-		}
-
-		valStr := fmt.Sprint(val)
-		if valStr == "" {
-			parts = append(parts, fmt.Sprintf("%d=", idx))
-			continue
-		}
-		parts = append(parts, fmt.Sprintf("%d=%s", idx, formatLabelValue(valStr)))
+		// An empty value removes the label.
+		parts = append(parts, fmt.Sprintf("%d=%s", ensureLabel(key), labels[key]))
 	}
 
 	return strings.Join(parts, ",")
 }
 
-func formatLabelValue(value string) string {
-	if strings.Contains(value, ",") {
-		return fmt.Sprintf("%q", value)
-	}
-	return value
-}
-
 func encodeHeaderList(values []string) string {
 	encoded := make([]string, 0, len(values))
 	for _, value := range values {
@@ -0,0 +1,93 @@
+package reductgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSlowWriteServer accepts record uploads but only answers when the client
+// gives up. HEAD requests answer with headStatus and the stored record.
+func newSlowWriteServer(t *testing.T, headStatus int, stored string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reduct-API", "v1.20")
+		switch r.Method {
+		case http.MethodPost:
+			_, err := io.Copy(io.Discard, r.Body)
+			assert.NoError(t, err)
+			<-r.Context().Done()
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.Itoa(len(stored)))
+			w.Header().Set("X-Reduct-Label-Robot", "r1")
+			w.WriteHeader(headStatus)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWriteContextCanceled(t *testing.T) {
+	tests := []struct {
+		name       string
+		headStatus int
+		stored     string
+		committed  bool
+		checkErr   bool
+	}{
+		{name: "committed", headStatus: http.StatusOK, stored: "data", committed: true},
+		{name: "not committed", headStatus: http.StatusNotFound},
+		{name: "other record", headStatus: http.StatusOK, stored: "existing"},
+		{name: "unknown", headStatus: http.StatusInternalServerError, checkErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSlowWriteServer(t, tt.headStatus, tt.stored)
+			bucket, err := NewClient(server.URL, ClientOptions{}).GetBucket(context.Background(), "bucket")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err = bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, Labels: LabelMap{"robot": "r1"}}).Write("data")
+
+			var canceled *WriteCanceledError
+			require.ErrorAs(t, err, &canceled)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, "entry", canceled.Entry)
+			assert.Equal(t, int64(1), canceled.Timestamp)
+			assert.Equal(t, tt.committed, canceled.Committed)
+			assert.Equal(t, tt.checkErr, canceled.CheckErr != nil)
+		})
+	}
+}
+
+func TestWriteContextBeforeSend(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.Header().Set("X-Reduct-API", "v1.20")
+	}))
+	defer server.Close()
+	bucket, err := NewClient(server.URL, ClientOptions{}).GetBucket(context.Background(), "bucket")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = bucket.BeginWrite(context.Background(), "entry", &WriteOptions{Timestamp: 1}).WriteContext(ctx, []byte("data"))
+
+	var canceled *WriteCanceledError
+	require.True(t, errors.As(err, &canceled))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, canceled.Committed)
+	assert.NoError(t, canceled.CheckErr)
+	assert.Equal(t, 1, requests, "only GetBucket reaches the server")
+}
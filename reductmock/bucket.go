@@ -0,0 +1,232 @@
+package reductmock
+
+import (
+	"context"
+	"io"
+
+	"github.com/stretchr/testify/mock"
+
+	reductgo "github.com/reductstore/reduct-go"
+	"github.com/reductstore/reduct-go/model"
+)
+
+// Bucket is a mock of reductgo.BucketAPI.
+type Bucket struct {
+	mock.Mock
+}
+
+var _ reductgo.BucketAPI = (*Bucket)(nil)
+
+// NewBucket returns a mock bucket whose expectations are asserted when the test ends.
+func NewBucket(t TestingT) *Bucket {
+	b := &Bucket{}
+	b.Test(t)
+	t.Cleanup(func() { b.AssertExpectations(t) })
+	return b
+}
+
+// GetName implements reductgo.BucketAPI.
+func (b *Bucket) GetName() string {
+	args := b.Called()
+	return result[string](args, 0)
+}
+
+// CheckExists implements reductgo.BucketAPI.
+func (b *Bucket) CheckExists(ctx context.Context) (bool, error) {
+	args := b.Called(ctx)
+	return result[bool](args, 0), args.Error(1)
+}
+
+// GetInfo implements reductgo.BucketAPI.
+func (b *Bucket) GetInfo(ctx context.Context) (model.BucketInfo, error) {
+	args := b.Called(ctx)
+	return result[model.BucketInfo](args, 0), args.Error(1)
+}
+
+// GetEntries implements reductgo.BucketAPI.
+func (b *Bucket) GetEntries(ctx context.Context) ([]model.EntryInfo, error) {
+	args := b.Called(ctx)
+	return result[[]model.EntryInfo](args, 0), args.Error(1)
+}
+
+// GetFullInfo implements reductgo.BucketAPI.
+func (b *Bucket) GetFullInfo(ctx context.Context) (model.FullBucketDetail, error) {
+	args := b.Called(ctx)
+	return result[model.FullBucketDetail](args, 0), args.Error(1)
+}
+
+// GetSettings implements reductgo.BucketAPI.
+func (b *Bucket) GetSettings(ctx context.Context) (model.BucketSetting, error) {
+	args := b.Called(ctx)
+	return result[model.BucketSetting](args, 0), args.Error(1)
+}
+
+// SetSettings implements reductgo.BucketAPI.
+func (b *Bucket) SetSettings(ctx context.Context, settings model.BucketSetting) error {
+	args := b.Called(ctx, settings)
+	return args.Error(0)
+}
+
+// Rename implements reductgo.BucketAPI.
+func (b *Bucket) Rename(ctx context.Context, newName string) error {
+	args := b.Called(ctx, newName)
+	return args.Error(0)
+}
+
+// Remove implements reductgo.BucketAPI.
+func (b *Bucket) Remove(ctx context.Context) error {
+	args := b.Called(ctx)
+	return args.Error(0)
+}
+
+// RemoveRecord implements reductgo.BucketAPI.
+func (b *Bucket) RemoveRecord(ctx context.Context, entry string, ts int64) error {
+	args := b.Called(ctx, entry, ts)
+	return args.Error(0)
+}
+
+// RemoveEntry implements reductgo.BucketAPI.
+func (b *Bucket) RemoveEntry(ctx context.Context, entry string) error {
+	args := b.Called(ctx, entry)
+	return args.Error(0)
+}
+
+// RenameEntry implements reductgo.BucketAPI.
+func (b *Bucket) RenameEntry(ctx context.Context, entry, newName string) error {
+	args := b.Called(ctx, entry, newName)
+	return args.Error(0)
+}
+
+// BeginRead implements reductgo.BucketAPI.
+func (b *Bucket) BeginRead(ctx context.Context, entry string, ts *int64) (*reductgo.ReadableRecord, error) {
+	args := b.Called(ctx, entry, ts)
+	return result[*reductgo.ReadableRecord](args, 0), args.Error(1)
+}
+
+// BeginMetadataRead implements reductgo.BucketAPI.
+func (b *Bucket) BeginMetadataRead(ctx context.Context, entry string, ts *int64) (*reductgo.ReadableRecord, error) {
+	args := b.Called(ctx, entry, ts)
+	return result[*reductgo.ReadableRecord](args, 0), args.Error(1)
+}
+
+// BeginWrite implements reductgo.BucketAPI.
+func (b *Bucket) BeginWrite(ctx context.Context, entry string, options *reductgo.WriteOptions) *reductgo.WritableRecord {
+	args := b.Called(ctx, entry, options)
+	return result[*reductgo.WritableRecord](args, 0)
+}
+
+// BeginWriteBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginWriteBatch(ctx context.Context, entry string) *reductgo.Batch {
+	args := b.Called(ctx, entry)
+	return result[*reductgo.Batch](args, 0)
+}
+
+// BeginUpdateBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginUpdateBatch(ctx context.Context, entry string) *reductgo.Batch {
+	args := b.Called(ctx, entry)
+	return result[*reductgo.Batch](args, 0)
+}
+
+// BeginRemoveBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginRemoveBatch(ctx context.Context, entry string) *reductgo.Batch {
+	args := b.Called(ctx, entry)
+	return result[*reductgo.Batch](args, 0)
+}
+
+// BeginWriteRecordBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginWriteRecordBatch(ctx context.Context) *reductgo.RecordBatch {
+	args := b.Called(ctx)
+	return result[*reductgo.RecordBatch](args, 0)
+}
+
+// BeginUpdateRecordBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginUpdateRecordBatch(ctx context.Context) *reductgo.RecordBatch {
+	args := b.Called(ctx)
+	return result[*reductgo.RecordBatch](args, 0)
+}
+
+// BeginBufferedWrite implements reductgo.BucketAPI.
+func (b *Bucket) BeginBufferedWrite(ctx context.Context, options *reductgo.BufferedWriterOptions) *reductgo.BufferedWriter {
+	args := b.Called(ctx, options)
+	return result[*reductgo.BufferedWriter](args, 0)
+}
+
+// BeginRemoveRecordBatch implements reductgo.BucketAPI.
+func (b *Bucket) BeginRemoveRecordBatch(ctx context.Context) *reductgo.RecordBatch {
+	args := b.Called(ctx)
+	return result[*reductgo.RecordBatch](args, 0)
+}
+
+// WriteObject implements reductgo.BucketAPI.
+func (b *Bucket) WriteObject(ctx context.Context, entry, objectID string, reader io.Reader,
+	options *reductgo.ObjectOptions,
+) (reductgo.ObjectInfo, error) {
+	args := b.Called(ctx, entry, objectID, reader, options)
+	return result[reductgo.ObjectInfo](args, 0), args.Error(1)
+}
+
+// ReadObject implements reductgo.BucketAPI.
+func (b *Bucket) ReadObject(ctx context.Context, entry, objectID string) (*reductgo.ObjectReader, error) {
+	args := b.Called(ctx, entry, objectID)
+	return result[*reductgo.ObjectReader](args, 0), args.Error(1)
+}
+
+// Query implements reductgo.BucketAPI.
+func (b *Bucket) Query(ctx context.Context, entry string, options *reductgo.QueryOptions) (*reductgo.QueryResult, error) {
+	args := b.Called(ctx, entry, options)
+	return result[*reductgo.QueryResult](args, 0), args.Error(1)
+}
+
+// QueryMany implements reductgo.BucketAPI.
+func (b *Bucket) QueryMany(ctx context.Context, entries []string, options *reductgo.QueryOptions) (*reductgo.QueryResult, error) {
+	args := b.Called(ctx, entries, options)
+	return result[*reductgo.QueryResult](args, 0), args.Error(1)
+}
+
+// RemoveQuery implements reductgo.BucketAPI.
+func (b *Bucket) RemoveQuery(ctx context.Context, entry string, options *reductgo.QueryOptions) (int64, error) {
+	args := b.Called(ctx, entry, options)
+	return result[int64](args, 0), args.Error(1)
+}
+
+// RemoveQueryMany implements reductgo.BucketAPI.
+func (b *Bucket) RemoveQueryMany(ctx context.Context, entries []string, options *reductgo.QueryOptions) (int64, error) {
+	args := b.Called(ctx, entries, options)
+	return result[int64](args, 0), args.Error(1)
+}
+
+// Update implements reductgo.BucketAPI.
+func (b *Bucket) Update(ctx context.Context, entry string, ts int64, labels any) error {
+	args := b.Called(ctx, entry, ts, labels)
+	return args.Error(0)
+}
+
+// WriteAttachments implements reductgo.BucketAPI.
+func (b *Bucket) WriteAttachments(ctx context.Context, entry string, attachments map[string]any) error {
+	args := b.Called(ctx, entry, attachments)
+	return args.Error(0)
+}
+
+// ReadAttachments implements reductgo.BucketAPI.
+func (b *Bucket) ReadAttachments(ctx context.Context, entry string) (map[string]any, error) {
+	args := b.Called(ctx, entry)
+	return result[map[string]any](args, 0), args.Error(1)
+}
+
+// RemoveAttachments implements reductgo.BucketAPI.
+func (b *Bucket) RemoveAttachments(ctx context.Context, entry string, attachmentKeys []string) error {
+	args := b.Called(ctx, entry, attachmentKeys)
+	return args.Error(0)
+}
+
+// CreateQueryLink implements reductgo.BucketAPI.
+func (b *Bucket) CreateQueryLink(ctx context.Context, entry string, options reductgo.QueryLinkOptions) (string, error) {
+	args := b.Called(ctx, entry, options)
+	return result[string](args, 0), args.Error(1)
+}
+
+// CreateQueryLinkMany implements reductgo.BucketAPI.
+func (b *Bucket) CreateQueryLinkMany(ctx context.Context, entries []string, options reductgo.QueryLinkOptions) (string, error) {
+	args := b.Called(ctx, entries, options)
+	return result[string](args, 0), args.Error(1)
+}
@@ -0,0 +1,187 @@
+package reductmock
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+
+	reductgo "github.com/reductstore/reduct-go"
+	"github.com/reductstore/reduct-go/model"
+)
+
+// Client is a mock of reductgo.Client.
+type Client struct {
+	mock.Mock
+}
+
+var _ reductgo.Client = (*Client)(nil)
+
+// NewClient returns a mock client whose expectations are asserted when the test ends.
+func NewClient(t TestingT) *Client {
+	c := &Client{}
+	c.Test(t)
+	t.Cleanup(func() { c.AssertExpectations(t) })
+	return c
+}
+
+// GetInfo implements reductgo.Client.
+func (c *Client) GetInfo(ctx context.Context) (model.ServerInfo, error) {
+	args := c.Called(ctx)
+	return result[model.ServerInfo](args, 0), args.Error(1)
+}
+
+// IsLive implements reductgo.Client.
+func (c *Client) IsLive(ctx context.Context) (bool, error) {
+	args := c.Called(ctx)
+	return result[bool](args, 0), args.Error(1)
+}
+
+// GetBuckets implements reductgo.Client.
+func (c *Client) GetBuckets(ctx context.Context) ([]model.BucketInfo, error) {
+	args := c.Called(ctx)
+	return result[[]model.BucketInfo](args, 0), args.Error(1)
+}
+
+// CreateBucket implements reductgo.Client.
+func (c *Client) CreateBucket(ctx context.Context, name string, settings *model.BucketSetting) (reductgo.BucketAPI, error) {
+	args := c.Called(ctx, name, settings)
+	return result[reductgo.BucketAPI](args, 0), args.Error(1)
+}
+
+// CreateOrGetBucket implements reductgo.Client.
+func (c *Client) CreateOrGetBucket(ctx context.Context, name string, settings *model.BucketSetting) (reductgo.BucketAPI, error) {
+	args := c.Called(ctx, name, settings)
+	return result[reductgo.BucketAPI](args, 0), args.Error(1)
+}
+
+// GetBucket implements reductgo.Client.
+func (c *Client) GetBucket(ctx context.Context, name string) (reductgo.BucketAPI, error) {
+	args := c.Called(ctx, name)
+	return result[reductgo.BucketAPI](args, 0), args.Error(1)
+}
+
+// CheckBucketExists implements reductgo.Client.
+func (c *Client) CheckBucketExists(ctx context.Context, name string) (bool, error) {
+	args := c.Called(ctx, name)
+	return result[bool](args, 0), args.Error(1)
+}
+
+// RemoveBucket implements reductgo.Client.
+func (c *Client) RemoveBucket(ctx context.Context, name string) error {
+	args := c.Called(ctx, name)
+	return args.Error(0)
+}
+
+// GetTokens implements reductgo.Client.
+func (c *Client) GetTokens(ctx context.Context) ([]model.Token, error) {
+	args := c.Called(ctx)
+	return result[[]model.Token](args, 0), args.Error(1)
+}
+
+// GetToken implements reductgo.Client.
+func (c *Client) GetToken(ctx context.Context, name string) (model.Token, error) {
+	args := c.Called(ctx, name)
+	return result[model.Token](args, 0), args.Error(1)
+}
+
+// CreateToken implements reductgo.Client.
+func (c *Client) CreateToken(ctx context.Context, name string, permissions model.TokenPermissions) (string, error) {
+	args := c.Called(ctx, name, permissions)
+	return result[string](args, 0), args.Error(1)
+}
+
+// CreateTokenWithOptions implements reductgo.Client.
+func (c *Client) CreateTokenWithOptions(ctx context.Context, name string, options model.TokenCreateOptions) (model.TokenCreateResponse, error) {
+	args := c.Called(ctx, name, options)
+	return result[model.TokenCreateResponse](args, 0), args.Error(1)
+}
+
+// RotateToken implements reductgo.Client.
+func (c *Client) RotateToken(ctx context.Context, name string) (model.TokenCreateResponse, error) {
+	args := c.Called(ctx, name)
+	return result[model.TokenCreateResponse](args, 0), args.Error(1)
+}
+
+// RemoveToken implements reductgo.Client.
+func (c *Client) RemoveToken(ctx context.Context, name string) error {
+	args := c.Called(ctx, name)
+	return args.Error(0)
+}
+
+// GetCurrentToken implements reductgo.Client.
+func (c *Client) GetCurrentToken(ctx context.Context) (model.Token, error) {
+	args := c.Called(ctx)
+	return result[model.Token](args, 0), args.Error(1)
+}
+
+// GetReplicationTasks implements reductgo.Client.
+func (c *Client) GetReplicationTasks(ctx context.Context) ([]model.ReplicationInfo, error) {
+	args := c.Called(ctx)
+	return result[[]model.ReplicationInfo](args, 0), args.Error(1)
+}
+
+// GetReplicationTask implements reductgo.Client.
+func (c *Client) GetReplicationTask(ctx context.Context, name string) (model.FullReplicationInfo, error) {
+	args := c.Called(ctx, name)
+	return result[model.FullReplicationInfo](args, 0), args.Error(1)
+}
+
+// CreateReplicationTask implements reductgo.Client.
+func (c *Client) CreateReplicationTask(ctx context.Context, name string, task model.ReplicationSettings) error {
+	args := c.Called(ctx, name, task)
+	return args.Error(0)
+}
+
+// UpdateReplicationTask implements reductgo.Client.
+func (c *Client) UpdateReplicationTask(ctx context.Context, name string, task model.ReplicationSettings) error {
+	args := c.Called(ctx, name, task)
+	return args.Error(0)
+}
+
+// SetReplicationMode implements reductgo.Client.
+func (c *Client) SetReplicationMode(ctx context.Context, name string, mode model.ReplicationMode) error {
+	args := c.Called(ctx, name, mode)
+	return args.Error(0)
+}
+
+// RemoveReplicationTask implements reductgo.Client.
+func (c *Client) RemoveReplicationTask(ctx context.Context, name string) error {
+	args := c.Called(ctx, name)
+	return args.Error(0)
+}
+
+// GetLifecycles implements reductgo.Client.
+func (c *Client) GetLifecycles(ctx context.Context) ([]model.LifecycleInfo, error) {
+	args := c.Called(ctx)
+	return result[[]model.LifecycleInfo](args, 0), args.Error(1)
+}
+
+// GetLifecycle implements reductgo.Client.
+func (c *Client) GetLifecycle(ctx context.Context, name string) (model.FullLifecycleInfo, error) {
+	args := c.Called(ctx, name)
+	return result[model.FullLifecycleInfo](args, 0), args.Error(1)
+}
+
+// CreateLifecycle implements reductgo.Client.
+func (c *Client) CreateLifecycle(ctx context.Context, name string, settings model.LifecycleSettings) error {
+	args := c.Called(ctx, name, settings)
+	return args.Error(0)
+}
+
+// UpdateLifecycle implements reductgo.Client.
+func (c *Client) UpdateLifecycle(ctx context.Context, name string, settings model.LifecycleSettings) error {
+	args := c.Called(ctx, name, settings)
+	return args.Error(0)
+}
+
+// SetLifecycleMode implements reductgo.Client.
+func (c *Client) SetLifecycleMode(ctx context.Context, name string, mode model.LifecycleMode) error {
+	args := c.Called(ctx, name, mode)
+	return args.Error(0)
+}
+
+// RemoveLifecycle implements reductgo.Client.
+func (c *Client) RemoveLifecycle(ctx context.Context, name string) error {
+	args := c.Called(ctx, name)
+	return args.Error(0)
+}
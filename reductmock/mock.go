@@ -0,0 +1,38 @@
+// Package reductmock provides mocks of reductgo.Client and reductgo.BucketAPI
+// built on github.com/stretchr/testify/mock, for unit tests of code that uses
+// the SDK.
+//
+//	bucket := reductmock.NewBucket(t)
+//	bucket.On("GetInfo", mock.Anything).Return(model.BucketInfo{Name: "data"}, nil)
+//
+//	client := reductmock.NewClient(t)
+//	client.On("GetBucket", mock.Anything, "data").Return(bucket, nil).Once()
+//
+// A call without a matching expectation fails the test. Expectations that
+// were not met fail the test when it ends.
+//
+// The batches and writers returned by BeginWrite, BeginWriteBatch,
+// BeginWriteRecordBatch, BeginBufferedWrite and the like are the concrete SDK
+// types, which send their records over HTTP. To test code that writes them,
+// return ones begun on a bucket of a reducttest server and check the records
+// it stores.
+package reductmock
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+// TestingT is the part of testing.TB the mocks use.
+type TestingT interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// result returns the i-th return value as T, or the zero value if it is nil.
+func result[T any](args mock.Arguments, i int) T {
+	if args.Get(i) == nil {
+		var zero T
+		return zero
+	}
+	return args.Get(i).(T)
+}
@@ -0,0 +1,154 @@
+package reductmock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	reductgo "github.com/reductstore/reduct-go"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+// errFailNow stops a call that failed the recorder.
+var errFailNow = errors.New("FailNow")
+
+// recorder is a TestingT that keeps the failures instead of reporting them.
+type recorder struct {
+	errors   []string
+	logs     []string
+	cleanups []func()
+}
+
+func (r *recorder) Logf(format string, args ...any) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) FailNow() {
+	panic(errFailNow)
+}
+
+func (r *recorder) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+func (r *recorder) finish() {
+	for _, fn := range r.cleanups {
+		fn()
+	}
+}
+
+// countRecords is code under test that only depends on the SDK interfaces.
+func countRecords(ctx context.Context, client reductgo.Client, bucketName, entry string) (int, error) {
+	bucket, err := client.GetBucket(ctx, bucketName)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bucket.Query(ctx, entry, nil)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for range result.Records() {
+		count++
+	}
+	return count, result.Err()
+}
+
+func TestMockClientAndBucket(t *testing.T) {
+	ctx := context.Background()
+	records := []*reductgo.ReadableRecord{
+		reductgo.NewReadableRecord("entry", 1, 1, false, bytes.NewReader([]byte("a")), nil, ""),
+		reductgo.NewReadableRecord("entry", 2, 1, true, bytes.NewReader([]byte("b")), nil, ""),
+	}
+
+	bucket := NewBucket(t)
+	bucket.On("Query", mock.Anything, "entry", (*reductgo.QueryOptions)(nil)).
+		Return(reductgo.NewQueryResult(records, nil), nil)
+	client := NewClient(t)
+	client.On("GetBucket", mock.Anything, "data").Return(bucket, nil).Once()
+	client.On("GetBucket", mock.Anything, mock.MatchedBy(func(name string) bool { return name != "data" })).
+		Return(nil, &model.APIError{Status: 404})
+
+	count, err := countRecords(ctx, client, "data", "entry")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = countRecords(ctx, client, "other", "entry")
+	assert.True(t, errors.Is(err, model.ErrNotFound))
+
+	client.AssertCalled(t, "GetBucket", ctx, "data")
+	client.AssertCalled(t, "GetBucket", ctx, "other")
+	client.AssertNumberOfCalls(t, "GetBucket", 2)
+}
+
+func TestMockRun(t *testing.T) {
+	bucket := NewBucket(t)
+	var updated reductgo.LabelMap
+	bucket.On("Update", mock.Anything, "entry", int64(1), mock.Anything).
+		Run(func(args mock.Arguments) { updated = args.Get(3).(reductgo.LabelMap) }).
+		Return(nil)
+
+	require.NoError(t, bucket.Update(context.Background(), "entry", 1, reductgo.LabelMap{"a": "b"}))
+	assert.Equal(t, reductgo.LabelMap{"a": "b"}, updated)
+}
+
+func TestMockFailures(t *testing.T) {
+	rec := &recorder{}
+	bucket := NewBucket(rec)
+	bucket.On("GetName").Return("data").Times(2)
+	bucket.On("Remove", mock.Anything).Return(nil)
+
+	assert.Equal(t, "data", bucket.GetName())
+	assert.PanicsWithValue(t, errFailNow, func() { _, _ = bucket.GetInfo(context.Background()) })
+	rec.finish()
+
+	require.Len(t, rec.errors, 2)
+	assert.Contains(t, rec.errors[0], "GetInfo")
+	assert.Contains(t, rec.errors[1], "0 out of 2 expectation(s) were met")
+}
+
+// storeReadings is code under test that writes a batch.
+func storeReadings(ctx context.Context, bucket reductgo.BucketAPI, readings []string) error {
+	batch := bucket.BeginWriteBatch(ctx, "readings")
+	for i, reading := range readings {
+		batch.Add(int64(i+1), []byte(reading), "text/plain", nil)
+	}
+	errs, err := batch.Write(ctx)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d readings failed", len(errs))
+	}
+	return nil
+}
+
+func TestMockBatch(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	store, err := reductgo.NewClient(server.URL, reductgo.ClientOptions{}).CreateBucket(ctx, "data", nil)
+	require.NoError(t, err)
+
+	bucket := NewBucket(t)
+	bucket.On("BeginWriteBatch", mock.Anything, "readings").Return(store.BeginWriteBatch(ctx, "readings"))
+	require.NoError(t, storeReadings(ctx, bucket, []string{"1.5", "2.5"}))
+
+	ts := int64(2)
+	record, err := store.BeginRead(ctx, "readings", &ts)
+	require.NoError(t, err)
+	data, err := record.ReadAsString()
+	require.NoError(t, err)
+	assert.Equal(t, "2.5", data)
+}
@@ -0,0 +1,376 @@
+package reducttest
+
+import (
+	"crypto/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+const initTokenName = "init-token"
+
+type token struct {
+	info  model.Token
+	value string
+}
+
+type replication struct {
+	info     model.ReplicationInfo
+	settings model.ReplicationSettings
+}
+
+type lifecycle struct {
+	info     model.LifecycleInfo
+	settings model.LifecycleSettings
+}
+
+// authenticate returns the token of the request, or nil if authentication is disabled.
+func (s *Server) authenticate(r *http.Request) (*token, error) {
+	if s.options.APIToken == "" {
+		return nil, nil
+	}
+
+	value, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || value == "" {
+		return nil, errorf(http.StatusUnauthorized, "No bearer token in request header")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tok := range s.tokens {
+		if tok.value != value {
+			continue
+		}
+		if tok.expired() {
+			return nil, errorf(http.StatusUnauthorized, "Token '%s' has expired", tok.info.Name)
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		tok.info.LastAccess = &now
+		return tok, nil
+	}
+	return nil, errorf(http.StatusUnauthorized, "Invalid token")
+}
+
+func (t *token) expired() bool {
+	if t.info.ExpiresAt == nil {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *t.info.ExpiresAt)
+	return err == nil && !time.Now().Before(expiresAt)
+}
+
+// requireFullAccess checks that the token may manage the server. A nil token
+// means that authentication is disabled.
+func requireFullAccess(tok *token) error {
+	if tok == nil || tok.info.Permissions.FullAccess {
+		return nil
+	}
+	return errorf(http.StatusForbidden, "Token '%s' doesn't have full access", tok.info.Name)
+}
+
+func requireRead(tok *token, bucketName string) error {
+	if tok == nil || tok.info.Permissions.FullAccess || slices.Contains(tok.info.Permissions.Read, bucketName) {
+		return nil
+	}
+	return errorf(http.StatusForbidden, "Token '%s' doesn't have read access to bucket '%s'", tok.info.Name, bucketName)
+}
+
+func requireWrite(tok *token, bucketName string) error {
+	if tok == nil || tok.info.Permissions.FullAccess || slices.Contains(tok.info.Permissions.Write, bucketName) {
+		return nil
+	}
+	return errorf(http.StatusForbidden, "Token '%s' doesn't have write access to bucket '%s'", tok.info.Name, bucketName)
+}
+
+func (s *Server) serveMe(w http.ResponseWriter, r *http.Request, tok *token) error {
+	if r.Method != http.MethodGet {
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+	if tok == nil {
+		return writeJSON(w, model.Token{
+			Name:        initTokenName,
+			CreatedAt:   s.started.UTC().Format(time.RFC3339),
+			Permissions: &model.TokenPermissions{FullAccess: true},
+		})
+	}
+
+	s.mu.Lock()
+	info := tok.info
+	s.mu.Unlock()
+	return writeJSON(w, info)
+}
+
+// serveTokens handles /tokens, /tokens/<name> and /tokens/<name>/rotate.
+func (s *Server) serveTokens(w http.ResponseWriter, r *http.Request, tok *token, rest string) error {
+	if err := requireFullAccess(tok); err != nil {
+		return err
+	}
+
+	name, action, _ := strings.Cut(rest, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		tokens := make([]model.Token, 0, len(s.tokens))
+		for _, t := range s.tokens {
+			info := t.info
+			info.IsExpired = t.expired()
+			tokens = append(tokens, info)
+		}
+		slices.SortFunc(tokens, func(a, b model.Token) int { return strings.Compare(a.Name, b.Name) })
+		return writeJSON(w, map[string][]model.Token{"tokens": tokens})
+	case name == "":
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	case action == "rotate" && r.Method == http.MethodPost:
+		t, ok := s.tokens[name]
+		if !ok {
+			return errorf(http.StatusNotFound, "Token '%s' doesn't exist", name)
+		}
+		t.value = newTokenValue(name)
+		t.info.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		return writeJSON(w, model.TokenCreateResponse{Value: t.value, CreatedAt: t.info.CreatedAt})
+	case action != "":
+		return errorf(http.StatusNotFound, "Not found")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		t, ok := s.tokens[name]
+		if !ok {
+			return errorf(http.StatusNotFound, "Token '%s' doesn't exist", name)
+		}
+		info := t.info
+		info.IsExpired = t.expired()
+		return writeJSON(w, info)
+	case http.MethodPost:
+		var options model.TokenCreateOptions
+		if err := readJSON(r, &options); err != nil {
+			return err
+		}
+		if _, ok := s.tokens[name]; ok {
+			return errorf(http.StatusConflict, "Token '%s' already exists", name)
+		}
+		for _, bucketName := range slices.Concat(options.Permissions.Read, options.Permissions.Write) {
+			if _, ok := s.buckets[bucketName]; !ok {
+				return errorf(http.StatusUnprocessableEntity, "Bucket '%s' doesn't exist", bucketName)
+			}
+		}
+		if options.TTL != nil && *options.TTL == 0 {
+			return errorf(http.StatusUnprocessableEntity, "TTL must be greater than 0")
+		}
+
+		permissions := options.Permissions
+		t := &token{
+			info: model.Token{
+				Name:        name,
+				CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+				Permissions: &permissions,
+				ExpiresAt:   options.ExpiresAt,
+				TTL:         options.TTL,
+				IPAllowlist: options.IPAllowlist,
+			},
+			value: newTokenValue(name),
+		}
+		s.tokens[name] = t
+		return writeJSON(w, model.TokenCreateResponse{Value: t.value, CreatedAt: t.info.CreatedAt})
+	case http.MethodDelete:
+		t, ok := s.tokens[name]
+		if !ok {
+			return errorf(http.StatusNotFound, "Token '%s' doesn't exist", name)
+		}
+		if t.info.IsProvisioned {
+			return errorf(http.StatusConflict, "Can't remove provisioned token '%s'", name)
+		}
+		delete(s.tokens, name)
+		return nil
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func newTokenValue(name string) string {
+	return name + "-" + rand.Text()
+}
+
+// serveReplications handles /replications, /replications/<name> and /replications/<name>/mode.
+func (s *Server) serveReplications(w http.ResponseWriter, r *http.Request, tok *token, rest string) error {
+	if err := requireFullAccess(tok); err != nil {
+		return err
+	}
+
+	name, action, _ := strings.Cut(rest, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		tasks := make([]model.ReplicationInfo, 0, len(s.replications))
+		for _, task := range s.replications {
+			tasks = append(tasks, task.info)
+		}
+		slices.SortFunc(tasks, func(a, b model.ReplicationInfo) int { return strings.Compare(a.Name, b.Name) })
+		return writeJSON(w, map[string][]model.ReplicationInfo{"replications": tasks})
+	case name == "":
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	case action == "mode" && r.Method == http.MethodPatch:
+		var payload model.ReplicationModePayload
+		if err := readJSON(r, &payload); err != nil {
+			return err
+		}
+		task, ok := s.replications[name]
+		if !ok {
+			return errorf(http.StatusNotFound, "Replication '%s' does not exist", name)
+		}
+		if !payload.Mode.IsValid() {
+			return errorf(http.StatusUnprocessableEntity, "Invalid replication mode '%s'", payload.Mode)
+		}
+		task.info.Mode = payload.Mode
+		task.settings.Mode = payload.Mode
+		return nil
+	case action != "":
+		return errorf(http.StatusNotFound, "Not found")
+	}
+
+	task, exists := s.replications[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			return errorf(http.StatusNotFound, "Replication '%s' does not exist", name)
+		}
+		info, settings := task.info, task.settings
+		settings.DstToken = ""
+		return writeJSON(w, model.FullReplicationInfo{
+			Info:     &info,
+			Settings: &settings,
+			Diagnostics: &model.Diagnostics{
+				Hourly: &model.DiagnosticsItem{Errors: map[int64]*model.DiagnosticsError{}},
+			},
+		})
+	case http.MethodPost, http.MethodPut:
+		var settings model.ReplicationSettings
+		if err := readJSON(r, &settings); err != nil {
+			return err
+		}
+		if r.Method == http.MethodPost && exists {
+			return errorf(http.StatusConflict, "Replication '%s' already exists", name)
+		}
+		if r.Method == http.MethodPut && !exists {
+			return errorf(http.StatusNotFound, "Replication '%s' does not exist", name)
+		}
+		if _, ok := s.buckets[settings.SrcBucket]; !ok {
+			return errorf(http.StatusNotFound, "Source bucket '%s' for replication '%s' does not exist", settings.SrcBucket, name)
+		}
+		if settings.Mode == "" {
+			settings.Mode = model.ReplicationModeEnabled
+			if exists {
+				settings.Mode = task.info.Mode
+			}
+		}
+		s.replications[name] = &replication{
+			info:     model.ReplicationInfo{Name: name, Mode: settings.Mode},
+			settings: settings,
+		}
+		return writeJSON(w, map[string]any{})
+	case http.MethodDelete:
+		if !exists {
+			return errorf(http.StatusNotFound, "Replication '%s' does not exist", name)
+		}
+		delete(s.replications, name)
+		return nil
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveLifecycles handles /lifecycles, /lifecycles/<name> and /lifecycles/<name>/mode.
+func (s *Server) serveLifecycles(w http.ResponseWriter, r *http.Request, tok *token, rest string) error {
+	if err := requireFullAccess(tok); err != nil {
+		return err
+	}
+
+	name, action, _ := strings.Cut(rest, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		policies := make([]model.LifecycleInfo, 0, len(s.lifecycles))
+		for _, policy := range s.lifecycles {
+			policies = append(policies, policy.info)
+		}
+		slices.SortFunc(policies, func(a, b model.LifecycleInfo) int { return strings.Compare(a.Name, b.Name) })
+		return writeJSON(w, map[string][]model.LifecycleInfo{"lifecycles": policies})
+	case name == "":
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	case action == "mode" && r.Method == http.MethodPatch:
+		var payload model.LifecycleModePayload
+		if err := readJSON(r, &payload); err != nil {
+			return err
+		}
+		policy, ok := s.lifecycles[name]
+		if !ok {
+			return errorf(http.StatusNotFound, "Lifecycle '%s' does not exist", name)
+		}
+		if !payload.Mode.IsValid() {
+			return errorf(http.StatusUnprocessableEntity, "Invalid lifecycle mode '%s'", payload.Mode)
+		}
+		policy.info.Mode = payload.Mode
+		policy.settings.Mode = payload.Mode
+		return nil
+	case action != "":
+		return errorf(http.StatusNotFound, "Not found")
+	}
+
+	policy, exists := s.lifecycles[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			return errorf(http.StatusNotFound, "Lifecycle '%s' does not exist", name)
+		}
+		info, settings := policy.info, policy.settings
+		return writeJSON(w, model.FullLifecycleInfo{Info: &info, Settings: &settings})
+	case http.MethodPost, http.MethodPut:
+		var settings model.LifecycleSettings
+		if err := readJSON(r, &settings); err != nil {
+			return err
+		}
+		if r.Method == http.MethodPost && exists {
+			return errorf(http.StatusConflict, "Lifecycle '%s' already exists", name)
+		}
+		if r.Method == http.MethodPut && !exists {
+			return errorf(http.StatusNotFound, "Lifecycle '%s' does not exist", name)
+		}
+		if _, ok := s.buckets[settings.Bucket]; !ok {
+			return errorf(http.StatusNotFound, "Bucket '%s' for lifecycle '%s' does not exist", settings.Bucket, name)
+		}
+		if settings.OlderThan == "" {
+			return errorf(http.StatusUnprocessableEntity, "older_than is required")
+		}
+		if settings.LifecycleType == "" {
+			settings.LifecycleType = model.LifecycleTypeDelete
+		}
+		if settings.Mode == "" {
+			settings.Mode = model.LifecycleModeEnabled
+			if exists {
+				settings.Mode = policy.info.Mode
+			}
+		}
+		s.lifecycles[name] = &lifecycle{
+			info:     model.LifecycleInfo{Name: name, LifecycleType: settings.LifecycleType, Mode: settings.Mode},
+			settings: settings,
+		}
+		return writeJSON(w, map[string]any{})
+	case http.MethodDelete:
+		if !exists {
+			return errorf(http.StatusNotFound, "Lifecycle '%s' does not exist", name)
+		}
+		delete(s.lifecycles, name)
+		return nil
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,587 @@
+package reducttest
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	timeHeaderPrefix  = "x-reduct-time-"
+	errorHeaderPrefix = "x-reduct-error-"
+	headerPrefix      = "x-reduct-"
+	entriesHeader     = "x-reduct-entries"
+	startTSHeader     = "x-reduct-start-ts"
+	labelsHeader      = "x-reduct-labels"
+	lastHeader        = "x-reduct-last"
+	queryIDHeader     = "x-reduct-query-id"
+	defaultType       = "application/octet-stream"
+)
+
+// batchRecord is a record of a batch request in the order of its payload.
+type batchRecord struct {
+	entry string
+	// errorKey identifies the record in the x-reduct-error-<key> response header.
+	errorKey string
+	rec      *record
+	// labelOps of an update; a nil value removes the label.
+	labelOps map[string]*string
+	// err is the failure of the record, reported in the response headers.
+	err error
+}
+
+// serveBatchV1 handles /b/<bucket>/<entry>/batch of Batch Protocol v1.
+func (s *Server) serveBatchV1(w http.ResponseWriter, r *http.Request, tok *token, bucketName, entryName string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if err := requireRead(tok, bucketName); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(r.URL.Query().Get("q"), 10, 64)
+		if err != nil {
+			return errorf(http.StatusUnprocessableEntity, "'q' parameter must be a query ID")
+		}
+		return s.readBatch(w, r, bucketName, id, false)
+	case http.MethodPost, http.MethodPatch, http.MethodDelete:
+		if err := requireWrite(tok, bucketName); err != nil {
+			return err
+		}
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+
+	records, err := parseBatchV1(r.Header, entryName, r.Method)
+	if err != nil {
+		return err
+	}
+	return s.applyBatch(w, r, bucketName, records)
+}
+
+// parseBatchV1 reads the x-reduct-time-<ts> headers of a v1 batch request.
+func parseBatchV1(header http.Header, entryName, method string) ([]*batchRecord, error) {
+	var records []*batchRecord
+	for key, values := range header {
+		raw, found := strings.CutPrefix(strings.ToLower(key), timeHeaderPrefix)
+		if !found {
+			continue
+		}
+		ts, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s': must be an unix timestamp in microseconds", key)
+		}
+
+		item := &batchRecord{entry: entryName, errorKey: raw, rec: &record{time: ts, labels: map[string]string{}}}
+		fields := splitRow(values[0])
+		if method != http.MethodDelete {
+			if len(fields) == 0 {
+				return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s': content-length is required", key)
+			}
+			if item.rec.data, err = parseSize(fields[0]); err != nil {
+				return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s': %v", key, err)
+			}
+			if len(fields) > 1 {
+				item.rec.contentType = fields[1]
+			}
+			item.labelOps = map[string]*string{}
+			for _, field := range fields[min(len(fields), 2):] {
+				name, value, ok := strings.Cut(field, "=")
+				if !ok {
+					continue
+				}
+				if value == "" {
+					item.labelOps[name] = nil
+				} else {
+					item.labelOps[name] = &value
+					item.rec.labels[name] = value
+				}
+			}
+		}
+		records = append(records, item)
+	}
+
+	slices.SortFunc(records, func(a, b *batchRecord) int { return cmp.Compare(a.rec.time, b.rec.time) })
+	return records, nil
+}
+
+// parseSize allocates the payload buffer of a record from its content length.
+func parseSize(raw string) ([]byte, error) {
+	size, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid content length '%s'", raw)
+	}
+	return make([]byte, size), nil
+}
+
+// splitRow splits a header row at commas outside of double quotes, keeping
+// empty fields and dropping the quotes.
+func splitRow(row string) []string {
+	if row == "" {
+		return nil
+	}
+	var fields []string
+	var current strings.Builder
+	quoted := false
+	for _, char := range row {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case char == ',' && !quoted:
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(char)
+		}
+	}
+	return append(fields, current.String())
+}
+
+// applyBatch writes, updates or removes the records of a batch request and
+// reports the records that failed in x-reduct-error-<key> headers.
+func (s *Server) applyBatch(w http.ResponseWriter, r *http.Request, bucketName string, records []*batchRecord) error {
+	if err := s.checkBatchLimits(r, records); err != nil {
+		return err
+	}
+	if r.Method == http.MethodPost {
+		for _, item := range records {
+			if _, err := io.ReadFull(r.Body, item.rec.data); err != nil {
+				return errorf(http.StatusBadRequest, "Content is smaller than in content-length")
+			}
+			if item.rec.contentType == "" {
+				item.rec.contentType = defaultType
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range records {
+		if item.err == nil {
+			item.err = applyBatchRecord(b, r.Method, item)
+		}
+		if item.err == nil {
+			continue
+		}
+
+		status := http.StatusInternalServerError
+		message := item.err.Error()
+		if httpErr, ok := item.err.(*httpError); ok {
+			status, message = httpErr.status, httpErr.message
+		}
+		w.Header().Set(errorHeaderPrefix+item.errorKey, fmt.Sprintf("%d,%s", status, message))
+	}
+	return nil
+}
+
+// checkBatchLimits rejects a batch request that exceeds Options.Limits.
+func (s *Server) checkBatchLimits(r *http.Request, records []*batchRecord) error {
+	limits := s.options.Limits
+	if limits == nil {
+		return nil
+	}
+	if limits.BatchMaxRecords > 0 && len(records) > limits.BatchMaxRecords {
+		return errorf(http.StatusRequestEntityTooLarge, "Batch has %d records, the limit is %d", len(records), limits.BatchMaxRecords)
+	}
+	if limits.BatchMaxSize > 0 && r.ContentLength > limits.BatchMaxSize {
+		return errorf(http.StatusRequestEntityTooLarge, "Batch has %d bytes, the limit is %d", r.ContentLength, limits.BatchMaxSize)
+	}
+	if limits.BatchMaxHeaderSize > 0 {
+		size := 0
+		for name, values := range r.Header {
+			if !strings.HasPrefix(strings.ToLower(name), "x-reduct-") {
+				continue
+			}
+			for _, value := range values {
+				size += len(name) + len(value) + len(": \r\n")
+			}
+		}
+		if size > limits.BatchMaxHeaderSize {
+			return errorf(http.StatusRequestHeaderFieldsTooLarge, "Batch has %d bytes of record headers, the limit is %d", size, limits.BatchMaxHeaderSize)
+		}
+	}
+	return nil
+}
+
+func applyBatchRecord(b *bucket, method string, item *batchRecord) error {
+	e, err := b.entry(item.entry, method == http.MethodPost)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case http.MethodPost:
+		return e.insert(item.rec)
+	case http.MethodPatch:
+		rec := e.get(item.rec.time)
+		if rec == nil {
+			return errorf(http.StatusNotFound, "No record with timestamp %d", item.rec.time)
+		}
+		update := map[string]string{}
+		for name, value := range item.labelOps {
+			if value != nil {
+				update[name] = *value
+			} else {
+				update[name] = ""
+			}
+		}
+		updateLabels(rec, update)
+		return nil
+	default:
+		return e.remove(item.rec.time)
+	}
+}
+
+// writeBatchV2 handles POST /io/<bucket>/write.
+func (s *Server) writeBatchV2(w http.ResponseWriter, r *http.Request, tok *token, bucketName string) error {
+	if err := requireWrite(tok, bucketName); err != nil {
+		return err
+	}
+	records, err := parseBatchV2(r.Header, true)
+	if err != nil {
+		return err
+	}
+	return s.applyBatch(w, r, bucketName, records)
+}
+
+// updateBatchV2 handles PATCH /io/<bucket>/update.
+func (s *Server) updateBatchV2(w http.ResponseWriter, r *http.Request, tok *token, bucketName string) error {
+	if err := requireWrite(tok, bucketName); err != nil {
+		return err
+	}
+	records, err := parseBatchV2(r.Header, false)
+	if err != nil {
+		return err
+	}
+	return s.applyBatch(w, r, bucketName, records)
+}
+
+// removeBatchV2 handles DELETE /io/<bucket>/remove.
+func (s *Server) removeBatchV2(w http.ResponseWriter, r *http.Request, tok *token, bucketName string) error {
+	if err := requireWrite(tok, bucketName); err != nil {
+		return err
+	}
+	records, err := parseBatchV2(r.Header, false)
+	if err != nil {
+		return err
+	}
+	return s.applyBatch(w, r, bucketName, records)
+}
+
+// parseBatchV2 reads the record headers x-reduct-<entry index>-<delta> of a
+// Batch Protocol v2 request. In a write the content type and labels of a
+// record default to those of the previous record of the same entry.
+func parseBatchV2(header http.Header, write bool) ([]*batchRecord, error) {
+	entries, err := parseHeaderList(header.Get(entriesHeader))
+	if err != nil {
+		return nil, errorf(http.StatusUnprocessableEntity, "Invalid %s header", entriesHeader)
+	}
+	startTS, err := strconv.ParseInt(header.Get(startTSHeader), 10, 64)
+	if err != nil && len(entries) > 0 {
+		return nil, errorf(http.StatusUnprocessableEntity, "Invalid %s header", startTSHeader)
+	}
+	labelNames, err := parseHeaderList(header.Get(labelsHeader))
+	if err != nil {
+		return nil, errorf(http.StatusUnprocessableEntity, "Invalid %s header", labelsHeader)
+	}
+
+	type indexed struct {
+		entryIndex int
+		delta      int64
+		key, value string
+	}
+	var headers []indexed
+	for key, values := range header {
+		lower := strings.ToLower(key)
+		suffix, found := strings.CutPrefix(lower, headerPrefix)
+		if !found || strings.HasPrefix(lower, errorHeaderPrefix) || strings.HasPrefix(lower, labelHeaderPrefix) {
+			continue
+		}
+		entryRaw, deltaRaw, found := strings.Cut(suffix, "-")
+		if !found {
+			continue
+		}
+		entryIndex, err1 := strconv.Atoi(entryRaw)
+		delta, err2 := strconv.ParseInt(deltaRaw, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if entryIndex < 0 || entryIndex >= len(entries) {
+			return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s': entry index out of range", key)
+		}
+		headers = append(headers, indexed{entryIndex: entryIndex, delta: delta, key: suffix, value: values[0]})
+	}
+	slices.SortFunc(headers, func(a, b indexed) int {
+		return cmp.Or(cmp.Compare(a.entryIndex, b.entryIndex), cmp.Compare(a.delta, b.delta))
+	})
+
+	previous := map[int]*record{}
+	records := make([]*batchRecord, 0, len(headers))
+	for _, h := range headers {
+		item := &batchRecord{
+			entry:    entries[h.entryIndex],
+			errorKey: h.key,
+			rec:      &record{time: startTS + h.delta, labels: map[string]string{}},
+		}
+		records = append(records, item)
+
+		sizeRaw, rest, hasMeta := strings.Cut(h.value, ",")
+		if h.value == "" {
+			// Remove requests carry no metadata.
+			continue
+		}
+		if item.rec.data, err = parseSize(sizeRaw); err != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s%s': %v", headerPrefix, h.key, err)
+		}
+		if !write {
+			item.rec.data = nil
+		}
+
+		prev := previous[h.entryIndex]
+		contentType, delta, hasLabels := strings.Cut(rest, ",")
+		if !hasMeta || contentType == "" {
+			contentType = defaultType
+			if prev != nil {
+				contentType = prev.contentType
+			}
+		}
+		item.rec.contentType = contentType
+		if write && prev != nil {
+			for name, value := range prev.labels {
+				item.rec.labels[name] = value
+			}
+		}
+
+		if hasLabels {
+			item.labelOps, err = parseLabelDelta(delta, labelNames)
+			if err != nil {
+				return nil, errorf(http.StatusUnprocessableEntity, "Invalid header '%s%s': %v", headerPrefix, h.key, err)
+			}
+			for name, value := range item.labelOps {
+				if value == nil {
+					delete(item.rec.labels, name)
+				} else {
+					item.rec.labels[name] = *value
+				}
+			}
+		}
+		previous[h.entryIndex] = item.rec
+	}
+	return records, nil
+}
+
+// parseLabelDelta parses `<label>=<value>,...` where a label is an index into
+// the x-reduct-labels header or a name, and an empty value removes the label.
+func parseLabelDelta(raw string, labelNames []string) (map[string]*string, error) {
+	ops := map[string]*string{}
+	for _, field := range splitRow(raw) {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label '%s'", field)
+		}
+		key = strings.TrimSpace(key)
+		if idx, err := strconv.Atoi(key); err == nil && len(labelNames) > 0 {
+			if idx < 0 || idx >= len(labelNames) {
+				return nil, fmt.Errorf("label index '%s' is out of range", key)
+			}
+			key = labelNames[idx]
+		}
+		if value == "" {
+			ops[key] = nil
+		} else {
+			ops[key] = &value
+		}
+	}
+	return ops, nil
+}
+
+func parseHeaderList(header string) ([]string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, nil
+	}
+	parts := strings.Split(header, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		decoded, err := url.PathUnescape(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, decoded)
+	}
+	return out, nil
+}
+
+func encodeHeaderList(values []string) string {
+	encoded := make([]string, 0, len(values))
+	for _, value := range values {
+		var builder strings.Builder
+		for _, b := range []byte(value) {
+			if isTchar(b) && b != '%' {
+				builder.WriteByte(b)
+			} else {
+				_, _ = fmt.Fprintf(&builder, "%%%02X", b)
+			}
+		}
+		encoded = append(encoded, builder.String())
+	}
+	return strings.Join(encoded, ",")
+}
+
+func isTchar(b byte) bool {
+	switch {
+	case b >= '0' && b <= '9', b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", b) >= 0
+}
+
+// formatLabelValue quotes values that contain commas.
+func formatLabelValue(value string) string {
+	if strings.Contains(value, ",") {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+// readBatch answers a batch read of a query with the next records, in the
+// format of Batch Protocol v1 or v2.
+func (s *Server) readBatch(w http.ResponseWriter, r *http.Request, bucketName string, id int64, v2 bool) error {
+	items, last, err := s.nextBatch(bucketName, id)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	var size int64
+	for _, item := range items {
+		size += int64(len(item.rec.data))
+	}
+	header := w.Header()
+	if v2 {
+		items = encodeBatchV2(header, items)
+	} else {
+		for _, item := range items {
+			header.Set(timeHeaderPrefix+strconv.FormatInt(item.rec.time, 10), formatRowV1(item.rec))
+		}
+	}
+	header.Set("Content-Type", defaultType)
+	header.Set(lastHeader, strconv.FormatBool(last))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	for _, item := range items {
+		if _, err := w.Write(item.rec.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatRowV1(rec *record) string {
+	fields := []string{strconv.Itoa(len(rec.data)), rec.contentType}
+	for _, name := range sortedKeys(rec.labels) {
+		fields = append(fields, name+"="+formatLabelValue(rec.labels[name]))
+	}
+	return strings.Join(fields, ",")
+}
+
+// encodeBatchV2 sets the headers of a v2 batch and returns the records in the
+// order of their payloads: by entry, then by time.
+func encodeBatchV2(header http.Header, items []queryItem) []queryItem {
+	var entries []string
+	entryIndex := map[string]int{}
+	startTS := items[0].rec.time
+	for _, item := range items {
+		if _, ok := entryIndex[item.entry]; !ok {
+			entryIndex[item.entry] = len(entries)
+			entries = append(entries, item.entry)
+		}
+		startTS = min(startTS, item.rec.time)
+	}
+	slices.SortStableFunc(items, func(a, b queryItem) int {
+		return cmp.Or(cmp.Compare(entryIndex[a.entry], entryIndex[b.entry]), cmp.Compare(a.rec.time, b.rec.time))
+	})
+
+	var labelNames []string
+	labelIndex := map[string]int{}
+	indexOf := func(name string) string {
+		idx, ok := labelIndex[name]
+		if !ok {
+			idx = len(labelNames)
+			labelIndex[name] = idx
+			labelNames = append(labelNames, name)
+		}
+		return strconv.Itoa(idx)
+	}
+
+	previous := map[string]map[string]string{}
+	for _, item := range items {
+		prev := previous[item.entry]
+		var ops []string
+		for _, name := range sortedKeys(item.rec.labels) {
+			value := item.rec.labels[name]
+			if old, ok := prev[name]; ok && old == value {
+				continue
+			}
+			ops = append(ops, indexOf(name)+"="+formatLabelValue(value))
+		}
+		for _, name := range sortedKeys(prev) {
+			if _, ok := item.rec.labels[name]; !ok {
+				ops = append(ops, indexOf(name)+"=")
+			}
+		}
+		previous[item.entry] = item.rec.labels
+
+		value := strconv.Itoa(len(item.rec.data)) + "," + item.rec.contentType
+		if len(ops) > 0 {
+			value += "," + strings.Join(ops, ",")
+		}
+		key := fmt.Sprintf("%s%d-%d", headerPrefix, entryIndex[item.entry], item.rec.time-startTS)
+		header.Set(key, value)
+	}
+
+	header.Set(entriesHeader, encodeHeaderList(entries))
+	header.Set(startTSHeader, strconv.FormatInt(startTS, 10))
+	if len(labelNames) > 0 {
+		header.Set(labelsHeader, encodeHeaderList(labelNames))
+	}
+	return items
+}
+
+// readBatchV2 handles GET and HEAD /io/<bucket>/read.
+func (s *Server) readBatchV2(w http.ResponseWriter, r *http.Request, tok *token, bucketName string) error {
+	if err := requireRead(tok, bucketName); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(r.Header.Get(queryIDHeader), 10, 64)
+	if err != nil {
+		return errorf(http.StatusUnprocessableEntity, "%s header must be a query ID", queryIDHeader)
+	}
+	return s.readBatch(w, r, bucketName, id, true)
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,643 @@
+package reducttest
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	queryTypeQuery  = "QUERY"
+	queryTypeRemove = "REMOVE"
+)
+
+// queryRequest is the body of the entry and the Batch Protocol v2 query requests.
+type queryRequest struct {
+	QueryType    string   `json:"query_type"`
+	Entries      []string `json:"entries,omitempty"`
+	Start        int64    `json:"start,omitempty"`
+	Stop         int64    `json:"stop,omitempty"`
+	When         any      `json:"when,omitempty"`
+	Strict       bool     `json:"strict,omitempty"`
+	Continuous   bool     `json:"continuous,omitempty"`
+	Head         bool     `json:"head,omitempty"`
+	OnlyMetadata bool     `json:"only_metadata,omitempty"`
+}
+
+type query struct {
+	bucket  string
+	request queryRequest
+	// next is the earliest timestamp that is not read yet, per entry.
+	next    map[string]int64
+	expires time.Time
+}
+
+// queryItem is a record matched by a query.
+type queryItem struct {
+	entry string
+	rec   *record
+}
+
+// createEntryQuery handles POST /b/<bucket>/<entry>/q.
+func (s *Server) createEntryQuery(w http.ResponseWriter, r *http.Request, tok *token, bucketName, entryName string) error {
+	var request queryRequest
+	if err := readJSON(r, &request); err != nil {
+		return err
+	}
+	request.Entries = []string{entryName}
+	return s.createQuery(w, tok, bucketName, request)
+}
+
+// createIOQuery handles POST /io/<bucket>/q.
+func (s *Server) createIOQuery(w http.ResponseWriter, r *http.Request, tok *token, bucketName string) error {
+	var request queryRequest
+	if err := readJSON(r, &request); err != nil {
+		return err
+	}
+	if len(request.Entries) == 0 {
+		request.Entries = []string{"*"}
+	}
+	return s.createQuery(w, tok, bucketName, request)
+}
+
+func (s *Server) createQuery(w http.ResponseWriter, tok *token, bucketName string, request queryRequest) error {
+	if request.QueryType == "" {
+		request.QueryType = queryTypeQuery
+	}
+	switch request.QueryType {
+	case queryTypeQuery:
+		if err := requireRead(tok, bucketName); err != nil {
+			return err
+		}
+	case queryTypeRemove:
+		if err := requireWrite(tok, bucketName); err != nil {
+			return err
+		}
+	default:
+		return errorf(http.StatusUnprocessableEntity, "Unknown query type '%s'", request.QueryType)
+	}
+	if request.Stop != 0 && request.Start > request.Stop {
+		return errorf(http.StatusUnprocessableEntity, "Start timestamp cannot be older stop timestamp")
+	}
+	if err := validateCondition(request.When); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range request.Entries {
+		if !strings.Contains(pattern, "*") && b.entries[pattern] == nil {
+			return errorf(http.StatusNotFound, "Entry '%s' not found in bucket '%s'", pattern, bucketName)
+		}
+	}
+
+	if request.QueryType == queryTypeRemove {
+		items, err := matchRecords(b, request, nil)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := b.entries[item.entry].remove(item.rec.time); err != nil {
+				return err
+			}
+		}
+		return writeJSON(w, map[string]int{"removed_records": len(items)})
+	}
+
+	s.expireQueries()
+	s.lastQueryID++
+	s.queries[s.lastQueryID] = &query{
+		bucket:  bucketName,
+		request: request,
+		next:    map[string]int64{},
+		expires: time.Now().Add(s.options.QueryLifetime),
+	}
+	return writeJSON(w, map[string]int64{"id": s.lastQueryID})
+}
+
+// expireQueries drops the queries that were not read for the query lifetime;
+// the caller holds the lock.
+func (s *Server) expireQueries() {
+	now := time.Now()
+	for id, q := range s.queries {
+		if now.After(q.expires) {
+			delete(s.queries, id)
+		}
+	}
+}
+
+// nextBatch returns the next records of a query and whether they are the last
+// ones. A finished query is removed.
+func (s *Server) nextBatch(bucketName string, id int64) ([]queryItem, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireQueries()
+	q := s.queries[id]
+	if q == nil || q.bucket != bucketName {
+		return nil, false, errorf(http.StatusNotFound, "Query %d not found or expired", id)
+	}
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	items, err := matchRecords(b, q.request, q.next)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var size int64
+	count := 0
+	for _, item := range items {
+		if count >= s.options.BatchRecords || size >= s.options.BatchSize {
+			break
+		}
+		count++
+		size += int64(len(item.rec.data))
+		q.next[item.entry] = item.rec.time + 1
+	}
+
+	last := !q.request.Continuous && count == len(items)
+	if last {
+		delete(s.queries, id)
+	} else {
+		q.expires = time.Now().Add(s.options.QueryLifetime)
+	}
+	return items[:count], last, nil
+}
+
+// matchRecords returns the records of a bucket that match a query and are not
+// read yet, ordered by time and entry; the caller holds the lock.
+func matchRecords(b *bucket, request queryRequest, next map[string]int64) ([]queryItem, error) {
+	var items []queryItem
+	for _, name := range sortedEntries(b) {
+		if !slices.ContainsFunc(request.Entries, func(pattern string) bool { return matchPattern(pattern, name) }) {
+			continue
+		}
+
+		start := max(request.Start, next[name])
+		e := b.entries[name]
+		idx, _ := e.find(start)
+		for _, rec := range e.records[idx:] {
+			if request.Stop != 0 && rec.time >= request.Stop {
+				break
+			}
+			ok, err := matchCondition(request.When, rec.labels, request.Strict)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				items = append(items, queryItem{entry: name, rec: rec})
+			}
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b queryItem) int {
+		return cmp.Or(cmp.Compare(a.rec.time, b.rec.time), strings.Compare(a.entry, b.entry))
+	})
+	return items, nil
+}
+
+func sortedEntries(b *bucket) []string {
+	names := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// matchPattern matches an entry name against a pattern where '*' stands for
+// any sequence of characters, including slashes.
+func matchPattern(pattern, name string) bool {
+	head, rest, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, head) {
+		return false
+	}
+	name = name[len(head):]
+	for i := 0; i <= len(name); i++ {
+		if matchPattern(rest, name[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+var supportedOperators = map[string]bool{
+	"$and": true, "$or": true, "$not": true,
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$exists": true, "$cast": true,
+}
+
+// validateCondition rejects conditions with operators the server does not support.
+func validateCondition(node any) error {
+	switch value := node.(type) {
+	case map[string]any:
+		for key, operand := range value {
+			if strings.HasPrefix(key, "$") && !strings.HasPrefix(key, "$$") && !supportedOperators[key] {
+				return errorf(http.StatusUnprocessableEntity, "Operator '%s' not supported", key)
+			}
+			if err := validateCondition(operand); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, operand := range value {
+			if err := validateCondition(operand); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchCondition evaluates a `when` condition on the labels of a record. A nil
+// condition matches every record.
+func matchCondition(when any, labels map[string]string, strict bool) (bool, error) {
+	if when == nil {
+		return true, nil
+	}
+	value, err := evaluate(when, labels, strict)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// missing is the value of a reference to a label the record does not have.
+type missing struct{}
+
+func evaluate(node any, labels map[string]string, strict bool) (any, error) {
+	switch value := node.(type) {
+	case string:
+		if name, found := strings.CutPrefix(value, "&"); found {
+			label, ok := labels[name]
+			if !ok {
+				if strict {
+					return nil, errorf(http.StatusNotFound, "Reference '%s' not found", value)
+				}
+				return missing{}, nil
+			}
+			return label, nil
+		}
+		if escaped, found := strings.CutPrefix(value, "$$"); found {
+			return "$" + escaped, nil
+		}
+		return value, nil
+	case []any:
+		out := make([]any, 0, len(value))
+		for _, item := range value {
+			evaluated, err := evaluate(item, labels, strict)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, evaluated)
+		}
+		return out, nil
+	case map[string]any:
+		return evaluateObject(value, labels, strict)
+	default:
+		return value, nil
+	}
+}
+
+// evaluateObject evaluates `{"$op": [args]}` and `{"<operand>": {"$op": arg}}`
+// expressions. Several keys are combined with AND.
+func evaluateObject(object map[string]any, labels map[string]string, strict bool) (any, error) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var results []any
+	for _, key := range keys {
+		operand := object[key]
+		if supportedOperators[key] {
+			args, ok := operand.([]any)
+			if !ok {
+				args = []any{operand}
+			}
+			result, err := apply(key, args, labels, strict)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+			continue
+		}
+
+		operators, ok := operand.(map[string]any)
+		if !ok {
+			return nil, errorf(http.StatusUnprocessableEntity, "Invalid condition for '%s'", key)
+		}
+		for op, arg := range operators {
+			args := []any{key}
+			if list, isList := arg.([]any); isList && (op == "$in" || op == "$nin") {
+				args = append(args, list...)
+			} else {
+				args = append(args, arg)
+			}
+			result, err := apply(op, args, labels, strict)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	for _, result := range results {
+		if !truthy(result) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func apply(op string, rawArgs []any, labels map[string]string, strict bool) (any, error) {
+	if op == "$exists" {
+		if len(rawArgs) != 2 {
+			return nil, errorf(http.StatusUnprocessableEntity, "Operator '%s' requires two operands", op)
+		}
+		name, _ := rawArgs[0].(string)
+		_, exists := labels[strings.TrimPrefix(name, "&")]
+		return exists == truthy(rawArgs[1]), nil
+	}
+
+	value, err := evaluate(rawArgs, labels, strict)
+	if err != nil {
+		return nil, err
+	}
+	args, _ := value.([]any)
+
+	switch op {
+	case "$and":
+		for _, arg := range args {
+			if !truthy(arg) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, arg := range args {
+			if truthy(arg) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		if len(args) != 1 {
+			return nil, errorf(http.StatusUnprocessableEntity, "Operator '%s' requires one operand", op)
+		}
+		return !truthy(args[0]), nil
+	case "$in", "$nin":
+		if len(args) == 0 {
+			return nil, errorf(http.StatusUnprocessableEntity, "Operator '%s' requires operands", op)
+		}
+		found := false
+		for _, arg := range args[1:] {
+			if c, ok := compare(args[0], arg); ok && c == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in"), nil
+	case "$cast":
+		if len(args) != 2 {
+			return nil, errorf(http.StatusUnprocessableEntity, "Operator '%s' requires two operands", op)
+		}
+		return cast(args[0], fmt.Sprint(args[1]))
+	}
+
+	if len(args) != 2 {
+		return nil, errorf(http.StatusUnprocessableEntity, "Operator '%s' requires two operands", op)
+	}
+	c, ok := compare(args[0], args[1])
+	if !ok {
+		return false, nil
+	}
+	switch op {
+	case "$eq":
+		return c == 0, nil
+	case "$ne":
+		return c != 0, nil
+	case "$gt":
+		return c > 0, nil
+	case "$gte":
+		return c >= 0, nil
+	case "$lt":
+		return c < 0, nil
+	default: // $lte
+		return c <= 0, nil
+	}
+}
+
+// compare compares two values as numbers when both are numeric and as
+// strings otherwise. It fails if a value is a missing label.
+func compare(a, b any) (int, bool) {
+	if _, ok := a.(missing); ok {
+		return 0, false
+	}
+	if _, ok := b.(missing); ok {
+		return 0, false
+	}
+	x, xOK := number(a)
+	y, yOK := number(b)
+	if xOK && yOK {
+		return cmp.Compare(x, y), true
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), true
+}
+
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func cast(value any, kind string) (any, error) {
+	if _, ok := value.(missing); ok {
+		return value, nil
+	}
+	switch kind {
+	case "string":
+		return fmt.Sprint(value), nil
+	case "int", "float":
+		f, ok := number(value)
+		if !ok {
+			return nil, errorf(http.StatusUnprocessableEntity, "Value '%v' can't be cast to %s", value, kind)
+		}
+		if kind == "int" {
+			return float64(int64(f)), nil
+		}
+		return f, nil
+	case "bool":
+		return truthy(value), nil
+	default:
+		return nil, errorf(http.StatusUnprocessableEntity, "Unknown type '%s'", kind)
+	}
+}
+
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		return v != ""
+	default:
+		return false
+	}
+}
+
+// link is a query link created by POST /links/<file>.
+type link struct {
+	bucket      string
+	recordEntry string
+	recordTime  *int64
+	request     queryRequest
+	index       int
+	expireAt    int64
+}
+
+// serveLink creates query links with POST /links/<file> and serves the
+// record of a link with GET /links/<file>?ct=<id>. Downloads need no token.
+func (s *Server) serveLink(w http.ResponseWriter, r *http.Request, fileName string) error {
+	switch r.Method {
+	case http.MethodPost:
+		return s.createLink(w, r, fileName)
+	case http.MethodGet, http.MethodHead:
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+
+	s.mu.Lock()
+	l := s.links[r.URL.Query().Get("ct")]
+	if l == nil {
+		s.mu.Unlock()
+		return errorf(http.StatusNotFound, "Link not found")
+	}
+	if time.Now().Unix() > l.expireAt {
+		s.mu.Unlock()
+		return errorf(http.StatusUnprocessableEntity, "Query link has expired")
+	}
+	rec, err := s.linkedRecord(l)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", rec.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(rec.data)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = w.Write(rec.data)
+	return err
+}
+
+// linkedRecord finds the record of a link; the caller holds the lock.
+func (s *Server) linkedRecord(l *link) (*record, error) {
+	if l.recordEntry != "" && l.recordTime != nil {
+		return s.record(l.bucket, l.recordEntry, *l.recordTime)
+	}
+
+	b, err := s.bucket(l.bucket)
+	if err != nil {
+		return nil, err
+	}
+	items, err := matchRecords(b, l.request, nil)
+	if err != nil {
+		return nil, err
+	}
+	if l.index >= len(items) {
+		return nil, errorf(http.StatusNotFound, "Record number %d not found", l.index)
+	}
+	return items[l.index].rec, nil
+}
+
+func (s *Server) createLink(w http.ResponseWriter, r *http.Request, fileName string) error {
+	tok, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	var payload struct {
+		Bucket      string       `json:"bucket"`
+		Entry       string       `json:"entry"`
+		RecordEntry string       `json:"record_entry"`
+		RecordTime  *int64       `json:"record_timestamp"`
+		Query       queryRequest `json:"query"`
+		Index       int          `json:"index"`
+		ExpireAt    int64        `json:"expire_at"`
+		BaseURL     string       `json:"base_url"`
+	}
+	if err := readJSON(r, &payload); err != nil {
+		return err
+	}
+	if err := requireRead(tok, payload.Bucket); err != nil {
+		return err
+	}
+	if err := validateCondition(payload.Query.When); err != nil {
+		return err
+	}
+	if len(payload.Query.Entries) == 0 {
+		payload.Query.Entries = []string{payload.Entry}
+	}
+
+	s.mu.Lock()
+	_, err = s.bucket(payload.Bucket)
+	key := rand.Text()
+	if err == nil {
+		s.links[key] = &link{
+			bucket:      payload.Bucket,
+			recordEntry: payload.RecordEntry,
+			recordTime:  payload.RecordTime,
+			request:     payload.Query,
+			index:       payload.Index,
+			expireAt:    payload.ExpireAt,
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	base := payload.BaseURL
+	if base == "" {
+		base = s.URL
+	}
+	target := fmt.Sprintf("%s%s/links/%s?ct=%s", strings.TrimSuffix(base, "/"), apiPrefix, url.PathEscape(fileName), key)
+	return writeJSON(w, map[string]string{"link": target})
+}
@@ -0,0 +1,306 @@
+// Package reducttest provides an in-memory ReductStore server for unit tests.
+//
+// The server implements the part of the HTTP API that the SDK uses: buckets,
+// entries, single records, Batch Protocol v1 and v2, queries with continuous
+// mode and basic `when` conditions, tokens, replication tasks, lifecycle
+// policies and query links. All data lives in memory and is lost when the
+// server is closed. It is not a reference implementation: quotas, extensions,
+// computed labels and replication itself are not supported.
+//
+//	server := reducttest.NewServer(reducttest.Options{})
+//	defer server.Close()
+//
+//	client := reductgo.NewClient(server.URL, reductgo.ClientOptions{})
+package reducttest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+const (
+	apiPrefix            = "/api/v1"
+	defaultBatchRecords  = 85
+	defaultBatchSize     = 8 * 1024 * 1024
+	defaultQueryLifetime = time.Minute
+)
+
+// Options configures a Server.
+type Options struct {
+	// APIToken enables authentication. Requests must then carry this token or
+	// one created through the API. Empty disables authentication.
+	APIToken string
+	// Version is the server version reported by /info and the X-Reduct-API
+	// header. Defaults to the version of the SDK.
+	Version string
+	// BatchRecords caps the number of records in one query batch. Defaults to 85.
+	BatchRecords int
+	// BatchSize closes a query batch once its payload reaches this many bytes.
+	// Defaults to 8 MiB.
+	BatchSize int64
+	// QueryLifetime is how long a query that is not read is kept. Defaults to one minute.
+	QueryLifetime time.Duration
+	// Limits are reported by /info and enforced on batch writes, updates and
+	// removals. Nil disables them.
+	Limits *model.ServerLimits
+}
+
+// Server is an in-memory ReductStore server listening on a local address.
+// Use its URL field as the URL of a client.
+type Server struct {
+	*httptest.Server
+
+	options Options
+	started time.Time
+
+	mu           sync.Mutex
+	buckets      map[string]*bucket
+	tokens       map[string]*token
+	replications map[string]*replication
+	lifecycles   map[string]*lifecycle
+	queries      map[int64]*query
+	links        map[string]*link
+	lastQueryID  int64
+}
+
+// NewServer starts a new server. Close it when the test is done.
+func NewServer(options Options) *Server {
+	s := NewUnstartedServer(options)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server that is not started yet, e.g. to
+// configure TLS. Call Start or StartTLS before using it.
+func NewUnstartedServer(options Options) *Server {
+	if options.Version == "" {
+		options.Version = model.GetVersion()
+	}
+	if options.BatchRecords <= 0 {
+		options.BatchRecords = defaultBatchRecords
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.QueryLifetime <= 0 {
+		options.QueryLifetime = defaultQueryLifetime
+	}
+
+	s := &Server{
+		options:      options,
+		started:      time.Now(),
+		buckets:      map[string]*bucket{},
+		tokens:       map[string]*token{},
+		replications: map[string]*replication{},
+		lifecycles:   map[string]*lifecycle{},
+		queries:      map[int64]*query{},
+		links:        map[string]*link{},
+	}
+	if options.APIToken != "" {
+		s.tokens[initTokenName] = &token{
+			info: model.Token{
+				Name:          initTokenName,
+				CreatedAt:     s.started.UTC().Format(time.RFC3339),
+				IsProvisioned: true,
+				Permissions:   &model.TokenPermissions{FullAccess: true},
+			},
+			value: options.APIToken,
+		}
+	}
+	s.Server = httptest.NewUnstartedServer(s)
+	return s
+}
+
+// httpError is an error answered with a status and an x-reduct-error header.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d: %s", e.status, e.message)
+}
+
+func errorf(status int, format string, args ...any) error {
+	return &httpError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Reduct-API", apiVersion(s.options.Version))
+
+	err := s.serve(w, r)
+	if err == nil {
+		return
+	}
+
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) {
+		httpErr = &httpError{status: http.StatusInternalServerError, message: err.Error()}
+	}
+	w.Header().Set("X-Reduct-Error", httpErr.message)
+	w.WriteHeader(httpErr.status)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	path, found := strings.CutPrefix(r.URL.Path, apiPrefix)
+	if !found {
+		return errorf(http.StatusNotFound, "Not found")
+	}
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+
+	switch resource {
+	case "alive":
+		return nil
+	case "links":
+		return s.serveLink(w, r, rest)
+	}
+
+	tok, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	switch resource {
+	case "info":
+		return s.serveInfo(w, r)
+	case "me":
+		return s.serveMe(w, r, tok)
+	case "list":
+		return s.serveList(w, r, tok)
+	case "tokens":
+		return s.serveTokens(w, r, tok, rest)
+	case "replications":
+		return s.serveReplications(w, r, tok, rest)
+	case "lifecycles":
+		return s.serveLifecycles(w, r, tok, rest)
+	case "b":
+		return s.serveBucketPath(w, r, tok, rest)
+	case "io":
+		return s.serveIO(w, r, tok, rest)
+	default:
+		return errorf(http.StatusNotFound, "Not found")
+	}
+}
+
+// serveBucketPath dispatches /b/<bucket>[/<entry>[/batch|/q|/rename]].
+// Entry names may contain slashes, e.g. the `<entry>/$meta` attachments.
+func (s *Server) serveBucketPath(w http.ResponseWriter, r *http.Request, tok *token, rest string) error {
+	bucketName, entryPath, _ := strings.Cut(rest, "/")
+	switch {
+	case entryPath == "":
+		return s.serveBucket(w, r, tok, bucketName)
+	case entryPath == "rename" && r.Method == http.MethodPut:
+		return s.renameBucket(w, r, tok, bucketName)
+	}
+
+	if entry, found := strings.CutSuffix(entryPath, "/batch"); found {
+		return s.serveBatchV1(w, r, tok, bucketName, entry)
+	}
+	if entry, found := strings.CutSuffix(entryPath, "/q"); found && r.Method == http.MethodPost {
+		return s.createEntryQuery(w, r, tok, bucketName, entry)
+	}
+	if entry, found := strings.CutSuffix(entryPath, "/rename"); found && r.Method == http.MethodPut {
+		return s.renameEntry(w, r, tok, bucketName, entry)
+	}
+	return s.serveRecord(w, r, tok, bucketName, entryPath)
+}
+
+// serveIO dispatches /io/<bucket>/{write,update,remove,q,read} of Batch Protocol v2.
+func (s *Server) serveIO(w http.ResponseWriter, r *http.Request, tok *token, rest string) error {
+	bucketName, action, _ := strings.Cut(rest, "/")
+	switch {
+	case action == "write" && r.Method == http.MethodPost:
+		return s.writeBatchV2(w, r, tok, bucketName)
+	case action == "update" && r.Method == http.MethodPatch:
+		return s.updateBatchV2(w, r, tok, bucketName)
+	case action == "remove" && r.Method == http.MethodDelete:
+		return s.removeBatchV2(w, r, tok, bucketName)
+	case action == "q" && r.Method == http.MethodPost:
+		return s.createIOQuery(w, r, tok, bucketName)
+	case action == "read" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		return s.readBatchV2(w, r, tok, bucketName)
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+
+	s.mu.Lock()
+	info := model.ServerInfo{
+		Version:     s.options.Version,
+		BucketCount: int64(len(s.buckets)),
+		Uptime:      uint64(time.Since(s.started).Seconds()),
+		Defaults:    model.ServerDefaults{Bucket: defaultBucketSettings()},
+		Limits:      s.options.Limits,
+	}
+	for _, b := range s.buckets {
+		stats := b.info()
+		info.Usage += uint64(max(stats.Size, 0)) // #nosec G115 -- sizes are never negative
+		if stats.EntryCount == 0 {
+			continue
+		}
+		if info.OldestRecord == 0 || stats.OldestRecord < info.OldestRecord {
+			info.OldestRecord = stats.OldestRecord
+		}
+		info.LatestRecord = max(info.LatestRecord, stats.LatestRecord)
+	}
+	s.mu.Unlock()
+
+	return writeJSON(w, info)
+}
+
+// apiVersion returns the major.minor part of a server version.
+func apiVersion(version string) string {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// readJSON decodes a request body; an empty body leaves value unchanged.
+func readJSON(r *http.Request, value any) error {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil && !errors.Is(err, io.EOF) {
+		return errorf(http.StatusUnprocessableEntity, "Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = w.Write(data)
+	return err
+}
+
+// parseTimestamp parses the ts query parameter of a record request.
+func parseTimestamp(r *http.Request) (int64, bool, error) {
+	raw := r.URL.Query().Get("ts")
+	if raw == "" {
+		return 0, false, nil
+	}
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, false, errorf(http.StatusUnprocessableEntity, "'ts' must be an unix timestamp in microseconds")
+	}
+	return ts, true, nil
+}
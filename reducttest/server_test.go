@@ -0,0 +1,228 @@
+package reducttest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	reductgo "github.com/reductstore/reduct-go"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+func newTestBucket(t *testing.T, options reducttest.Options) (*reducttest.Server, reductgo.BucketAPI) {
+	t.Helper()
+	server := reducttest.NewServer(options)
+	t.Cleanup(server.Close)
+
+	client := reductgo.NewClient(server.URL, reductgo.ClientOptions{APIToken: options.APIToken})
+	bucket, err := client.CreateBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return server, bucket
+}
+
+func readAll(t *testing.T, result *reductgo.QueryResult) map[int64]string {
+	t.Helper()
+	records := map[int64]string{}
+	for record := range result.Records() {
+		data, err := record.Read()
+		require.NoError(t, err)
+		records[record.Time()] = string(data)
+	}
+	require.NoError(t, result.Err())
+	return records
+}
+
+func TestServerRecords(t *testing.T) {
+	ctx := context.Background()
+	_, bucket := newTestBucket(t, reducttest.Options{})
+
+	err := bucket.BeginWrite(ctx, "entry", &reductgo.WriteOptions{
+		Timestamp: 1000,
+		Labels:    reductgo.LabelMap{"type": "a"},
+	}).Write("hello")
+	require.NoError(t, err)
+
+	err = bucket.BeginWrite(ctx, "entry", &reductgo.WriteOptions{Timestamp: 1000}).Write("again")
+	assert.True(t, errors.Is(err, model.ErrConflict))
+
+	require.NoError(t, bucket.Update(ctx, "entry", 1000, reductgo.LabelMap{"type": "", "state": "ok"}))
+
+	ts := int64(1000)
+	record, err := bucket.BeginRead(ctx, "entry", &ts)
+	require.NoError(t, err)
+	data, err := record.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, reductgo.LabelMap{"state": "ok"}, record.Labels())
+
+	info, err := bucket.GetInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), info.EntryCount)
+	assert.Equal(t, int64(5), info.Size)
+
+	_, err = bucket.BeginRead(ctx, "missing", nil)
+	assert.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func TestServerBatches(t *testing.T) {
+	ctx := context.Background()
+	_, bucket := newTestBucket(t, reducttest.Options{})
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("one"), "text/plain", reductgo.LabelMap{"note": "a,b"})
+	batch.Add(2, []byte("two"), "text/plain", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(2, []byte("dup"), "text/plain", nil)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, errs[2].Status)
+
+	records := bucket.BeginWriteRecordBatch(ctx)
+	records.Add("entry", 3, []byte("three"), "text/plain", reductgo.LabelMap{"note": "c"})
+	records.Add("other", 3, []byte("other"), "", nil)
+	recordErrs, err := records.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	removal := bucket.BeginRemoveRecordBatch(ctx)
+	removal.AddOnlyTimestamp("entry", 2)
+	removal.AddOnlyTimestamp("entry", 10)
+	recordErrs, err = removal.Send(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, recordErrs["entry"][10].Status)
+
+	result, err := bucket.QueryMany(ctx, []string{"entry", "other"}, nil)
+	require.NoError(t, err)
+	var labels []reductgo.LabelMap
+	for record := range result.Records() {
+		labels = append(labels, record.Labels())
+	}
+	assert.Equal(t, []reductgo.LabelMap{{"note": "a,b"}, {"note": "c"}, {}}, labels)
+}
+
+func TestServerQueries(t *testing.T) {
+	ctx := context.Background()
+	_, bucket := newTestBucket(t, reducttest.Options{BatchRecords: 2})
+
+	batch := bucket.BeginWriteBatch(ctx, "sensor/1")
+	for ts, value := range []string{"1", "5", "10", "20"} {
+		batch.Add(int64(ts), []byte(value), "text/plain", reductgo.LabelMap{"value": value})
+	}
+	_, err := batch.Write(ctx)
+	require.NoError(t, err)
+
+	t.Run("when", func(t *testing.T) {
+		options := reductgo.NewQueryOptionsBuilder().
+			WithWhen(map[string]any{"&value": map[string]any{"$gte": 5}}).
+			WithStop(3).
+			Build()
+		result, err := bucket.Query(ctx, "sensor/*", &options)
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: "5", 2: "10"}, readAll(t, result))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		options := reductgo.NewQueryOptionsBuilder().
+			WithWhen(map[string]any{"&missing": map[string]any{"$eq": 1}}).
+			WithStrict(true).
+			Build()
+		_, err := bucket.Query(ctx, "sensor/1", &options)
+		assert.True(t, errors.Is(err, model.ErrNotFound))
+	})
+
+	t.Run("unsupported operator", func(t *testing.T) {
+		options := reductgo.NewQueryOptionsBuilder().
+			WithWhen(map[string]any{"$limit": 1}).
+			Build()
+		_, err := bucket.Query(ctx, "sensor/1", &options)
+		assert.True(t, errors.Is(err, model.ErrUnprocessable))
+	})
+
+	t.Run("continuous", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		options := reductgo.NewQueryOptionsBuilder().
+			WithStart(3).
+			WithContinuous(true).
+			WithPollInterval(10 * time.Millisecond).
+			Build()
+		result, err := bucket.Query(ctx, "sensor/1", &options)
+		require.NoError(t, err)
+
+		record := <-result.Records()
+		assert.Equal(t, int64(3), record.Time())
+
+		require.NoError(t, bucket.BeginWrite(ctx, "sensor/1", &reductgo.WriteOptions{Timestamp: 4}).Write("new"))
+		record = <-result.Records()
+		require.NotNil(t, record)
+		data, err := record.Read()
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(data))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		options := reductgo.NewQueryOptionsBuilder().WithStart(3).Build()
+		removed, err := bucket.RemoveQuery(ctx, "sensor/1", &options)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), removed)
+	})
+}
+
+func TestServerAuthentication(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestBucket(t, reducttest.Options{APIToken: "secret"})
+	admin := reductgo.NewClient(server.URL, reductgo.ClientOptions{APIToken: "secret"})
+
+	_, err := reductgo.NewClient(server.URL, reductgo.ClientOptions{APIToken: "wrong"}).GetBuckets(ctx)
+	assert.True(t, errors.Is(err, model.ErrUnauthorized))
+
+	value, err := admin.CreateToken(ctx, "reader", model.TokenPermissions{Read: []string{"bucket"}})
+	require.NoError(t, err)
+	reader := reductgo.NewClient(server.URL, reductgo.ClientOptions{APIToken: value})
+
+	bucket, err := reader.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+	err = bucket.BeginWrite(ctx, "entry", nil).Write("data")
+	assert.True(t, errors.Is(err, model.ErrForbidden))
+
+	_, err = reader.CreateBucket(ctx, "other", nil)
+	assert.True(t, errors.Is(err, model.ErrForbidden))
+}
+
+func TestServerQueryLink(t *testing.T) {
+	ctx := context.Background()
+	_, bucket := newTestBucket(t, reducttest.Options{})
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("linked"), "text/plain", nil)
+	_, err := batch.Write(ctx)
+	require.NoError(t, err)
+
+	link, err := bucket.CreateQueryLink(ctx, "entry", reductgo.NewQueryLinkOptionsBuilder().
+		WithRecordEntry("entry").
+		WithRecordTimestamp(1).
+		Build())
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "linked", string(data))
+}
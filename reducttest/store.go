@@ -0,0 +1,473 @@
+package reducttest
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+const labelHeaderPrefix = "x-reduct-label-"
+
+var bucketNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type record struct {
+	time        int64
+	data        []byte
+	contentType string
+	labels      map[string]string
+}
+
+type entry struct {
+	name    string
+	records []*record // ordered by time
+}
+
+type bucket struct {
+	name     string
+	settings model.BucketSetting
+	entries  map[string]*entry
+}
+
+func defaultBucketSettings() model.BucketSetting {
+	return model.BucketSetting{
+		MaxBlockSize:    64000000,
+		MaxBlockRecords: 256,
+		QuotaType:       model.QuotaTypeNone,
+	}
+}
+
+// merge applies the fields that are set in update.
+func mergeSettings(settings, update model.BucketSetting) model.BucketSetting {
+	if update.MaxBlockSize != 0 {
+		settings.MaxBlockSize = update.MaxBlockSize
+	}
+	if update.MaxBlockRecords != 0 {
+		settings.MaxBlockRecords = update.MaxBlockRecords
+	}
+	if update.QuotaType != "" {
+		settings.QuotaType = update.QuotaType
+	}
+	if update.QuotaSize != 0 {
+		settings.QuotaSize = update.QuotaSize
+	}
+	return settings
+}
+
+func (e *entry) find(ts int64) (int, bool) {
+	return slices.BinarySearchFunc(e.records, ts, func(r *record, ts int64) int {
+		switch {
+		case r.time < ts:
+			return -1
+		case r.time > ts:
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+func (e *entry) get(ts int64) *record {
+	if i, found := e.find(ts); found {
+		return e.records[i]
+	}
+	return nil
+}
+
+// insert adds a record unless one with the same timestamp exists.
+func (e *entry) insert(rec *record) error {
+	i, found := e.find(rec.time)
+	if found {
+		return errorf(http.StatusConflict, "A record with timestamp %d already exists", rec.time)
+	}
+	e.records = slices.Insert(e.records, i, rec)
+	return nil
+}
+
+func (e *entry) remove(ts int64) error {
+	i, found := e.find(ts)
+	if !found {
+		return errorf(http.StatusNotFound, "No record with timestamp %d", ts)
+	}
+	e.records = slices.Delete(e.records, i, i+1)
+	return nil
+}
+
+func (e *entry) info() model.EntryInfo {
+	info := model.EntryInfo{Name: e.name, RecordCount: int64(len(e.records)), Status: model.StatusReady}
+	for _, rec := range e.records {
+		info.Size += int64(len(rec.data))
+	}
+	if len(e.records) > 0 {
+		info.BlockCount = 1
+		info.OldestRecord = e.records[0].time
+		info.LatestRecord = e.records[len(e.records)-1].time
+	}
+	return info
+}
+
+func (b *bucket) info() model.BucketInfo {
+	info := model.BucketInfo{Name: b.name, EntryCount: int64(len(b.entries)), Status: model.StatusReady}
+	for _, e := range b.entries {
+		stats := e.info()
+		info.Size += stats.Size
+		if stats.RecordCount == 0 {
+			continue
+		}
+		oldest, latest := uint64(stats.OldestRecord), uint64(stats.LatestRecord) // #nosec G115 -- timestamps are never negative
+		if info.OldestRecord == 0 || oldest < info.OldestRecord {
+			info.OldestRecord = oldest
+		}
+		info.LatestRecord = max(info.LatestRecord, latest)
+	}
+	return info
+}
+
+// entry returns an entry of the bucket, creating it if create is set.
+func (b *bucket) entry(name string, create bool) (*entry, error) {
+	if e, ok := b.entries[name]; ok {
+		return e, nil
+	}
+	if !create {
+		return nil, errorf(http.StatusNotFound, "Entry '%s' not found in bucket '%s'", name, b.name)
+	}
+	e := &entry{name: name}
+	b.entries[name] = e
+	return e, nil
+}
+
+// bucket returns a bucket; the caller holds the lock.
+func (s *Server) bucket(name string) (*bucket, error) {
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Bucket '%s' is not found", name)
+	}
+	return b, nil
+}
+
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, tok *token) error {
+	if r.Method != http.MethodGet {
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+
+	s.mu.Lock()
+	buckets := make([]model.BucketInfo, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		if requireRead(tok, b.name) == nil {
+			buckets = append(buckets, b.info())
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(buckets, func(a, b model.BucketInfo) int { return strings.Compare(a.Name, b.Name) })
+	return writeJSON(w, map[string][]model.BucketInfo{"buckets": buckets})
+}
+
+// serveBucket handles /b/<bucket>.
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, tok *token, name string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if err := requireRead(tok, name); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		b, err := s.bucket(name)
+		if err != nil {
+			return err
+		}
+		if r.Method == http.MethodHead {
+			return nil
+		}
+
+		detail := model.FullBucketDetail{Settings: b.settings, Info: b.info(), Entries: []model.EntryInfo{}}
+		for _, e := range b.entries {
+			detail.Entries = append(detail.Entries, e.info())
+		}
+		slices.SortFunc(detail.Entries, func(a, b model.EntryInfo) int { return strings.Compare(a.Name, b.Name) })
+		return writeJSON(w, detail)
+	case http.MethodPost, http.MethodPut:
+		if err := requireFullAccess(tok); err != nil {
+			return err
+		}
+		var settings model.BucketSetting
+		if err := readJSON(r, &settings); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		b, exists := s.buckets[name]
+		if r.Method == http.MethodPut {
+			if !exists {
+				return errorf(http.StatusNotFound, "Bucket '%s' is not found", name)
+			}
+			b.settings = mergeSettings(b.settings, settings)
+			return nil
+		}
+
+		if exists {
+			return errorf(http.StatusConflict, "Bucket '%s' already exists", name)
+		}
+		if !bucketNamePattern.MatchString(name) {
+			return errorf(http.StatusUnprocessableEntity, "Bucket name can contain only letters, digits and [-,_] symbols")
+		}
+		s.buckets[name] = &bucket{
+			name:     name,
+			settings: mergeSettings(defaultBucketSettings(), settings),
+			entries:  map[string]*entry{},
+		}
+		return nil
+	case http.MethodDelete:
+		if err := requireFullAccess(tok); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, err := s.bucket(name); err != nil {
+			return err
+		}
+		delete(s.buckets, name)
+		return nil
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) renameBucket(_ http.ResponseWriter, r *http.Request, tok *token, name string) error {
+	if err := requireFullAccess(tok); err != nil {
+		return err
+	}
+	var payload struct {
+		NewName string `json:"new_name"`
+	}
+	if err := readJSON(r, &payload); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := s.buckets[payload.NewName]; exists {
+		return errorf(http.StatusConflict, "Bucket '%s' already exists", payload.NewName)
+	}
+	if !bucketNamePattern.MatchString(payload.NewName) {
+		return errorf(http.StatusUnprocessableEntity, "Bucket name can contain only letters, digits and [-,_] symbols")
+	}
+	delete(s.buckets, name)
+	b.name = payload.NewName
+	s.buckets[b.name] = b
+	return nil
+}
+
+func (s *Server) renameEntry(_ http.ResponseWriter, r *http.Request, tok *token, bucketName, name string) error {
+	if err := requireWrite(tok, bucketName); err != nil {
+		return err
+	}
+	var payload struct {
+		NewName string `json:"new_name"`
+	}
+	if err := readJSON(r, &payload); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	e, err := b.entry(name, false)
+	if err != nil {
+		return err
+	}
+	if _, exists := b.entries[payload.NewName]; exists {
+		return errorf(http.StatusConflict, "Entry '%s' already exists in bucket '%s'", payload.NewName, bucketName)
+	}
+	delete(b.entries, name)
+	e.name = payload.NewName
+	b.entries[e.name] = e
+	return nil
+}
+
+// serveRecord handles single record requests on /b/<bucket>/<entry>.
+func (s *Server) serveRecord(w http.ResponseWriter, r *http.Request, tok *token, bucketName, entryName string) error {
+	ts, hasTS, err := parseTimestamp(r)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if err := requireRead(tok, bucketName); err != nil {
+			return err
+		}
+		return s.readRecord(w, r, bucketName, entryName, ts, hasTS)
+	case http.MethodPost:
+		if err := requireWrite(tok, bucketName); err != nil {
+			return err
+		}
+		if !hasTS {
+			return errorf(http.StatusUnprocessableEntity, "'ts' parameter is required")
+		}
+		return s.writeRecord(r, bucketName, entryName, ts)
+	case http.MethodPatch:
+		if err := requireWrite(tok, bucketName); err != nil {
+			return err
+		}
+		if !hasTS {
+			return errorf(http.StatusUnprocessableEntity, "'ts' parameter is required")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		rec, err := s.record(bucketName, entryName, ts)
+		if err != nil {
+			return err
+		}
+		updateLabels(rec, labelsFromHeaders(r.Header))
+		return nil
+	case http.MethodDelete:
+		if err := requireWrite(tok, bucketName); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		b, err := s.bucket(bucketName)
+		if err != nil {
+			return err
+		}
+		e, err := b.entry(entryName, false)
+		if err != nil {
+			return err
+		}
+		if !hasTS {
+			delete(b.entries, entryName)
+			return nil
+		}
+		return e.remove(ts)
+	default:
+		return errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// record returns a stored record; the caller holds the lock.
+func (s *Server) record(bucketName, entryName string, ts int64) (*record, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	e, err := b.entry(entryName, false)
+	if err != nil {
+		return nil, err
+	}
+	rec := e.get(ts)
+	if rec == nil {
+		return nil, errorf(http.StatusNotFound, "No record with timestamp %d", ts)
+	}
+	return rec, nil
+}
+
+func (s *Server) readRecord(w http.ResponseWriter, r *http.Request, bucketName, entryName string, ts int64, hasTS bool) error {
+	s.mu.Lock()
+	var rec *record
+	var err error
+	if hasTS {
+		rec, err = s.record(bucketName, entryName, ts)
+	} else {
+		rec, err = s.latestRecord(bucketName, entryName)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	header := w.Header()
+	header.Set("x-reduct-time", strconv.FormatInt(rec.time, 10))
+	header.Set("Content-Type", rec.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(rec.data)))
+	for name, value := range rec.labels {
+		header.Set(labelHeaderPrefix+name, value)
+	}
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = w.Write(rec.data)
+	return err
+}
+
+func (s *Server) latestRecord(bucketName, entryName string) (*record, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	e, err := b.entry(entryName, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.records) == 0 {
+		return nil, errorf(http.StatusNotFound, "No records in entry '%s'", entryName)
+	}
+	return e.records[len(e.records)-1], nil
+}
+
+func (s *Server) writeRecord(r *http.Request, bucketName, entryName string, ts int64) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errorf(http.StatusBadRequest, "Failed to read record body: %v", err)
+	}
+	if r.ContentLength >= 0 && int64(len(data)) != r.ContentLength {
+		return errorf(http.StatusBadRequest, "Content is smaller than in content-length")
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultType
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	e, err := b.entry(entryName, true)
+	if err != nil {
+		return err
+	}
+	return e.insert(&record{time: ts, data: data, contentType: contentType, labels: labelsFromHeaders(r.Header)})
+}
+
+// labelsFromHeaders collects the x-reduct-label-<name> headers of a request.
+func labelsFromHeaders(header http.Header) map[string]string {
+	labels := map[string]string{}
+	for key, values := range header {
+		name, found := strings.CutPrefix(strings.ToLower(key), labelHeaderPrefix)
+		if found && len(values) > 0 {
+			labels[name] = values[0]
+		}
+	}
+	return labels
+}
+
+// updateLabels sets the given labels of a record; empty values remove labels.
+func updateLabels(rec *record, labels map[string]string) {
+	updated := make(map[string]string, len(rec.labels)+len(labels))
+	for name, value := range rec.labels {
+		updated[name] = value
+	}
+	for name, value := range labels {
+		if value == "" {
+			delete(updated, name)
+		} else {
+			updated[name] = value
+		}
+	}
+	rec.labels = updated
+}
@@ -0,0 +1,766 @@
+package reductgo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+const (
+	defaultSpoolSegmentSize   = 64 << 20
+	defaultSpoolRetryInterval = 5 * time.Second
+	// spoolReplayRecords and spoolReplaySize bound the batches of a replay.
+	spoolReplayRecords = 1000
+	spoolReplaySize    = 8 << 20
+	// A frame is the payload size and its CRC-32C, followed by the payload.
+	spoolFrameHeaderSize = 8
+	spoolSegmentExt      = ".wal"
+	spoolCursorFile      = "cursor"
+)
+
+var (
+	// ErrSpoolFull is returned with the error of a failed write whose records
+	// do not fit into the spool, see SpoolOptions.MaxSize.
+	ErrSpoolFull = errors.New("spool is full")
+	// ErrSpoolClosed is returned with the error of a failed write after the
+	// spool is closed.
+	ErrSpoolClosed = errors.New("spool is closed")
+)
+
+var spoolCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// SpoolOptions configures a Spool. Zero values use the defaults.
+type SpoolOptions struct {
+	// SegmentSize is the size at which the spool starts a new segment file.
+	// Segments are removed once their records are replayed. Defaults to 64 MiB.
+	SegmentSize int64
+	// MaxSize bounds the bytes of the records in the spool. Records that do
+	// not fit are not spooled, and their write fails with ErrSpoolFull. Zero
+	// means no bound.
+	MaxSize int64
+	// RetryInterval is how often the spool checks with IsLive whether the
+	// server is back while it has records. Defaults to 5 seconds.
+	RetryInterval time.Duration
+	// OnError is called for every replayed record that the server rejected,
+	// with its per-record error or the error of its batch. 409 Conflict is not
+	// reported, as the record was written before a crash.
+	OnError func(bucket, entry string, ts int64, err error)
+}
+
+// SpoolDepth is the number of records in a spool and their size on disk.
+type SpoolDepth struct {
+	Records int64
+	Bytes   int64
+}
+
+// Spool is a write-ahead queue on disk for write batches that cannot be sent
+// because the server is unreachable. Open it with OpenSpool and pass it to a
+// client with ClientOptions.Spool: when Batch.Write or RecordBatch.Send (and so
+// a BufferedWriter) fails with a transient error, see
+// model.IsRetryableStatus, the records that were not sent are appended to
+// the spool and the write succeeds. While the spool has records, new writes
+// are appended as well, so that records are replayed in the order they were
+// written. The client replays them once IsLive succeeds.
+//
+// Records are stored in segment files with a CRC-32C per record, and the
+// position of the replay is kept in a cursor file, so a spool opened after a
+// crash replays the records that were not written yet. A record may be
+// written twice if the process stops during a replay; the 409 Conflict of the
+// second write is ignored. Records are replayed with ConflictFail.
+//
+// The spool reports the records and bytes appended to and removed from it as
+// the telemetry.MetricSpoolAppendedRecords, MetricSpoolAppendedBytes,
+// MetricSpoolRemovedRecords and MetricSpoolRemovedBytes counters of the
+// client; their difference is the depth of the spool, see Depth.
+type Spool struct {
+	dir     string
+	options SpoolOptions
+
+	mu       sync.Mutex
+	segments []uint64 // sequence numbers of the segment files, oldest first
+	active   *os.File // the last segment, open for appending
+	size     int64    // size of the last segment
+	cursor   spoolCursor
+	depth    SpoolDepth
+	client   *ReductClient
+	logger   *slog.Logger
+	metrics  telemetry.Metrics
+	closed   bool
+
+	// replayMu serializes replays.
+	replayMu sync.Mutex
+	cancel   context.CancelFunc
+	exited   chan struct{}
+}
+
+// spoolCursor is the position of the first record that is not replayed.
+type spoolCursor struct {
+	segment uint64
+	offset  int64
+}
+
+// spoolRecord is a record of a write batch in the spool. Its data and labels
+// are compressed and encrypted like in the batch. The labels are formatted
+// with FormatLabels; they are quoted for the batch headers when replayed.
+type spoolRecord struct {
+	bucket      string
+	entry       string
+	ts          int64
+	contentType string
+	labels      map[string]string
+	data        []byte
+}
+
+// OpenSpool opens the spool in the directory and creates it if needed. The
+// records of a previous process are recovered: a segment is cut at its first
+// record that does not match its CRC, e.g. one that was partly written
+// before a crash.
+func OpenSpool(dir string, options *SpoolOptions) (*Spool, error) {
+	var opts SpoolOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSpoolSegmentSize
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultSpoolRetryInterval
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+
+	s := &Spool{dir: dir, options: opts, logger: slog.Default(), metrics: telemetry.NoopMetrics()}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover loads the segments and the cursor and opens the last segment.
+func (s *Spool) recover() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+spoolSegmentExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), spoolSegmentExt), 10, 64)
+		if err == nil {
+			s.segments = append(s.segments, seq)
+		}
+	}
+	slices.Sort(s.segments)
+
+	s.cursor, err = s.readCursor()
+	if err != nil {
+		s.logger.Warn("spool cursor is damaged, replaying all segments", "dir", s.dir, "error", err)
+		s.cursor = spoolCursor{}
+	}
+	// Segments before the cursor were replayed but not removed.
+	for len(s.segments) > 0 && s.segments[0] < s.cursor.segment {
+		if err := os.Remove(s.segmentPath(s.segments[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove replayed spool segment: %w", err)
+		}
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 || s.segments[0] != s.cursor.segment {
+		s.cursor.offset = 0
+	}
+	if len(s.segments) == 0 {
+		s.segments = []uint64{s.cursor.segment + 1}
+	}
+	s.cursor.segment = s.segments[0]
+
+	if info, err := os.Stat(s.segmentPath(s.cursor.segment)); err == nil && info.Size() < s.cursor.offset {
+		// The segment was emptied after it was replayed.
+		s.cursor.offset = 0
+	}
+	for i, seq := range s.segments {
+		var offset int64
+		if i == 0 {
+			offset = s.cursor.offset
+		}
+		records, end, err := scanSpoolSegment(s.segmentPath(seq), offset)
+		if err != nil {
+			return err
+		}
+		s.depth.Records += records
+		s.depth.Bytes += end - offset
+	}
+
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(s.segmentPath(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	info, err := s.active.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+	return nil
+}
+
+// scanSpoolSegment counts the records of the segment after offset and
+// returns the end of the last valid one. The segment is cut there.
+func scanSpoolSegment(path string, offset int64) (records, end int64, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return 0, 0, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	end = offset
+	reader := bufio.NewReader(file)
+	for {
+		payload, err := readSpoolFrame(reader, info.Size()-end)
+		if errors.Is(err, io.EOF) {
+			return records, end, nil
+		}
+		if err != nil {
+			slog.Default().Warn("spool segment is damaged, dropping its last records",
+				"segment", path, "offset", end, "error", err)
+			if err := file.Truncate(end); err != nil {
+				return 0, 0, fmt.Errorf("cut spool segment: %w", err)
+			}
+			return records, end, file.Sync()
+		}
+		records++
+		end += int64(spoolFrameHeaderSize + len(payload))
+	}
+}
+
+// readSpoolFrame reads the payload of the next frame. remaining bounds its
+// size. It returns io.EOF at the end of the segment.
+func readSpoolFrame(reader io.Reader, remaining int64) ([]byte, error) {
+	var header [spoolFrameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	if size > remaining-spoolFrameHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, spoolCRC) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("record does not match its CRC")
+	}
+	return payload, nil
+}
+
+// appendSpoolFrame appends the record as a frame to buf.
+func appendSpoolFrame(buf []byte, record spoolRecord) []byte {
+	appendString := func(buf []byte, value string) []byte {
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		return append(buf, value...)
+	}
+	start := len(buf)
+	buf = append(buf, make([]byte, spoolFrameHeaderSize)...)
+	buf = appendString(buf, record.bucket)
+	buf = appendString(buf, record.entry)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(record.ts)) // #nosec G115 -- the bits are restored by decodeSpoolRecord
+	buf = appendString(buf, record.contentType)
+	buf = binary.AppendUvarint(buf, uint64(len(record.labels)))
+	for name, value := range record.labels {
+		buf = appendString(buf, name)
+		buf = appendString(buf, value)
+	}
+	buf = append(buf, record.data...)
+
+	payload := buf[start+spoolFrameHeaderSize:]
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(payload))) // #nosec G115 -- records are smaller than 4 GiB
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, spoolCRC))
+	return buf
+}
+
+// decodeSpoolRecord decodes the payload of a frame.
+func decodeSpoolRecord(payload []byte) (spoolRecord, error) {
+	errDecode := errors.New("malformed spool record")
+	readString := func() (string, error) {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || size > uint64(len(payload)-n) {
+			return "", errDecode
+		}
+		value := string(payload[n : n+int(size)]) // #nosec G115 -- size is bounded by the payload
+		payload = payload[n+int(size):]           // #nosec G115 -- size is bounded by the payload
+		return value, nil
+	}
+
+	var record spoolRecord
+	var err error
+	if record.bucket, err = readString(); err != nil {
+		return record, err
+	}
+	if record.entry, err = readString(); err != nil {
+		return record, err
+	}
+	if len(payload) < 8 {
+		return record, errDecode
+	}
+	record.ts = int64(binary.BigEndian.Uint64(payload)) // #nosec G115 -- restores the bits of appendSpoolFrame
+	payload = payload[8:]
+	if record.contentType, err = readString(); err != nil {
+		return record, err
+	}
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)) {
+		return record, errDecode
+	}
+	payload = payload[n:]
+	record.labels = make(map[string]string, count)
+	for range count {
+		name, err := readString()
+		if err != nil {
+			return record, err
+		}
+		if record.labels[name], err = readString(); err != nil {
+			return record, err
+		}
+	}
+	record.data = payload
+	return record, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// readCursor reads the cursor file: the segment and the offset followed by
+// their CRC-32C. A missing file is the start of the spool.
+func (s *Spool) readCursor() (spoolCursor, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return spoolCursor{}, nil
+	}
+	if err != nil {
+		return spoolCursor{}, err
+	}
+	if len(data) != 20 || crc32.Checksum(data[:16], spoolCRC) != binary.BigEndian.Uint32(data[16:]) {
+		return spoolCursor{}, errors.New("cursor does not match its CRC")
+	}
+	return spoolCursor{
+		segment: binary.BigEndian.Uint64(data),
+		offset:  int64(binary.BigEndian.Uint64(data[8:])), // #nosec G115 -- restores the bits of writeCursor
+	}, nil
+}
+
+// writeCursor replaces the cursor file atomically; the caller holds mu.
+func (s *Spool) writeCursor() error {
+	data := binary.BigEndian.AppendUint64(nil, s.cursor.segment)
+	data = binary.BigEndian.AppendUint64(data, uint64(s.cursor.offset)) // #nosec G115 -- offsets are not negative
+	data = binary.BigEndian.AppendUint32(data, crc32.Checksum(data, spoolCRC))
+
+	path := filepath.Join(s.dir, spoolCursorFile)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// attach starts replaying the spool with the client. A spool is replayed by
+// the first client it is passed to.
+func (s *Spool) attach(client *ReductClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil || s.closed {
+		return
+	}
+	s.client = client
+	s.logger = httpclient.LoggerOf(client.HTTPClient)
+	s.metrics = httpclient.MetricsOf(client.HTTPClient)
+	// Records recovered from disk are counted as appended by this process.
+	s.metrics.AddCounter(telemetry.MetricSpoolAppendedRecords, float64(s.depth.Records))
+	s.metrics.AddCounter(telemetry.MetricSpoolAppendedBytes, float64(s.depth.Bytes))
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.exited = make(chan struct{})
+	go s.run(ctx)
+}
+
+// run replays the spool whenever it has records and the server is live.
+func (s *Spool) run(ctx context.Context) {
+	defer close(s.exited)
+	ticker := time.NewTicker(s.options.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.pending() {
+			if live, err := s.client.IsLive(ctx); err == nil && live {
+				if err := s.Replay(ctx); err != nil && ctx.Err() == nil {
+					s.logger.WarnContext(ctx, "spool replay failed", "dir", s.dir, "error", err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Depth returns the number of records in the spool and their size.
+func (s *Spool) Depth() SpoolDepth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// pending reports whether the spool has records. A nil spool has none.
+func (s *Spool) pending() bool {
+	if s == nil {
+		return false
+	}
+	return s.Depth().Records > 0
+}
+
+// Close stops replaying the spool and closes its files. The records that were
+// not replayed stay on disk for the next OpenSpool.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	cancel, exited := s.cancel, s.exited
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-exited
+	}
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+// append writes the records to the spool and syncs them to disk.
+func (s *Spool) append(records []spoolRecord) error {
+	var buf []byte
+	for _, record := range records {
+		buf = appendSpoolFrame(buf, record)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	if s.options.MaxSize > 0 && s.depth.Bytes+int64(len(buf)) > s.options.MaxSize {
+		return ErrSpoolFull
+	}
+	if s.size > 0 && s.size+int64(len(buf)) > s.options.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.active.Write(buf); err != nil {
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("sync spool segment: %w", err)
+	}
+	s.size += int64(len(buf))
+	s.depth.Records += int64(len(records))
+	s.depth.Bytes += int64(len(buf))
+	s.metrics.AddCounter(telemetry.MetricSpoolAppendedRecords, float64(len(records)))
+	s.metrics.AddCounter(telemetry.MetricSpoolAppendedBytes, float64(len(buf)))
+	return nil
+}
+
+// rotate starts a new segment; the caller holds mu.
+func (s *Spool) rotate() error {
+	next := s.segments[len(s.segments)-1] + 1
+	file, err := os.OpenFile(s.segmentPath(next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	if err := s.active.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	s.active = file
+	s.size = 0
+	s.segments = append(s.segments, next)
+	return nil
+}
+
+// Replay writes the records of the spool to the server in the order they
+// were spooled, until the spool is empty or a batch fails with a transient
+// error, which Replay returns. Batches that fail otherwise are reported to
+// SpoolOptions.OnError and removed. The client replays the spool in the
+// background; Replay is useful to drain it before the client is closed.
+func (s *Spool) Replay(ctx context.Context) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	s.mu.Lock()
+	client, closed := s.client, s.closed
+	s.mu.Unlock()
+	if closed {
+		return ErrSpoolClosed
+	}
+	if client == nil {
+		return errors.New("spool is not passed to a client")
+	}
+
+	for {
+		records, end, err := s.next()
+		if err != nil || end < 0 {
+			return err
+		}
+		if len(records) > 0 {
+			if err := s.send(ctx, client, records); err != nil {
+				return err
+			}
+		}
+		if err := s.advance(int64(len(records)), end); err != nil {
+			return err
+		}
+	}
+}
+
+// next reads the records of the next replay batch: records of one bucket
+// from the cursor on. end is the offset after them in the segment of the
+// cursor, or -1 if the spool is empty.
+func (s *Spool) next() (records []spoolRecord, end int64, err error) {
+	s.mu.Lock()
+	cursor := s.cursor
+	last := len(s.segments) == 1
+	size := s.size
+	s.mu.Unlock()
+
+	file, err := os.Open(s.segmentPath(cursor.segment))
+	if err != nil {
+		return nil, 0, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer file.Close()
+	if !last {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		size = info.Size()
+	}
+	if cursor.offset >= size {
+		if last {
+			return nil, -1, nil
+		}
+		// The segment is replayed; advance removes it.
+		return nil, size, nil
+	}
+	if _, err := file.Seek(cursor.offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	end = cursor.offset
+	var batchSize int64
+	reader := bufio.NewReader(file)
+	for end < size && len(records) < spoolReplayRecords && batchSize < spoolReplaySize {
+		payload, err := readSpoolFrame(reader, size-end)
+		if err != nil {
+			return nil, 0, fmt.Errorf("read spool segment: %w", err)
+		}
+		record, err := decodeSpoolRecord(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(records) > 0 && record.bucket != records[0].bucket {
+			break
+		}
+		records = append(records, record)
+		batchSize += int64(len(record.data))
+		end += int64(spoolFrameHeaderSize + len(payload))
+	}
+	return records, end, nil
+}
+
+// send writes the records of a bucket as one batch.
+func (s *Spool) send(ctx context.Context, client *ReductClient, records []spoolRecord) error {
+	bucket := records[0].bucket
+	batch := newRecordBatch(bucket, client.HTTPClient, BatchWrite)
+	for _, record := range records {
+		labels := make(LabelMap, len(record.labels))
+		for name, value := range record.labels {
+			labels[name] = value
+		}
+		// The records are added as they were spooled, without encoding them again.
+		rec := &recordBatchRecord{
+			entry:       record.entry,
+			timestamp:   record.ts,
+			data:        record.data,
+			contentType: record.contentType,
+			labels:      labels,
+		}
+		batch.records[recordBatchKey{entry: record.entry, ts: record.ts}] = rec
+		batch.totalSize += rec.size()
+	}
+
+	errs, err := batch.Send(ctx)
+	if err != nil {
+		if spoolable(err) || ctx.Err() != nil {
+			return err
+		}
+		s.reportErrors(records, func(string, int64) error { return err })
+		return nil
+	}
+	s.reportErrors(records, func(entry string, ts int64) error {
+		if recordErr, failed := errs[entry][ts]; failed && !isConflict(recordErr) {
+			return recordErr
+		}
+		return nil
+	})
+	return nil
+}
+
+// reportErrors reports the errors of replayed records to OnError.
+func (s *Spool) reportErrors(records []spoolRecord, errOf func(entry string, ts int64) error) {
+	for _, record := range records {
+		if err := errOf(record.entry, record.ts); err != nil {
+			s.logger.Warn("spooled record was rejected", "bucket", record.bucket, "entry", record.entry,
+				"ts", record.ts, "error", err)
+			if s.options.OnError != nil {
+				s.options.OnError(record.bucket, record.entry, record.ts, err)
+			}
+		}
+	}
+}
+
+// advance moves the cursor after replayed records and removes or empties the
+// segments that are fully replayed.
+func (s *Spool) advance(records, end int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := end - s.cursor.offset
+	s.cursor.offset = end
+	s.depth.Records -= records
+	s.depth.Bytes -= removed
+	s.metrics.AddCounter(telemetry.MetricSpoolRemovedRecords, float64(records))
+	s.metrics.AddCounter(telemetry.MetricSpoolRemovedBytes, float64(removed))
+
+	replayed := s.segments[0]
+	switch {
+	case len(s.segments) > 1 && records == 0:
+		s.segments = s.segments[1:]
+		s.cursor = spoolCursor{segment: s.segments[0]}
+	case len(s.segments) == 1 && end == s.size:
+		// The last segment is reused once it is replayed.
+		if err := s.active.Truncate(0); err != nil {
+			return fmt.Errorf("empty spool segment: %w", err)
+		}
+		s.size = 0
+		s.cursor.offset = 0
+	}
+	if err := s.writeCursor(); err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	if s.cursor.segment != replayed {
+		if err := os.Remove(s.segmentPath(replayed)); err != nil {
+			return fmt.Errorf("remove replayed spool segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// spoolable reports whether the records of a write that failed with err are
+// spooled: the server could not be reached or is temporarily unavailable.
+func spoolable(err error) bool {
+	if errors.Is(err, ErrPayloadSize) {
+		return false
+	}
+	return model.IsRetryableStatus(toAPIError(err).Status)
+}
+
+// spoolChunks appends the records of the chunks of a write batch of the
+// bucket that were not sent to the spool, in order. If failed is set, the
+// first chunk was sent and failed with it: its streamed payloads were
+// consumed, so they are reported in errs instead. Streamed payloads of the
+// other chunks are read into memory.
+func (s *Spool) spoolChunks(ctx context.Context, bucket string, chunks [][]*recordBatchRecord, failed error,
+	errs RecordBatchErrorMap,
+) error {
+	var records []spoolRecord
+	var consumed []*recordBatchRecord
+	for i, chunk := range chunks {
+		for _, rec := range chunk {
+			data := rec.data
+			if rec.stream != nil {
+				if i == 0 && failed != nil {
+					consumed = append(consumed, rec)
+					continue
+				}
+				var err error
+				if data, err = rec.stream.readAll(); err != nil {
+					return err
+				}
+			}
+			labels, err := FormatLabels(rec.labels)
+			if err != nil {
+				return err
+			}
+			records = append(records, spoolRecord{
+				bucket:      bucket,
+				entry:       rec.entry,
+				ts:          rec.timestamp,
+				contentType: rec.contentType,
+				labels:      labels,
+				data:        data,
+			})
+		}
+	}
+	if err := s.append(records); err != nil {
+		return err
+	}
+	for _, rec := range consumed {
+		errs.add(rec.entry, rec.timestamp, toAPIError(failed))
+	}
+	if failed != nil {
+		s.logger.WarnContext(ctx, "server is unreachable, batch is spooled",
+			"bucket", bucket, "records", len(records), "error", failed)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package reductgo
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+// newOfflineTestServer returns the URL of a server that answers 503 to all
+// requests while down is set.
+func newOfflineTestServer(t *testing.T) (string, *atomic.Bool) {
+	t.Helper()
+	backend := reducttest.NewUnstartedServer(reducttest.Options{})
+	t.Cleanup(backend.Close)
+	down := &atomic.Bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, down
+}
+
+func newSpoolTestBucket(t *testing.T, url string, spool *Spool, options ClientOptions) *Bucket {
+	t.Helper()
+	options.Spool = spool
+	bucket, err := NewClient(url, options).CreateOrGetBucket(context.Background(), "bucket", nil)
+	require.NoError(t, err)
+	return bucket.(*Bucket)
+}
+
+func openTestSpool(t *testing.T, dir string, options *SpoolOptions) *Spool {
+	t.Helper()
+	spool, err := OpenSpool(dir, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { spool.Close() })
+	return spool
+}
+
+func TestSpoolReplay(t *testing.T) {
+	ctx := context.Background()
+	url, down := newOfflineTestServer(t)
+	metrics := telemetry.NewMemoryMetrics()
+	spool := openTestSpool(t, t.TempDir(), &SpoolOptions{RetryInterval: 10 * time.Millisecond})
+	bucket := newSpoolTestBucket(t, url, spool, ClientOptions{Metrics: metrics})
+	down.Store(true)
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("first"), "text/plain", LabelMap{"n": 1, "list": "a,b"})
+	batch.AddReader(2, &patternReader{remaining: 100}, 100, "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2}, slices.Collect(maps.Keys(errs)), "a streamed payload is consumed by the failed request")
+	assert.Equal(t, http.StatusServiceUnavailable, errs[2].Status)
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.AddReader(2, &patternReader{remaining: 100}, 100, "", nil)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs, "the payload is read into the spool")
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.SetCompression(&CompressionOptions{Algorithm: CompressionGzip})
+	recordBatch.Add("entry", 3, []byte("third"), "", nil)
+	recordBatch.Add("other", 1, []byte("other"), "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+
+	writer := bucket.BeginBufferedWrite(ctx, &BufferedWriterOptions{
+		OnError: func(entry string, ts int64, err error) { t.Errorf("%s/%d failed: %v", entry, ts, err) },
+	})
+	require.NoError(t, writer.Write(ctx, "entry", 4, []byte("buffered"), "", nil))
+	require.NoError(t, writer.Close(ctx))
+
+	depth := spool.Depth()
+	assert.Equal(t, int64(5), depth.Records)
+	snapshot := metrics.Snapshot()
+	assert.InDelta(t, 5, snapshot.Counter(telemetry.MetricSpoolAppendedRecords), 0)
+	assert.InDelta(t, float64(depth.Bytes), snapshot.Counter(telemetry.MetricSpoolAppendedBytes), 0)
+
+	down.Store(false)
+	require.Eventually(t, func() bool { return spool.Depth() == SpoolDepth{} }, 5*time.Second, 10*time.Millisecond)
+	for ts, want := range map[int64]string{1: "first", 3: "third", 4: "buffered"} {
+		assert.Equal(t, want, readEntryRecord(t, bucket, "entry", ts))
+	}
+	assert.Equal(t, "other", readEntryRecord(t, bucket, "other", 1))
+	assert.Equal(t, 100, len(readEntryRecord(t, bucket, "entry", 2)))
+	record := mustReadEntry(t, bucket, "entry", 1)
+	assert.Equal(t, "1", record.Labels()["n"])
+	assert.Equal(t, "a,b", record.Labels()["list"], "a label with a comma is quoted once")
+	assert.Equal(t, "text/plain", record.ContentType())
+	assert.Equal(t, CompressionGzip, mustReadEntry(t, bucket, "entry", 3).Compression())
+
+	snapshot = metrics.Snapshot()
+	assert.InDelta(t, 5, snapshot.Counter(telemetry.MetricSpoolRemovedRecords), 0)
+	assert.InDelta(t, float64(depth.Bytes), snapshot.Counter(telemetry.MetricSpoolRemovedBytes), 0)
+}
+
+func TestSpoolKeepsOrder(t *testing.T) {
+	ctx := context.Background()
+	url, down := newOfflineTestServer(t)
+	spool := openTestSpool(t, t.TempDir(), &SpoolOptions{RetryInterval: time.Hour})
+	bucket := newSpoolTestBucket(t, url, spool, ClientOptions{})
+
+	down.Store(true)
+	err := bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("direct")
+	require.ErrorIs(t, err, model.ErrServer, "single records are not spooled")
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(2, []byte("spooled"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+	down.Store(false)
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(3, []byte("after"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), spool.Depth().Records, "records are written after the spooled ones")
+	assert.Zero(t, countEntryRecords(t, bucket, "entry"))
+
+	require.NoError(t, spool.Replay(ctx))
+	assert.Equal(t, SpoolDepth{}, spool.Depth())
+	assert.Equal(t, "after", readEntryRecord(t, bucket, "entry", 3))
+}
+
+func TestSpoolResume(t *testing.T) {
+	ctx := context.Background()
+	url, down := newOfflineTestServer(t)
+	dir := t.TempDir()
+	options := &SpoolOptions{SegmentSize: 100, RetryInterval: time.Hour}
+
+	spool := openTestSpool(t, dir, options)
+	bucket := newSpoolTestBucket(t, url, spool, ClientOptions{})
+	down.Store(true)
+	for ts := int64(1); ts <= 5; ts++ {
+		batch := bucket.BeginWriteBatch(ctx, "entry")
+		batch.Add(ts, []byte("record of 40 bytes to rotate the segment"), "", nil)
+		_, err := batch.Write(ctx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, spool.Close())
+	segments, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1, "segments are rotated")
+
+	// A record that was partly written before a crash is dropped.
+	last, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = last.Write([]byte{0, 0, 0, 60, 1, 2, 3, 4, 'p', 'a', 'r', 't'})
+	require.NoError(t, err)
+	require.NoError(t, last.Close())
+
+	spool = openTestSpool(t, dir, options)
+	assert.Equal(t, int64(5), spool.Depth().Records)
+	down.Store(false)
+
+	// Replay the first record and stop as if the process crashed.
+	records, end, err := spool.next()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.NoError(t, spool.send(ctx, NewClient(url, ClientOptions{}).(*ReductClient), records))
+	require.NoError(t, spool.advance(int64(len(records)), end))
+	require.NoError(t, spool.Close())
+
+	spool = openTestSpool(t, dir, options)
+	assert.Equal(t, int64(4), spool.Depth().Records)
+	bucket = newSpoolTestBucket(t, url, spool, ClientOptions{})
+	require.NoError(t, spool.Replay(ctx))
+	assert.Equal(t, SpoolDepth{}, spool.Depth())
+	assert.Equal(t, 5, countEntryRecords(t, bucket, "entry"))
+
+	segments, err = filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1, "replayed segments are removed")
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestSpoolErrors(t *testing.T) {
+	ctx := context.Background()
+	url, down := newOfflineTestServer(t)
+	var mu sync.Mutex
+	rejected := map[string][]int64{}
+	spool := openTestSpool(t, t.TempDir(), &SpoolOptions{
+		MaxSize:       200,
+		RetryInterval: time.Hour,
+		OnError: func(bucket, entry string, ts int64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.ErrorIs(t, err, model.ErrNotFound)
+			rejected[bucket+"/"+entry] = append(rejected[bucket+"/"+entry], ts)
+		},
+	})
+	bucket := newSpoolTestBucket(t, url, spool, ClientOptions{})
+	client := NewClient(url, ClientOptions{})
+	other, err := client.CreateBucket(ctx, "other", nil)
+	require.NoError(t, err)
+	other.(*Bucket).Spool = spool
+
+	down.Store(true)
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, make([]byte, 300), "", nil)
+	_, err = batch.Write(ctx)
+	require.ErrorIs(t, err, ErrSpoolFull)
+	require.ErrorIs(t, err, model.ErrServer)
+
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("conflict"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+	batch = other.BeginWriteBatch(ctx, "entry")
+	batch.Add(1, []byte("removed bucket"), "", nil)
+	_, err = batch.Write(ctx)
+	require.NoError(t, err)
+
+	down.Store(false)
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1}).Write("written"))
+	require.NoError(t, other.Remove(ctx))
+	require.NoError(t, spool.Replay(ctx))
+	assert.Equal(t, SpoolDepth{}, spool.Depth())
+	assert.Equal(t, map[string][]int64{"other/entry": {1}}, rejected, "409 Conflict is not reported")
+	assert.Equal(t, "written", readEntryRecord(t, bucket, "entry", 1))
+
+	require.NoError(t, spool.Close())
+	batch = bucket.BeginWriteBatch(ctx, "entry")
+	batch.Add(2, []byte("closed"), "", nil)
+	down.Store(true)
+	_, err = batch.Write(ctx)
+	require.ErrorIs(t, err, ErrSpoolClosed)
+}
@@ -0,0 +1,45 @@
+package reductgo
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/reductstore/reduct-go/telemetry"
+)
+
+// endSpan finishes a span, marking it as failed if err is set.
+func endSpan(span telemetry.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// setSpanStatus records the HTTP status of the response on the span.
+func setSpanStatus(span telemetry.Span, resp *http.Response) {
+	if resp != nil {
+		span.SetAttributes(telemetry.Int(telemetry.AttrHTTPStatus, resp.StatusCode))
+	}
+}
+
+// observeBatch reports the number of records and payload bytes of a sent batch.
+func observeBatch(metrics telemetry.Metrics, operation string, records int, size int64) {
+	label := telemetry.Label{Name: telemetry.LabelOperation, Value: operation}
+	metrics.ObserveHistogram(telemetry.MetricBatchRecords, float64(records), label)
+	metrics.ObserveHistogram(telemetry.MetricBatchBytes, float64(size), label)
+}
+
+// observeWritten reports the payload bytes of a successful write.
+func observeWritten(metrics telemetry.Metrics, operation string, size int64) {
+	metrics.AddCounter(telemetry.MetricBytesWritten, float64(size),
+		telemetry.Label{Name: telemetry.LabelOperation, Value: operation})
+}
+
+// observeRecordErrors reports the records rejected by the server by status.
+func observeRecordErrors(metrics telemetry.Metrics, operation string, errs ErrorMap) {
+	for _, err := range errs {
+		metrics.AddCounter(telemetry.MetricRecordErrors, 1,
+			telemetry.Label{Name: telemetry.LabelOperation, Value: operation},
+			telemetry.Label{Name: telemetry.LabelStatus, Value: strconv.Itoa(err.Status)})
+	}
+}
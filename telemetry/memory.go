@@ -0,0 +1,193 @@
+package telemetry
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds used by MemoryMetrics
+// for the SDK metrics. Other histograms use DefaultLatencyBuckets.
+var DefaultBuckets = map[string][]float64{
+	MetricRequestDuration: DefaultLatencyBuckets,
+	MetricBatchRecords:    {1, 10, 100, 1000, 10_000, 100_000},
+	MetricBatchBytes:      {1 << 10, 16 << 10, 256 << 10, 1 << 20, 8 << 20, 64 << 20},
+}
+
+// DefaultLatencyBuckets are bucket upper bounds in seconds for request latencies.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// MemoryMetrics keeps the metrics in memory. Use Snapshot to read them or
+// PrometheusHandler to expose them for scraping.
+type MemoryMetrics struct {
+	mu         sync.Mutex
+	buckets    map[string][]float64
+	counters   map[string]*CounterSnapshot
+	histograms map[string]*HistogramSnapshot
+}
+
+// NewMemoryMetrics creates an empty in-memory collector that uses DefaultBuckets.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		buckets:    map[string][]float64{},
+		counters:   map[string]*CounterSnapshot{},
+		histograms: map[string]*HistogramSnapshot{},
+	}
+}
+
+// SetBuckets sets the bucket upper bounds of a histogram. It must be called
+// before the first observation of the histogram.
+func (m *MemoryMetrics) SetBuckets(name string, bounds []float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets[name] = slices.Sorted(slices.Values(bounds))
+}
+
+// AddCounter increases the counter by value.
+func (m *MemoryMetrics) AddCounter(name string, value float64, labels ...Label) {
+	labels = sortLabels(labels)
+	key := seriesKey(name, labels)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, ok := m.counters[key]
+	if !ok {
+		counter = &CounterSnapshot{Name: name, Labels: labels}
+		m.counters[key] = counter
+	}
+	counter.Value += value
+}
+
+// ObserveHistogram records a single observation of the histogram.
+func (m *MemoryMetrics) ObserveHistogram(name string, value float64, labels ...Label) {
+	labels = sortLabels(labels)
+	key := seriesKey(name, labels)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	histogram, ok := m.histograms[key]
+	if !ok {
+		bounds := m.bucketsFor(name)
+		histogram = &HistogramSnapshot{
+			Name:   name,
+			Labels: labels,
+			Bounds: bounds,
+			Counts: make([]uint64, len(bounds)+1),
+		}
+		m.histograms[key] = histogram
+	}
+	idx, _ := slices.BinarySearch(histogram.Bounds, value)
+	histogram.Counts[idx]++
+	histogram.Count++
+	histogram.Sum += value
+}
+
+func (m *MemoryMetrics) bucketsFor(name string) []float64 {
+	if bounds, ok := m.buckets[name]; ok {
+		return bounds
+	}
+	if bounds, ok := DefaultBuckets[name]; ok {
+		return bounds
+	}
+	return DefaultLatencyBuckets
+}
+
+// Snapshot returns a copy of the current values sorted by name and labels.
+func (m *MemoryMetrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var snapshot MetricsSnapshot
+	for _, key := range slices.Sorted(maps.Keys(m.counters)) {
+		snapshot.Counters = append(snapshot.Counters, *m.counters[key])
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.histograms)) {
+		histogram := *m.histograms[key]
+		histogram.Counts = slices.Clone(histogram.Counts)
+		snapshot.Histograms = append(snapshot.Histograms, histogram)
+	}
+	return snapshot
+}
+
+// Reset removes all values.
+func (m *MemoryMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.counters)
+	clear(m.histograms)
+}
+
+// MetricsSnapshot is a point-in-time copy of the values of MemoryMetrics.
+type MetricsSnapshot struct {
+	Counters   []CounterSnapshot
+	Histograms []HistogramSnapshot
+}
+
+// CounterSnapshot is the value of a single counter series.
+type CounterSnapshot struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// HistogramSnapshot is the state of a single histogram series.
+type HistogramSnapshot struct {
+	Name   string
+	Labels []Label
+	// Bounds are the upper bounds of the buckets.
+	Bounds []float64
+	// Counts holds the observations per bucket, the last one counts the
+	// observations above the highest bound. The counts are not cumulative.
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// Counter returns the sum of the counter series that have all the given labels.
+func (s MetricsSnapshot) Counter(name string, labels ...Label) float64 {
+	var sum float64
+	for _, counter := range s.Counters {
+		if counter.Name == name && hasLabels(counter.Labels, labels) {
+			sum += counter.Value
+		}
+	}
+	return sum
+}
+
+// Histogram returns the first histogram series that has all the given labels.
+func (s MetricsSnapshot) Histogram(name string, labels ...Label) (HistogramSnapshot, bool) {
+	for _, histogram := range s.Histograms {
+		if histogram.Name == name && hasLabels(histogram.Labels, labels) {
+			return histogram, true
+		}
+	}
+	return HistogramSnapshot{}, false
+}
+
+func hasLabels(labels, want []Label) bool {
+	for _, label := range want {
+		if !slices.Contains(labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortLabels(labels []Label) []Label {
+	labels = slices.Clone(labels)
+	slices.SortFunc(labels, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+	return labels
+}
+
+func seriesKey(name string, labels []Label) string {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, label := range labels {
+		key.WriteByte(0)
+		key.WriteString(label.Name)
+		key.WriteByte(0)
+		key.WriteString(label.Value)
+	}
+	return key.String()
+}
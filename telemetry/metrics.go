@@ -0,0 +1,64 @@
+package telemetry
+
+// Names of the metrics reported by the SDK.
+const (
+	// MetricRequests counts HTTP requests, each retry attempt included,
+	// by endpoint, method and status ("error" for transport failures).
+	MetricRequests = "reduct_client_requests_total"
+	// MetricRequestDuration is a histogram of request latency in seconds by endpoint and method.
+	MetricRequestDuration = "reduct_client_request_duration_seconds"
+	// MetricBytesWritten counts payload bytes of successful write requests by operation.
+	MetricBytesWritten = "reduct_client_written_bytes_total"
+	// MetricBytesRead counts payload bytes delivered through query results.
+	MetricBytesRead = "reduct_client_read_bytes_total"
+	// MetricRecordsRead counts records delivered through query results.
+	MetricRecordsRead = "reduct_client_read_records_total"
+	// MetricBatchRecords is a histogram of the number of records per sent batch by operation.
+	MetricBatchRecords = "reduct_client_batch_records"
+	// MetricBatchBytes is a histogram of the payload size of sent batches by operation.
+	MetricBatchBytes = "reduct_client_batch_bytes"
+	// MetricRecordErrors counts records rejected by the server in a batch by operation and status.
+	MetricRecordErrors = "reduct_client_record_errors_total"
+	// MetricSpoolAppendedRecords counts records appended to a write spool.
+	MetricSpoolAppendedRecords = "reduct_client_spool_appended_records_total"
+	// MetricSpoolAppendedBytes counts bytes appended to a write spool.
+	MetricSpoolAppendedBytes = "reduct_client_spool_appended_bytes_total"
+	// MetricSpoolRemovedRecords counts records removed from a write spool after
+	// they were replayed. The depth of the spool is appended minus removed.
+	MetricSpoolRemovedRecords = "reduct_client_spool_removed_records_total"
+	// MetricSpoolRemovedBytes counts bytes removed from a write spool after they were replayed.
+	MetricSpoolRemovedBytes = "reduct_client_spool_removed_bytes_total"
+)
+
+// Label names used by the SDK metrics.
+const (
+	LabelEndpoint  = "endpoint"
+	LabelMethod    = "method"
+	LabelStatus    = "status"
+	LabelOperation = "operation"
+)
+
+// Label is a name/value pair that identifies a series of a metric.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metrics receives the measurements of the SDK. Implementations must be safe
+// for concurrent use; the calls are made on the hot path, so they should not block.
+type Metrics interface {
+	// AddCounter increases the counter by value.
+	AddCounter(name string, value float64, labels ...Label)
+	// ObserveHistogram records a single observation of the histogram.
+	ObserveHistogram(name string, value float64, labels ...Label)
+}
+
+// NoopMetrics returns metrics that discard every measurement.
+func NoopMetrics() Metrics {
+	return noopMetrics{}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) AddCounter(string, float64, ...Label)       {}
+func (noopMetrics) ObserveHistogram(string, float64, ...Label) {}
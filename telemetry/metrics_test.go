@@ -0,0 +1,70 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMetricsSnapshot(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.SetBuckets("latency", []float64{1, 0.1})
+
+	// Label order does not matter.
+	metrics.AddCounter("requests", 1, Label{"endpoint", "a"}, Label{"status", "200"})
+	metrics.AddCounter("requests", 2, Label{"status", "200"}, Label{"endpoint", "a"})
+	metrics.AddCounter("requests", 1, Label{"endpoint", "b"}, Label{"status", "500"})
+	metrics.ObserveHistogram("latency", 0.05)
+	metrics.ObserveHistogram("latency", 0.1)
+	metrics.ObserveHistogram("latency", 3)
+
+	snapshot := metrics.Snapshot()
+	require.Len(t, snapshot.Counters, 2)
+	assert.InDelta(t, 3, snapshot.Counter("requests", Label{"endpoint", "a"}), 0)
+	assert.InDelta(t, 4, snapshot.Counter("requests"), 0)
+	assert.InDelta(t, 0, snapshot.Counter("requests", Label{"endpoint", "c"}), 0)
+
+	histogram, ok := snapshot.Histogram("latency")
+	require.True(t, ok)
+	assert.Equal(t, []float64{0.1, 1}, histogram.Bounds)
+	assert.Equal(t, []uint64{2, 0, 1}, histogram.Counts)
+	assert.Equal(t, uint64(3), histogram.Count)
+	assert.InDelta(t, 3.15, histogram.Sum, 1e-9)
+
+	// The snapshot does not change with later observations.
+	metrics.ObserveHistogram("latency", 0.5)
+	assert.Equal(t, []uint64{2, 0, 1}, histogram.Counts)
+
+	metrics.Reset()
+	assert.Empty(t, metrics.Snapshot().Counters)
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.SetBuckets(MetricBatchRecords, []float64{1, 10})
+	metrics.AddCounter(MetricRecordErrors, 2, Label{LabelOperation, "Batch.Write"}, Label{LabelStatus, "409"})
+	metrics.AddCounter("custom", 1, Label{"name", "a \"quoted\"\\value"})
+	metrics.ObserveHistogram(MetricBatchRecords, 5, Label{LabelOperation, "Batch.Write"})
+
+	recorder := httptest.NewRecorder()
+	PrometheusHandler(metrics).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	assert.Equal(t, `# TYPE custom counter
+custom{name="a \"quoted\"\\value"} 1
+# HELP reduct_client_record_errors_total Records of a batch rejected by ReductStore.
+# TYPE reduct_client_record_errors_total counter
+reduct_client_record_errors_total{operation="Batch.Write",status="409"} 2
+# HELP reduct_client_batch_records Number of records per sent batch.
+# TYPE reduct_client_batch_records histogram
+reduct_client_batch_records_bucket{operation="Batch.Write",le="1"} 0
+reduct_client_batch_records_bucket{operation="Batch.Write",le="10"} 1
+reduct_client_batch_records_bucket{operation="Batch.Write",le="+Inf"} 1
+reduct_client_batch_records_sum{operation="Batch.Write"} 5
+reduct_client_batch_records_count{operation="Batch.Write"} 1
+`, recorder.Body.String())
+}
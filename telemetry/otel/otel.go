@@ -0,0 +1,70 @@
+// Package otel adapts an OpenTelemetry tracer to the telemetry.Tracer interface
+// of the ReductStore SDK. It lives in its own module, so the SDK itself does
+// not depend on OpenTelemetry.
+//
+//	client := reductgo.NewClient(url, reductgo.ClientOptions{
+//		Tracer: otel.NewTracer(otelapi.Tracer("reduct-go")),
+//	})
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/reductstore/reduct-go/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewTracer returns a telemetry.Tracer that records spans with tracer.
+func NewTracer(tracer trace.Tracer) telemetry.Tracer {
+	return &otelTracer{tracer: tracer}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t *otelTracer) Start(ctx context.Context, name string, attrs ...telemetry.Attribute) (context.Context, telemetry.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(convert(attrs)...))
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttributes(attrs ...telemetry.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
+
+func convert(attrs []telemetry.Attribute) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch v := attr.Value.(type) {
+		case string:
+			out = append(out, attribute.String(attr.Key, v))
+		case int64:
+			out = append(out, attribute.Int64(attr.Key, v))
+		case int:
+			out = append(out, attribute.Int(attr.Key, v))
+		case bool:
+			out = append(out, attribute.Bool(attr.Key, v))
+		case float64:
+			out = append(out, attribute.Float64(attr.Key, v))
+		default:
+			out = append(out, attribute.String(attr.Key, fmt.Sprint(v)))
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/reductstore/reduct-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracerRecordsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider.Tracer("test"))
+
+	ctx, parent := tracer.Start(context.Background(), "Bucket.Query", telemetry.String(telemetry.AttrBucket, "bucket"))
+	_, child := tracer.Start(ctx, "ReadBatch", telemetry.Int64(telemetry.AttrQueryID, 7))
+	child.SetAttributes(telemetry.Int(telemetry.AttrRecordCount, 3))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "ReadBatch", spans[0].Name())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.Int64(telemetry.AttrQueryID, 7))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int64(telemetry.AttrRecordCount, 3))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[1].Attributes(), attribute.String(telemetry.AttrBucket, "bucket"))
+}
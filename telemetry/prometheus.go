@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var metricHelp = map[string]string{
+	MetricRequests:        "HTTP requests sent to ReductStore, including retries.",
+	MetricRequestDuration: "Latency of HTTP requests to ReductStore in seconds.",
+	MetricBytesWritten:    "Payload bytes written to ReductStore.",
+	MetricBytesRead:       "Payload bytes read from query results.",
+	MetricRecordsRead:     "Records read from query results.",
+	MetricBatchRecords:    "Number of records per sent batch.",
+	MetricBatchBytes:      "Payload bytes per sent batch.",
+	MetricRecordErrors:    "Records of a batch rejected by ReductStore.",
+}
+
+// PrometheusHandler returns an http.Handler that serves the metrics in the
+// Prometheus text exposition format.
+func PrometheusHandler(metrics *MemoryMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WritePrometheus(w, metrics.Snapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, snapshot MetricsSnapshot) error {
+	var out strings.Builder
+
+	last := ""
+	for _, counter := range snapshot.Counters {
+		if counter.Name != last {
+			writeHeader(&out, counter.Name, "counter")
+			last = counter.Name
+		}
+		writeSample(&out, counter.Name, counter.Labels, counter.Value)
+	}
+
+	last = ""
+	for _, histogram := range snapshot.Histograms {
+		if histogram.Name != last {
+			writeHeader(&out, histogram.Name, "histogram")
+			last = histogram.Name
+		}
+
+		var cumulative uint64
+		for i, count := range histogram.Counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(histogram.Bounds) {
+				bound = histogram.Bounds[i]
+			}
+			labels := slices.Concat(histogram.Labels, []Label{{Name: "le", Value: formatFloat(bound)}})
+			writeSample(&out, histogram.Name+"_bucket", labels, float64(cumulative))
+		}
+		writeSample(&out, histogram.Name+"_sum", histogram.Labels, histogram.Sum)
+		writeSample(&out, histogram.Name+"_count", histogram.Labels, float64(histogram.Count))
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func writeHeader(out *strings.Builder, name, kind string) {
+	if help, ok := metricHelp[name]; ok {
+		fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(out, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(out *strings.Builder, name string, labels []Label, value float64) {
+	out.WriteString(name)
+	if len(labels) > 0 {
+		out.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			fmt.Fprintf(out, "%s=\"%s\"", label.Name, labelEscaper.Replace(label.Value))
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte(' ')
+	out.WriteString(formatFloat(value))
+	out.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,76 @@
+// Package telemetry defines the hooks the SDK uses to report what it is doing.
+// It has no third-party dependencies, so any tracing backend can be plugged in
+// with a small adapter (see the telemetry/otel module for OpenTelemetry).
+package telemetry
+
+import "context"
+
+// Attribute keys set on the spans of the SDK.
+const (
+	AttrBucket      = "reduct.bucket"
+	AttrEntry       = "reduct.entry"
+	AttrQueryID     = "reduct.query_id"
+	AttrRecordCount = "reduct.record_count"
+	AttrBytes       = "reduct.bytes"
+	AttrHTTPStatus  = "http.response.status_code"
+)
+
+// Attribute is a key/value pair attached to a span.
+// Values are strings, bools, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer attribute.
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	// SetAttributes adds or overwrites attributes of the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed with the error.
+	RecordError(err error)
+	// End finishes the span. It is called exactly once.
+	End()
+}
+
+// Tracer starts spans. The returned context carries the span, so that spans
+// started from it, also in background goroutines, become its children.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// NoopTracer returns a tracer that records nothing.
+func NoopTracer() Tracer {
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
@@ -0,0 +1,185 @@
+package reductgo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/reductstore/reduct-go/model"
+)
+
+// maxConflictShifts bounds how often a record is moved to the next
+// microsecond with ConflictShift before its conflict is reported.
+const maxConflictShifts = 16
+
+// TimestampAllocator hands out strictly increasing timestamps per entry, so
+// that records captured in the same microsecond do not collide. A bucket
+// obtained from a client shares one allocator with all handles of the bucket,
+// see Bucket.Timestamps. It is safe for concurrent use.
+type TimestampAllocator struct {
+	mu   sync.Mutex
+	last map[string]int64
+	// now returns the current time; it is replaced in tests.
+	now func() time.Time
+}
+
+// NewTimestampAllocator creates an allocator that starts at the current time.
+func NewTimestampAllocator() *TimestampAllocator {
+	return &TimestampAllocator{last: map[string]int64{}, now: time.Now}
+}
+
+// Next returns the timestamp of a new record of the entry in microseconds:
+// the current time, or one microsecond after the last timestamp of the entry
+// if the clock has not advanced since. A nil allocator returns the current time.
+func (a *TimestampAllocator) Next(entry string) int64 {
+	if a == nil {
+		return time.Now().UTC().UnixMicro()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ts := max(a.now().UTC().UnixMicro(), a.last[entry]+1)
+	a.last[entry] = ts
+	return ts
+}
+
+// Observe records that the entry has a record with the timestamp, e.g. one
+// given explicitly, so that Next returns later timestamps. A nil allocator
+// ignores it.
+func (a *TimestampAllocator) Observe(entry string, ts int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ts > a.last[entry] {
+		a.last[entry] = ts
+	}
+}
+
+// ConflictPolicy decides what a write does when the entry already has a
+// record with the timestamp (HTTP 409), and what a write batch does with a
+// record added at the timestamp of another one.
+type ConflictPolicy int
+
+const (
+	// ConflictFail reports the conflict: the write fails with 409, and a
+	// record added to a batch at a timestamp it already has is reported with
+	// 409 by Write, while the first one is written.
+	ConflictFail ConflictPolicy = iota
+	// ConflictShift writes the record at the next free microsecond instead.
+	// Batches report the new timestamps with Shifted.
+	ConflictShift
+	// ConflictOverwrite removes the existing record and writes the new one in
+	// its place. A record added to a batch replaces the one at its timestamp.
+	ConflictOverwrite
+)
+
+// duplicateTimestampError is the per-record error of a record added to a
+// batch at a timestamp it already has, with ConflictFail.
+func duplicateTimestampError() model.APIError {
+	return model.APIError{Status: http.StatusConflict, Message: "duplicate timestamp in batch"}
+}
+
+// isConflict reports whether a per-record error is a 409.
+func isConflict(err model.APIError) bool {
+	return err.Status == http.StatusConflict
+}
+
+// resolveConflicts applies a ConflictShift or ConflictOverwrite policy to the
+// records of a write batch that failed with 409. errs and shifted are keyed by
+// the timestamps the records were added with. occupied reports timestamps
+// that other records of the batch use, and send and remove issue a batch of
+// the records with ConflictFail.
+func resolveConflicts(ctx context.Context, policy ConflictPolicy, timestamps *TimestampAllocator,
+	conflicts map[recordBatchKey]*recordBatchRecord, occupied func(recordBatchKey) bool,
+	send func(context.Context, map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error),
+	remove func(context.Context, map[recordBatchKey]*recordBatchRecord) (RecordBatchErrorMap, error),
+	errs RecordBatchErrorMap, shifted map[string]map[int64]int64,
+) error {
+	pending := map[recordBatchKey]*recordBatchRecord{}
+	for key, rec := range conflicts {
+		// A streamed payload was consumed by the first request.
+		if rec.stream == nil {
+			pending[key] = rec
+		}
+	}
+	if len(pending) == 0 || policy == ConflictFail {
+		return nil
+	}
+
+	if policy == ConflictOverwrite {
+		removeErrs, err := remove(ctx, pending)
+		if err != nil {
+			return err
+		}
+		for key := range pending {
+			if removeErr, failed := removeErrs[key.entry][key.ts]; failed {
+				errs.add(key.entry, key.ts, removeErr)
+				delete(pending, key)
+			}
+		}
+		sendErrs, err := send(ctx, pending)
+		if err != nil {
+			return err
+		}
+		for key := range pending {
+			setRecordResult(errs, key, key, sendErrs)
+		}
+		return nil
+	}
+
+	// origins maps the timestamp a record is sent with to the one it was added with.
+	origins := map[recordBatchKey]recordBatchKey{}
+	for key := range pending {
+		origins[key] = key
+	}
+	for range maxConflictShifts {
+		moved := make(map[recordBatchKey]*recordBatchRecord, len(pending))
+		for key, rec := range pending {
+			next := recordBatchKey{entry: key.entry, ts: key.ts + 1}
+			for occupied(next) || moved[next] != nil {
+				next.ts++
+			}
+			copied := *rec
+			copied.timestamp = next.ts
+			moved[next] = &copied
+			origins[next] = origins[key]
+			timestamps.Observe(next.entry, next.ts)
+		}
+		sendErrs, err := send(ctx, moved)
+		if err != nil {
+			return err
+		}
+		pending = map[recordBatchKey]*recordBatchRecord{}
+		for key, rec := range moved {
+			recordErr, failed := sendErrs[key.entry][key.ts]
+			if failed && isConflict(recordErr) {
+				pending[key] = rec
+				continue
+			}
+			origin := origins[key]
+			setRecordResult(errs, origin, key, sendErrs)
+			if !failed {
+				if shifted[origin.entry] == nil {
+					shifted[origin.entry] = map[int64]int64{}
+				}
+				shifted[origin.entry][origin.ts] = key.ts
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// setRecordResult replaces the error of the record added at origin with the
+// result of sending it at sent. Emptied entries are kept in errs.
+func setRecordResult(errs RecordBatchErrorMap, origin, sent recordBatchKey, sendErrs RecordBatchErrorMap) {
+	if recordErr, failed := sendErrs[sent.entry][sent.ts]; failed {
+		errs.add(origin.entry, origin.ts, recordErr)
+		return
+	}
+	delete(errs[origin.entry], origin.ts)
+}
@@ -0,0 +1,211 @@
+package reductgo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/model"
+	"github.com/reductstore/reduct-go/reducttest"
+)
+
+func TestTimestampAllocator(t *testing.T) {
+	now := time.UnixMicro(1000)
+	allocator := NewTimestampAllocator()
+	allocator.now = func() time.Time { return now }
+
+	assert.Equal(t, int64(1000), allocator.Next("a"))
+	assert.Equal(t, int64(1001), allocator.Next("a"), "the clock has not advanced")
+	assert.Equal(t, int64(1000), allocator.Next("b"), "entries are independent")
+
+	allocator.Observe("a", 2000)
+	allocator.Observe("a", 1500)
+	assert.Equal(t, int64(2001), allocator.Next("a"))
+
+	now = time.UnixMicro(5000)
+	assert.Equal(t, int64(5000), allocator.Next("a"))
+
+	var unset *TimestampAllocator
+	unset.Observe("a", 1)
+	assert.Positive(t, unset.Next("a"))
+}
+
+func TestBeginWriteAllocatesTimestamps(t *testing.T) {
+	ctx := context.Background()
+	server := reducttest.NewServer(reducttest.Options{})
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, ClientOptions{})
+	first, err := client.CreateBucket(ctx, "bucket", nil)
+	require.NoError(t, err)
+	second, err := client.GetBucket(ctx, "bucket")
+	require.NoError(t, err)
+
+	seen := map[int64]bool{}
+	for i := range 50 {
+		bucket := first
+		if i%2 == 1 {
+			bucket = second
+		}
+		record := bucket.BeginWrite(ctx, "frames", nil)
+		require.NoError(t, record.Write("frame"))
+		seen[record.Timestamp()] = true
+	}
+	assert.Len(t, seen, 50, "the handles of the bucket share the allocator")
+
+	record := first.BeginWrite(ctx, "frames", &WriteOptions{Timestamp: time.Now().Add(time.Hour).UnixMicro()})
+	require.NoError(t, record.Write("frame"))
+	assert.Greater(t, second.BeginWrite(ctx, "frames", nil).Timestamp(), record.Timestamp(),
+		"explicit timestamps are observed")
+}
+
+func TestBatchDuplicateTimestamps(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fail", func(t *testing.T) {
+		bucket := newBufferedTestBucket(t)
+		batch := bucket.BeginWriteBatch(ctx, "entry")
+		batch.Add(1, []byte("first"), "", nil)
+		batch.Add(1, []byte("second"), "", nil)
+		batch.Add(2, []byte("other"), "", nil)
+		assert.Equal(t, 2, batch.RecordCount())
+		errs, err := batch.Write(ctx)
+		require.NoError(t, err)
+		require.Len(t, errs, 1)
+		assert.Equal(t, http.StatusConflict, errs[1].Status)
+		assert.Equal(t, "first", readEntryRecord(t, bucket, "entry", 1))
+
+		recordBatch := bucket.BeginWriteRecordBatch(ctx)
+		recordBatch.Add("other", 1, []byte("first"), "", nil)
+		recordBatch.Add("other", 1, []byte("second"), "", nil)
+		recordBatch.Add("more", 1, []byte("more"), "", nil)
+		recordErrs, err := recordBatch.Send(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, RecordBatchErrorMap{"other": {1: duplicateTimestampError()}}, recordErrs)
+	})
+
+	t.Run("shift", func(t *testing.T) {
+		bucket := newBufferedTestBucket(t)
+		batch := bucket.BeginWriteBatch(ctx, "entry")
+		batch.SetConflictPolicy(ConflictShift)
+		batch.Add(1, []byte("first"), "", nil)
+		batch.Add(2, []byte("next"), "", nil)
+		batch.Add(1, []byte("second"), "", nil)
+		errs, err := batch.Write(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, errs)
+		assert.Equal(t, map[int64]int64{1: 3}, batch.Shifted())
+		assert.Equal(t, "second", readEntryRecord(t, bucket, "entry", 3))
+
+		recordBatch := bucket.BeginWriteRecordBatch(ctx)
+		recordBatch.SetConflictPolicy(ConflictShift)
+		recordBatch.Add("other", 1, []byte("first"), "", nil)
+		recordBatch.Add("other", 1, []byte("second"), "", nil)
+		recordErrs, err := recordBatch.Send(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, recordErrs)
+		assert.Equal(t, map[string]map[int64]int64{"other": {1: 2}}, recordBatch.Shifted())
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		bucket := newBufferedTestBucket(t)
+		batch := bucket.BeginWriteBatch(ctx, "entry")
+		batch.SetConflictPolicy(ConflictOverwrite)
+		batch.Add(1, []byte("first"), "", nil)
+		batch.Add(1, []byte("second"), "", nil)
+		assert.Equal(t, int64(len("second")), batch.Size())
+		errs, err := batch.Write(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, errs)
+		assert.Equal(t, "second", readEntryRecord(t, bucket, "entry", 1))
+	})
+}
+
+func TestBatchAddNow(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	bucket.Timestamps.now = func() time.Time { return time.UnixMicro(1000) }
+
+	batch := bucket.BeginWriteBatch(ctx, "entry")
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	assert.Equal(t, int64(1000), batch.AddNow([]byte("a"), "", nil))
+	assert.Equal(t, int64(1001), batch.AddNow([]byte("b"), "", nil))
+	assert.Equal(t, int64(1002), recordBatch.AddNow("entry", []byte("c"), "", nil))
+	assert.Equal(t, int64(1000), recordBatch.AddNow("other", []byte("d"), "", nil))
+
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recordErrs)
+	assert.Equal(t, 3, countEntryRecords(t, bucket, "entry"))
+}
+
+func TestConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	for ts, entry := range map[int64]string{1: "batch", 2: "batch", 3: "single", 4: "single"} {
+		require.NoError(t, bucket.BeginWrite(ctx, entry, &WriteOptions{Timestamp: ts}).Write("stored"))
+	}
+
+	batch := bucket.BeginWriteBatch(ctx, "batch")
+	batch.Add(1, []byte("new"), "", nil)
+	errs, err := batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, errs[1].Status, "ConflictFail is the default")
+
+	batch.SetConflictPolicy(ConflictShift)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[int64]int64{1: 3}, batch.Shifted(), "2 is taken as well")
+	assert.Equal(t, "new", readEntryRecord(t, bucket, "batch", 3))
+
+	batch = bucket.BeginWriteBatch(ctx, "batch")
+	batch.SetConflictPolicy(ConflictOverwrite)
+	batch.Add(2, []byte("replaced"), "", nil)
+	errs, err = batch.Write(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, "replaced", readEntryRecord(t, bucket, "batch", 2))
+
+	recordBatch := bucket.BeginWriteRecordBatch(ctx)
+	recordBatch.SetConflictPolicy(ConflictShift)
+	recordBatch.Add("batch", 1, []byte("again"), "", nil)
+	recordBatch.AddReader("batch", 2, strings.NewReader("streamed"), 8, "", nil)
+	recordErrs, err := recordBatch.Send(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[int64]int64{"batch": {1: 4}}, recordBatch.Shifted())
+	assert.Equal(t, http.StatusConflict, recordErrs["batch"][2].Status, "a streamed payload is not sent again")
+
+	err = bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 3}).Write("new")
+	require.ErrorIs(t, err, model.ErrConflict)
+
+	record := bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 3, OnConflict: ConflictShift})
+	require.NoError(t, record.Write([]byte("shifted")))
+	assert.Equal(t, int64(5), record.Timestamp())
+	assert.Equal(t, "shifted", readEntryRecord(t, bucket, "single", 5))
+
+	record = bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 3, Size: 8, OnConflict: ConflictOverwrite})
+	require.NoError(t, record.Write(strings.NewReader("replaced")))
+	assert.Equal(t, "replaced", readEntryRecord(t, bucket, "single", 3))
+
+	err = bucket.BeginWrite(ctx, "single", &WriteOptions{Timestamp: 3, Size: 12, OnConflict: ConflictShift}).
+		Write(struct{ io.Reader }{strings.NewReader("not seekable")})
+	require.ErrorIs(t, err, model.ErrConflict)
+}
+
+func readEntryRecord(t *testing.T, bucket *Bucket, entry string, ts int64) string {
+	t.Helper()
+	record, err := bucket.BeginRead(context.Background(), entry, &ts)
+	require.NoError(t, err)
+	data, err := record.ReadAsString()
+	require.NoError(t, err)
+	return data
+}
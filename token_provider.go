@@ -0,0 +1,181 @@
+package reductgo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reductstore/reduct-go/httpclient"
+	"github.com/reductstore/reduct-go/model"
+)
+
+const (
+	defaultRotateBefore = 5 * time.Minute
+	// tokenReloadDelay limits how often the expiry is read after a failure.
+	tokenReloadDelay = 10 * time.Second
+)
+
+// RotatingTokenOptions configures a RotatingTokenProvider.
+type RotatingTokenOptions struct {
+	// Name of the token. Defaults to the name reported by GetCurrentToken.
+	Name string
+	// RotateBefore is how long before its expiry the token is rotated. Defaults to 5 minutes.
+	RotateBefore time.Duration
+	// Persist is called with every new token value, e.g. to store it for the
+	// next start of the process. An error is logged, the new value is used anyway,
+	// because the server has already revoked the previous one.
+	Persist func(ctx context.Context, token model.TokenCreateResponse) error
+	// Client rotates the token and reads its expiry, e.g. a client with an
+	// admin token. It may also be the client that uses the provider. Defaults
+	// to a client that connects to the URL with the token itself.
+	Client Client
+	// Logger receives rotation failures. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// rotationKey marks the requests of a provider, so that they use the current
+// token instead of waiting for the rotation they are part of.
+type rotationKey struct{}
+
+// RotatingTokenProvider is a token provider for tokens that expire. It rotates
+// the token with RotateToken before the expiry reported by GetCurrentToken,
+// and when the server rejects the token with 401 Unauthorized.
+type RotatingTokenProvider struct {
+	options RotatingTokenOptions
+	client  Client
+	value   atomic.Pointer[string]
+
+	mu        sync.Mutex
+	loaded    bool
+	loadAfter time.Time
+	expiresAt time.Time
+}
+
+// NewRotatingTokenProvider creates a provider that starts with token.
+// Pass it as ClientOptions.TokenProvider of a client for the same url.
+func NewRotatingTokenProvider(url, token string, options RotatingTokenOptions) *RotatingTokenProvider {
+	if options.RotateBefore <= 0 {
+		options.RotateBefore = defaultRotateBefore
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	p := &RotatingTokenProvider{options: options, client: options.Client}
+	p.value.Store(&token)
+	if p.client == nil {
+		p.client = NewClient(url, ClientOptions{TokenProvider: httpclient.TokenFunc(p.current)})
+	}
+	return p
+}
+
+func (p *RotatingTokenProvider) current(context.Context) (string, error) {
+	return *p.value.Load(), nil
+}
+
+// Token returns the current token and rotates it first if it expires soon.
+// If the rotation fails, the current token is returned and the rotation is
+// tried again with the next request.
+func (p *RotatingTokenProvider) Token(ctx context.Context) (string, error) {
+	token := *p.value.Load()
+	if ctx.Value(rotationKey{}) == p {
+		return token, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx = context.WithValue(ctx, rotationKey{}, p)
+	if !p.loaded {
+		if time.Now().Before(p.loadAfter) {
+			return token, nil
+		}
+		if err := p.load(ctx); err != nil {
+			p.loadAfter = time.Now().Add(tokenReloadDelay)
+			p.warn(ctx, "failed to read API token expiry", err)
+			return token, nil
+		}
+	}
+	if p.expiresAt.IsZero() || time.Until(p.expiresAt) > p.options.RotateBefore {
+		return *p.value.Load(), nil
+	}
+
+	if err := p.rotate(ctx); err != nil {
+		p.warn(ctx, "failed to rotate API token", err)
+	}
+	return *p.value.Load(), nil
+}
+
+// Refresh rotates the token after the server rejected it, unless another
+// request has rotated it already.
+func (p *RotatingTokenProvider) Refresh(ctx context.Context, rejected string) (string, error) {
+	if ctx.Value(rotationKey{}) == p {
+		return "", errors.New("token rejected during rotation")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx = context.WithValue(ctx, rotationKey{}, p)
+	if token := *p.value.Load(); token != rejected {
+		return token, nil
+	}
+	if err := p.rotate(ctx); err != nil {
+		return "", err
+	}
+	return *p.value.Load(), nil
+}
+
+// load reads the name and expiry of the current token.
+func (p *RotatingTokenProvider) load(ctx context.Context) error {
+	info, err := p.client.GetCurrentToken(ctx)
+	if err != nil {
+		return err
+	}
+	if p.options.Name == "" {
+		p.options.Name = info.Name
+	}
+
+	p.expiresAt = time.Time{}
+	if info.ExpiresAt != nil {
+		p.expiresAt, err = time.Parse(time.RFC3339, *info.ExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	p.loaded = true
+	return nil
+}
+
+func (p *RotatingTokenProvider) rotate(ctx context.Context) error {
+	if p.options.Name == "" {
+		if err := p.load(ctx); err != nil {
+			return err
+		}
+	}
+
+	token, err := p.client.RotateToken(ctx, p.options.Name)
+	if err != nil {
+		return err
+	}
+	p.value.Store(&token.Value)
+	if p.options.Persist != nil {
+		if err := p.options.Persist(ctx, token); err != nil {
+			p.warn(ctx, "failed to persist rotated API token", err)
+		}
+	}
+
+	// A rotated token may keep the expiry of the previous one; then it is only
+	// rotated again when the server rejects it.
+	p.loaded = false
+	p.expiresAt = time.Time{}
+	if err := p.load(ctx); err == nil && time.Until(p.expiresAt) <= p.options.RotateBefore {
+		p.expiresAt = time.Time{}
+	}
+	return nil
+}
+
+func (p *RotatingTokenProvider) warn(ctx context.Context, msg string, err error) {
+	p.options.Logger.WarnContext(ctx, msg, "token", p.options.Name, "error", err)
+}
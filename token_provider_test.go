@@ -0,0 +1,111 @@
+package reductgo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/reductstore/reduct-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer accepts the admin token and a single token value, which changes
+// with every rotation.
+type tokenServer struct {
+	mu        sync.Mutex
+	value     string
+	rotations int
+	expiresAt time.Time
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("X-Reduct-API", "v1.20")
+	auth := r.Header.Get("Authorization")
+	if auth != "Bearer "+s.value && auth != "Bearer admin" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/me":
+		_, err := fmt.Fprintf(w, `{"name":"ingest","created_at":"2025-01-01T00:00:00Z","expires_at":%q}`,
+			s.expiresAt.Format(time.RFC3339))
+		if err != nil {
+			panic(err)
+		}
+	case "/api/v1/tokens/ingest/rotate":
+		s.rotations++
+		s.value = fmt.Sprintf("token-%d", s.rotations)
+		s.expiresAt = time.Now().Add(time.Hour)
+		_, err := fmt.Fprintf(w, `{"value":%q,"created_at":"2025-01-01T00:00:00Z"}`, s.value)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *tokenServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value = ""
+}
+
+func TestRotatingTokenProviderRotatesBeforeExpiry(t *testing.T) {
+	state := &tokenServer{value: "initial", expiresAt: time.Now().Add(time.Minute)}
+	server := httptest.NewServer(state)
+	defer server.Close()
+
+	var persisted []string
+	provider := NewRotatingTokenProvider(server.URL, "initial", RotatingTokenOptions{
+		Persist: func(_ context.Context, token model.TokenCreateResponse) error {
+			persisted = append(persisted, token.Value)
+			return nil
+		},
+	})
+	client := NewClient(server.URL, ClientOptions{TokenProvider: provider})
+
+	// The token expires within the default five minutes, so it is rotated first.
+	_, err := client.GetBucket(context.Background(), "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.rotations)
+	assert.Equal(t, []string{"token-1"}, persisted)
+
+	// The new token is valid for an hour.
+	_, err = client.GetBucket(context.Background(), "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.rotations)
+}
+
+func TestRotatingTokenProviderRefreshesOnUnauthorized(t *testing.T) {
+	state := &tokenServer{value: "initial", expiresAt: time.Now().Add(time.Hour)}
+	server := httptest.NewServer(state)
+	defer server.Close()
+
+	admin := NewClient(server.URL, ClientOptions{APIToken: "admin"})
+	provider := NewRotatingTokenProvider(server.URL, "initial", RotatingTokenOptions{Name: "ingest", Client: admin})
+	client := NewClient(server.URL, ClientOptions{TokenProvider: provider})
+
+	_, err := client.GetBucket(context.Background(), "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, 0, state.rotations)
+
+	// The token is no longer accepted, e.g. it expired after inactivity.
+	state.expire()
+	_, err = client.GetBucket(context.Background(), "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.rotations)
+
+	token, err := provider.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+}
@@ -0,0 +1,181 @@
+package reductgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/reductstore/reduct-go/codec"
+)
+
+// TypedEntryOptions configures a TypedEntry.
+type TypedEntryOptions struct {
+	// Codec encodes the values written. Defaults to codec.JSON.
+	Codec codec.Codec
+	// Registry selects the codec that decodes a record by its stored content
+	// type. Defaults to codec.Default.
+	Registry *codec.Registry
+}
+
+// TypedEntry writes and reads values of type T in one entry of a bucket,
+// encoding them with a codec instead of handling raw payloads.
+//
+// Example:
+//
+//	type Reading struct {
+//	    Sensor string  `json:"sensor"`
+//	    Value  float64 `json:"value"`
+//	}
+//
+//	readings := reductgo.NewTypedEntry[Reading](bucket, "readings", nil)
+//	err := readings.Write(ctx, ts, Reading{Sensor: "temp", Value: 21.5}, nil)
+//
+//	result, err := readings.Query(ctx, nil)
+//	for record := range result.Records() {
+//	    fmt.Println(record.Time(), record.Value.Value)
+//	}
+//	err = result.Err()
+type TypedEntry[T any] struct {
+	bucket   BucketAPI
+	entry    string
+	codec    codec.Codec
+	registry *codec.Registry
+}
+
+// NewTypedEntry creates a TypedEntry for an entry of the bucket. options may be nil.
+func NewTypedEntry[T any](bucket BucketAPI, entry string, options *TypedEntryOptions) *TypedEntry[T] {
+	e := &TypedEntry[T]{bucket: bucket, entry: entry, codec: codec.JSON, registry: codec.Default}
+	if options != nil {
+		if options.Codec != nil {
+			e.codec = options.Codec
+		}
+		if options.Registry != nil {
+			e.registry = options.Registry
+		}
+	}
+	return e
+}
+
+// Name returns the name of the entry.
+func (e *TypedEntry[T]) Name() string {
+	return e.entry
+}
+
+// TypedRecord is a record of a TypedEntry with its decoded value. The
+// metadata, e.g. Time and Labels, is that of the embedded ReadableRecord,
+// whose payload has been consumed.
+type TypedRecord[T any] struct {
+	*ReadableRecord
+	Value T
+}
+
+// Write encodes value with the codec of the entry and writes it with the
+// timestamp and labels, given like in Batch.Add. The record is stored with
+// the content type of the codec.
+func (e *TypedEntry[T]) Write(ctx context.Context, ts int64, value T, labels any) error {
+	data, err := e.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode record %d of %s as %s: %w", ts, e.entry, e.codec.ContentType(), err)
+	}
+	return e.bucket.BeginWrite(ctx, e.entry, &WriteOptions{
+		Timestamp:   ts,
+		ContentType: e.codec.ContentType(),
+		LabelsFrom:  labels,
+	}).WriteContext(ctx, data)
+}
+
+// Read reads and decodes the record with the timestamp, or the latest record if ts is nil.
+func (e *TypedEntry[T]) Read(ctx context.Context, ts *int64) (*TypedRecord[T], error) {
+	record, err := e.bucket.BeginRead(ctx, e.entry, ts)
+	if err != nil {
+		return nil, err
+	}
+	return e.decode(record)
+}
+
+// Query queries the entry like Bucket.Query and decodes the records with the
+// codec registered for their stored content type. With options.Head, only the
+// metadata is returned and Value is the zero value.
+//
+// The first record that cannot be decoded ends the result; Err returns its
+// error. Cancel ctx to stop a query that is not drained.
+func (e *TypedEntry[T]) Query(ctx context.Context, options *QueryOptions) (*TypedQueryResult[T], error) {
+	result, err := e.bucket.Query(ctx, e.entry, options)
+	if err != nil {
+		return &TypedQueryResult[T]{}, err
+	}
+	head := options != nil && options.Head
+
+	records := make(chan *TypedRecord[T])
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(records)
+		for record := range result.Records() {
+			typed := &TypedRecord[T]{ReadableRecord: record}
+			if !head {
+				var decodeErr error
+				if typed, decodeErr = e.decode(record); decodeErr != nil {
+					errCh <- decodeErr
+					return
+				}
+			}
+			select {
+			case records <- typed:
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+		}
+		if err := result.Err(); err != nil {
+			errCh <- err
+		}
+	}()
+	return &TypedQueryResult[T]{records: records, errCh: errCh}, nil
+}
+
+// decode reads the payload of a record and decodes it by its content type.
+func (e *TypedEntry[T]) decode(record *ReadableRecord) (*TypedRecord[T], error) {
+	c, err := e.registry.Lookup(record.ContentType())
+	if err != nil {
+		return nil, fmt.Errorf("decode record %d of %s: %w", record.Time(), record.Entry(), err)
+	}
+	data, err := record.Read()
+	if err != nil {
+		return nil, err
+	}
+	typed := &TypedRecord[T]{ReadableRecord: record}
+	if err := c.Unmarshal(data, &typed.Value); err != nil {
+		return nil, fmt.Errorf("decode record %d of %s as %s: %w", record.Time(), record.Entry(), c.ContentType(), err)
+	}
+	return typed, nil
+}
+
+// TypedQueryResult is the result of TypedEntry.Query.
+type TypedQueryResult[T any] struct {
+	records <-chan *TypedRecord[T]
+	errCh   <-chan error
+}
+
+// Records returns the decoded records.
+func (q *TypedQueryResult[T]) Records() <-chan *TypedRecord[T] {
+	if q.records == nil {
+		ch := make(chan *TypedRecord[T])
+		close(ch)
+		return ch
+	}
+	return q.records
+}
+
+// Err returns the error that ended the query, e.g. a record that could not be
+// decoded. Call it after the Records() channel has been fully drained.
+func (q *TypedQueryResult[T]) Err() error {
+	if q.errCh == nil {
+		return nil
+	}
+	select {
+	case err := <-q.errCh:
+		return err
+	default:
+		return nil
+	}
+}
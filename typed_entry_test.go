@@ -0,0 +1,78 @@
+package reductgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reductstore/reduct-go/codec"
+)
+
+type reading struct {
+	Sensor string  `json:"sensor"`
+	Value  float64 `json:"value"`
+}
+
+func TestTypedEntry(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+
+	// Records written with different codecs are decoded by their content type.
+	for i, c := range []codec.Codec{codec.JSON, codec.MessagePack, codec.CBOR} {
+		entry := NewTypedEntry[reading](bucket, "readings", &TypedEntryOptions{Codec: c})
+		ts := int64(i + 1)
+		require.NoError(t, entry.Write(ctx, ts, reading{Sensor: "temp", Value: float64(ts)}, sensorLabels{Sensor: "temp"}))
+	}
+
+	readings := NewTypedEntry[reading](bucket, "readings", nil)
+	assert.Equal(t, "readings", readings.Name())
+	result, err := readings.Query(ctx, nil)
+	require.NoError(t, err)
+	var contentTypes []string
+	for record := range result.Records() {
+		assert.Equal(t, reading{Sensor: "temp", Value: float64(record.Time())}, record.Value)
+		assert.Equal(t, "temp", record.Labels()["sensor"])
+		contentTypes = append(contentTypes, record.ContentType())
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, []string{codec.ContentTypeJSON, codec.ContentTypeMessagePack, codec.ContentTypeCBOR}, contentTypes)
+
+	ts := int64(2)
+	record, err := readings.Read(ctx, &ts)
+	require.NoError(t, err)
+	assert.Equal(t, reading{Sensor: "temp", Value: 2}, record.Value)
+
+	result, err = readings.Query(ctx, &QueryOptions{Head: true})
+	require.NoError(t, err)
+	count := 0
+	for record := range result.Records() {
+		assert.Zero(t, record.Value)
+		count++
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, 3, count)
+}
+
+func TestTypedEntryUnknownContentType(t *testing.T) {
+	ctx := context.Background()
+	bucket := newBufferedTestBucket(t)
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 1, ContentType: "application/json"}).Write(`{"sensor":"a"}`))
+	require.NoError(t, bucket.BeginWrite(ctx, "entry", &WriteOptions{Timestamp: 2, ContentType: "image/png"}).Write("png"))
+
+	result, err := NewTypedEntry[reading](bucket, "entry", nil).Query(ctx, nil)
+	require.NoError(t, err)
+	var values []reading
+	for record := range result.Records() {
+		values = append(values, record.Value)
+	}
+	assert.Equal(t, []reading{{Sensor: "a"}}, values)
+	require.ErrorIs(t, result.Err(), codec.ErrUnknownContentType)
+
+	raw := NewTypedEntry[[]byte](bucket, "raw", &TypedEntryOptions{Codec: codec.Raw})
+	require.NoError(t, raw.Write(ctx, 1, []byte{0, 1, 2}, nil))
+	record, err := raw.Read(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, record.Value)
+}